@@ -0,0 +1,373 @@
+// Package changeset helps producers build consistent changesets.
+package changeset
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/pennsieve/processor-post-metadata/client/models"
+	"github.com/pennsieve/processor-post-metadata/client/validation"
+)
+
+// Builder assembles a models.Dataset from handles for models, links and relationships, so that model names and
+// external IDs are only written once. Mistakes, such as declaring a model twice or linking a record to the wrong
+// model, are collected and returned by Build.
+type Builder struct {
+	models        []*Model
+	modelsByName  map[string]*Model
+	links         []*Link
+	relationships []*Relationship
+	// proxies holds the package proxy changes of each record, in the order their records were first given a package
+	proxies                       []*models.ProxyRecordChanges
+	createProxyRelationshipSchema bool
+	proxyRelationshipSchemas      []models.RelationshipSchemaCreate
+	errs                          []error
+}
+
+func NewBuilder() *Builder {
+	return &Builder{modelsByName: map[string]*Model{}}
+}
+
+// Model is a handle for a model declared with NewModel or ExistingModel
+type Model struct {
+	builder *Builder
+	name    string
+	// id is empty for a new model
+	id         models.PennsieveSchemaID
+	create     models.ModelPropsCreate
+	records    []models.RecordCreate
+	existingID map[models.ExternalInstanceID]models.PennsieveInstanceID
+	// existingOrder holds the keys of existingID in the order added
+	existingOrder []models.ExternalInstanceID
+	// externalIDs holds the external IDs of all records of the model known to the Builder
+	externalIDs map[models.ExternalInstanceID]bool
+}
+
+// NewModel declares a model to create with the given properties
+func (b *Builder) NewModel(model models.ModelCreateParams, properties ...models.PropertyCreateParams) *Model {
+	handle := b.declareModel(model.Name)
+	handle.create = models.ModelPropsCreate{Model: model, Properties: properties}
+	return handle
+}
+
+// ExistingModel declares a model that already exists in Pennsieve with the given ID
+func (b *Builder) ExistingModel(name string, id models.PennsieveSchemaID) *Model {
+	handle := b.declareModel(name)
+	if len(id) == 0 {
+		b.addError("existing model %q has no ID", name)
+	}
+	handle.id = id
+	return handle
+}
+
+func (b *Builder) declareModel(name string) *Model {
+	handle := &Model{
+		builder:     b,
+		name:        name,
+		existingID:  map[models.ExternalInstanceID]models.PennsieveInstanceID{},
+		externalIDs: map[models.ExternalInstanceID]bool{},
+	}
+	if len(name) == 0 {
+		b.addError("model name is empty")
+	} else if _, declared := b.modelsByName[name]; declared {
+		b.addError("model %q is declared more than once", name)
+	} else {
+		b.modelsByName[name] = handle
+	}
+	b.models = append(b.models, handle)
+	return handle
+}
+
+// Name returns the name of the model
+func (m *Model) Name() string {
+	return m.name
+}
+
+// IsNew returns true if the model was declared with NewModel
+func (m *Model) IsNew() bool {
+	return len(m.id) == 0
+}
+
+// AddRecord adds a record with the given values to create in the model, and returns its new external ID
+func (m *Model) AddRecord(values ...models.RecordValue) models.ExternalInstanceID {
+	return m.AddRecordWithID(models.ExternalInstanceID(uuid.NewString()), values...)
+}
+
+// AddRecordWithID adds a record with the given external ID and values to create in the model, and returns the
+// external ID
+func (m *Model) AddRecordWithID(externalID models.ExternalInstanceID, values ...models.RecordValue) models.ExternalInstanceID {
+	if m.addExternalID(externalID) {
+		m.records = append(m.records, models.RecordCreate{
+			ExternalID:   externalID,
+			RecordValues: models.RecordValues{Values: values},
+		})
+	}
+	return externalID
+}
+
+// ExistingRecord declares a record of an existing model that already exists in Pennsieve with the given ID, so that
+// it can be linked or given packages. Returns the external ID of the record, which is its Pennsieve ID.
+func (m *Model) ExistingRecord(id models.PennsieveInstanceID) models.ExternalInstanceID {
+	externalID := models.ExternalInstanceID(id)
+	if m.IsNew() {
+		m.builder.addError("model %q is new, so record %s cannot already exist", m.name, id)
+		return externalID
+	}
+	if m.addExternalID(externalID) {
+		m.existingID[externalID] = id
+		m.existingOrder = append(m.existingOrder, externalID)
+	}
+	return externalID
+}
+
+// AttachPackages links the packages with the given node IDs to the record with the default "belongs_to" relationship
+func (m *Model) AttachPackages(record models.ExternalInstanceID, packageNodeIDs ...string) {
+	if !m.checkRecord(record) {
+		return
+	}
+	recordChanges := m.builder.proxyRecordChanges(m, record)
+	recordChanges.NodeIDCreates = append(recordChanges.NodeIDCreates, packageNodeIDs...)
+}
+
+// AttachPackage links the package with the given node ID to the record with the given relationship. A relationship type
+// other than "belongs_to" that does not exist yet is created by the processor, as declared with
+// NewProxyRelationshipSchema, or with a display name made from the type if it was not declared.
+func (m *Model) AttachPackage(record models.ExternalInstanceID, packageNodeID string, relationship models.ProxyRelationship) {
+	if !m.checkRecord(record) {
+		return
+	}
+	recordChanges := m.builder.proxyRecordChanges(m, record)
+	recordChanges.PackageCreates = append(recordChanges.PackageCreates, models.ProxyPackageCreate{
+		NodeID:            packageNodeID,
+		ProxyRelationship: relationship,
+	})
+}
+
+// addExternalID returns false, and records an error, if the external ID is empty or already used in the model
+func (m *Model) addExternalID(externalID models.ExternalInstanceID) bool {
+	if len(externalID) == 0 {
+		m.builder.addError("record of model %q has an empty external ID", m.name)
+		return false
+	}
+	if m.externalIDs[externalID] {
+		m.builder.addError("record %q of model %q is added more than once", externalID, m.name)
+		return false
+	}
+	m.externalIDs[externalID] = true
+	return true
+}
+
+// checkRecord returns false, and records an error, if the record was not added to the model
+func (m *Model) checkRecord(record models.ExternalInstanceID) bool {
+	if m.externalIDs[record] {
+		return true
+	}
+	m.builder.addError("record %q was not added to model %q", record, m.name)
+	return false
+}
+
+// checkModel returns false, and records an error, if model was not declared with this Builder
+func (b *Builder) checkModel(model *Model) bool {
+	if model == nil || model.builder != b {
+		b.addError("model was not declared with this builder")
+		return false
+	}
+	return true
+}
+
+// CreateProxyRelationshipSchema creates the "belongs_to" relationship schema used by package proxies. Needed once
+// per dataset, before the first package is attached.
+func (b *Builder) CreateProxyRelationshipSchema() *Builder {
+	b.createProxyRelationshipSchema = true
+	return b
+}
+
+// NewProxyRelationshipSchema declares the display name, description and models of a relationship schema used by
+// AttachPackage, to create before any packages are attached
+func (b *Builder) NewProxyRelationshipSchema(schema RelationshipSchema) *Builder {
+	for _, declared := range b.proxyRelationshipSchemas {
+		if declared.Name == schema.Name {
+			b.addError("proxy relationship schema %q is declared more than once", schema.Name)
+			return b
+		}
+	}
+	b.proxyRelationshipSchemas = append(b.proxyRelationshipSchemas, b.relationshipSchemaCreate(schema))
+	return b
+}
+
+func (b *Builder) proxyRecordChanges(model *Model, record models.ExternalInstanceID) *models.ProxyRecordChanges {
+	for _, recordChanges := range b.proxies {
+		if recordChanges.ModelName == model.name && recordChanges.RecordExternalID == record {
+			return recordChanges
+		}
+	}
+	recordChanges := &models.ProxyRecordChanges{ModelName: model.name, RecordExternalID: record}
+	b.proxies = append(b.proxies, recordChanges)
+	return recordChanges
+}
+
+// Link is a handle for a linked property declared with NewLink or ExistingLink
+type Link struct {
+	builder *Builder
+	from    *Model
+	to      *Model
+	changes models.LinkedPropertyChanges
+}
+
+// NewLink declares a linked property to create from records of one model to records of another
+func (b *Builder) NewLink(from *Model, to *Model, create models.SchemaLinkedPropertyCreate) *Link {
+	return b.declareLink(from, to, models.LinkedPropertyChanges{Create: &create})
+}
+
+// ExistingLink declares a linked property that already exists in Pennsieve with the given ID
+func (b *Builder) ExistingLink(from *Model, to *Model, id models.PennsieveSchemaID) *Link {
+	if len(id) == 0 {
+		b.addError("existing link has no ID")
+	}
+	return b.declareLink(from, to, models.LinkedPropertyChanges{ID: id})
+}
+
+func (b *Builder) declareLink(from *Model, to *Model, changes models.LinkedPropertyChanges) *Link {
+	link := &Link{builder: b, from: from, to: to, changes: changes}
+	if b.checkModel(from) && b.checkModel(to) {
+		link.changes.FromModelName = from.name
+		link.changes.ToModelName = to.name
+	}
+	b.links = append(b.links, link)
+	return link
+}
+
+// Add links a record of the from model to a record of the to model
+func (l *Link) Add(fromRecord models.ExternalInstanceID, toRecord models.ExternalInstanceID) *Link {
+	if l.builder.checkModel(l.from) && l.builder.checkModel(l.to) && l.from.checkRecord(fromRecord) && l.to.checkRecord(toRecord) {
+		l.changes.Instances.Create = append(l.changes.Instances.Create, models.InstanceLinkedPropertyCreate{
+			FromExternalID: fromRecord,
+			ToExternalID:   toRecord,
+		})
+	}
+	return l
+}
+
+// RelationshipSchema describes a relationship schema to create
+type RelationshipSchema struct {
+	Name        string
+	DisplayName string
+	Description string
+	// From, if not nil, restricts the relationship to records of this model in the "from" role
+	From *Model
+	// To, if not nil, restricts the relationship to records of this model in the "to" role
+	To *Model
+}
+
+// Relationship is a handle for a relationship declared with NewRelationship or ExistingRelationship
+type Relationship struct {
+	builder *Builder
+	changes models.RelationshipChanges
+}
+
+// NewRelationship declares a relationship schema to create
+func (b *Builder) NewRelationship(schema RelationshipSchema) *Relationship {
+	create := b.relationshipSchemaCreate(schema)
+	return b.declareRelationship(models.RelationshipChanges{Create: &create})
+}
+
+func (b *Builder) relationshipSchemaCreate(schema RelationshipSchema) models.RelationshipSchemaCreate {
+	create := models.RelationshipSchemaCreate{
+		Name:        schema.Name,
+		DisplayName: schema.DisplayName,
+		Description: schema.Description,
+	}
+	if schema.From != nil && b.checkModel(schema.From) {
+		create.FromModelName = schema.From.name
+	}
+	if schema.To != nil && b.checkModel(schema.To) {
+		create.ToModelName = schema.To.name
+	}
+	return create
+}
+
+// ExistingRelationship declares a relationship schema that already exists in Pennsieve with the given ID
+func (b *Builder) ExistingRelationship(id models.PennsieveSchemaID) *Relationship {
+	if len(id) == 0 {
+		b.addError("existing relationship has no ID")
+	}
+	return b.declareRelationship(models.RelationshipChanges{ID: id})
+}
+
+func (b *Builder) declareRelationship(changes models.RelationshipChanges) *Relationship {
+	relationship := &Relationship{builder: b, changes: changes}
+	b.relationships = append(b.relationships, relationship)
+	return relationship
+}
+
+// Add relates a record of the from model to a record of the to model
+func (r *Relationship) Add(from *Model, fromRecord models.ExternalInstanceID, to *Model, toRecord models.ExternalInstanceID) *Relationship {
+	if r.builder.checkModel(from) && r.builder.checkModel(to) && from.checkRecord(fromRecord) && to.checkRecord(toRecord) {
+		r.changes.Instances.Create = append(r.changes.Instances.Create, models.RelationshipInstanceCreate{
+			FromModelName:  from.name,
+			FromExternalID: fromRecord,
+			ToModelName:    to.name,
+			ToExternalID:   toRecord,
+		})
+	}
+	return r
+}
+
+func (b *Builder) addError(format string, args ...any) {
+	b.errs = append(b.errs, fmt.Errorf(format, args...))
+}
+
+// Build returns the changeset declared so far, with ExistingModelIDMap and RecordIDMaps filled in from the existing
+// models and records. Returns an error if any mistakes were made while declaring it, or if the changeset does not
+// pass validation.Validate.
+func (b *Builder) Build() (models.Dataset, error) {
+	if len(b.errs) > 0 {
+		return models.Dataset{}, fmt.Errorf("error building changeset: %w", errors.Join(b.errs...))
+	}
+	var dataset models.Dataset
+	for _, model := range b.models {
+		if model.IsNew() {
+			dataset.Models.Creates = append(dataset.Models.Creates, models.ModelCreate{
+				Create:  model.create,
+				Records: model.records,
+			})
+			continue
+		}
+		if dataset.ExistingModelIDMap == nil {
+			dataset.ExistingModelIDMap = map[string]models.PennsieveSchemaID{}
+		}
+		dataset.ExistingModelIDMap[model.name] = model.id
+		if len(model.records) > 0 {
+			dataset.Models.Updates = append(dataset.Models.Updates, models.ModelUpdate{
+				ID:      model.id,
+				Records: models.RecordChanges{Create: model.records},
+			})
+		}
+		if len(model.existingOrder) > 0 {
+			recordIDMap := models.NewRecordIDMap(model.name)
+			for _, externalID := range model.existingOrder {
+				recordIDMap.ExternalToPennsieve[externalID] = model.existingID[externalID]
+			}
+			dataset.RecordIDMaps = append(dataset.RecordIDMaps, recordIDMap)
+		}
+	}
+	for _, link := range b.links {
+		dataset.LinkedProperties = append(dataset.LinkedProperties, link.changes)
+	}
+	for _, relationship := range b.relationships {
+		dataset.Relationships = append(dataset.Relationships, relationship.changes)
+	}
+	if b.createProxyRelationshipSchema || len(b.proxyRelationshipSchemas) > 0 || len(b.proxies) > 0 {
+		dataset.Proxies = &models.ProxyChanges{
+			CreateProxyRelationshipSchema: b.createProxyRelationshipSchema,
+			RelationshipSchemaCreates:     b.proxyRelationshipSchemas,
+		}
+		for _, recordChanges := range b.proxies {
+			dataset.Proxies.RecordChanges = append(dataset.Proxies.RecordChanges, *recordChanges)
+		}
+	}
+	if err := validation.Validate(dataset); err != nil {
+		return models.Dataset{}, fmt.Errorf("error building changeset: %w", err)
+	}
+	return dataset, nil
+}
@@ -0,0 +1,198 @@
+package changeset_test
+
+import (
+	"github.com/pennsieve/processor-post-metadata/client/changeset"
+	"github.com/pennsieve/processor-post-metadata/client/clienttest"
+	"github.com/pennsieve/processor-post-metadata/client/models"
+	"github.com/pennsieve/processor-post-metadata/client/validation"
+	"github.com/pennsieve/processor-pre-metadata/client/models/datatypes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestBuilder(t *testing.T) {
+	for scenario, testFunc := range map[string]func(t *testing.T){
+		"new models, links, relationships and packages": buildNewModels,
+		"existing models and records":                   buildExistingModels,
+		"proxy relationship schemas":                    buildProxyRelationshipSchemas,
+		"declaration mistakes":                          buildDeclarationMistakes,
+		"invalid changeset":                             buildInvalidChangeset,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			testFunc(t)
+		})
+	}
+}
+
+func newModelCreate(name string) models.ModelCreateParams {
+	model := clienttest.NewModelCreate()
+	model.Name = name
+	return model
+}
+
+func buildNewModels(t *testing.T) {
+	subjectModel := newModelCreate("subject")
+	sampleModel := newModelCreate("sample")
+	property := clienttest.NewPropertyCreateSimple(t, datatypes.StringType)
+	value := models.RecordValue{Name: property.Name, Value: "a"}
+
+	builder := changeset.NewBuilder().CreateProxyRelationshipSchema()
+	subject := builder.NewModel(subjectModel, property)
+	sample := builder.NewModel(sampleModel)
+	subjectRecord := subject.AddRecord(value)
+	sampleRecord := sample.AddRecordWithID("sample-1")
+	builder.NewLink(sample, subject, models.SchemaLinkedPropertyCreate{Name: "subject", DisplayName: "Subject"}).
+		Add(sampleRecord, subjectRecord)
+	builder.NewRelationship(changeset.RelationshipSchema{Name: "derived_from", DisplayName: "Derived From", From: sample}).
+		Add(sample, sampleRecord, subject, subjectRecord)
+	sample.AttachPackages(sampleRecord, "N:package:1", "N:package:2")
+	sample.AttachPackage(sampleRecord, "N:package:3", models.ProxyRelationship{Direction: models.ToTarget})
+
+	dataset, err := builder.Build()
+	require.NoError(t, err)
+
+	assert.NotEmpty(t, subjectRecord)
+	assert.Equal(t, models.ExternalInstanceID("sample-1"), sampleRecord)
+	assert.Equal(t, models.Dataset{
+		Models: models.ModelChanges{
+			Creates: []models.ModelCreate{
+				{
+					Create:  models.ModelPropsCreate{Model: subjectModel, Properties: models.PropertiesCreateParams{property}},
+					Records: []models.RecordCreate{{ExternalID: subjectRecord, RecordValues: models.RecordValues{Values: []models.RecordValue{value}}}},
+				},
+				{
+					Create:  models.ModelPropsCreate{Model: sampleModel},
+					Records: []models.RecordCreate{{ExternalID: sampleRecord}},
+				},
+			},
+		},
+		LinkedProperties: []models.LinkedPropertyChanges{{
+			FromModelName: "sample",
+			ToModelName:   "subject",
+			Create:        &models.SchemaLinkedPropertyCreate{Name: "subject", DisplayName: "Subject"},
+			Instances: models.InstanceChanges{
+				Create: []models.InstanceLinkedPropertyCreate{{FromExternalID: sampleRecord, ToExternalID: subjectRecord}},
+			},
+		}},
+		Relationships: []models.RelationshipChanges{{
+			Create: &models.RelationshipSchemaCreate{Name: "derived_from", DisplayName: "Derived From", FromModelName: "sample"},
+			Instances: models.RelationshipInstanceChanges{
+				Create: []models.RelationshipInstanceCreate{{
+					FromModelName:  "sample",
+					FromExternalID: sampleRecord,
+					ToModelName:    "subject",
+					ToExternalID:   subjectRecord,
+				}},
+			},
+		}},
+		Proxies: &models.ProxyChanges{
+			CreateProxyRelationshipSchema: true,
+			RecordChanges: []models.ProxyRecordChanges{{
+				ModelName:        "sample",
+				RecordExternalID: sampleRecord,
+				NodeIDCreates:    []string{"N:package:1", "N:package:2"},
+				PackageCreates: []models.ProxyPackageCreate{{
+					NodeID:            "N:package:3",
+					ProxyRelationship: models.ProxyRelationship{Direction: models.ToTarget},
+				}},
+			}},
+		},
+	}, dataset)
+}
+
+func buildExistingModels(t *testing.T) {
+	subjectID := clienttest.NewPennsieveSchemaID()
+	sampleID := clienttest.NewPennsieveSchemaID()
+	linkID := clienttest.NewPennsieveSchemaID()
+	subjectRecordID := clienttest.NewPennsieveInstanceID()
+
+	builder := changeset.NewBuilder()
+	subject := builder.ExistingModel("subject", subjectID)
+	sample := builder.ExistingModel("sample", sampleID)
+	subjectRecord := subject.ExistingRecord(subjectRecordID)
+	sampleRecord := sample.AddRecord()
+	builder.ExistingLink(sample, subject, linkID).Add(sampleRecord, subjectRecord)
+
+	dataset, err := builder.Build()
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]models.PennsieveSchemaID{"subject": subjectID, "sample": sampleID}, dataset.ExistingModelIDMap)
+	assert.Equal(t, []models.RecordIDMap{{
+		ModelName:           "subject",
+		ExternalToPennsieve: map[models.ExternalInstanceID]models.PennsieveInstanceID{subjectRecord: subjectRecordID},
+	}}, dataset.RecordIDMaps)
+	assert.Equal(t, []models.ModelUpdate{{
+		ID:      sampleID,
+		Records: models.RecordChanges{Create: []models.RecordCreate{{ExternalID: sampleRecord}}},
+	}}, dataset.Models.Updates)
+	assert.Empty(t, dataset.Models.Creates)
+	require.Len(t, dataset.LinkedProperties, 1)
+	assert.Equal(t, linkID, dataset.LinkedProperties[0].ID)
+	assert.Nil(t, dataset.Proxies)
+}
+
+func buildProxyRelationshipSchemas(t *testing.T) {
+	builder := changeset.NewBuilder().CreateProxyRelationshipSchema()
+	sample := builder.NewModel(newModelCreate("sample"))
+	builder.NewProxyRelationshipSchema(changeset.RelationshipSchema{Name: "derived_from", DisplayName: "Derived From", From: sample})
+	sampleRecord := sample.AddRecord()
+	sample.AttachPackage(sampleRecord, "N:package:1", models.ProxyRelationship{RelationshipType: "derived_from"})
+
+	dataset, err := builder.Build()
+	require.NoError(t, err)
+
+	require.NotNil(t, dataset.Proxies)
+	assert.True(t, dataset.Proxies.CreateProxyRelationshipSchema)
+	assert.Equal(t, []models.RelationshipSchemaCreate{{Name: "derived_from", DisplayName: "Derived From", FromModelName: "sample"}},
+		dataset.Proxies.RelationshipSchemaCreates)
+	assert.Equal(t, []models.ProxyRecordChanges{{
+		ModelName:        "sample",
+		RecordExternalID: sampleRecord,
+		PackageCreates: []models.ProxyPackageCreate{{
+			NodeID:            "N:package:1",
+			ProxyRelationship: models.ProxyRelationship{RelationshipType: "derived_from"},
+		}},
+	}}, dataset.Proxies.RecordChanges)
+}
+
+func buildDeclarationMistakes(t *testing.T) {
+	builder := changeset.NewBuilder()
+	subject := builder.NewModel(newModelCreate("subject"))
+	builder.NewModel(newModelCreate("subject"))
+	sample := builder.NewModel(newModelCreate("sample"))
+	subjectRecord := subject.AddRecordWithID("record-1")
+	subject.AddRecordWithID("record-1")
+	subject.ExistingRecord(clienttest.NewPennsieveInstanceID())
+	sampleRecord := sample.AddRecord()
+	// records swapped
+	builder.NewLink(sample, subject, models.SchemaLinkedPropertyCreate{Name: "subject", DisplayName: "Subject"}).
+		Add(subjectRecord, sampleRecord)
+	other := changeset.NewBuilder().NewModel(newModelCreate("other"))
+	builder.NewRelationship(changeset.RelationshipSchema{Name: "derived_from", DisplayName: "Derived From"}).
+		Add(other, "record", subject, subjectRecord)
+	sample.AttachPackages("unknown", "N:package:1")
+	builder.NewProxyRelationshipSchema(changeset.RelationshipSchema{Name: "is_about"}).
+		NewProxyRelationshipSchema(changeset.RelationshipSchema{Name: "is_about"})
+
+	_, err := builder.Build()
+	require.Error(t, err)
+	assert.ErrorContains(t, err, `model "subject" is declared more than once`)
+	assert.ErrorContains(t, err, `record "record-1" of model "subject" is added more than once`)
+	assert.ErrorContains(t, err, `model "subject" is new, so record`)
+	assert.ErrorContains(t, err, `record "record-1" was not added to model "sample"`)
+	assert.ErrorContains(t, err, "model was not declared with this builder")
+	assert.ErrorContains(t, err, `record "unknown" was not added to model "sample"`)
+	assert.ErrorContains(t, err, `proxy relationship schema "is_about" is declared more than once`)
+}
+
+func buildInvalidChangeset(t *testing.T) {
+	builder := changeset.NewBuilder().CreateProxyRelationshipSchema()
+	subject := builder.NewModel(newModelCreate("subject"))
+	subject.AttachPackage(subject.AddRecord(), "N:package:1", models.ProxyRelationship{Direction: "sideways"})
+
+	_, err := builder.Build()
+	var validationErr *validation.Error
+	require.ErrorAs(t, err, &validationErr)
+	assert.Len(t, validationErr.Problems, 1)
+}
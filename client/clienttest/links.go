@@ -20,3 +20,11 @@ func NewInstanceLinkedPropertyCreate() models.InstanceLinkedPropertyCreate {
 		ToExternalID:   NewExternalInstanceID(),
 	}
 }
+
+func NewRelationshipSchemaCreate() models.RelationshipSchemaCreate {
+	return models.RelationshipSchemaCreate{
+		Name:        uuid.NewString(),
+		DisplayName: uuid.NewString(),
+		Description: uuid.NewString(),
+	}
+}
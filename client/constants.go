@@ -1,3 +1,6 @@
 package client
 
 const Filename = "changeset.json"
+
+// StreamFilename is the name of a streamed changeset. See the stream package.
+const StreamFilename = "changeset.ndjson"
@@ -0,0 +1,610 @@
+// Package diff computes the changeset that takes the metadata of a dataset from its current state, as downloaded by
+// processor-pre-metadata, to a desired state, so that producers can describe what the dataset should contain instead
+// of writing changesets by hand.
+package diff
+
+import (
+	"errors"
+	"fmt"
+	clientmodels "github.com/pennsieve/processor-post-metadata/client/models"
+	"github.com/pennsieve/processor-post-metadata/client/validation"
+	"github.com/pennsieve/processor-pre-metadata/client/models/instance"
+	"github.com/pennsieve/processor-pre-metadata/client/models/schema"
+	"slices"
+	"sort"
+)
+
+// Current is the metadata of a dataset in the form processor-pre-metadata downloads it. Relationships other than
+// package proxies are not part of it, so Changeset neither creates nor deletes them.
+type Current struct {
+	Models           []CurrentModel
+	LinkedProperties []CurrentLinkedProperty
+	// ProxySchema is the "belongs_to" relationship schema of package proxies, or nil if the dataset has none yet
+	ProxySchema *schema.NullableRelationship
+}
+
+// CurrentModel is a model with its properties, records and package proxies
+type CurrentModel struct {
+	schema.Model
+	Records []instance.Record
+	// Proxies holds the package proxies of the records of the model by record ID, as returned by
+	// Reader.GetProxiesForModel
+	Proxies map[string][]instance.Proxy
+}
+
+// CurrentLinkedProperty is a linked property schema, whose From and To are model IDs, with its instances
+type CurrentLinkedProperty struct {
+	schema.LinkedProperty
+	Instances []instance.LinkedProperty
+}
+
+// Desired is the metadata a dataset should have. Models, records, links and packages that are current but not
+// desired are deleted.
+type Desired struct {
+	Models []Model
+	Links  []Link
+}
+
+// Model is a desired model with all its properties and records
+type Model struct {
+	clientmodels.ModelCreateParams
+	Properties clientmodels.PropertiesCreateParams
+	// KeyProperty names the property whose value identifies a record, so that current records can be matched with
+	// desired ones. If empty, the concept title property is used.
+	KeyProperty string
+	// Records are the desired records by external ID. The external IDs are used to refer to the records in Links.
+	Records map[clientmodels.ExternalInstanceID]Record
+}
+
+// Record is a desired record with all its values
+type Record struct {
+	Values []clientmodels.RecordValue
+	// PackageNodeIDs are the node IDs of the packages that should belong to the record
+	PackageNodeIDs []string
+}
+
+// Link is a desired linked property schema with all its instances
+type Link struct {
+	clientmodels.SchemaLinkedPropertyCreate
+	FromModelName string
+	ToModelName   string
+	Instances     []clientmodels.InstanceLinkedPropertyCreate
+}
+
+// Changeset returns the smallest changeset that takes a dataset from current to desired. Current records are matched
+// with desired ones by the value of the model's key property, and are updated only if a value of a desired property
+// differs. Only the display name of a model, and the display name, data type and required flag of a property are
+// compared, since those are the parts of the schema current holds. Returns an error if desired is inconsistent, for
+// example if a link refers to a record that is not desired, if a key value is missing or repeated, or if the
+// changeset does not pass validation.Validate.
+func Changeset(current Current, desired Desired) (clientmodels.Dataset, error) {
+	d := newDiffer(current)
+	d.diffModels(desired.Models)
+	d.diffLinks(desired.Links)
+	d.diffProxies()
+	if len(d.errs) > 0 {
+		return clientmodels.Dataset{}, fmt.Errorf("error computing changeset: %w", errors.Join(d.errs...))
+	}
+	dataset := d.dataset()
+	if err := validation.Validate(dataset); err != nil {
+		return clientmodels.Dataset{}, fmt.Errorf("error computing changeset: %w", err)
+	}
+	return dataset, nil
+}
+
+type differ struct {
+	current           Current
+	currentModels     map[string]*CurrentModel
+	currentModelsByID map[string]*CurrentModel
+	// models holds the state of each desired model, in the order desired
+	models       []*modelState
+	modelsByName map[string]*modelState
+	// deletedModels are the current models that are not desired, in current order
+	deletedModels []*CurrentModel
+
+	changes clientmodels.Dataset
+	// existingModelIDs holds the IDs of the current models that the changeset refers to by name
+	existingModelIDs map[string]clientmodels.PennsieveSchemaID
+	// recordIDMaps holds the RecordIDMap of each model that the changeset refers to by name, in the order first used
+	recordIDMaps []clientmodels.RecordIDMap
+	errs         []error
+}
+
+// modelState is a desired model along with the current model of the same name, if any
+type modelState struct {
+	desired Model
+	// current is nil for a new model
+	current *CurrentModel
+	// externalIDs are the external IDs of the desired records, sorted
+	externalIDs []clientmodels.ExternalInstanceID
+	// matches maps the external IDs of desired records to the IDs of the current records they match
+	matches map[clientmodels.ExternalInstanceID]clientmodels.PennsieveInstanceID
+	// matched holds the IDs of the current records in matches
+	matched map[clientmodels.PennsieveInstanceID]bool
+	// deletedRecords are the current records that match no desired record, in current order
+	deletedRecords []instance.Record
+}
+
+func newDiffer(current Current) *differ {
+	d := &differ{
+		current:           current,
+		currentModels:     map[string]*CurrentModel{},
+		currentModelsByID: map[string]*CurrentModel{},
+		modelsByName:      map[string]*modelState{},
+		existingModelIDs:  map[string]clientmodels.PennsieveSchemaID{},
+	}
+	for i := range current.Models {
+		model := &current.Models[i]
+		d.currentModels[model.Name] = model
+		d.currentModelsByID[model.ID] = model
+	}
+	return d
+}
+
+func (d *differ) addError(format string, args ...any) {
+	d.errs = append(d.errs, fmt.Errorf(format, args...))
+}
+
+// existingModel records that the changeset refers to the current model by name
+func (d *differ) existingModel(model *CurrentModel) {
+	d.existingModelIDs[model.Name] = clientmodels.PennsieveSchemaID(model.ID)
+}
+
+// mapRecord records that the changeset refers to a current record of the model by externalID
+func (d *differ) mapRecord(model *CurrentModel, externalID clientmodels.ExternalInstanceID, recordID clientmodels.PennsieveInstanceID) {
+	d.existingModel(model)
+	index := slices.IndexFunc(d.recordIDMaps, func(m clientmodels.RecordIDMap) bool { return m.ModelName == model.Name })
+	if index < 0 {
+		d.recordIDMaps = append(d.recordIDMaps, clientmodels.NewRecordIDMap(model.Name))
+		index = len(d.recordIDMaps) - 1
+	}
+	d.recordIDMaps[index].ExternalToPennsieve[externalID] = recordID
+}
+
+func (d *differ) diffModels(desiredModels []Model) {
+	for _, desired := range desiredModels {
+		if len(desired.Name) == 0 {
+			d.addError("desired model name is empty")
+			continue
+		}
+		if _, found := d.modelsByName[desired.Name]; found {
+			d.addError("model %q is desired more than once", desired.Name)
+			continue
+		}
+		state := &modelState{
+			desired: desired,
+			current: d.currentModels[desired.Name],
+			matches: map[clientmodels.ExternalInstanceID]clientmodels.PennsieveInstanceID{},
+			matched: map[clientmodels.PennsieveInstanceID]bool{},
+		}
+		for externalID := range desired.Records {
+			state.externalIDs = append(state.externalIDs, externalID)
+		}
+		sort.Slice(state.externalIDs, func(i, j int) bool { return state.externalIDs[i] < state.externalIDs[j] })
+		d.models = append(d.models, state)
+		d.modelsByName[desired.Name] = state
+		if state.current == nil {
+			d.createModel(state)
+		} else {
+			d.updateModel(state)
+		}
+	}
+	for i := range d.current.Models {
+		current := &d.current.Models[i]
+		if _, desired := d.modelsByName[current.Name]; desired {
+			continue
+		}
+		d.deletedModels = append(d.deletedModels, current)
+		modelDelete := clientmodels.ModelDelete{ID: clientmodels.PennsieveSchemaID(current.ID)}
+		for _, record := range current.Records {
+			modelDelete.Records = append(modelDelete.Records, clientmodels.PennsieveInstanceID(record.ID))
+		}
+		d.changes.Models.Deletes = append(d.changes.Models.Deletes, modelDelete)
+	}
+}
+
+func (d *differ) createModel(state *modelState) {
+	modelCreate := clientmodels.ModelCreate{
+		Create: clientmodels.ModelPropsCreate{Model: state.desired.ModelCreateParams, Properties: state.desired.Properties},
+	}
+	for _, externalID := range state.externalIDs {
+		modelCreate.Records = append(modelCreate.Records, recordCreate(externalID, state.desired.Records[externalID]))
+	}
+	d.changes.Models.Creates = append(d.changes.Models.Creates, modelCreate)
+}
+
+func (d *differ) updateModel(state *modelState) {
+	modelUpdate := clientmodels.ModelUpdate{ID: clientmodels.PennsieveSchemaID(state.current.ID)}
+	if state.desired.DisplayName != state.current.DisplayName {
+		displayName := state.desired.DisplayName
+		modelUpdate.Model = &clientmodels.ModelUpdateParams{DisplayName: &displayName}
+	}
+	modelUpdate.Properties = d.diffProperties(state)
+	modelUpdate.Records = d.diffRecords(state)
+	if modelUpdate.Model != nil || !modelUpdate.Properties.IsEmpty() || !isEmpty(modelUpdate.Records) {
+		d.changes.Models.Updates = append(d.changes.Models.Updates, modelUpdate)
+	}
+}
+
+func (d *differ) diffProperties(state *modelState) clientmodels.PropertyChanges {
+	var changes clientmodels.PropertyChanges
+	for _, desired := range state.desired.Properties {
+		index := slices.IndexFunc(state.current.Properties, func(p schema.Property) bool { return p.Name == desired.Name })
+		if index < 0 {
+			changes.Create = append(changes.Create, desired)
+			continue
+		}
+		current := state.current.Properties[index]
+		sameDataType, err := equalJSON(current.DataType, desired.DataType)
+		if err != nil {
+			d.addError("error comparing data types of property %q of model %q: %w", desired.Name, state.desired.Name, err)
+			continue
+		}
+		if !sameDataType || current.DisplayName != desired.DisplayName || current.Required != desired.Required {
+			changes.Update = append(changes.Update, clientmodels.PropertyUpdate{
+				ID:                   clientmodels.PennsieveSchemaID(current.ID),
+				PropertyCreateParams: desired,
+			})
+		}
+	}
+	for _, current := range state.current.Properties {
+		if !slices.ContainsFunc(state.desired.Properties, func(p clientmodels.PropertyCreateParams) bool { return p.Name == current.Name }) {
+			changes.Delete = append(changes.Delete, clientmodels.PennsieveSchemaID(current.ID))
+		}
+	}
+	return changes
+}
+
+// diffRecords matches the current records of the model with the desired ones, and returns the changes that take the
+// current records to the desired ones
+func (d *differ) diffRecords(state *modelState) clientmodels.RecordChanges {
+	var changes clientmodels.RecordChanges
+	keyProperty, found := state.keyProperty()
+	if !found {
+		d.addError("model %q has no key property and no concept title property to match records with", state.desired.Name)
+		return changes
+	}
+	currentByKey := map[string]instance.Record{}
+	for _, record := range state.current.Records {
+		key, hasKey, err := keyOf(currentValues(record)[keyProperty])
+		if err != nil {
+			d.addError("error reading key of record %s of model %q: %w", record.ID, state.desired.Name, err)
+			continue
+		}
+		if !hasKey {
+			continue
+		}
+		if _, duplicate := currentByKey[key]; duplicate {
+			d.addError("more than one record of model %q has %s %s", state.desired.Name, keyProperty, key)
+			continue
+		}
+		currentByKey[key] = record
+	}
+	desiredKeys := map[string]clientmodels.ExternalInstanceID{}
+	for _, externalID := range state.externalIDs {
+		desired := state.desired.Records[externalID]
+		key, hasKey, err := keyOf(desiredValues(desired)[keyProperty])
+		if err != nil {
+			d.addError("error reading key of record %q of model %q: %w", externalID, state.desired.Name, err)
+			continue
+		}
+		if !hasKey {
+			d.addError("record %q of model %q has no value for key property %q", externalID, state.desired.Name, keyProperty)
+			continue
+		}
+		if other, duplicate := desiredKeys[key]; duplicate {
+			d.addError("records %q and %q of model %q have the same %s %s", other, externalID, state.desired.Name, keyProperty, key)
+			continue
+		}
+		desiredKeys[key] = externalID
+		current, found := currentByKey[key]
+		if !found {
+			changes.Create = append(changes.Create, recordCreate(externalID, desired))
+			continue
+		}
+		recordID := clientmodels.PennsieveInstanceID(current.ID)
+		state.matches[externalID] = recordID
+		state.matched[recordID] = true
+		same, err := state.sameValues(current, desired)
+		if err != nil {
+			d.addError("error comparing values of record %q of model %q: %w", externalID, state.desired.Name, err)
+			continue
+		}
+		if !same {
+			changes.Update = append(changes.Update, clientmodels.RecordUpdate{
+				PennsieveID:  recordID,
+				RecordValues: clientmodels.RecordValues{Values: desired.Values},
+			})
+		}
+	}
+	for _, current := range state.current.Records {
+		if !state.matched[clientmodels.PennsieveInstanceID(current.ID)] {
+			state.deletedRecords = append(state.deletedRecords, current)
+			changes.Delete = append(changes.Delete, clientmodels.PennsieveInstanceID(current.ID))
+		}
+	}
+	return changes
+}
+
+// keyProperty returns KeyProperty, or the name of the concept title property if it is empty
+func (s *modelState) keyProperty() (string, bool) {
+	if len(s.desired.KeyProperty) > 0 {
+		return s.desired.KeyProperty, true
+	}
+	index := slices.IndexFunc(s.desired.Properties, func(p clientmodels.PropertyCreateParams) bool { return p.ConceptTitle })
+	if index < 0 {
+		return "", false
+	}
+	return s.desired.Properties[index].Name, true
+}
+
+// sameValues returns true if the current record has the desired value for each desired property
+func (s *modelState) sameValues(current instance.Record, desired Record) (bool, error) {
+	currentByName := currentValues(current)
+	desiredByName := desiredValues(desired)
+	for _, property := range s.desired.Properties {
+		same, err := equalValues(currentByName[property.Name], desiredByName[property.Name])
+		if err != nil || !same {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// recordID returns the ID of the current record that the desired record matches, if any
+func (s *modelState) recordID(externalID clientmodels.ExternalInstanceID) (clientmodels.PennsieveInstanceID, bool) {
+	recordID, found := s.matches[externalID]
+	return recordID, found
+}
+
+func (d *differ) diffLinks(desiredLinks []Link) {
+	// kept holds the IDs of the current link schemas that are desired
+	kept := map[string]bool{}
+	for _, desired := range desiredLinks {
+		from, to := d.modelsByName[desired.FromModelName], d.modelsByName[desired.ToModelName]
+		if from == nil || to == nil {
+			d.addError("link %q goes from model %q to model %q, which are not both desired", desired.Name, desired.FromModelName, desired.ToModelName)
+			continue
+		}
+		var current *CurrentLinkedProperty
+		if from.current != nil {
+			current = d.currentLink(from.current.ID, desired.Name)
+		}
+		if current != nil && (to.current == nil || current.To != to.current.ID) {
+			d.addError("link %q of model %q cannot be changed to go to model %q", desired.Name, desired.FromModelName, desired.ToModelName)
+			continue
+		}
+		linkChanges := clientmodels.LinkedPropertyChanges{FromModelName: desired.FromModelName, ToModelName: desired.ToModelName}
+		if current == nil {
+			create := desired.SchemaLinkedPropertyCreate
+			linkChanges.Create = &create
+		} else {
+			kept[current.ID] = true
+			linkChanges.ID = clientmodels.PennsieveSchemaID(current.ID)
+			if current.DisplayName != desired.DisplayName || current.Position != desired.Position {
+				linkChanges.Update = &clientmodels.SchemaLinkedPropertyUpdate{
+					Name:        desired.Name,
+					DisplayName: desired.DisplayName,
+					Position:    desired.Position,
+				}
+			}
+		}
+		linkChanges.Instances = d.diffLinkInstances(desired, from, to, current)
+		if linkChanges.Create == nil && linkChanges.Update == nil && len(linkChanges.Instances.Create)+len(linkChanges.Instances.Delete) == 0 {
+			continue
+		}
+		if from.current != nil {
+			d.existingModel(from.current)
+		}
+		if to.current != nil {
+			d.existingModel(to.current)
+		}
+		d.changes.LinkedProperties = append(d.changes.LinkedProperties, linkChanges)
+	}
+	for _, current := range d.current.LinkedProperties {
+		if kept[current.ID] {
+			continue
+		}
+		from, to := d.currentModelsByID[current.From], d.currentModelsByID[current.To]
+		if from == nil || to == nil {
+			d.addError("current link %q goes from model %s to model %s, which are not both current", current.Name, current.From, current.To)
+			continue
+		}
+		d.existingModel(from)
+		d.existingModel(to)
+		linkChanges := clientmodels.LinkedPropertyChanges{
+			FromModelName: from.Name,
+			ToModelName:   to.Name,
+			ID:            clientmodels.PennsieveSchemaID(current.ID),
+			Delete:        true,
+		}
+		for _, linkInstance := range current.Instances {
+			linkChanges.Instances.Delete = append(linkChanges.Instances.Delete, linkDelete(linkInstance))
+		}
+		d.changes.LinkedProperties = append(d.changes.LinkedProperties, linkChanges)
+	}
+}
+
+// currentLink returns the current link schema of the model with the given name, if any
+func (d *differ) currentLink(fromModelID string, name string) *CurrentLinkedProperty {
+	index := slices.IndexFunc(d.current.LinkedProperties, func(l CurrentLinkedProperty) bool { return l.From == fromModelID && l.Name == name })
+	if index < 0 {
+		return nil
+	}
+	return &d.current.LinkedProperties[index]
+}
+
+// linkedRecords are the IDs of the records a link instance goes from and to
+type linkedRecords struct {
+	from string
+	to   string
+}
+
+// diffLinkInstances returns the changes that take the instances of the current link schema, which may be nil, to
+// the desired ones
+func (d *differ) diffLinkInstances(desired Link, from *modelState, to *modelState, current *CurrentLinkedProperty) clientmodels.InstanceChanges {
+	var changes clientmodels.InstanceChanges
+	// kept holds the IDs of the current instances that are desired
+	kept := map[string]bool{}
+	linkedFrom := map[clientmodels.ExternalInstanceID]bool{}
+	// currentByRecords holds the first current instance between each pair of records
+	currentByRecords := map[linkedRecords]instance.LinkedProperty{}
+	if current != nil {
+		for _, linkInstance := range current.Instances {
+			key := linkedRecords{from: linkInstance.From, to: linkInstance.To}
+			if _, found := currentByRecords[key]; !found {
+				currentByRecords[key] = linkInstance
+			}
+		}
+	}
+	for _, instanceCreate := range desired.Instances {
+		_, fromFound := from.desired.Records[instanceCreate.FromExternalID]
+		_, toFound := to.desired.Records[instanceCreate.ToExternalID]
+		if !fromFound || !toFound {
+			d.addError("link %q goes from record %q of model %q to record %q of model %q, which are not both desired",
+				desired.Name, instanceCreate.FromExternalID, from.desired.Name, instanceCreate.ToExternalID, to.desired.Name)
+			continue
+		}
+		if linkedFrom[instanceCreate.FromExternalID] {
+			d.addError("record %q of model %q has more than one %q link", instanceCreate.FromExternalID, from.desired.Name, desired.Name)
+			continue
+		}
+		linkedFrom[instanceCreate.FromExternalID] = true
+		fromRecordID, fromExists := from.recordID(instanceCreate.FromExternalID)
+		toRecordID, toExists := to.recordID(instanceCreate.ToExternalID)
+		if fromExists && toExists {
+			if linkInstance, found := currentByRecords[linkedRecords{from: string(fromRecordID), to: string(toRecordID)}]; found {
+				kept[linkInstance.ID] = true
+				continue
+			}
+		}
+		if fromExists {
+			d.mapRecord(from.current, instanceCreate.FromExternalID, fromRecordID)
+		}
+		if toExists {
+			d.mapRecord(to.current, instanceCreate.ToExternalID, toRecordID)
+		}
+		changes.Create = append(changes.Create, instanceCreate)
+	}
+	if current != nil {
+		for _, linkInstance := range current.Instances {
+			if !kept[linkInstance.ID] {
+				changes.Delete = append(changes.Delete, linkDelete(linkInstance))
+			}
+		}
+	}
+	return changes
+}
+
+func (d *differ) diffProxies() {
+	var recordChanges []clientmodels.ProxyRecordChanges
+	for _, state := range d.models {
+		for _, externalID := range state.externalIDs {
+			desired := state.desired.Records[externalID]
+			changes := clientmodels.ProxyRecordChanges{ModelName: state.desired.Name, RecordExternalID: externalID}
+			var currentProxies []instance.Proxy
+			recordID, exists := state.recordID(externalID)
+			if exists {
+				currentProxies = state.current.Proxies[string(recordID)]
+			}
+			for _, nodeID := range desired.PackageNodeIDs {
+				if !slices.ContainsFunc(currentProxies, func(p instance.Proxy) bool { return p.Content.NodeID == nodeID }) {
+					changes.NodeIDCreates = append(changes.NodeIDCreates, nodeID)
+				}
+			}
+			for _, proxy := range currentProxies {
+				if !slices.Contains(desired.PackageNodeIDs, proxy.Content.NodeID) {
+					changes.InstanceIDDeletes = append(changes.InstanceIDDeletes, clientmodels.PennsieveInstanceID(proxy.ID))
+				}
+			}
+			if len(changes.NodeIDCreates)+len(changes.InstanceIDDeletes) == 0 {
+				continue
+			}
+			if exists {
+				d.mapRecord(state.current, externalID, recordID)
+			}
+			recordChanges = append(recordChanges, changes)
+		}
+		if state.current != nil {
+			recordChanges = append(recordChanges, d.deleteProxies(state.current, state.deletedRecords)...)
+		}
+	}
+	for _, current := range d.deletedModels {
+		recordChanges = append(recordChanges, d.deleteProxies(current, current.Records)...)
+	}
+	if len(recordChanges) == 0 {
+		return
+	}
+	d.changes.Proxies = &clientmodels.ProxyChanges{RecordChanges: recordChanges}
+	for _, changes := range recordChanges {
+		if len(changes.NodeIDCreates) > 0 {
+			d.changes.Proxies.CreateProxyRelationshipSchema = d.current.ProxySchema == nil
+			break
+		}
+	}
+}
+
+// deleteProxies returns the changes that delete the package proxies of records of the model that are to be deleted.
+// The external ID of each such record is its Pennsieve ID.
+func (d *differ) deleteProxies(model *CurrentModel, records []instance.Record) []clientmodels.ProxyRecordChanges {
+	var recordChanges []clientmodels.ProxyRecordChanges
+	for _, record := range records {
+		proxies := model.Proxies[record.ID]
+		if len(proxies) == 0 {
+			continue
+		}
+		recordID := clientmodels.PennsieveInstanceID(record.ID)
+		externalID := clientmodels.ExternalInstanceID(record.ID)
+		d.mapRecord(model, externalID, recordID)
+		changes := clientmodels.ProxyRecordChanges{ModelName: model.Name, RecordExternalID: externalID}
+		for _, proxy := range proxies {
+			changes.InstanceIDDeletes = append(changes.InstanceIDDeletes, clientmodels.PennsieveInstanceID(proxy.ID))
+		}
+		recordChanges = append(recordChanges, changes)
+	}
+	return recordChanges
+}
+
+// dataset returns the changes with ExistingModelIDMap and RecordIDMaps filled in
+func (d *differ) dataset() clientmodels.Dataset {
+	dataset := d.changes
+	if len(d.existingModelIDs) > 0 {
+		dataset.ExistingModelIDMap = d.existingModelIDs
+	}
+	dataset.RecordIDMaps = d.recordIDMaps
+	return dataset
+}
+
+func recordCreate(externalID clientmodels.ExternalInstanceID, record Record) clientmodels.RecordCreate {
+	return clientmodels.RecordCreate{
+		ExternalID:   externalID,
+		RecordValues: clientmodels.RecordValues{Values: record.Values},
+	}
+}
+
+func linkDelete(linkInstance instance.LinkedProperty) clientmodels.InstanceLinkedPropertyDelete {
+	return clientmodels.InstanceLinkedPropertyDelete{
+		FromRecordID:             clientmodels.PennsieveInstanceID(linkInstance.From),
+		InstanceLinkedPropertyID: clientmodels.PennsieveInstanceID(linkInstance.ID),
+	}
+}
+
+func isEmpty(changes clientmodels.RecordChanges) bool {
+	return len(changes.Delete)+len(changes.Create)+len(changes.Update)+len(changes.Upsert) == 0
+}
+
+func currentValues(record instance.Record) map[string]any {
+	values := make(map[string]any, len(record.Values))
+	for _, value := range record.Values {
+		values[value.Name] = value.Value
+	}
+	return values
+}
+
+func desiredValues(record Record) map[string]any {
+	values := make(map[string]any, len(record.Values))
+	for _, value := range record.Values {
+		values[value.Name] = value.Value
+	}
+	return values
+}
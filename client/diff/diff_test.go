@@ -0,0 +1,348 @@
+package diff_test
+
+import (
+	"encoding/json"
+	"github.com/pennsieve/processor-post-metadata/client/clienttest"
+	"github.com/pennsieve/processor-post-metadata/client/diff"
+	"github.com/pennsieve/processor-post-metadata/client/models"
+	"github.com/pennsieve/processor-pre-metadata/client/models/datatypes"
+	"github.com/pennsieve/processor-pre-metadata/client/models/instance"
+	"github.com/pennsieve/processor-pre-metadata/client/models/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestChangeset(t *testing.T) {
+	for scenario, testFunc := range map[string]func(t *testing.T){
+		"empty dataset":                     diffEmptyDataset,
+		"no changes":                        diffNoChanges,
+		"record, property and link changes": diffRecordPropertyAndLinkChanges,
+		"undesired model":                   diffUndesiredModel,
+		"inconsistent desired state":        diffInconsistentDesiredState,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			testFunc(t)
+		})
+	}
+}
+
+// fixture is a dataset with a subject model and a sample model linked to it. Each has a string "id" concept title
+// property, and samples have a Long "count" property. sample-1 of subject-1 has package N:package:1.
+type fixture struct {
+	current         diff.Current
+	desired         diff.Desired
+	subjectModelID  string
+	sampleModelID   string
+	subject1ID      string
+	sample1ID       string
+	sample2ID       string
+	linkSchemaID    string
+	linkInstanceID  string
+	proxyID         string
+	idProperty      models.PropertyCreateParams
+	countProperty   models.PropertyCreateParams
+	countPropertyID string
+}
+
+func newFixture(t *testing.T) fixture {
+	f := fixture{
+		subjectModelID:  clienttest.NewPennsieveSchemaID().String(),
+		sampleModelID:   clienttest.NewPennsieveSchemaID().String(),
+		subject1ID:      string(clienttest.NewPennsieveInstanceID()),
+		sample1ID:       string(clienttest.NewPennsieveInstanceID()),
+		sample2ID:       string(clienttest.NewPennsieveInstanceID()),
+		linkSchemaID:    clienttest.NewPennsieveSchemaID().String(),
+		linkInstanceID:  string(clienttest.NewPennsieveInstanceID()),
+		proxyID:         string(clienttest.NewPennsieveInstanceID()),
+		idProperty:      newProperty(t, "id", datatypes.StringType, true),
+		countProperty:   newProperty(t, "count", datatypes.LongType, false),
+		countPropertyID: clienttest.NewPennsieveSchemaID().String(),
+	}
+	idPropertyID := clienttest.NewPennsieveSchemaID().String()
+	f.current = diff.Current{
+		Models: []diff.CurrentModel{
+			{
+				Model: schema.Model{
+					Element:    schema.Element{ID: f.subjectModelID, Type: string(schema.ModelType), Name: "subject", DisplayName: "Subject"},
+					Properties: []schema.Property{currentProperty(idPropertyID, f.idProperty)},
+				},
+				Records: []instance.Record{{ID: f.subject1ID, Values: []instance.Property{{Name: "id", Value: "subject-1"}}}},
+			},
+			{
+				Model: schema.Model{
+					Element: schema.Element{ID: f.sampleModelID, Type: string(schema.ModelType), Name: "sample", DisplayName: "Sample"},
+					Properties: []schema.Property{
+						currentProperty(idPropertyID, f.idProperty),
+						currentProperty(f.countPropertyID, f.countProperty),
+					},
+				},
+				Records: []instance.Record{
+					// counts downloaded from Pennsieve are float64
+					{ID: f.sample1ID, Values: []instance.Property{{Name: "id", Value: "sample-1"}, {Name: "count", Value: float64(3)}}},
+					{ID: f.sample2ID, Values: []instance.Property{{Name: "id", Value: "sample-2"}, {Name: "count", Value: nil}}},
+				},
+				Proxies: map[string][]instance.Proxy{f.sample1ID: {newProxy(f.proxyID, "N:package:1")}},
+			},
+		},
+		LinkedProperties: []diff.CurrentLinkedProperty{{
+			LinkedProperty: schema.LinkedProperty{
+				Element:  schema.Element{ID: f.linkSchemaID, Type: string(schema.LinkedPropertyType), Name: "subject", DisplayName: "Subject"},
+				From:     f.sampleModelID,
+				To:       f.subjectModelID,
+				Position: 1,
+			},
+			Instances: []instance.LinkedProperty{{ID: f.linkInstanceID, From: f.sample1ID, To: f.subject1ID, Name: "subject"}},
+		}},
+		ProxySchema: &schema.NullableRelationship{ID: clienttest.NewPennsieveSchemaID().String(), Name: schema.ProxyName, DisplayName: schema.ProxyDisplayName},
+	}
+	f.desired = diff.Desired{
+		Models: []diff.Model{
+			{
+				ModelCreateParams: models.ModelCreateParams{Name: "subject", DisplayName: "Subject"},
+				Properties:        models.PropertiesCreateParams{f.idProperty},
+				Records: map[models.ExternalInstanceID]diff.Record{
+					"s1": {Values: []models.RecordValue{{Name: "id", Value: "subject-1"}}},
+				},
+			},
+			{
+				ModelCreateParams: models.ModelCreateParams{Name: "sample", DisplayName: "Sample"},
+				Properties:        models.PropertiesCreateParams{f.idProperty, f.countProperty},
+				Records: map[models.ExternalInstanceID]diff.Record{
+					// a producer's count is an int
+					"a1": {Values: []models.RecordValue{{Name: "id", Value: "sample-1"}, {Name: "count", Value: 3}}, PackageNodeIDs: []string{"N:package:1"}},
+					"a2": {Values: []models.RecordValue{{Name: "id", Value: "sample-2"}}},
+				},
+			},
+		},
+		Links: []diff.Link{{
+			SchemaLinkedPropertyCreate: models.SchemaLinkedPropertyCreate{Name: "subject", DisplayName: "Subject", Position: 1},
+			FromModelName:              "sample",
+			ToModelName:                "subject",
+			Instances:                  []models.InstanceLinkedPropertyCreate{{FromExternalID: "a1", ToExternalID: "s1"}},
+		}},
+	}
+	return f
+}
+
+func newProperty(t *testing.T, name string, dataType datatypes.SimpleType, conceptTitle bool) models.PropertyCreateParams {
+	property := clienttest.NewPropertyCreateSimple(t, dataType)
+	property.Name = name
+	property.DisplayName = name
+	property.ConceptTitle = conceptTitle
+	property.Required = conceptTitle
+	return property
+}
+
+func currentProperty(id string, property models.PropertyCreateParams) schema.Property {
+	return schema.Property{
+		ID:          id,
+		Name:        property.Name,
+		DisplayName: property.DisplayName,
+		DataType:    property.DataType,
+		Required:    property.Required,
+	}
+}
+
+func newProxy(id string, nodeID string) instance.Proxy {
+	return instance.Proxy{
+		ProxyID:      instance.ProxyID{ID: id},
+		ProxyPackage: instance.ProxyPackage{Content: instance.ProxyPackageContent{NodeID: nodeID}},
+	}
+}
+
+func diffEmptyDataset(t *testing.T) {
+	f := newFixture(t)
+
+	dataset, err := diff.Changeset(diff.Current{}, f.desired)
+	require.NoError(t, err)
+
+	require.Len(t, dataset.Models.Creates, 2)
+	assert.Equal(t, f.desired.Models[0].ModelCreateParams, dataset.Models.Creates[0].Create.Model)
+	sampleCreate := dataset.Models.Creates[1]
+	assert.Equal(t, models.PropertiesCreateParams{f.idProperty, f.countProperty}, sampleCreate.Create.Properties)
+	// records are in external ID order
+	assert.Equal(t, []models.RecordCreate{
+		{ExternalID: "a1", RecordValues: clienttest.NewRecordValues(f.desired.Models[1].Records["a1"].Values...)},
+		{ExternalID: "a2", RecordValues: clienttest.NewRecordValues(f.desired.Models[1].Records["a2"].Values...)},
+	}, sampleCreate.Records)
+	assert.Empty(t, dataset.Models.Updates)
+	assert.Empty(t, dataset.Models.Deletes)
+
+	assert.Equal(t, []models.LinkedPropertyChanges{{
+		FromModelName: "sample",
+		ToModelName:   "subject",
+		Create:        &models.SchemaLinkedPropertyCreate{Name: "subject", DisplayName: "Subject", Position: 1},
+		Instances:     models.InstanceChanges{Create: []models.InstanceLinkedPropertyCreate{{FromExternalID: "a1", ToExternalID: "s1"}}},
+	}}, dataset.LinkedProperties)
+
+	assert.Equal(t, &models.ProxyChanges{
+		CreateProxyRelationshipSchema: true,
+		RecordChanges:                 []models.ProxyRecordChanges{{ModelName: "sample", RecordExternalID: "a1", NodeIDCreates: []string{"N:package:1"}}},
+	}, dataset.Proxies)
+	assert.Empty(t, dataset.ExistingModelIDMap)
+	assert.Empty(t, dataset.RecordIDMaps)
+}
+
+func diffNoChanges(t *testing.T) {
+	f := newFixture(t)
+
+	dataset, err := diff.Changeset(f.current, f.desired)
+	require.NoError(t, err)
+	assert.Equal(t, models.Dataset{}, dataset)
+}
+
+func diffRecordPropertyAndLinkChanges(t *testing.T) {
+	f := newFixture(t)
+	subject := &f.desired.Models[0]
+	sample := &f.desired.Models[1]
+	// a new subject, to which sample-1 is now linked
+	subject.Records["s2"] = diff.Record{Values: []models.RecordValue{{Name: "id", Value: "subject-2"}}}
+	f.desired.Links[0].Instances = []models.InstanceLinkedPropertyCreate{{FromExternalID: "a1", ToExternalID: "s2"}}
+	// sample-1 has a new count and another package instead of N:package:1
+	sample.Records["a1"] = diff.Record{
+		Values:         []models.RecordValue{{Name: "id", Value: "sample-1"}, {Name: "count", Value: 4}},
+		PackageNodeIDs: []string{"N:package:2"},
+	}
+	// sample-2 is no longer desired, and samples no longer have a count, but a description
+	delete(sample.Records, "a2")
+	description := newProperty(t, "description", datatypes.StringType, false)
+	sample.Properties = models.PropertiesCreateParams{f.idProperty, description}
+	// the sample display name is new
+	sample.DisplayName = "Specimen"
+
+	dataset, err := diff.Changeset(f.current, f.desired)
+	require.NoError(t, err)
+
+	assert.Equal(t, []models.ModelUpdate{
+		{
+			ID: models.PennsieveSchemaID(f.subjectModelID),
+			Records: models.RecordChanges{
+				Create: []models.RecordCreate{{ExternalID: "s2", RecordValues: clienttest.NewRecordValues(subject.Records["s2"].Values...)}},
+			},
+		},
+		{
+			ID:    models.PennsieveSchemaID(f.sampleModelID),
+			Model: &models.ModelUpdateParams{DisplayName: &sample.DisplayName},
+			Properties: models.PropertyChanges{
+				Delete: []models.PennsieveSchemaID{models.PennsieveSchemaID(f.countPropertyID)},
+				Create: models.PropertiesCreateParams{description},
+			},
+			Records: models.RecordChanges{
+				Delete: []models.PennsieveInstanceID{models.PennsieveInstanceID(f.sample2ID)},
+				// sample-1 is not updated, since count is no longer desired and it has no description either way
+			},
+		},
+	}, dataset.Models.Updates)
+
+	assert.Equal(t, []models.LinkedPropertyChanges{{
+		FromModelName: "sample",
+		ToModelName:   "subject",
+		ID:            models.PennsieveSchemaID(f.linkSchemaID),
+		Instances: models.InstanceChanges{
+			Create: []models.InstanceLinkedPropertyCreate{{FromExternalID: "a1", ToExternalID: "s2"}},
+			Delete: []models.InstanceLinkedPropertyDelete{{
+				FromRecordID:             models.PennsieveInstanceID(f.sample1ID),
+				InstanceLinkedPropertyID: models.PennsieveInstanceID(f.linkInstanceID),
+			}},
+		},
+	}}, dataset.LinkedProperties)
+
+	assert.Equal(t, &models.ProxyChanges{
+		RecordChanges: []models.ProxyRecordChanges{{
+			ModelName:         "sample",
+			RecordExternalID:  "a1",
+			NodeIDCreates:     []string{"N:package:2"},
+			InstanceIDDeletes: []models.PennsieveInstanceID{models.PennsieveInstanceID(f.proxyID)},
+		}},
+	}, dataset.Proxies)
+
+	assert.Equal(t, map[string]models.PennsieveSchemaID{
+		"subject": models.PennsieveSchemaID(f.subjectModelID),
+		"sample":  models.PennsieveSchemaID(f.sampleModelID),
+	}, dataset.ExistingModelIDMap)
+	assert.Equal(t, []models.RecordIDMap{{
+		ModelName:           "sample",
+		ExternalToPennsieve: map[models.ExternalInstanceID]models.PennsieveInstanceID{"a1": models.PennsieveInstanceID(f.sample1ID)},
+	}}, dataset.RecordIDMaps)
+}
+
+func diffUndesiredModel(t *testing.T) {
+	f := newFixture(t)
+	f.desired.Models = f.desired.Models[:1]
+	f.desired.Links = nil
+
+	dataset, err := diff.Changeset(f.current, f.desired)
+	require.NoError(t, err)
+
+	assert.Empty(t, dataset.Models.Creates)
+	assert.Empty(t, dataset.Models.Updates)
+	assert.Equal(t, []models.ModelDelete{{
+		ID:      models.PennsieveSchemaID(f.sampleModelID),
+		Records: []models.PennsieveInstanceID{models.PennsieveInstanceID(f.sample1ID), models.PennsieveInstanceID(f.sample2ID)},
+	}}, dataset.Models.Deletes)
+
+	// the link schema of the deleted model and its instances are deleted first
+	assert.Equal(t, []models.LinkedPropertyChanges{{
+		FromModelName: "sample",
+		ToModelName:   "subject",
+		ID:            models.PennsieveSchemaID(f.linkSchemaID),
+		Delete:        true,
+		Instances: models.InstanceChanges{Delete: []models.InstanceLinkedPropertyDelete{{
+			FromRecordID:             models.PennsieveInstanceID(f.sample1ID),
+			InstanceLinkedPropertyID: models.PennsieveInstanceID(f.linkInstanceID),
+		}}},
+	}}, dataset.LinkedProperties)
+
+	// as are the package proxies of its records, which are known by their Pennsieve IDs
+	sample1ExternalID := models.ExternalInstanceID(f.sample1ID)
+	assert.Equal(t, &models.ProxyChanges{
+		RecordChanges: []models.ProxyRecordChanges{{
+			ModelName:         "sample",
+			RecordExternalID:  sample1ExternalID,
+			InstanceIDDeletes: []models.PennsieveInstanceID{models.PennsieveInstanceID(f.proxyID)},
+		}},
+	}, dataset.Proxies)
+	assert.Equal(t, []models.RecordIDMap{{
+		ModelName:           "sample",
+		ExternalToPennsieve: map[models.ExternalInstanceID]models.PennsieveInstanceID{sample1ExternalID: models.PennsieveInstanceID(f.sample1ID)},
+	}}, dataset.RecordIDMaps)
+	assert.Equal(t, map[string]models.PennsieveSchemaID{
+		"subject": models.PennsieveSchemaID(f.subjectModelID),
+		"sample":  models.PennsieveSchemaID(f.sampleModelID),
+	}, dataset.ExistingModelIDMap)
+}
+
+func diffInconsistentDesiredState(t *testing.T) {
+	f := newFixture(t)
+	sample := &f.desired.Models[1]
+	// no key value
+	sample.Records["a3"] = diff.Record{Values: []models.RecordValue{{Name: "count", Value: 1}}}
+	// same key value as a1
+	sample.Records["a4"] = diff.Record{Values: []models.RecordValue{{Name: "id", Value: "sample-1"}}}
+	f.desired.Links[0].Instances = append(f.desired.Links[0].Instances,
+		models.InstanceLinkedPropertyCreate{FromExternalID: "a2", ToExternalID: "s9"},
+		models.InstanceLinkedPropertyCreate{FromExternalID: "a1", ToExternalID: "s1"},
+	)
+	f.desired.Links = append(f.desired.Links, diff.Link{
+		SchemaLinkedPropertyCreate: models.SchemaLinkedPropertyCreate{Name: "visit"},
+		FromModelName:              "sample",
+		ToModelName:                "visit",
+	})
+	badDataType, err := json.Marshal(datatypes.StringType)
+	require.NoError(t, err)
+	f.current.Models[0].Properties[0].DataType = badDataType[:2]
+
+	_, err = diff.Changeset(f.current, f.desired)
+	require.Error(t, err)
+	for _, expected := range []string{
+		`record "a3" of model "sample" has no value for key property "id"`,
+		`records "a1" and "a4" of model "sample" have the same id "sample-1"`,
+		`link "subject" goes from record "a2" of model "sample" to record "s9" of model "subject", which are not both desired`,
+		`record "a1" of model "sample" has more than one "subject" link`,
+		`link "visit" goes from model "sample" to model "visit", which are not both desired`,
+		`error comparing data types of property "id" of model "subject"`,
+	} {
+		assert.ErrorContains(t, err, expected)
+	}
+}
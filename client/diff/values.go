@@ -0,0 +1,63 @@
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// normalize returns value as it would be decoded from JSON, so that a value given by a producer, such as an int, can
+// be compared with the same value downloaded from Pennsieve, such as a float64
+func normalize(value any) (any, error) {
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling value %v: %w", value, err)
+	}
+	var normalized any
+	if err := json.Unmarshal(bytes, &normalized); err != nil {
+		return nil, fmt.Errorf("error unmarshalling value %s: %w", bytes, err)
+	}
+	return normalized, nil
+}
+
+// equalValues returns true if the two record values are the same once normalized. A missing value is nil.
+func equalValues(current any, desired any) (bool, error) {
+	normalizedCurrent, err := normalize(current)
+	if err != nil {
+		return false, err
+	}
+	normalizedDesired, err := normalize(desired)
+	if err != nil {
+		return false, err
+	}
+	return reflect.DeepEqual(normalizedCurrent, normalizedDesired), nil
+}
+
+// equalJSON returns true if the two JSON documents encode the same value
+func equalJSON(current json.RawMessage, desired json.RawMessage) (bool, error) {
+	var currentValue, desiredValue any
+	if err := json.Unmarshal(current, &currentValue); err != nil {
+		return false, fmt.Errorf("error unmarshalling %s: %w", current, err)
+	}
+	if err := json.Unmarshal(desired, &desiredValue); err != nil {
+		return false, fmt.Errorf("error unmarshalling %s: %w", desired, err)
+	}
+	return reflect.DeepEqual(currentValue, desiredValue), nil
+}
+
+// keyOf returns the key value of a record as a string that can be used to match records. Returns false if value
+// is nil.
+func keyOf(value any) (string, bool, error) {
+	if value == nil {
+		return "", false, nil
+	}
+	normalized, err := normalize(value)
+	if err != nil {
+		return "", false, err
+	}
+	bytes, err := json.Marshal(normalized)
+	if err != nil {
+		return "", false, fmt.Errorf("error marshalling key %v: %w", normalized, err)
+	}
+	return string(bytes), true, nil
+}
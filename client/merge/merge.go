@@ -0,0 +1,610 @@
+// Package merge combines the changesets that several producers make against the same dataset into one.
+package merge
+
+import (
+	"fmt"
+	"github.com/pennsieve/processor-post-metadata/client/models"
+	"github.com/pennsieve/processor-post-metadata/client/validation"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Conflict is a change that contradicts another change in the same or an earlier changeset
+type Conflict struct {
+	// Path locates the later change, for example changesets[1].models.updates[0].records.update[2]
+	Path    string
+	Message string
+}
+
+func (c Conflict) String() string {
+	return fmt.Sprintf("%s: %s", c.Path, c.Message)
+}
+
+// Error is returned by Changesets and lists every Conflict found
+type Error struct {
+	Conflicts []Conflict
+}
+
+func (e *Error) Error() string {
+	var builder strings.Builder
+	_, _ = fmt.Fprintf(&builder, "changesets have %d conflict(s):", len(e.Conflicts))
+	for _, conflict := range e.Conflicts {
+		builder.WriteString("\n\t")
+		builder.WriteString(conflict.String())
+	}
+	return builder.String()
+}
+
+// Changesets combines the changesets into one. ExistingModelIDMap and RecordIDMaps are unified, and a change made
+// by more than one changeset, such as the create of a model with the same name and properties, or of a record with
+// the same external ID and values, is made once. Changes are otherwise kept in the order given.
+//
+// Returns an *Error listing every conflict if changes contradict each other, for example if a model is created
+// twice with different properties, a record is updated with different values, or updated or upserted in one
+// changeset and deleted in another, or a link or package is added to a record that is deleted. Upserts are matched to
+// deleted records through RecordIDMaps. Returns an error if the merged changeset does not pass validation.Validate.
+func Changesets(changesets ...models.Dataset) (models.Dataset, error) {
+	m := newMerger()
+	for i, changeset := range changesets {
+		m.add(fmt.Sprintf("changesets[%d].", i), changeset)
+	}
+	m.checkDeletes()
+	if len(m.conflicts) > 0 {
+		return models.Dataset{}, &Error{Conflicts: m.conflicts}
+	}
+	if err := validation.Validate(m.merged); err != nil {
+		return models.Dataset{}, fmt.Errorf("merged changeset is invalid: %w", err)
+	}
+	return m.merged, nil
+}
+
+type merger struct {
+	merged    models.Dataset
+	conflicts []Conflict
+
+	// modelCreates, modelUpdates, modelDeletes, links, relationships and proxies hold the index in merged of the
+	// change with each key
+	modelCreates  map[string]int
+	modelUpdates  map[models.PennsieveSchemaID]int
+	modelDeletes  map[models.PennsieveSchemaID]int
+	links         map[string]int
+	relationships map[string]int
+	proxies       map[proxyKey]int
+	// changes holds the index in merged of the record changes of each model, and of the instance changes of each link
+	// and relationship, so that large changesets are merged without scanning what was merged so far
+	changes map[changeKey]int
+	// modelNames holds the name of each model in the merged ExistingModelIDMap
+	modelNames map[models.PennsieveSchemaID]string
+
+	// recordUpdates, recordUpserts, propertyUpdates and modelChanges hold the path of the first change to each object
+	// that a delete would contradict, and recordDeletes, propertyDeletes and modelDeletePaths the path of its first
+	// delete
+	recordUpdates    map[recordIDKey]string
+	recordUpserts    map[externalIDKey]string
+	recordDeletes    map[recordIDKey]string
+	propertyUpdates  map[propertyIDKey]string
+	propertyDeletes  map[propertyIDKey]string
+	modelChanges     map[models.PennsieveSchemaID]string
+	modelDeletePaths map[models.PennsieveSchemaID]string
+	// recordUses holds the paths of the links, relationships and package proxies that are added to each record
+	recordUses map[proxyKey][]string
+}
+
+type recordIDKey struct {
+	modelID  models.PennsieveSchemaID
+	recordID models.PennsieveInstanceID
+}
+
+// externalIDKey identifies a record by model ID and external ID
+type externalIDKey struct {
+	modelID    models.PennsieveSchemaID
+	externalID models.ExternalInstanceID
+}
+
+type propertyIDKey struct {
+	modelID    models.PennsieveSchemaID
+	propertyID models.PennsieveSchemaID
+}
+
+// changeKey identifies a change of the given kind by the model, link or relationship it belongs to, and its own key
+type changeKey struct {
+	kind  string
+	owner any
+	key   any
+}
+
+// proxyKey identifies a record by model name and external ID
+type proxyKey struct {
+	modelName  string
+	externalID models.ExternalInstanceID
+}
+
+func newMerger() *merger {
+	return &merger{
+		modelCreates:     map[string]int{},
+		modelUpdates:     map[models.PennsieveSchemaID]int{},
+		modelDeletes:     map[models.PennsieveSchemaID]int{},
+		links:            map[string]int{},
+		relationships:    map[string]int{},
+		proxies:          map[proxyKey]int{},
+		changes:          map[changeKey]int{},
+		modelNames:       map[models.PennsieveSchemaID]string{},
+		recordUpdates:    map[recordIDKey]string{},
+		recordUpserts:    map[externalIDKey]string{},
+		recordDeletes:    map[recordIDKey]string{},
+		propertyUpdates:  map[propertyIDKey]string{},
+		propertyDeletes:  map[propertyIDKey]string{},
+		modelChanges:     map[models.PennsieveSchemaID]string{},
+		modelDeletePaths: map[models.PennsieveSchemaID]string{},
+		recordUses:       map[proxyKey][]string{},
+	}
+}
+
+func (m *merger) addConflict(path string, format string, args ...any) {
+	m.conflicts = append(m.conflicts, Conflict{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// changeIndex returns the index in merged of the change with the key, and true, if there is one. Otherwise it returns
+// false and records next as the index of the change.
+func (m *merger) changeIndex(key changeKey, next int) (int, bool) {
+	if index, found := m.changes[key]; found {
+		return index, true
+	}
+	m.changes[key] = next
+	return next, false
+}
+
+func (m *merger) add(pathPrefix string, changeset models.Dataset) {
+	m.addExistingModels(pathPrefix+"existing_model_id_map", changeset.ExistingModelIDMap)
+	m.addRecordIDMaps(pathPrefix+"record_id_maps", changeset.RecordIDMaps)
+	for i, modelCreate := range changeset.Models.Creates {
+		m.addModelCreate(fmt.Sprintf("%smodels.creates[%d]", pathPrefix, i), modelCreate)
+	}
+	for i, modelUpdate := range changeset.Models.Updates {
+		m.addModelUpdate(fmt.Sprintf("%smodels.updates[%d]", pathPrefix, i), modelUpdate)
+	}
+	for i, modelDelete := range changeset.Models.Deletes {
+		m.addModelDelete(fmt.Sprintf("%smodels.deletes[%d]", pathPrefix, i), modelDelete)
+	}
+	for i, linkChanges := range changeset.LinkedProperties {
+		m.addLinkChanges(fmt.Sprintf("%slinked_properties[%d]", pathPrefix, i), linkChanges)
+	}
+	for i, relationshipChanges := range changeset.Relationships {
+		m.addRelationshipChanges(fmt.Sprintf("%srelationships[%d]", pathPrefix, i), relationshipChanges)
+	}
+	if changeset.Proxies != nil {
+		m.addProxyChanges(pathPrefix+"proxies", *changeset.Proxies)
+	}
+}
+
+func (m *merger) addExistingModels(path string, existingModelIDMap map[string]models.PennsieveSchemaID) {
+	if len(existingModelIDMap) == 0 {
+		return
+	}
+	if m.merged.ExistingModelIDMap == nil {
+		m.merged.ExistingModelIDMap = map[string]models.PennsieveSchemaID{}
+	}
+	for _, name := range sortedKeys(existingModelIDMap) {
+		id := existingModelIDMap[name]
+		if earlier, found := m.merged.ExistingModelIDMap[name]; found && earlier != id {
+			m.addConflict(fmt.Sprintf("%s[%q]", path, name), "model %q has ID %s, but %s in an earlier changeset", name, id, earlier)
+			continue
+		}
+		if earlier, found := m.modelNames[id]; found && earlier != name {
+			m.addConflict(fmt.Sprintf("%s[%q]", path, name), "model %s is named both %q and %q", id, earlier, name)
+			continue
+		}
+		m.merged.ExistingModelIDMap[name] = id
+		m.modelNames[id] = name
+	}
+}
+
+func (m *merger) addRecordIDMaps(path string, recordIDMaps []models.RecordIDMap) {
+	for i, recordIDMap := range recordIDMaps {
+		index := slices.IndexFunc(m.merged.RecordIDMaps, func(r models.RecordIDMap) bool { return r.ModelName == recordIDMap.ModelName })
+		if index < 0 {
+			m.merged.RecordIDMaps = append(m.merged.RecordIDMaps, models.NewRecordIDMap(recordIDMap.ModelName))
+			index = len(m.merged.RecordIDMaps) - 1
+		}
+		merged := m.merged.RecordIDMaps[index].ExternalToPennsieve
+		for _, externalID := range sortedKeys(recordIDMap.ExternalToPennsieve) {
+			id := recordIDMap.ExternalToPennsieve[externalID]
+			if earlier, found := merged[externalID]; found && earlier != id {
+				m.addConflict(fmt.Sprintf("%s[%d].external_to_pennsieve[%q]", path, i, externalID),
+					"record %q of model %q has ID %s, but %s in an earlier changeset", externalID, recordIDMap.ModelName, id, earlier)
+				continue
+			}
+			merged[externalID] = id
+		}
+	}
+}
+
+func (m *merger) addModelCreate(path string, modelCreate models.ModelCreate) {
+	name := modelCreate.Create.Model.Name
+	index, found := m.modelCreates[name]
+	if !found {
+		m.modelCreates[name] = len(m.merged.Models.Creates)
+		m.merged.Models.Creates = append(m.merged.Models.Creates, models.ModelCreate{Create: modelCreate.Create})
+		index = len(m.merged.Models.Creates) - 1
+	} else if !sameModelCreate(m.merged.Models.Creates[index].Create, modelCreate.Create) {
+		m.addConflict(path+".create", "model %q is created with different params or properties than in an earlier change", name)
+		return
+	}
+	merged := &m.merged.Models.Creates[index]
+	merged.Records = m.mergeRecordCreates(path+".records", name, changeKey{kind: "model create record", owner: name}, merged.Records, modelCreate.Records)
+}
+
+// sameModelCreate returns true if the two creates have the same model params and properties, in any order
+func sameModelCreate(a models.ModelPropsCreate, b models.ModelPropsCreate) bool {
+	return a.Model == b.Model && reflect.DeepEqual(sortedProperties(a.Properties), sortedProperties(b.Properties))
+}
+
+func sortedProperties(properties models.PropertiesCreateParams) models.PropertiesCreateParams {
+	sorted := slices.Clone(properties)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	return sorted
+}
+
+// mergeRecordCreates returns merged with the records of recordCreates added. A record with an external ID already in
+// merged is added only once. Records without an external ID are always added. The records are indexed by external ID
+// with recordKey, which has the kind and owner of the records.
+func (m *merger) mergeRecordCreates(path string, modelName string, recordKey changeKey, merged []models.RecordCreate, recordCreates []models.RecordCreate) []models.RecordCreate {
+	for i, recordCreate := range recordCreates {
+		if len(recordCreate.ExternalID) > 0 {
+			recordKey.key = recordCreate.ExternalID
+			if index, found := m.changeIndex(recordKey, len(merged)); found {
+				if !reflect.DeepEqual(merged[index], recordCreate) {
+					m.addConflict(fmt.Sprintf("%s[%d]", path, i), "record %q of model %q is created with different values than in an earlier change", recordCreate.ExternalID, modelName)
+				}
+				continue
+			}
+		}
+		merged = append(merged, recordCreate)
+	}
+	return merged
+}
+
+func (m *merger) addModelUpdate(path string, modelUpdate models.ModelUpdate) {
+	index, found := m.modelUpdates[modelUpdate.ID]
+	if !found {
+		m.modelUpdates[modelUpdate.ID] = len(m.merged.Models.Updates)
+		m.merged.Models.Updates = append(m.merged.Models.Updates, models.ModelUpdate{ID: modelUpdate.ID})
+		index = len(m.merged.Models.Updates) - 1
+	}
+	merged := &m.merged.Models.Updates[index]
+	// deleting records of a model that is deleted anyway is not a conflict, but other changes to it are
+	records := modelUpdate.Records
+	if modelUpdate.Model != nil || !modelUpdate.Properties.IsEmpty() || len(records.Create)+len(records.Update)+len(records.Upsert) > 0 {
+		if _, found := m.modelChanges[modelUpdate.ID]; !found {
+			m.modelChanges[modelUpdate.ID] = path
+		}
+	}
+	m.mergeModelUpdateParams(path+".model", merged, modelUpdate.Model)
+	m.mergePropertyChanges(path+".properties", merged.ID, &merged.Properties, modelUpdate.Properties)
+	m.mergeRecordChanges(path+".records", merged.ID, &merged.Records, modelUpdate.Records)
+}
+
+func (m *merger) mergeModelUpdateParams(path string, merged *models.ModelUpdate, params *models.ModelUpdateParams) {
+	if params == nil {
+		return
+	}
+	if merged.Model == nil {
+		copied := *params
+		merged.Model = &copied
+		return
+	}
+	mergeField(m, path+".display_name", merged.ID, &merged.Model.DisplayName, params.DisplayName)
+	mergeField(m, path+".description", merged.ID, &merged.Model.Description, params.Description)
+	mergeField(m, path+".locked", merged.ID, &merged.Model.Locked, params.Locked)
+}
+
+// mergeField sets *merged to value if only value is set, and adds a conflict if both are set to different values
+func mergeField[T comparable](m *merger, path string, modelID models.PennsieveSchemaID, merged **T, value *T) {
+	if value == nil {
+		return
+	}
+	if *merged == nil {
+		copied := *value
+		*merged = &copied
+		return
+	}
+	if **merged != *value {
+		m.addConflict(path, "model %s is changed to %v, but to %v in an earlier change", modelID, *value, **merged)
+	}
+}
+
+func (m *merger) mergePropertyChanges(path string, modelID models.PennsieveSchemaID, merged *models.PropertyChanges, changes models.PropertyChanges) {
+	for i, propertyID := range changes.Delete {
+		key := propertyIDKey{modelID: modelID, propertyID: propertyID}
+		if _, found := m.propertyDeletes[key]; !found {
+			m.propertyDeletes[key] = fmt.Sprintf("%s.delete[%d]", path, i)
+			merged.Delete = append(merged.Delete, propertyID)
+		}
+	}
+	for i, propertyCreate := range changes.Create {
+		index := slices.IndexFunc(merged.Create, func(p models.PropertyCreateParams) bool { return p.Name == propertyCreate.Name })
+		if index < 0 {
+			merged.Create = append(merged.Create, propertyCreate)
+		} else if !reflect.DeepEqual(merged.Create[index], propertyCreate) {
+			m.addConflict(fmt.Sprintf("%s.create[%d]", path, i), "property %q of model %s is created with different params than in an earlier change", propertyCreate.Name, modelID)
+		}
+	}
+	for i, propertyUpdate := range changes.Update {
+		updatePath := fmt.Sprintf("%s.update[%d]", path, i)
+		index := slices.IndexFunc(merged.Update, func(p models.PropertyUpdate) bool { return p.ID == propertyUpdate.ID })
+		if index < 0 {
+			m.propertyUpdates[propertyIDKey{modelID: modelID, propertyID: propertyUpdate.ID}] = updatePath
+			merged.Update = append(merged.Update, propertyUpdate)
+		} else if !reflect.DeepEqual(merged.Update[index], propertyUpdate) {
+			m.addConflict(updatePath, "property %s of model %s is updated with different params than in an earlier change", propertyUpdate.ID, modelID)
+		}
+	}
+}
+
+func (m *merger) mergeRecordChanges(path string, modelID models.PennsieveSchemaID, merged *models.RecordChanges, changes models.RecordChanges) {
+	for i, recordID := range changes.Delete {
+		m.addRecordDelete(fmt.Sprintf("%s.delete[%d]", path, i), modelID, recordID, &merged.Delete)
+	}
+	merged.Create = m.mergeRecordCreates(path+".create", modelID.String(), changeKey{kind: "model update record", owner: modelID}, merged.Create, changes.Create)
+	for i, recordUpdate := range changes.Update {
+		updatePath := fmt.Sprintf("%s.update[%d]", path, i)
+		index, found := m.changeIndex(changeKey{kind: "record update", owner: modelID, key: recordUpdate.PennsieveID}, len(merged.Update))
+		if !found {
+			m.recordUpdates[recordIDKey{modelID: modelID, recordID: recordUpdate.PennsieveID}] = updatePath
+			merged.Update = append(merged.Update, recordUpdate)
+		} else if !reflect.DeepEqual(merged.Update[index], recordUpdate) {
+			m.addConflict(updatePath, "record %s of model %s is updated with different values than in an earlier change", recordUpdate.PennsieveID, modelID)
+		}
+	}
+	for i, recordUpsert := range changes.Upsert {
+		upsertPath := fmt.Sprintf("%s.upsert[%d]", path, i)
+		index, found := m.changeIndex(changeKey{kind: "record upsert", owner: modelID, key: recordUpsert.ExternalID}, len(merged.Upsert))
+		if !found {
+			m.recordUpserts[externalIDKey{modelID: modelID, externalID: recordUpsert.ExternalID}] = upsertPath
+			merged.Upsert = append(merged.Upsert, recordUpsert)
+		} else if !reflect.DeepEqual(merged.Upsert[index], recordUpsert) {
+			m.addConflict(upsertPath, "record %q of model %s is upserted with different values than in an earlier change", recordUpsert.ExternalID, modelID)
+		}
+	}
+}
+
+// addRecordDelete appends recordID to merged unless the record is already deleted
+func (m *merger) addRecordDelete(path string, modelID models.PennsieveSchemaID, recordID models.PennsieveInstanceID, merged *[]models.PennsieveInstanceID) {
+	key := recordIDKey{modelID: modelID, recordID: recordID}
+	if _, found := m.recordDeletes[key]; found {
+		return
+	}
+	m.recordDeletes[key] = path
+	*merged = append(*merged, recordID)
+}
+
+func (m *merger) addModelDelete(path string, modelDelete models.ModelDelete) {
+	index, found := m.modelDeletes[modelDelete.ID]
+	if !found {
+		m.modelDeletes[modelDelete.ID] = len(m.merged.Models.Deletes)
+		m.merged.Models.Deletes = append(m.merged.Models.Deletes, models.ModelDelete{ID: modelDelete.ID})
+		m.modelDeletePaths[modelDelete.ID] = path
+		index = len(m.merged.Models.Deletes) - 1
+	}
+	merged := &m.merged.Models.Deletes[index]
+	for i, recordID := range modelDelete.Records {
+		m.addRecordDelete(fmt.Sprintf("%s.records[%d]", path, i), modelDelete.ID, recordID, &merged.Records)
+	}
+}
+
+func (m *merger) addLinkChanges(path string, linkChanges models.LinkedPropertyChanges) {
+	key := "id:" + linkChanges.ID.String()
+	if len(linkChanges.ID) == 0 && linkChanges.Create != nil {
+		key = fmt.Sprintf("create:%s/%s", linkChanges.FromModelName, linkChanges.Create.Name)
+	}
+	index, found := m.links[key]
+	if !found {
+		m.links[key] = len(m.merged.LinkedProperties)
+		m.merged.LinkedProperties = append(m.merged.LinkedProperties, models.LinkedPropertyChanges{
+			FromModelName: linkChanges.FromModelName,
+			ToModelName:   linkChanges.ToModelName,
+			ID:            linkChanges.ID,
+			Create:        linkChanges.Create,
+		})
+		index = len(m.merged.LinkedProperties) - 1
+	}
+	merged := &m.merged.LinkedProperties[index]
+	if merged.FromModelName != linkChanges.FromModelName || merged.ToModelName != linkChanges.ToModelName {
+		m.addConflict(path, "link goes from model %q to model %q, but from model %q to model %q in an earlier change",
+			linkChanges.FromModelName, linkChanges.ToModelName, merged.FromModelName, merged.ToModelName)
+		return
+	}
+	if linkChanges.Create != nil && !reflect.DeepEqual(merged.Create, linkChanges.Create) {
+		m.addConflict(path+".create", "link %q is created with different params than in an earlier change", linkChanges.Create.Name)
+	}
+	if linkChanges.Update != nil {
+		if merged.Update == nil {
+			merged.Update = linkChanges.Update
+		} else if *merged.Update != *linkChanges.Update {
+			m.addConflict(path+".update", "link %s is updated with different params than in an earlier change", linkChanges.ID)
+		}
+	}
+	for i, instanceCreate := range linkChanges.Instances.Create {
+		instancePath := fmt.Sprintf("%s.instances.create[%d]", path, i)
+		instanceIndex, found := m.changeIndex(changeKey{kind: "link instance", owner: key, key: instanceCreate.FromExternalID}, len(merged.Instances.Create))
+		if !found {
+			merged.Instances.Create = append(merged.Instances.Create, instanceCreate)
+			m.useRecord(instancePath, linkChanges.FromModelName, instanceCreate.FromExternalID)
+			m.useRecord(instancePath, linkChanges.ToModelName, instanceCreate.ToExternalID)
+		} else if merged.Instances.Create[instanceIndex] != instanceCreate {
+			m.addConflict(instancePath, "record %q of model %q is linked to record %q, but to record %q in an earlier change",
+				instanceCreate.FromExternalID, linkChanges.FromModelName, instanceCreate.ToExternalID, merged.Instances.Create[instanceIndex].ToExternalID)
+		}
+	}
+	for _, instanceDelete := range linkChanges.Instances.Delete {
+		if _, found := m.changeIndex(changeKey{kind: "link instance delete", owner: key, key: instanceDelete}, len(merged.Instances.Delete)); !found {
+			merged.Instances.Delete = append(merged.Instances.Delete, instanceDelete)
+		}
+	}
+	if (linkChanges.Delete && (merged.Update != nil || len(merged.Instances.Create) > 0)) ||
+		(merged.Delete && (linkChanges.Update != nil || len(linkChanges.Instances.Create) > 0)) {
+		m.addConflict(path, "link %s is both deleted and changed or given instances", merged.ID)
+	}
+	merged.Delete = merged.Delete || linkChanges.Delete
+}
+
+func (m *merger) addRelationshipChanges(path string, relationshipChanges models.RelationshipChanges) {
+	key := "id:" + relationshipChanges.ID.String()
+	if len(relationshipChanges.ID) == 0 && relationshipChanges.Create != nil {
+		key = "create:" + relationshipChanges.Create.Name
+	}
+	index, found := m.relationships[key]
+	if !found {
+		m.relationships[key] = len(m.merged.Relationships)
+		m.merged.Relationships = append(m.merged.Relationships, models.RelationshipChanges{
+			ID:     relationshipChanges.ID,
+			Create: relationshipChanges.Create,
+		})
+		index = len(m.merged.Relationships) - 1
+	}
+	merged := &m.merged.Relationships[index]
+	if relationshipChanges.Create != nil && !reflect.DeepEqual(merged.Create, relationshipChanges.Create) {
+		m.addConflict(path+".create", "relationship %q is created with different params than in an earlier change", relationshipChanges.Create.Name)
+	}
+	for i, instanceCreate := range relationshipChanges.Instances.Create {
+		if _, found := m.changeIndex(changeKey{kind: "relationship instance", owner: key, key: instanceCreate}, len(merged.Instances.Create)); found {
+			continue
+		}
+		instancePath := fmt.Sprintf("%s.instances.create[%d]", path, i)
+		merged.Instances.Create = append(merged.Instances.Create, instanceCreate)
+		m.useRecord(instancePath, instanceCreate.FromModelName, instanceCreate.FromExternalID)
+		m.useRecord(instancePath, instanceCreate.ToModelName, instanceCreate.ToExternalID)
+	}
+	for _, instanceDelete := range relationshipChanges.Instances.Delete {
+		if _, found := m.changeIndex(changeKey{kind: "relationship instance delete", owner: key, key: instanceDelete}, len(merged.Instances.Delete)); !found {
+			merged.Instances.Delete = append(merged.Instances.Delete, instanceDelete)
+		}
+	}
+}
+
+func (m *merger) addProxyChanges(path string, proxyChanges models.ProxyChanges) {
+	if m.merged.Proxies == nil {
+		m.merged.Proxies = &models.ProxyChanges{}
+	}
+	merged := m.merged.Proxies
+	merged.CreateProxyRelationshipSchema = merged.CreateProxyRelationshipSchema || proxyChanges.CreateProxyRelationshipSchema
+	for i, schemaCreate := range proxyChanges.RelationshipSchemaCreates {
+		index := slices.IndexFunc(merged.RelationshipSchemaCreates, func(c models.RelationshipSchemaCreate) bool { return c.Name == schemaCreate.Name })
+		if index < 0 {
+			merged.RelationshipSchemaCreates = append(merged.RelationshipSchemaCreates, schemaCreate)
+		} else if merged.RelationshipSchemaCreates[index] != schemaCreate {
+			m.addConflict(fmt.Sprintf("%s.relationship_schema_creates[%d]", path, i), "relationship %q is created with different params than in an earlier change", schemaCreate.Name)
+		}
+	}
+	for i, recordChanges := range proxyChanges.RecordChanges {
+		recordPath := fmt.Sprintf("%s.record_changes[%d]", path, i)
+		key := proxyKey{modelName: recordChanges.ModelName, externalID: recordChanges.RecordExternalID}
+		index, found := m.proxies[key]
+		if !found {
+			m.proxies[key] = len(merged.RecordChanges)
+			merged.RecordChanges = append(merged.RecordChanges, models.ProxyRecordChanges{
+				ModelName:        recordChanges.ModelName,
+				RecordExternalID: recordChanges.RecordExternalID,
+			})
+			index = len(merged.RecordChanges) - 1
+		}
+		mergedRecord := &merged.RecordChanges[index]
+		for _, nodeID := range recordChanges.NodeIDCreates {
+			if !slices.Contains(mergedRecord.NodeIDCreates, nodeID) {
+				mergedRecord.NodeIDCreates = append(mergedRecord.NodeIDCreates, nodeID)
+			}
+		}
+		for _, packageCreate := range recordChanges.PackageCreates {
+			if !slices.ContainsFunc(mergedRecord.PackageCreates, func(c models.ProxyPackageCreate) bool { return reflect.DeepEqual(c, packageCreate) }) {
+				mergedRecord.PackageCreates = append(mergedRecord.PackageCreates, packageCreate)
+			}
+		}
+		for _, proxyID := range recordChanges.InstanceIDDeletes {
+			if !slices.Contains(mergedRecord.InstanceIDDeletes, proxyID) {
+				mergedRecord.InstanceIDDeletes = append(mergedRecord.InstanceIDDeletes, proxyID)
+			}
+		}
+		if len(recordChanges.NodeIDCreates)+len(recordChanges.PackageCreates) > 0 {
+			m.useRecord(recordPath, recordChanges.ModelName, recordChanges.RecordExternalID)
+		}
+	}
+}
+
+// useRecord records that a link, relationship or package proxy is added to the record
+func (m *merger) useRecord(path string, modelName string, externalID models.ExternalInstanceID) {
+	key := proxyKey{modelName: modelName, externalID: externalID}
+	m.recordUses[key] = append(m.recordUses[key], path)
+}
+
+// checkDeletes adds a conflict for each change to a model, property or record that is deleted, whichever changeset
+// the change and delete are in
+func (m *merger) checkDeletes() {
+	for _, modelDelete := range m.merged.Models.Deletes {
+		if path, changed := m.modelChanges[modelDelete.ID]; changed {
+			m.addConflict(path, "model %s is changed, but deleted by %s", modelDelete.ID, m.modelDeletePaths[modelDelete.ID])
+		}
+	}
+	for _, key := range sortedKeys(m.propertyUpdates) {
+		if deletePath, deleted := m.propertyDeletes[key]; deleted {
+			m.addConflict(m.propertyUpdates[key], "property %s of model %s is updated, but deleted by %s", key.propertyID, key.modelID, deletePath)
+		}
+	}
+	for _, key := range sortedKeys(m.recordUpdates) {
+		if deletePath, deleted := m.recordDeletes[key]; deleted {
+			m.addConflict(m.recordUpdates[key], "record %s of model %s is updated, but deleted by %s", key.recordID, key.modelID, deletePath)
+		}
+	}
+	// Upserts and uses of records name them by external ID, so they are resolved through RecordIDMaps to find the
+	// deleted records among them
+	existingRecordIDs := map[proxyKey]models.PennsieveInstanceID{}
+	for _, recordIDMap := range m.merged.RecordIDMaps {
+		for externalID, recordID := range recordIDMap.ExternalToPennsieve {
+			existingRecordIDs[proxyKey{modelName: recordIDMap.ModelName, externalID: externalID}] = recordID
+		}
+	}
+	modelNames := map[models.PennsieveSchemaID]string{}
+	for name, id := range m.merged.ExistingModelIDMap {
+		modelNames[id] = name
+	}
+	for _, key := range sortedKeys(m.recordUpserts) {
+		modelName, found := modelNames[key.modelID]
+		if !found {
+			continue
+		}
+		recordID, found := existingRecordIDs[proxyKey{modelName: modelName, externalID: key.externalID}]
+		if !found {
+			continue
+		}
+		if deletePath, deleted := m.recordDeletes[recordIDKey{modelID: key.modelID, recordID: recordID}]; deleted {
+			m.addConflict(m.recordUpserts[key], "record %q of model %s is upserted, but deleted by %s", key.externalID, key.modelID, deletePath)
+		}
+	}
+	for _, key := range sortedKeys(m.recordUses) {
+		modelID, found := m.merged.ExistingModelIDMap[key.modelName]
+		if !found {
+			continue
+		}
+		recordID, found := existingRecordIDs[key]
+		if !found {
+			continue
+		}
+		if deletePath, deleted := m.recordDeletes[recordIDKey{modelID: modelID, recordID: recordID}]; deleted {
+			for _, usePath := range m.recordUses[key] {
+				m.addConflict(usePath, "record %q of model %q is deleted by %s", key.externalID, key.modelName, deletePath)
+			}
+		}
+	}
+}
+
+// sortedKeys returns the keys of the map in the order of their formatted values, so that conflicts are reported in
+// the same order every time
+func sortedKeys[K comparable, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+	return keys
+}
@@ -0,0 +1,330 @@
+package merge_test
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pennsieve/processor-post-metadata/client/clienttest"
+	"github.com/pennsieve/processor-post-metadata/client/merge"
+	"github.com/pennsieve/processor-post-metadata/client/models"
+	"github.com/pennsieve/processor-post-metadata/client/validation"
+	"github.com/pennsieve/processor-pre-metadata/client/models/datatypes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestChangesets(t *testing.T) {
+	for scenario, testFunc := range map[string]func(t *testing.T){
+		"disjoint changesets are combined": mergeDisjointChangesets,
+		"shared changes are made once":     mergeSharedChanges,
+		"conflicts are reported":           mergeConflicts,
+		"upsert of a deleted record":       mergeUpsertOfDeletedRecord,
+		"model ID with two names":          mergeModelIDWithTwoNames,
+		"merged changeset is validated":    mergeInvalidChangeset,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			testFunc(t)
+		})
+	}
+}
+
+func newModelCreate(t *testing.T, name string, externalIDs ...models.ExternalInstanceID) models.ModelCreate {
+	model := clienttest.NewModelCreate()
+	model.Name = name
+	property := clienttest.NewPropertyCreateSimple(t, datatypes.StringType)
+	property.Name = "name"
+	modelCreate := models.ModelCreate{
+		Create: models.ModelPropsCreate{Model: model, Properties: models.PropertiesCreateParams{property}},
+	}
+	for _, externalID := range externalIDs {
+		modelCreate.Records = append(modelCreate.Records, newRecordCreate(externalID, string(externalID)))
+	}
+	return modelCreate
+}
+
+func newRecordCreate(externalID models.ExternalInstanceID, name string) models.RecordCreate {
+	return models.RecordCreate{
+		ExternalID:   externalID,
+		RecordValues: clienttest.NewRecordValues(models.RecordValue{Name: "name", Value: name}),
+	}
+}
+
+func mergeDisjointChangesets(t *testing.T) {
+	sampleModelID := clienttest.NewPennsieveSchemaID()
+	existingRecordID := clienttest.NewPennsieveInstanceID()
+	deletedRecordID := clienttest.NewPennsieveInstanceID()
+	subjectCreate := newModelCreate(t, "subject", "subject-1")
+
+	first := models.Dataset{
+		Models: models.ModelChanges{Creates: []models.ModelCreate{subjectCreate}},
+		Proxies: &models.ProxyChanges{
+			CreateProxyRelationshipSchema: true,
+			RecordChanges:                 []models.ProxyRecordChanges{{ModelName: "subject", RecordExternalID: "subject-1", NodeIDCreates: []string{"N:package:1"}}},
+		},
+	}
+	second := models.Dataset{
+		Models: models.ModelChanges{Updates: []models.ModelUpdate{{
+			ID:      sampleModelID,
+			Records: models.RecordChanges{Delete: []models.PennsieveInstanceID{deletedRecordID}},
+		}}},
+		LinkedProperties: []models.LinkedPropertyChanges{{
+			FromModelName: "sample",
+			ToModelName:   "subject",
+			Create:        &models.SchemaLinkedPropertyCreate{Name: "subject", DisplayName: "Subject"},
+			Instances:     models.InstanceChanges{Create: []models.InstanceLinkedPropertyCreate{{FromExternalID: "sample-1", ToExternalID: "subject-1"}}},
+		}},
+		ExistingModelIDMap: map[string]models.PennsieveSchemaID{"sample": sampleModelID},
+		RecordIDMaps: []models.RecordIDMap{{
+			ModelName:           "sample",
+			ExternalToPennsieve: map[models.ExternalInstanceID]models.PennsieveInstanceID{"sample-1": existingRecordID},
+		}},
+	}
+
+	merged, err := merge.Changesets(first, second)
+	require.NoError(t, err)
+
+	assert.Equal(t, models.Dataset{
+		Models: models.ModelChanges{
+			Creates: first.Models.Creates,
+			Updates: second.Models.Updates,
+		},
+		LinkedProperties:   second.LinkedProperties,
+		Proxies:            first.Proxies,
+		ExistingModelIDMap: second.ExistingModelIDMap,
+		RecordIDMaps:       second.RecordIDMaps,
+	}, merged)
+}
+
+func mergeSharedChanges(t *testing.T) {
+	subjectCreate := newModelCreate(t, "subject", "subject-1", "subject-2")
+	// the same model, with its properties in another order, and one of the same records
+	otherProperty := clienttest.NewPropertyCreateSimple(t, datatypes.StringType)
+	subjectCreate.Create.Properties = append(subjectCreate.Create.Properties, otherProperty)
+	sameSubjectCreate := models.ModelCreate{
+		Create: models.ModelPropsCreate{
+			Model:      subjectCreate.Create.Model,
+			Properties: models.PropertiesCreateParams{otherProperty, subjectCreate.Create.Properties[0]},
+		},
+		Records: []models.RecordCreate{subjectCreate.Records[1], newRecordCreate("subject-3", "subject-3")},
+	}
+	sampleModelID := clienttest.NewPennsieveSchemaID()
+	sample1ID := clienttest.NewPennsieveInstanceID()
+	sample2ID := clienttest.NewPennsieveInstanceID()
+	linkCreate := models.SchemaLinkedPropertyCreate{Name: "subject", DisplayName: "Subject"}
+	newChangeset := func(subjectCreate models.ModelCreate, sampleExternalID models.ExternalInstanceID, sampleID models.PennsieveInstanceID, nodeIDs ...string) models.Dataset {
+		return models.Dataset{
+			Models: models.ModelChanges{Creates: []models.ModelCreate{subjectCreate}},
+			LinkedProperties: []models.LinkedPropertyChanges{{
+				FromModelName: "sample",
+				ToModelName:   "subject",
+				Create:        &linkCreate,
+				Instances: models.InstanceChanges{Create: []models.InstanceLinkedPropertyCreate{
+					{FromExternalID: "sample-1", ToExternalID: "subject-2"},
+				}},
+			}},
+			Proxies: &models.ProxyChanges{
+				RecordChanges: []models.ProxyRecordChanges{{ModelName: "sample", RecordExternalID: "sample-1", NodeIDCreates: nodeIDs}},
+			},
+			ExistingModelIDMap: map[string]models.PennsieveSchemaID{"sample": sampleModelID},
+			RecordIDMaps: []models.RecordIDMap{{
+				ModelName: "sample",
+				ExternalToPennsieve: map[models.ExternalInstanceID]models.PennsieveInstanceID{
+					"sample-1":       sample1ID,
+					sampleExternalID: sampleID,
+				},
+			}},
+		}
+	}
+
+	merged, err := merge.Changesets(
+		newChangeset(subjectCreate, "sample-1", sample1ID, "N:package:1", "N:package:2"),
+		newChangeset(sameSubjectCreate, "sample-2", sample2ID, "N:package:2", "N:package:3"),
+	)
+	require.NoError(t, err)
+
+	require.Len(t, merged.Models.Creates, 1)
+	assert.Equal(t, subjectCreate.Create, merged.Models.Creates[0].Create)
+	assert.Equal(t, []models.RecordCreate{
+		subjectCreate.Records[0],
+		subjectCreate.Records[1],
+		newRecordCreate("subject-3", "subject-3"),
+	}, merged.Models.Creates[0].Records)
+
+	require.Len(t, merged.LinkedProperties, 1)
+	assert.Equal(t, []models.InstanceLinkedPropertyCreate{{FromExternalID: "sample-1", ToExternalID: "subject-2"}},
+		merged.LinkedProperties[0].Instances.Create)
+
+	assert.Equal(t, &models.ProxyChanges{
+		RecordChanges: []models.ProxyRecordChanges{{
+			ModelName:        "sample",
+			RecordExternalID: "sample-1",
+			NodeIDCreates:    []string{"N:package:1", "N:package:2", "N:package:3"},
+		}},
+	}, merged.Proxies)
+
+	assert.Equal(t, map[string]models.PennsieveSchemaID{"sample": sampleModelID}, merged.ExistingModelIDMap)
+	assert.Equal(t, []models.RecordIDMap{{
+		ModelName: "sample",
+		ExternalToPennsieve: map[models.ExternalInstanceID]models.PennsieveInstanceID{
+			"sample-1": sample1ID,
+			"sample-2": sample2ID,
+		},
+	}}, merged.RecordIDMaps)
+}
+
+func mergeConflicts(t *testing.T) {
+	sampleModelID := clienttest.NewPennsieveSchemaID()
+	deletedModelID := clienttest.NewPennsieveSchemaID()
+	updatedRecordID := clienttest.NewPennsieveInstanceID()
+	deletedRecordID := clienttest.NewPennsieveInstanceID()
+	displayName := "Samples"
+
+	first := models.Dataset{
+		Models: models.ModelChanges{
+			Creates: []models.ModelCreate{newModelCreate(t, "subject", "subject-1", "subject-2")},
+			Updates: []models.ModelUpdate{{
+				ID:      sampleModelID,
+				Records: models.RecordChanges{Delete: []models.PennsieveInstanceID{deletedRecordID}},
+			}},
+			Deletes: []models.ModelDelete{{ID: deletedModelID}},
+		},
+		LinkedProperties: []models.LinkedPropertyChanges{{
+			FromModelName: "sample",
+			ToModelName:   "subject",
+			Create:        &models.SchemaLinkedPropertyCreate{Name: "subject", DisplayName: "Subject"},
+			Instances: models.InstanceChanges{Create: []models.InstanceLinkedPropertyCreate{
+				{FromExternalID: "sample-1", ToExternalID: "subject-1"},
+			}},
+		}},
+		ExistingModelIDMap: map[string]models.PennsieveSchemaID{"sample": sampleModelID},
+		RecordIDMaps: []models.RecordIDMap{{
+			ModelName: "sample",
+			ExternalToPennsieve: map[models.ExternalInstanceID]models.PennsieveInstanceID{
+				"sample-1": updatedRecordID,
+				"sample-2": deletedRecordID,
+			},
+		}},
+	}
+	// the subject model with other properties
+	otherSubjectCreate := newModelCreate(t, "subject")
+	second := models.Dataset{
+		Models: models.ModelChanges{
+			Creates: []models.ModelCreate{otherSubjectCreate},
+			Updates: []models.ModelUpdate{
+				{
+					ID: sampleModelID,
+					Records: models.RecordChanges{Update: []models.RecordUpdate{{
+						PennsieveID:  deletedRecordID,
+						RecordValues: clienttest.NewRecordValues(models.RecordValue{Name: "name", Value: "sample-2"}),
+					}}},
+				},
+				{ID: deletedModelID, Model: &models.ModelUpdateParams{DisplayName: &displayName}},
+			},
+		},
+		LinkedProperties: []models.LinkedPropertyChanges{{
+			FromModelName: "sample",
+			ToModelName:   "subject",
+			Create:        &models.SchemaLinkedPropertyCreate{Name: "subject", DisplayName: "Subject"},
+			Instances: models.InstanceChanges{Create: []models.InstanceLinkedPropertyCreate{
+				{FromExternalID: "sample-1", ToExternalID: "subject-2"},
+			}},
+		}},
+		Proxies: &models.ProxyChanges{
+			RecordChanges: []models.ProxyRecordChanges{{ModelName: "sample", RecordExternalID: "sample-2", NodeIDCreates: []string{"N:package:1"}}},
+		},
+		ExistingModelIDMap: map[string]models.PennsieveSchemaID{"sample": clienttest.NewPennsieveSchemaID()},
+	}
+
+	_, err := merge.Changesets(first, second)
+	var mergeErr *merge.Error
+	require.ErrorAs(t, err, &mergeErr)
+	var paths []string
+	for _, conflict := range mergeErr.Conflicts {
+		paths = append(paths, conflict.Path)
+	}
+	assert.ElementsMatch(t, []string{
+		`changesets[1].existing_model_id_map["sample"]`,
+		"changesets[1].models.creates[0].create",
+		"changesets[1].models.updates[0].records.update[0]",
+		"changesets[1].models.updates[1]",
+		"changesets[1].linked_properties[0].instances.create[0]",
+		"changesets[1].proxies.record_changes[0]",
+	}, paths)
+	assert.ErrorContains(t, err, `model "subject" is created with different params or properties than in an earlier change`)
+	assert.ErrorContains(t, err, "is updated, but deleted by changesets[0].models.updates[0].records.delete[0]")
+	assert.ErrorContains(t, err, `record "sample-1" of model "sample" is linked to record "subject-2", but to record "subject-1" in an earlier change`)
+	assert.ErrorContains(t, err, `record "sample-2" of model "sample" is deleted by changesets[0].models.updates[0].records.delete[0]`)
+}
+
+func mergeUpsertOfDeletedRecord(t *testing.T) {
+	sampleModelID := clienttest.NewPennsieveSchemaID()
+	deletedRecordID := clienttest.NewPennsieveInstanceID()
+	first := models.Dataset{
+		Models: models.ModelChanges{Updates: []models.ModelUpdate{{
+			ID:      sampleModelID,
+			Records: models.RecordChanges{Delete: []models.PennsieveInstanceID{deletedRecordID}},
+		}}},
+		ExistingModelIDMap: map[string]models.PennsieveSchemaID{"sample": sampleModelID},
+		RecordIDMaps: []models.RecordIDMap{{
+			ModelName:           "sample",
+			ExternalToPennsieve: map[models.ExternalInstanceID]models.PennsieveInstanceID{"sample-1": deletedRecordID},
+		}},
+	}
+	// upserts name records by external ID, deletes by Pennsieve ID
+	second := models.Dataset{
+		Models: models.ModelChanges{Updates: []models.ModelUpdate{{
+			ID: sampleModelID,
+			Records: models.RecordChanges{Upsert: []models.RecordUpsert{
+				{ExternalID: "sample-1", KeyProperty: "name", RecordValues: clienttest.NewRecordValues(models.RecordValue{Name: "name", Value: "sample-1"})},
+				{ExternalID: "sample-2", KeyProperty: "name", RecordValues: clienttest.NewRecordValues(models.RecordValue{Name: "name", Value: "sample-2"})},
+			}},
+		}}},
+		ExistingModelIDMap: map[string]models.PennsieveSchemaID{"sample": sampleModelID},
+	}
+
+	_, err := merge.Changesets(first, second)
+	var mergeErr *merge.Error
+	require.ErrorAs(t, err, &mergeErr)
+	require.Len(t, mergeErr.Conflicts, 1)
+	assert.Equal(t, "changesets[1].models.updates[0].records.upsert[0]", mergeErr.Conflicts[0].Path)
+	assert.Contains(t, mergeErr.Conflicts[0].Message, `record "sample-1" of model `+sampleModelID.String()+" is upserted, but deleted by changesets[0].models.updates[0].records.delete[0]")
+}
+
+func mergeModelIDWithTwoNames(t *testing.T) {
+	modelID := clienttest.NewPennsieveSchemaID()
+	first := models.Dataset{ExistingModelIDMap: map[string]models.PennsieveSchemaID{"sample": modelID}}
+	second := models.Dataset{ExistingModelIDMap: map[string]models.PennsieveSchemaID{"specimen": modelID}}
+
+	_, err := merge.Changesets(first, second)
+	var mergeErr *merge.Error
+	require.ErrorAs(t, err, &mergeErr)
+	require.Len(t, mergeErr.Conflicts, 1)
+	assert.Equal(t, `changesets[1].existing_model_id_map["specimen"]`, mergeErr.Conflicts[0].Path)
+	assert.Equal(t, fmt.Sprintf(`model %s is named both "sample" and "specimen"`, modelID), mergeErr.Conflicts[0].Message)
+}
+
+func mergeInvalidChangeset(t *testing.T) {
+	// each changeset is valid on its own, but together they create the same record external ID in two ways
+	sampleModelID := clienttest.NewPennsieveSchemaID()
+	first := models.Dataset{
+		Models: models.ModelChanges{Updates: []models.ModelUpdate{{
+			ID:      sampleModelID,
+			Records: models.RecordChanges{Create: []models.RecordCreate{newRecordCreate("sample-1", "sample-1")}},
+		}}},
+		ExistingModelIDMap: map[string]models.PennsieveSchemaID{"sample": sampleModelID},
+	}
+	second := models.Dataset{
+		ExistingModelIDMap: map[string]models.PennsieveSchemaID{"sample": sampleModelID},
+		RecordIDMaps: []models.RecordIDMap{{
+			ModelName:           "sample",
+			ExternalToPennsieve: map[models.ExternalInstanceID]models.PennsieveInstanceID{"sample-1": clienttest.NewPennsieveInstanceID()},
+		}},
+	}
+	require.NoError(t, validation.Validate(first))
+	require.NoError(t, validation.Validate(second))
+
+	_, err := merge.Changesets(first, second)
+	var validationErr *validation.Error
+	require.True(t, errors.As(err, &validationErr))
+	assert.ErrorContains(t, err, `duplicate external ID "sample-1" in model "sample"`)
+}
@@ -3,6 +3,7 @@ package models
 type Dataset struct {
 	Models             ModelChanges                 `json:"models"`
 	LinkedProperties   []LinkedPropertyChanges      `json:"linked_properties"`
+	Relationships      []RelationshipChanges        `json:"relationships"`
 	Proxies            *ProxyChanges                `json:"proxies"`
 	ExistingModelIDMap map[string]PennsieveSchemaID `json:"existing_model_id_map"`
 	RecordIDMaps       []RecordIDMap                `json:"record_id_maps"`
@@ -20,6 +20,14 @@ type LinkedPropertyChanges struct {
 	// If Create is non-nil, the link schema should be created in the model schema
 	Create *SchemaLinkedPropertyCreate `json:"create,omitempty"`
 
+	// If Update is non-nil, the existing link schema with ID should be changed. The update is made before any
+	// instances are created.
+	Update *SchemaLinkedPropertyUpdate `json:"update,omitempty"`
+
+	// If Delete is true, the existing link schema with ID should be deleted from the model schema. The delete
+	// is made after the instance deletes in Instances. Pennsieve will not delete a link schema that still has instances.
+	Delete bool `json:"delete,omitempty"`
+
 	// Instances contains the create and delete info for link instances
 	Instances InstanceChanges `json:"instances"`
 }
@@ -38,6 +46,20 @@ type SchemaLinkedPropertyCreate struct {
 	Position int `json:"position"`
 }
 
+// SchemaLinkedPropertyUpdate will have to be translated to a PUT /models/datasets/<dataset id>/concepts/<from model id>/linked/<link schema id>
+// request with body {"name": Name,"displayName": DisplayName,"to": <to model id>,"position": Position} once the to and from model id
+// values are known. Include both changed and unchanged values.
+type SchemaLinkedPropertyUpdate struct {
+	// Name is the name of the linked property in the schema of the parent model
+	Name string `json:"name"`
+
+	// DisplayName is the display name of the linked property in the schema of the parent model
+	DisplayName string `json:"display_name"`
+
+	// Position is the position of the linked property in the schema of the parent model.
+	Position int `json:"position"`
+}
+
 type InstanceChanges struct {
 	Create []InstanceLinkedPropertyCreate `json:"create"`
 	Delete []InstanceLinkedPropertyDelete `json:"delete"`
@@ -37,10 +37,37 @@ type ModelCreate struct {
 type ModelUpdate struct {
 	// The ID of the model in Pennsieve.
 	ID PennsieveSchemaID `json:"id"`
+	// Model, if not nil, changes the metadata of the model
+	Model *ModelUpdateParams `json:"model,omitempty"`
+	// Properties describes the changes to the properties of this model type. They are made before the changes in Records.
+	Properties PropertyChanges `json:"properties"`
 	// Records describes the changes to the records of this model type
 	Records RecordChanges `json:"records"`
 }
 
+// ModelUpdateParams changes the metadata of an existing model. Only the fields that are not nil are changed.
+type ModelUpdateParams struct {
+	DisplayName *string `json:"display_name,omitempty"`
+	Description *string `json:"description,omitempty"`
+	Locked      *bool   `json:"locked,omitempty"`
+}
+
+// Apply returns current with the changes in ModelUpdateParams applied. The result can be used as a payload for
+// PUT /models/datasets/<dataset id>/concepts/<model id> to update a model
+func (u ModelUpdateParams) Apply(current ModelCreateParams) ModelCreateParams {
+	updated := current
+	if u.DisplayName != nil {
+		updated.DisplayName = *u.DisplayName
+	}
+	if u.Description != nil {
+		updated.Description = *u.Description
+	}
+	if u.Locked != nil {
+		updated.Locked = *u.Locked
+	}
+	return updated
+}
+
 type ModelDelete struct {
 	// The ID of the model in Pennsieve.
 	ID PennsieveSchemaID `json:"id"`
@@ -87,6 +114,29 @@ func (pc *PropertyCreateParams) SetDataType(dataType any) error {
 	return nil
 }
 
+type PropertyChanges struct {
+	// A list of property IDs to delete. Values of the property are removed from existing records.
+	Delete []PennsieveSchemaID `json:"delete"`
+	// Create are properties that should be added to the model
+	Create PropertiesCreateParams `json:"create"`
+	// Update are existing properties that should be modified
+	Update []PropertyUpdate `json:"update"`
+}
+
+// IsEmpty returns true if there are no property changes
+func (c PropertyChanges) IsEmpty() bool {
+	return len(c.Delete)+len(c.Create)+len(c.Update) == 0
+}
+
+// PropertyUpdate wraps a PropertyCreateParams that can be used as a payload for PUT /models/datasets/<dataset id>/concepts/<model id>/properties/<property id>
+// to modify a property, for example its display name, description, required or conceptTitle flags, or the allowed values of an enum.
+// Include both changed and unchanged params.
+// The ID is not part of the payload, but is the property id needed as a request path parameter
+type PropertyUpdate struct {
+	ID PennsieveSchemaID `json:"id"`
+	PropertyCreateParams
+}
+
 type RecordChanges struct {
 	// A list of RecordIDs to delete
 	Delete []PennsieveInstanceID `json:"delete"`
@@ -94,6 +144,8 @@ type RecordChanges struct {
 	Create []RecordCreate `json:"create"`
 	// Update are records that should be updated
 	Update []RecordUpdate `json:"update"`
+	// Upsert are records that should be updated if they exist, and otherwise created
+	Upsert []RecordUpsert `json:"upsert,omitempty"`
 }
 
 // RecordCreate wraps a RecordValues that can be used as a payload for
@@ -105,6 +157,26 @@ type RecordCreate struct {
 	RecordValues
 }
 
+// RecordUpsert is for when it is not known whether a record exists. The existing record, if any, is the one whose
+// value for KeyProperty equals the value for KeyProperty in RecordValues, for example the model's concept title
+// property. If there is one, it is updated with a PUT of RecordValues as for a RecordUpdate, otherwise a record is
+// created as for a RecordCreate. Either way, ExternalID is mapped to the record's PennsieveInstanceID.
+type RecordUpsert struct {
+	ExternalID  ExternalInstanceID `json:"external_id"`
+	KeyProperty string             `json:"key_property"`
+	RecordValues
+}
+
+// KeyValue returns the value for KeyProperty in RecordValues, and false if there is none
+func (u RecordUpsert) KeyValue() (any, bool) {
+	for _, value := range u.Values {
+		if value.Name == u.KeyProperty {
+			return value.Value, true
+		}
+	}
+	return nil, false
+}
+
 type RecordValue struct {
 	Value any    `json:"value"`
 	Name  string `json:"name"`
@@ -1,17 +1,34 @@
 package models
 
+// ProxyRelationshipSchemaName is the relationship type of package proxies that do not specify one
+const ProxyRelationshipSchemaName = "belongs_to"
+
+// ProxyDirection is the direction of the relationship between a record and a package proxy
+type ProxyDirection string
+
+const (
+	// FromTarget relationships go from the record to the package. The default.
+	FromTarget ProxyDirection = "FromTarget"
+	// ToTarget relationships go from the package to the record
+	ToTarget ProxyDirection = "ToTarget"
+)
+
 // ProxyChanges contains all the changes to package proxies.
 // If CreateProxyRelationshipSchema is true we send POST /models/datasets/<dataset id>/relationships the body
 // {"name":"belongs_to","displayName":"Belongs To","description":"","schema":[]} to create the special
 // relationship schema used by all package proxies regardless of record or pacakge type.
 type ProxyChanges struct {
-	CreateProxyRelationshipSchema bool                 `json:"create_proxy_relationship_schema"`
-	RecordChanges                 []ProxyRecordChanges `json:"record_changes"`
+	CreateProxyRelationshipSchema bool `json:"create_proxy_relationship_schema"`
+	// RelationshipSchemaCreates give the display name, description and models of relationship schemas used by
+	// PackageCreates. Every relationship type other than "belongs_to" used by PackageCreates is created before any
+	// proxies if it does not exist yet, with a display name made from the type if it has no RelationshipSchemaCreate.
+	RelationshipSchemaCreates []RelationshipSchemaCreate `json:"relationship_schema_creates,omitempty"`
+	RecordChanges             []ProxyRecordChanges       `json:"record_changes"`
 }
 
 func (pc ProxyChanges) Summary() (createCount int, deleteCount int) {
 	for _, recordChanges := range pc.RecordChanges {
-		createCount += len(recordChanges.NodeIDCreates)
+		createCount += len(recordChanges.NodeIDCreates) + len(recordChanges.PackageCreates)
 		deleteCount += len(recordChanges.InstanceIDDeletes)
 	}
 	return
@@ -55,6 +72,54 @@ type ProxyRecordChanges struct {
 	RecordExternalID ExternalInstanceID `json:"record_external_id"`
 	// NodeIDCreates The package node ids that should be linked to this record
 	NodeIDCreates []string `json:"node_id_creates"`
+	// PackageCreates link packages to this record like NodeIDCreates, but each with its own relationship, and
+	// optionally to other records with the same request
+	PackageCreates []ProxyPackageCreate `json:"package_creates,omitempty"`
 	// InstanceIDDeletes the proxy instance ids to delete for this record
 	InstanceIDDeletes []PennsieveInstanceID `json:"instance_id_deletes"`
 }
+
+// ProxyPackageCreate links one package to the record of its ProxyRecordChanges, and to any AdditionalTargets.
+// Each target becomes one entry of "targets" in the create request.
+type ProxyPackageCreate struct {
+	// NodeID is the package node id
+	NodeID string `json:"node_id"`
+	// ProxyRelationship is the relationship between the package and the record of the ProxyRecordChanges
+	ProxyRelationship
+	// AdditionalTargets are other records to link the package to
+	AdditionalTargets []ProxyTarget `json:"additional_targets,omitempty"`
+}
+
+// ProxyRelationship describes the relationship between a package proxy and a record. The zero value is the
+// "belongs_to" relationship from the record to the package used by NodeIDCreates.
+type ProxyRelationship struct {
+	// RelationshipType is the name of the relationship schema. "belongs_to" if empty.
+	RelationshipType string `json:"relationship_type,omitempty"`
+	// Direction is FromTarget if empty
+	Direction ProxyDirection `json:"direction,omitempty"`
+	// RelationshipData holds values for the properties of the relationship, if any
+	RelationshipData []RecordValue `json:"relationship_data,omitempty"`
+}
+
+// RelationshipTypeOrDefault returns RelationshipType, or "belongs_to" if it is empty
+func (r ProxyRelationship) RelationshipTypeOrDefault() string {
+	if len(r.RelationshipType) == 0 {
+		return ProxyRelationshipSchemaName
+	}
+	return r.RelationshipType
+}
+
+// DirectionOrDefault returns Direction, or FromTarget if it is empty
+func (r ProxyRelationship) DirectionOrDefault() ProxyDirection {
+	if len(r.Direction) == 0 {
+		return FromTarget
+	}
+	return r.Direction
+}
+
+// ProxyTarget is an additional record that a ProxyPackageCreate links its package to
+type ProxyTarget struct {
+	ModelName        string             `json:"model_name"`
+	RecordExternalID ExternalInstanceID `json:"record_external_id"`
+	ProxyRelationship
+}
@@ -0,0 +1,51 @@
+package models
+
+// RelationshipChanges holds the changes to the instances of one relationship type, optionally creating the relationship
+// schema. Unlike a linked property, a relationship is many-to-many and is not tied to a pair of models, so each instance
+// names the models of its records.
+// Executing the changes will depend on the records existing, so they are executed after model and link changes.
+type RelationshipChanges struct {
+	// The ID of the relationship schema. Can be empty or missing if the relationship schema does not exist.
+	// In this case, Create below should be non-nil
+	ID PennsieveSchemaID `json:"id,omitempty"`
+
+	// If Create is non-nil, the relationship schema should be created
+	Create *RelationshipSchemaCreate `json:"create,omitempty"`
+
+	// Instances contains the create and delete info for relationship instances
+	Instances RelationshipInstanceChanges `json:"instances"`
+}
+
+// RelationshipSchemaCreate will have to be translated to a POST /models/datasets/<dataset id>/relationships
+// request with body {"name": Name, "displayName": DisplayName, "description": Description, "schema": [], "from": <from model id>, "to": <to model id>}
+// once from and to model id values are known.
+type RelationshipSchemaCreate struct {
+	// Name is the relationship type, for example "derived_from"
+	Name string `json:"name"`
+
+	DisplayName string `json:"display_name"`
+
+	Description string `json:"description"`
+
+	// FromModelName, if not empty, restricts the relationship to records of this model in the "from" role
+	FromModelName string `json:"from_model_name,omitempty"`
+
+	// ToModelName, if not empty, restricts the relationship to records of this model in the "to" role
+	ToModelName string `json:"to_model_name,omitempty"`
+}
+
+type RelationshipInstanceChanges struct {
+	Create []RelationshipInstanceCreate `json:"create"`
+	// Delete holds the ids of relationship instances to delete
+	Delete []PennsieveInstanceID `json:"delete"`
+}
+
+// RelationshipInstanceCreate will have to be translated to a POST /models/datasets/<dataset id>/relationships/<relationship schema id>/instances
+// request with body {"from": <from record id>, "to": <to record id>, "values": []} once those id values are known
+// (The relationship schema and/or from and to records may not yet exist when instances of this struct are created)
+type RelationshipInstanceCreate struct {
+	FromModelName  string             `json:"from_model_name"`
+	FromExternalID ExternalInstanceID `json:"from_external_id"`
+	ToModelName    string             `json:"to_model_name"`
+	ToExternalID   ExternalInstanceID `json:"to_external_id"`
+}
@@ -0,0 +1,13 @@
+// Package stream reads and writes changesets too large to hold in memory at once.
+//
+// A streamed changeset is a sequence of JSON objects, one per line, each of which is a models.Dataset holding
+// part of the changes, called a section. Sections are applied in order, and must be in phase order: sections with
+// deletes first, then sections with model and record changes, then links, then relationships, and then proxies. ExistingModelIDMap and
+// RecordIDMaps do not belong to a phase. They can appear in any section, but must come before the sections that need
+// them. Within a section, as for a changeset.json, RecordIDMaps of models that already exist are added before any of
+// the section's changes, and those of models the section creates after its model changes.
+//
+// A ModelCreate can be split across sections by repeating the same ModelCreate.Create in each section
+// with the next part of the records. Likewise, a LinkedPropertyChanges or RelationshipChanges with a Create can be
+// repeated with the next part of the instances. The model, link schema, or relationship schema is only created once.
+package stream
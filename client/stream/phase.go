@@ -0,0 +1,108 @@
+package stream
+
+import (
+	"github.com/pennsieve/processor-post-metadata/client/models"
+)
+
+// Phase is one of the phases in which a changeset is applied. Sections of a streamed changeset must be in phase order.
+type Phase int
+
+const (
+	DeletesPhase Phase = iota
+	ModelChangesPhase
+	LinksPhase
+	RelationshipsPhase
+	ProxiesPhase
+)
+
+// NoPhase is returned by PhasesOf for sections that only hold ID maps
+const NoPhase Phase = -1
+
+var phaseNames = []string{"deletes", "model_changes", "links", "relationships", "proxies"}
+
+func (p Phase) String() string {
+	if p < DeletesPhase || p > ProxiesPhase {
+		return "none"
+	}
+	return phaseNames[p]
+}
+
+// PhasesOf returns the first and last phase that have changes in section. Both are NoPhase if section only contains
+// ExistingModelIDMap or RecordIDMaps, which do not belong to any phase.
+func PhasesOf(section models.Dataset) (first Phase, last Phase) {
+	first, last = NoPhase, NoPhase
+	for phase := DeletesPhase; phase <= ProxiesPhase; phase++ {
+		if hasChanges(section, phase) {
+			if first == NoPhase {
+				first = phase
+			}
+			last = phase
+		}
+	}
+	return
+}
+
+func hasChanges(section models.Dataset, phase Phase) bool {
+	switch phase {
+	case DeletesPhase:
+		if len(section.Models.Deletes) > 0 {
+			return true
+		}
+		for _, modelUpdate := range section.Models.Updates {
+			if len(modelUpdate.Records.Delete) > 0 {
+				return true
+			}
+		}
+		for _, linkChanges := range section.LinkedProperties {
+			if linkChanges.Delete || len(linkChanges.Instances.Delete) > 0 {
+				return true
+			}
+		}
+		for _, relationshipChanges := range section.Relationships {
+			if len(relationshipChanges.Instances.Delete) > 0 {
+				return true
+			}
+		}
+		if section.Proxies != nil {
+			for _, recordChanges := range section.Proxies.RecordChanges {
+				if len(recordChanges.InstanceIDDeletes) > 0 {
+					return true
+				}
+			}
+		}
+	case ModelChangesPhase:
+		if len(section.Models.Creates) > 0 {
+			return true
+		}
+		for _, modelUpdate := range section.Models.Updates {
+			if modelUpdate.Model != nil || !modelUpdate.Properties.IsEmpty() || len(modelUpdate.Records.Create)+len(modelUpdate.Records.Update)+len(modelUpdate.Records.Upsert) > 0 {
+				return true
+			}
+		}
+	case LinksPhase:
+		for _, linkChanges := range section.LinkedProperties {
+			if linkChanges.Create != nil || linkChanges.Update != nil || len(linkChanges.Instances.Create) > 0 {
+				return true
+			}
+		}
+	case RelationshipsPhase:
+		for _, relationshipChanges := range section.Relationships {
+			if relationshipChanges.Create != nil || len(relationshipChanges.Instances.Create) > 0 {
+				return true
+			}
+		}
+	case ProxiesPhase:
+		if section.Proxies == nil {
+			return false
+		}
+		if section.Proxies.CreateProxyRelationshipSchema || len(section.Proxies.RelationshipSchemaCreates) > 0 {
+			return true
+		}
+		for _, recordChanges := range section.Proxies.RecordChanges {
+			if len(recordChanges.NodeIDCreates) > 0 || len(recordChanges.PackageCreates) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package stream
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/pennsieve/processor-post-metadata/client/models"
+	"io"
+)
+
+// ErrOutOfOrder is returned when a section has changes from an earlier phase than a section before it
+var ErrOutOfOrder = errors.New("section out of phase order")
+
+// Reader reads a streamed changeset one section at a time, so that only one section needs to be in memory.
+type Reader struct {
+	decoder *json.Decoder
+	// index is the index of the last section returned by Next
+	index int
+	// phase is the last phase with changes in the sections read so far
+	phase Phase
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{
+		decoder: json.NewDecoder(r),
+		index:   -1,
+		phase:   NoPhase,
+	}
+}
+
+// Next returns the next section. Returns io.EOF after the last section. Returns an error wrapping ErrOutOfOrder if
+// the section has changes from an earlier phase than an earlier section.
+func (r *Reader) Next() (models.Dataset, error) {
+	var section models.Dataset
+	if err := r.decoder.Decode(&section); err != nil {
+		if errors.Is(err, io.EOF) {
+			return models.Dataset{}, io.EOF
+		}
+		return models.Dataset{}, fmt.Errorf("error decoding section %d: %w", r.index+1, err)
+	}
+	r.index++
+	first, last := PhasesOf(section)
+	if first == NoPhase {
+		return section, nil
+	}
+	if first < r.phase {
+		return models.Dataset{}, fmt.Errorf("section %d has %s changes after %s changes: %w", r.index, first, r.phase, ErrOutOfOrder)
+	}
+	r.phase = last
+	return section, nil
+}
+
+// Index returns the index of the last section returned by Next, starting from 0
+func (r *Reader) Index() int {
+	return r.index
+}
@@ -0,0 +1,307 @@
+package stream_test
+
+import (
+	"bytes"
+	"errors"
+	"github.com/pennsieve/processor-post-metadata/client/clienttest"
+	"github.com/pennsieve/processor-post-metadata/client/models"
+	"github.com/pennsieve/processor-post-metadata/client/stream"
+	"github.com/pennsieve/processor-post-metadata/client/validation"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestStream(t *testing.T) {
+	for scenario, testFunc := range map[string]func(t *testing.T){
+		"sections are split and in phase order": sectionsSplitInPhaseOrder,
+		"writer rejects out of order changes":   writerRejectsOutOfOrder,
+		"reader rejects out of order sections":  readerRejectsOutOfOrder,
+		"sections pass validation":              sectionsPassValidation,
+		"phases of a section":                   phasesOfSection,
+		"property changes before records":       propertyChangesBeforeRecords,
+		"relationships after links":             relationshipsAfterLinks,
+		"proxy package creates":                 proxyPackageCreates,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			testFunc(t)
+		})
+	}
+}
+
+func newRecordCreates(count int) []models.RecordCreate {
+	var recordCreates []models.RecordCreate
+	for i := 0; i < count; i++ {
+		recordCreates = append(recordCreates, models.RecordCreate{ExternalID: clienttest.NewExternalInstanceID()})
+	}
+	return recordCreates
+}
+
+// newChanges returns a changeset with changes in every phase. The created model has five records,
+// three of which are linked to the existing record, and two of which have a proxy
+func newChanges() models.Dataset {
+	modelCreate := clienttest.NewModelCreate()
+	recordCreates := newRecordCreates(5)
+	existingExternalID := clienttest.NewExternalInstanceID()
+	existingRecordIDMap := models.NewRecordIDMap("existing")
+	existingRecordIDMap.ExternalToPennsieve[existingExternalID] = clienttest.NewPennsieveInstanceID()
+	linkCreate := clienttest.NewSchemaLinkedPropertyCreate()
+	var instanceCreates []models.InstanceLinkedPropertyCreate
+	for _, recordCreate := range recordCreates[:3] {
+		instanceCreates = append(instanceCreates, models.InstanceLinkedPropertyCreate{
+			FromExternalID: recordCreate.ExternalID,
+			ToExternalID:   existingExternalID,
+		})
+	}
+	return models.Dataset{
+		Models: models.ModelChanges{
+			Creates: []models.ModelCreate{{
+				Create:  models.ModelPropsCreate{Model: modelCreate},
+				Records: recordCreates,
+			}},
+			Deletes: []models.ModelDelete{{ID: clienttest.NewPennsieveSchemaID()}},
+		},
+		LinkedProperties: []models.LinkedPropertyChanges{{
+			FromModelName: modelCreate.Name,
+			ToModelName:   "existing",
+			Create:        &linkCreate,
+			Instances:     models.InstanceChanges{Create: instanceCreates},
+		}},
+		Proxies: &models.ProxyChanges{
+			CreateProxyRelationshipSchema: true,
+			RecordChanges: []models.ProxyRecordChanges{
+				{ModelName: modelCreate.Name, RecordExternalID: recordCreates[0].ExternalID, NodeIDCreates: []string{"N:package:1"}},
+				{ModelName: modelCreate.Name, RecordExternalID: recordCreates[1].ExternalID, NodeIDCreates: []string{"N:package:2", "N:package:3"}},
+			},
+		},
+		ExistingModelIDMap: map[string]models.PennsieveSchemaID{"existing": clienttest.NewPennsieveSchemaID()},
+		RecordIDMaps:       []models.RecordIDMap{existingRecordIDMap},
+	}
+}
+
+func writeSections(t *testing.T, maxSectionSize int, changes ...models.Dataset) *bytes.Buffer {
+	var buffer bytes.Buffer
+	writer := stream.NewWriter(&buffer)
+	writer.MaxSectionSize = maxSectionSize
+	for _, change := range changes {
+		require.NoError(t, writer.Write(change))
+	}
+	return &buffer
+}
+
+func readSections(t *testing.T, r io.Reader) []models.Dataset {
+	reader := stream.NewReader(r)
+	var sections []models.Dataset
+	for {
+		section, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return sections
+		}
+		require.NoError(t, err)
+		assert.Equal(t, len(sections), reader.Index())
+		sections = append(sections, section)
+	}
+}
+
+func sectionsSplitInPhaseOrder(t *testing.T) {
+	changes := newChanges()
+	buffer := writeSections(t, 2, changes)
+	assert.Len(t, strings.Split(strings.TrimSpace(buffer.String()), "\n"), 10)
+
+	sections := readSections(t, buffer)
+	require.Len(t, sections, 10)
+
+	assert.Equal(t, changes.ExistingModelIDMap, sections[0].ExistingModelIDMap)
+	assert.Equal(t, changes.RecordIDMaps, sections[1].RecordIDMaps)
+	assert.Equal(t, changes.Models.Deletes, sections[2].Models.Deletes)
+
+	// records split into sections of two, each with the same model create
+	var records []models.RecordCreate
+	for _, section := range sections[3:6] {
+		require.Len(t, section.Models.Creates, 1)
+		assert.Equal(t, changes.Models.Creates[0].Create, section.Models.Creates[0].Create)
+		assert.LessOrEqual(t, len(section.Models.Creates[0].Records), 2)
+		records = append(records, section.Models.Creates[0].Records...)
+	}
+	assert.Equal(t, changes.Models.Creates[0].Records, records)
+
+	var instances []models.InstanceLinkedPropertyCreate
+	for _, section := range sections[6:8] {
+		require.Len(t, section.LinkedProperties, 1)
+		assert.Equal(t, changes.LinkedProperties[0].Create, section.LinkedProperties[0].Create)
+		instances = append(instances, section.LinkedProperties[0].Instances.Create...)
+	}
+	assert.Equal(t, changes.LinkedProperties[0].Instances.Create, instances)
+
+	// the first proxy section has the relationship schema create and one package. The second package of the
+	// second record would not fit.
+	require.NotNil(t, sections[8].Proxies)
+	assert.True(t, sections[8].Proxies.CreateProxyRelationshipSchema)
+	assert.Equal(t, changes.Proxies.RecordChanges[:1], sections[8].Proxies.RecordChanges)
+	require.NotNil(t, sections[9].Proxies)
+	assert.False(t, sections[9].Proxies.CreateProxyRelationshipSchema)
+	assert.Equal(t, changes.Proxies.RecordChanges[1:], sections[9].Proxies.RecordChanges)
+}
+
+func writerRejectsOutOfOrder(t *testing.T) {
+	writer := stream.NewWriter(io.Discard)
+	linkChanges := models.Dataset{LinkedProperties: newChanges().LinkedProperties}
+	modelChanges := models.Dataset{Models: models.ModelChanges{Creates: newChanges().Models.Creates}}
+
+	require.NoError(t, writer.Write(linkChanges))
+	// ID maps can come at any time
+	require.NoError(t, writer.Write(models.Dataset{ExistingModelIDMap: map[string]models.PennsieveSchemaID{"other": clienttest.NewPennsieveSchemaID()}}))
+	assert.ErrorIs(t, writer.Write(modelChanges), stream.ErrOutOfOrder)
+}
+
+func readerRejectsOutOfOrder(t *testing.T) {
+	linkChanges := models.Dataset{LinkedProperties: newChanges().LinkedProperties}
+	modelChanges := models.Dataset{Models: models.ModelChanges{Creates: newChanges().Models.Creates}}
+	var buffer bytes.Buffer
+	// write separately to get around the Writer's check
+	require.NoError(t, stream.NewWriter(&buffer).Write(linkChanges))
+	require.NoError(t, stream.NewWriter(&buffer).Write(modelChanges))
+
+	reader := stream.NewReader(&buffer)
+	_, err := reader.Next()
+	require.NoError(t, err)
+	_, err = reader.Next()
+	assert.ErrorIs(t, err, stream.ErrOutOfOrder)
+}
+
+func sectionsPassValidation(t *testing.T) {
+	changes := newChanges()
+	require.NoError(t, validation.Validate(changes))
+
+	validator := validation.NewValidator()
+	for _, section := range readSections(t, writeSections(t, 2, changes)) {
+		validator.Add("", section)
+	}
+	assert.NoError(t, validator.Err())
+}
+
+func phasesOfSection(t *testing.T) {
+	first, last := stream.PhasesOf(newChanges())
+	assert.Equal(t, stream.DeletesPhase, first)
+	assert.Equal(t, stream.ProxiesPhase, last)
+
+	first, last = stream.PhasesOf(models.Dataset{RecordIDMaps: newChanges().RecordIDMaps})
+	assert.Equal(t, stream.NoPhase, first)
+	assert.Equal(t, stream.NoPhase, last)
+
+	first, last = stream.PhasesOf(models.Dataset{Models: models.ModelChanges{Updates: []models.ModelUpdate{{
+		ID:      clienttest.NewPennsieveSchemaID(),
+		Records: models.RecordChanges{Delete: []models.PennsieveInstanceID{clienttest.NewPennsieveInstanceID()}},
+	}}}})
+	assert.Equal(t, stream.DeletesPhase, first)
+	assert.Equal(t, stream.DeletesPhase, last)
+}
+
+func propertyChangesBeforeRecords(t *testing.T) {
+	modelUpdate := models.ModelUpdate{
+		ID:         clienttest.NewPennsieveSchemaID(),
+		Properties: models.PropertyChanges{Delete: []models.PennsieveSchemaID{clienttest.NewPennsieveSchemaID()}},
+		Records:    models.RecordChanges{Create: newRecordCreates(3)},
+	}
+	sections := readSections(t, writeSections(t, 2, models.Dataset{Models: models.ModelChanges{Updates: []models.ModelUpdate{modelUpdate}}}))
+	require.Len(t, sections, 3)
+
+	require.Len(t, sections[0].Models.Updates, 1)
+	assert.Equal(t, modelUpdate.Properties, sections[0].Models.Updates[0].Properties)
+	assert.Empty(t, sections[0].Models.Updates[0].Records.Create)
+	first, last := stream.PhasesOf(sections[0])
+	assert.Equal(t, stream.ModelChangesPhase, first)
+	assert.Equal(t, stream.ModelChangesPhase, last)
+
+	for _, section := range sections[1:] {
+		require.Len(t, section.Models.Updates, 1)
+		assert.True(t, section.Models.Updates[0].Properties.IsEmpty())
+	}
+}
+
+func relationshipsAfterLinks(t *testing.T) {
+	changes := newChanges()
+	relationshipCreate := clienttest.NewRelationshipSchemaCreate()
+	var instanceCreates []models.RelationshipInstanceCreate
+	for _, recordCreate := range changes.Models.Creates[0].Records[:3] {
+		instanceCreates = append(instanceCreates, models.RelationshipInstanceCreate{
+			FromModelName:  changes.Models.Creates[0].Create.Model.Name,
+			FromExternalID: recordCreate.ExternalID,
+			ToModelName:    changes.Models.Creates[0].Create.Model.Name,
+			ToExternalID:   changes.Models.Creates[0].Records[4].ExternalID,
+		})
+	}
+	relationshipDelete := clienttest.NewPennsieveInstanceID()
+	changes.Relationships = []models.RelationshipChanges{
+		{
+			Create:    &relationshipCreate,
+			Instances: models.RelationshipInstanceChanges{Create: instanceCreates},
+		},
+		{
+			ID:        clienttest.NewPennsieveSchemaID(),
+			Instances: models.RelationshipInstanceChanges{Delete: []models.PennsieveInstanceID{relationshipDelete}},
+		},
+	}
+
+	sections := readSections(t, writeSections(t, 2, changes))
+	// two more sections for the relationship instance creates
+	require.Len(t, sections, 12)
+
+	// the relationship delete is with the other deletes
+	require.Len(t, sections[2].Relationships, 1)
+	assert.Equal(t, []models.PennsieveInstanceID{relationshipDelete}, sections[2].Relationships[0].Instances.Delete)
+
+	var instances []models.RelationshipInstanceCreate
+	for _, section := range sections[8:10] {
+		first, last := stream.PhasesOf(section)
+		assert.Equal(t, stream.RelationshipsPhase, first)
+		assert.Equal(t, stream.RelationshipsPhase, last)
+		require.Len(t, section.Relationships, 1)
+		assert.Equal(t, &relationshipCreate, section.Relationships[0].Create)
+		instances = append(instances, section.Relationships[0].Instances.Create...)
+	}
+	assert.Equal(t, instanceCreates, instances)
+
+	validator := validation.NewValidator()
+	for _, section := range sections {
+		validator.Add("", section)
+	}
+	assert.NoError(t, validator.Err())
+}
+
+func proxyPackageCreates(t *testing.T) {
+	changes := newChanges()
+	relationshipCreate := clienttest.NewRelationshipSchemaCreate()
+	changes.Proxies.RelationshipSchemaCreates = []models.RelationshipSchemaCreate{relationshipCreate}
+	var packageCreates []models.ProxyPackageCreate
+	for _, nodeID := range []string{"N:package:4", "N:package:5", "N:package:6"} {
+		packageCreates = append(packageCreates, models.ProxyPackageCreate{
+			NodeID:            nodeID,
+			ProxyRelationship: models.ProxyRelationship{RelationshipType: relationshipCreate.Name},
+		})
+	}
+	changes.Proxies.RecordChanges[0].PackageCreates = packageCreates
+
+	sections := readSections(t, writeSections(t, 2, changes))
+	require.Len(t, sections, 12)
+
+	// the schema creates are in the first proxy section
+	require.NotNil(t, sections[8].Proxies)
+	assert.Equal(t, changes.Proxies.RelationshipSchemaCreates, sections[8].Proxies.RelationshipSchemaCreates)
+	var actual []models.ProxyPackageCreate
+	for i, section := range sections[8:] {
+		require.NotNil(t, section.Proxies)
+		if i > 0 {
+			assert.Empty(t, section.Proxies.RelationshipSchemaCreates)
+		}
+		for _, recordChanges := range section.Proxies.RecordChanges {
+			actual = append(actual, recordChanges.PackageCreates...)
+		}
+		first, last := stream.PhasesOf(section)
+		assert.Equal(t, stream.ProxiesPhase, first)
+		assert.Equal(t, stream.ProxiesPhase, last)
+	}
+	assert.Equal(t, packageCreates, actual)
+}
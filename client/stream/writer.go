@@ -0,0 +1,281 @@
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pennsieve/processor-post-metadata/client/models"
+	"io"
+)
+
+const DefaultMaxSectionSize = 1000
+
+// Writer writes a changeset as a stream of sections, one JSON object per line. Changes must be written in phase
+// order: deletes, then model and record changes, then links, then relationships, and then proxies. The Writer does
+// not buffer, so wrap the destination in a bufio.Writer if needed.
+type Writer struct {
+	encoder *json.Encoder
+	// MaxSectionSize is the maximum number of records, link or relationship instances, proxies, or record ID map
+	// entries in one section. Deletes, model updates, and property changes are not split.
+	MaxSectionSize int
+	// phase is the last phase with changes written so far
+	phase Phase
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		encoder:        json.NewEncoder(w),
+		MaxSectionSize: DefaultMaxSectionSize,
+		phase:          NoPhase,
+	}
+}
+
+// Write splits changes by phase into sections of at most MaxSectionSize items and writes them. A model create, link
+// create, or relationship create that does not fit in one section is repeated in each of its sections along with the
+// next part of its records or instances. Returns an error wrapping ErrOutOfOrder if changes has changes from an earlier phase than
+// changes already written.
+func (w *Writer) Write(changes models.Dataset) error {
+	if first, _ := PhasesOf(changes); first != NoPhase && first < w.phase {
+		return fmt.Errorf("cannot write %s changes after %s changes: %w", first, w.phase, ErrOutOfOrder)
+	}
+	for _, section := range w.split(changes) {
+		if err := w.encoder.Encode(section); err != nil {
+			return fmt.Errorf("error writing changeset section: %w", err)
+		}
+		if _, last := PhasesOf(section); last != NoPhase {
+			w.phase = last
+		}
+	}
+	return nil
+}
+
+func (w *Writer) split(changes models.Dataset) []models.Dataset {
+	var sections []models.Dataset
+	if len(changes.ExistingModelIDMap) > 0 {
+		sections = append(sections, models.Dataset{ExistingModelIDMap: changes.ExistingModelIDMap})
+	}
+	// RecordIDMaps map existing records, so they can go before any changes
+	sections = append(sections, w.recordIDMapSections(changes.RecordIDMaps)...)
+	if deletes, found := deletesSection(changes); found {
+		sections = append(sections, deletes)
+	}
+	sections = append(sections, w.modelSections(changes.Models)...)
+	sections = append(sections, w.linkSections(changes.LinkedProperties)...)
+	sections = append(sections, w.relationshipSections(changes.Relationships)...)
+	sections = append(sections, w.proxySections(changes.Proxies)...)
+	return sections
+}
+
+// deletesSection returns a section with only the deletes from changes. Returns false if there are no deletes.
+func deletesSection(changes models.Dataset) (models.Dataset, bool) {
+	section := models.Dataset{
+		Models: models.ModelChanges{Deletes: changes.Models.Deletes},
+	}
+	for _, modelUpdate := range changes.Models.Updates {
+		if len(modelUpdate.Records.Delete) > 0 {
+			section.Models.Updates = append(section.Models.Updates, models.ModelUpdate{
+				ID:      modelUpdate.ID,
+				Records: models.RecordChanges{Delete: modelUpdate.Records.Delete},
+			})
+		}
+	}
+	for _, linkChanges := range changes.LinkedProperties {
+		if linkChanges.Delete || len(linkChanges.Instances.Delete) > 0 {
+			section.LinkedProperties = append(section.LinkedProperties, models.LinkedPropertyChanges{
+				FromModelName: linkChanges.FromModelName,
+				ToModelName:   linkChanges.ToModelName,
+				ID:            linkChanges.ID,
+				Delete:        linkChanges.Delete,
+				Instances:     models.InstanceChanges{Delete: linkChanges.Instances.Delete},
+			})
+		}
+	}
+	for _, relationshipChanges := range changes.Relationships {
+		if len(relationshipChanges.Instances.Delete) > 0 {
+			section.Relationships = append(section.Relationships, models.RelationshipChanges{
+				ID:        relationshipChanges.ID,
+				Instances: models.RelationshipInstanceChanges{Delete: relationshipChanges.Instances.Delete},
+			})
+		}
+	}
+	if changes.Proxies != nil {
+		var proxyDeletes []models.ProxyRecordChanges
+		for _, recordChanges := range changes.Proxies.RecordChanges {
+			if len(recordChanges.InstanceIDDeletes) > 0 {
+				proxyDeletes = append(proxyDeletes, models.ProxyRecordChanges{
+					ModelName:         recordChanges.ModelName,
+					RecordExternalID:  recordChanges.RecordExternalID,
+					InstanceIDDeletes: recordChanges.InstanceIDDeletes,
+				})
+			}
+		}
+		if len(proxyDeletes) > 0 {
+			section.Proxies = &models.ProxyChanges{RecordChanges: proxyDeletes}
+		}
+	}
+	first, _ := PhasesOf(section)
+	return section, first == DeletesPhase
+}
+
+func (w *Writer) modelSections(modelChanges models.ModelChanges) []models.Dataset {
+	var sections []models.Dataset
+	for _, modelCreate := range modelChanges.Creates {
+		for _, records := range chunks(modelCreate.Records, w.MaxSectionSize) {
+			sections = append(sections, models.Dataset{Models: models.ModelChanges{
+				Creates: []models.ModelCreate{{Create: modelCreate.Create, Records: records}},
+			}})
+		}
+	}
+	for _, modelUpdate := range modelChanges.Updates {
+		// model and property changes are not split, and come before the record changes that may depend on them
+		if modelUpdate.Model != nil || !modelUpdate.Properties.IsEmpty() {
+			sections = append(sections, modelUpdateSection(models.ModelUpdate{
+				ID:         modelUpdate.ID,
+				Model:      modelUpdate.Model,
+				Properties: modelUpdate.Properties,
+			}))
+		}
+		if len(modelUpdate.Records.Create) > 0 {
+			for _, records := range chunks(modelUpdate.Records.Create, w.MaxSectionSize) {
+				sections = append(sections, modelUpdateSection(models.ModelUpdate{ID: modelUpdate.ID, Records: models.RecordChanges{Create: records}}))
+			}
+		}
+		if len(modelUpdate.Records.Update) > 0 {
+			for _, records := range chunks(modelUpdate.Records.Update, w.MaxSectionSize) {
+				sections = append(sections, modelUpdateSection(models.ModelUpdate{ID: modelUpdate.ID, Records: models.RecordChanges{Update: records}}))
+			}
+		}
+		if len(modelUpdate.Records.Upsert) > 0 {
+			for _, records := range chunks(modelUpdate.Records.Upsert, w.MaxSectionSize) {
+				sections = append(sections, modelUpdateSection(models.ModelUpdate{ID: modelUpdate.ID, Records: models.RecordChanges{Upsert: records}}))
+			}
+		}
+	}
+	return sections
+}
+
+func modelUpdateSection(modelUpdate models.ModelUpdate) models.Dataset {
+	return models.Dataset{Models: models.ModelChanges{
+		Updates: []models.ModelUpdate{modelUpdate},
+	}}
+}
+
+func (w *Writer) recordIDMapSections(recordIDMaps []models.RecordIDMap) []models.Dataset {
+	var sections []models.Dataset
+	for _, recordIDMap := range recordIDMaps {
+		current := models.NewRecordIDMap(recordIDMap.ModelName)
+		for externalID, recordID := range recordIDMap.ExternalToPennsieve {
+			current.ExternalToPennsieve[externalID] = recordID
+			if len(current.ExternalToPennsieve) >= w.MaxSectionSize {
+				sections = append(sections, models.Dataset{RecordIDMaps: []models.RecordIDMap{current}})
+				current = models.NewRecordIDMap(recordIDMap.ModelName)
+			}
+		}
+		if len(current.ExternalToPennsieve) > 0 {
+			sections = append(sections, models.Dataset{RecordIDMaps: []models.RecordIDMap{current}})
+		}
+	}
+	return sections
+}
+
+func (w *Writer) linkSections(linkChanges []models.LinkedPropertyChanges) []models.Dataset {
+	var sections []models.Dataset
+	for _, linkChange := range linkChanges {
+		if linkChange.Create == nil && linkChange.Update == nil && len(linkChange.Instances.Create) == 0 {
+			continue
+		}
+		for i, instances := range chunks(linkChange.Instances.Create, w.MaxSectionSize) {
+			sectionChange := models.LinkedPropertyChanges{
+				FromModelName: linkChange.FromModelName,
+				ToModelName:   linkChange.ToModelName,
+				ID:            linkChange.ID,
+				Create:        linkChange.Create,
+				Instances:     models.InstanceChanges{Create: instances},
+			}
+			// the update only needs to be made once
+			if i == 0 {
+				sectionChange.Update = linkChange.Update
+			}
+			sections = append(sections, models.Dataset{LinkedProperties: []models.LinkedPropertyChanges{sectionChange}})
+		}
+	}
+	return sections
+}
+
+func (w *Writer) relationshipSections(relationshipChanges []models.RelationshipChanges) []models.Dataset {
+	var sections []models.Dataset
+	for _, relationshipChange := range relationshipChanges {
+		if relationshipChange.Create == nil && len(relationshipChange.Instances.Create) == 0 {
+			continue
+		}
+		for _, instances := range chunks(relationshipChange.Instances.Create, w.MaxSectionSize) {
+			sections = append(sections, models.Dataset{Relationships: []models.RelationshipChanges{{
+				ID:        relationshipChange.ID,
+				Create:    relationshipChange.Create,
+				Instances: models.RelationshipInstanceChanges{Create: instances},
+			}}})
+		}
+	}
+	return sections
+}
+
+// proxySections packs the proxy creates of several records into one section while they fit
+func (w *Writer) proxySections(proxyChanges *models.ProxyChanges) []models.Dataset {
+	if proxyChanges == nil {
+		return nil
+	}
+	var sections []models.Dataset
+	// the relationship schemas go in the first section, before any proxies that need them
+	current := &models.ProxyChanges{
+		CreateProxyRelationshipSchema: proxyChanges.CreateProxyRelationshipSchema,
+		RelationshipSchemaCreates:     proxyChanges.RelationshipSchemaCreates,
+	}
+	currentSize := 0
+	flush := func() {
+		sections = append(sections, models.Dataset{Proxies: current})
+		current = &models.ProxyChanges{}
+		currentSize = 0
+	}
+	add := func(recordChanges models.ProxyRecordChanges, size int) {
+		if currentSize > 0 && currentSize+size > w.MaxSectionSize {
+			flush()
+		}
+		current.RecordChanges = append(current.RecordChanges, recordChanges)
+		currentSize += size
+	}
+	for _, recordChanges := range proxyChanges.RecordChanges {
+		if len(recordChanges.NodeIDCreates) > 0 {
+			for _, nodeIDs := range chunks(recordChanges.NodeIDCreates, w.MaxSectionSize) {
+				add(models.ProxyRecordChanges{
+					ModelName:        recordChanges.ModelName,
+					RecordExternalID: recordChanges.RecordExternalID,
+					NodeIDCreates:    nodeIDs,
+				}, len(nodeIDs))
+			}
+		}
+		if len(recordChanges.PackageCreates) > 0 {
+			for _, packageCreates := range chunks(recordChanges.PackageCreates, w.MaxSectionSize) {
+				add(models.ProxyRecordChanges{
+					ModelName:        recordChanges.ModelName,
+					RecordExternalID: recordChanges.RecordExternalID,
+					PackageCreates:   packageCreates,
+				}, len(packageCreates))
+			}
+		}
+	}
+	if current.CreateProxyRelationshipSchema || len(current.RelationshipSchemaCreates) > 0 || currentSize > 0 {
+		flush()
+	}
+	return sections
+}
+
+// chunks splits items into slices of at most size items. Always returns at least one, possibly empty, chunk.
+func chunks[T any](items []T, size int) [][]T {
+	if size <= 0 || len(items) <= size {
+		return [][]T{items}
+	}
+	var result [][]T
+	for start := 0; start < len(items); start += size {
+		result = append(result, items[start:min(start+size, len(items))])
+	}
+	return result
+}
@@ -0,0 +1,350 @@
+// Package validation checks a changeset for problems that would otherwise only be discovered part way through
+// applying it, after some of the changes have already been made.
+package validation
+
+import (
+	"fmt"
+	"github.com/pennsieve/processor-post-metadata/client/models"
+	"reflect"
+	"strings"
+)
+
+// Problem is one thing wrong with a changeset
+type Problem struct {
+	// Path locates the problem in the changeset JSON, for example linked_properties[2].instances.create[0]
+	Path    string
+	Message string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s: %s", p.Path, p.Message)
+}
+
+// Error is returned by Validate and lists every Problem found
+type Error struct {
+	Problems []Problem
+}
+
+func (e *Error) Error() string {
+	var builder strings.Builder
+	_, _ = fmt.Fprintf(&builder, "changeset has %d problem(s):", len(e.Problems))
+	for _, problem := range e.Problems {
+		builder.WriteString("\n\t")
+		builder.WriteString(problem.String())
+	}
+	return builder.String()
+}
+
+// Validate checks that every model name and record external ID referred to by the changeset is either already known
+// to Pennsieve through ExistingModelIDMap and RecordIDMaps, or created by the changeset, that names and IDs are not
+// duplicated, that every LinkedPropertyChanges identifies its link schema and every property update or delete its
+// property, and that every record upsert has an external ID and a value for its key property. Returns nil if the
+// changeset is valid, otherwise an *Error listing all problems.
+func Validate(dataset models.Dataset) error {
+	v := newValidator(false)
+	v.add("", dataset)
+	return v.Err()
+}
+
+// Validator makes the same checks as Validate on a changeset that comes in parts, such as the sections of a
+// streamed changeset. Each part may refer to models and records from earlier parts. A ModelCreate repeated in a
+// later part with the same ModelCreate.Create continues the earlier one rather than duplicating it.
+type Validator struct {
+	validator *validator
+}
+
+func NewValidator() *Validator {
+	return &Validator{validator: newValidator(true)}
+}
+
+// Add checks the next part of the changeset. The paths of any problems found start with pathPrefix.
+func (v *Validator) Add(pathPrefix string, part models.Dataset) {
+	v.validator.add(pathPrefix, part)
+}
+
+// Err returns nil if no problems were found in the parts added so far, otherwise an *Error listing all problems.
+func (v *Validator) Err() error {
+	return v.validator.Err()
+}
+
+type validator struct {
+	// allowContinuations is true if a ModelCreate may be repeated to add more records
+	allowContinuations bool
+	// pathPrefix is prepended to the path of problems in the part being added
+	pathPrefix string
+	dataset    models.Dataset
+	problems   []Problem
+	// models holds the names of all models that exist or will be created
+	models map[string]bool
+	// modelCreates holds the params of models that will be created, by name
+	modelCreates map[string]models.ModelPropsCreate
+	// records holds, by model name, the external IDs of all records that exist or will be created
+	records map[string]map[models.ExternalInstanceID]bool
+	// modelNameByID is the reverse of ExistingModelIDMap
+	modelNameByID map[models.PennsieveSchemaID]string
+}
+
+func newValidator(allowContinuations bool) *validator {
+	return &validator{
+		allowContinuations: allowContinuations,
+		models:             map[string]bool{},
+		modelCreates:       map[string]models.ModelPropsCreate{},
+		records:            map[string]map[models.ExternalInstanceID]bool{},
+		modelNameByID:      map[models.PennsieveSchemaID]string{},
+	}
+}
+
+func (v *validator) add(pathPrefix string, dataset models.Dataset) {
+	v.pathPrefix = pathPrefix
+	v.dataset = dataset
+	for name, id := range dataset.ExistingModelIDMap {
+		v.models[name] = true
+		v.modelNameByID[id] = name
+	}
+	v.validateModels()
+	v.validateRecordIDMaps()
+	v.validateLinks()
+	v.validateRelationships()
+	v.validateProxies()
+}
+
+func (v *validator) Err() error {
+	if len(v.problems) == 0 {
+		return nil
+	}
+	return &Error{Problems: v.problems}
+}
+
+func (v *validator) addProblem(path string, format string, args ...any) {
+	v.problems = append(v.problems, Problem{Path: v.pathPrefix + path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) validateModels() {
+	for i, modelCreate := range v.dataset.Models.Creates {
+		path := fmt.Sprintf("models.creates[%d]", i)
+		name := modelCreate.Create.Model.Name
+		if len(name) == 0 {
+			v.addProblem(path+".create.model.name", "model name is empty")
+		} else if v.models[name] && !v.isContinuation(modelCreate.Create) {
+			v.addProblem(path+".create.model.name", "duplicate model name %q", name)
+		}
+		v.models[name] = true
+		v.modelCreates[name] = modelCreate.Create
+		v.addRecordCreates(path+".records", name, modelCreate.Records)
+	}
+	for i, modelUpdate := range v.dataset.Models.Updates {
+		// records of a model are referred to by model name, so only records of models in ExistingModelIDMap
+		// can be the target of links or proxies. But still check for duplicates in the others.
+		modelKey, found := v.modelNameByID[modelUpdate.ID]
+		if !found {
+			modelKey = "id:" + modelUpdate.ID.String()
+		}
+		if modelUpdate.Model != nil && modelUpdate.Model.DisplayName != nil && len(*modelUpdate.Model.DisplayName) == 0 {
+			v.addProblem(fmt.Sprintf("models.updates[%d].model.display_name", i), "model display name is empty")
+		}
+		v.validatePropertyChanges(fmt.Sprintf("models.updates[%d].properties", i), modelUpdate.Properties)
+		v.addRecordCreates(fmt.Sprintf("models.updates[%d].records.create", i), modelKey, modelUpdate.Records.Create)
+		v.addRecordUpserts(fmt.Sprintf("models.updates[%d].records.upsert", i), modelKey, modelUpdate.Records.Upsert)
+	}
+}
+
+func (v *validator) validatePropertyChanges(path string, propertyChanges models.PropertyChanges) {
+	for i, propertyID := range propertyChanges.Delete {
+		if len(propertyID) == 0 {
+			v.addProblem(fmt.Sprintf("%s.delete[%d]", path, i), "property id is empty")
+		}
+	}
+	names := map[string]bool{}
+	for i, propertyCreate := range propertyChanges.Create {
+		namePath := fmt.Sprintf("%s.create[%d].name", path, i)
+		if len(propertyCreate.Name) == 0 {
+			v.addProblem(namePath, "property name is empty")
+		} else if names[propertyCreate.Name] {
+			v.addProblem(namePath, "duplicate property name %q", propertyCreate.Name)
+		}
+		names[propertyCreate.Name] = true
+	}
+	for i, propertyUpdate := range propertyChanges.Update {
+		if len(propertyUpdate.ID) == 0 {
+			v.addProblem(fmt.Sprintf("%s.update[%d].id", path, i), "property id is empty")
+		}
+	}
+}
+
+// isContinuation returns true if continuations are allowed and a model was already created with the same params
+func (v *validator) isContinuation(modelCreate models.ModelPropsCreate) bool {
+	if !v.allowContinuations {
+		return false
+	}
+	earlier, found := v.modelCreates[modelCreate.Model.Name]
+	return found && reflect.DeepEqual(earlier, modelCreate)
+}
+
+func (v *validator) addRecordCreates(path string, modelName string, recordCreates []models.RecordCreate) {
+	for i, recordCreate := range recordCreates {
+		// external IDs are optional for records that are not the target of links or proxies
+		if len(recordCreate.ExternalID) == 0 {
+			continue
+		}
+		v.addRecord(fmt.Sprintf("%s[%d].external_id", path, i), modelName, recordCreate.ExternalID)
+	}
+}
+
+func (v *validator) addRecordUpserts(path string, modelName string, recordUpserts []models.RecordUpsert) {
+	for i, recordUpsert := range recordUpserts {
+		upsertPath := fmt.Sprintf("%s[%d]", path, i)
+		if len(recordUpsert.KeyProperty) == 0 {
+			v.addProblem(upsertPath+".key_property", "key property is empty")
+		} else if _, found := recordUpsert.KeyValue(); !found {
+			v.addProblem(upsertPath+".values", "no value for key property %q", recordUpsert.KeyProperty)
+		}
+		// unlike for creates, the external ID is required, since it is the only way to refer to the record
+		if len(recordUpsert.ExternalID) == 0 {
+			v.addProblem(upsertPath+".external_id", "external ID is empty")
+			continue
+		}
+		v.addRecord(upsertPath+".external_id", modelName, recordUpsert.ExternalID)
+	}
+}
+
+func (v *validator) addRecord(path string, modelName string, externalID models.ExternalInstanceID) {
+	modelRecords, found := v.records[modelName]
+	if !found {
+		modelRecords = map[models.ExternalInstanceID]bool{}
+		v.records[modelName] = modelRecords
+	}
+	if modelRecords[externalID] {
+		v.addProblem(path, "duplicate external ID %q in model %q", externalID, modelName)
+	}
+	modelRecords[externalID] = true
+}
+
+func (v *validator) validateRecordIDMaps() {
+	for i, recordIDMap := range v.dataset.RecordIDMaps {
+		path := fmt.Sprintf("record_id_maps[%d]", i)
+		v.checkModelName(path+".model_name", recordIDMap.ModelName)
+		for externalID := range recordIDMap.ExternalToPennsieve {
+			v.addRecord(fmt.Sprintf("%s.external_to_pennsieve[%q]", path, externalID), recordIDMap.ModelName, externalID)
+		}
+	}
+}
+
+func (v *validator) validateLinks() {
+	for i, linkChanges := range v.dataset.LinkedProperties {
+		path := fmt.Sprintf("linked_properties[%d]", i)
+		fromKnown := v.checkModelName(path+".from_model_name", linkChanges.FromModelName)
+		toKnown := v.checkModelName(path+".to_model_name", linkChanges.ToModelName)
+		if len(linkChanges.ID) == 0 && linkChanges.Create == nil {
+			v.addProblem(path, "neither id nor create is set")
+		}
+		if linkChanges.Update != nil && len(linkChanges.ID) == 0 {
+			v.addProblem(path+".update", "update of a link schema requires id")
+		}
+		if linkChanges.Delete {
+			if len(linkChanges.ID) == 0 {
+				v.addProblem(path+".delete", "delete of a link schema requires id")
+			}
+			if linkChanges.Create != nil || linkChanges.Update != nil || len(linkChanges.Instances.Create) > 0 {
+				v.addProblem(path+".delete", "deleted link schema cannot also be created, updated, or have instances created")
+			}
+		}
+		for j, instanceCreate := range linkChanges.Instances.Create {
+			instancePath := fmt.Sprintf("%s.instances.create[%d]", path, j)
+			if fromKnown {
+				v.checkExternalID(instancePath+".from_external_id", linkChanges.FromModelName, instanceCreate.FromExternalID)
+			}
+			if toKnown {
+				v.checkExternalID(instancePath+".to_external_id", linkChanges.ToModelName, instanceCreate.ToExternalID)
+			}
+		}
+	}
+}
+
+func (v *validator) validateRelationships() {
+	for i, relationshipChanges := range v.dataset.Relationships {
+		path := fmt.Sprintf("relationships[%d]", i)
+		if len(relationshipChanges.ID) == 0 && relationshipChanges.Create == nil {
+			v.addProblem(path, "neither id nor create is set")
+		}
+		if create := relationshipChanges.Create; create != nil {
+			if len(create.Name) == 0 {
+				v.addProblem(path+".create.name", "relationship name is empty")
+			}
+			if len(create.FromModelName) > 0 {
+				v.checkModelName(path+".create.from_model_name", create.FromModelName)
+			}
+			if len(create.ToModelName) > 0 {
+				v.checkModelName(path+".create.to_model_name", create.ToModelName)
+			}
+		}
+		for j, instanceCreate := range relationshipChanges.Instances.Create {
+			instancePath := fmt.Sprintf("%s.instances.create[%d]", path, j)
+			if v.checkModelName(instancePath+".from_model_name", instanceCreate.FromModelName) {
+				v.checkExternalID(instancePath+".from_external_id", instanceCreate.FromModelName, instanceCreate.FromExternalID)
+			}
+			if v.checkModelName(instancePath+".to_model_name", instanceCreate.ToModelName) {
+				v.checkExternalID(instancePath+".to_external_id", instanceCreate.ToModelName, instanceCreate.ToExternalID)
+			}
+		}
+	}
+}
+
+func (v *validator) validateProxies() {
+	if v.dataset.Proxies == nil {
+		return
+	}
+	for i, schemaCreate := range v.dataset.Proxies.RelationshipSchemaCreates {
+		namePath := fmt.Sprintf("proxies.relationship_schema_creates[%d].name", i)
+		if len(schemaCreate.Name) == 0 {
+			v.addProblem(namePath, "relationship name is empty")
+		} else if schemaCreate.Name == models.ProxyRelationshipSchemaName {
+			v.addProblem(namePath, "use create_proxy_relationship_schema to create %q", schemaCreate.Name)
+		}
+	}
+	for i, recordChanges := range v.dataset.Proxies.RecordChanges {
+		path := fmt.Sprintf("proxies.record_changes[%d]", i)
+		if v.checkModelName(path+".model_name", recordChanges.ModelName) {
+			v.checkExternalID(path+".record_external_id", recordChanges.ModelName, recordChanges.RecordExternalID)
+		}
+		for j, packageCreate := range recordChanges.PackageCreates {
+			packagePath := fmt.Sprintf("%s.package_creates[%d]", path, j)
+			if len(packageCreate.NodeID) == 0 {
+				v.addProblem(packagePath+".node_id", "package node id is empty")
+			}
+			v.checkProxyDirection(packagePath+".direction", packageCreate.Direction)
+			for k, target := range packageCreate.AdditionalTargets {
+				targetPath := fmt.Sprintf("%s.additional_targets[%d]", packagePath, k)
+				if v.checkModelName(targetPath+".model_name", target.ModelName) {
+					v.checkExternalID(targetPath+".record_external_id", target.ModelName, target.RecordExternalID)
+				}
+				v.checkProxyDirection(targetPath+".direction", target.Direction)
+			}
+		}
+	}
+}
+
+// checkProxyDirection adds a Problem if direction is neither empty nor one of the ProxyDirection constants
+func (v *validator) checkProxyDirection(path string, direction models.ProxyDirection) {
+	switch direction {
+	case "", models.FromTarget, models.ToTarget:
+		return
+	}
+	v.addProblem(path, "unknown proxy direction %q", direction)
+}
+
+// checkModelName adds a Problem and returns false if modelName is neither an existing model nor one being created
+func (v *validator) checkModelName(path string, modelName string) bool {
+	if v.models[modelName] {
+		return true
+	}
+	v.addProblem(path, "model %q is not in existing_model_id_map or models.creates", modelName)
+	return false
+}
+
+// checkExternalID adds a Problem if no record of the model with the external ID either exists or is being created
+func (v *validator) checkExternalID(path string, modelName string, externalID models.ExternalInstanceID) {
+	if v.records[modelName][externalID] {
+		return
+	}
+	v.addProblem(path, "no record with external ID %q in model %q is created or in record_id_maps", externalID, modelName)
+}
@@ -0,0 +1,421 @@
+package validation_test
+
+import (
+	"github.com/pennsieve/processor-post-metadata/client/clienttest"
+	"github.com/pennsieve/processor-post-metadata/client/models"
+	"github.com/pennsieve/processor-post-metadata/client/validation"
+	"github.com/pennsieve/processor-pre-metadata/client/models/datatypes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	for scenario, testFunc := range map[string]func(t *testing.T){
+		"empty changeset is valid":            emptyChangesetValid,
+		"valid changeset":                     validChangeset,
+		"unknown model names":                 unknownModelNames,
+		"unknown link external IDs":           unknownLinkExternalIDs,
+		"link without id or create":           linkWithoutIDOrCreate,
+		"duplicate model names":               duplicateModelNames,
+		"duplicate external IDs":              duplicateExternalIDs,
+		"unknown proxy record external ID":    unknownProxyExternalID,
+		"reports all problems":                reportsAllProblems,
+		"update records can be link targets":  updateRecordsCanBeLinkTargets,
+		"validator accepts continued creates": validatorAcceptsContinuedCreates,
+		"property changes":                    propertyChanges,
+		"empty model display name":            emptyModelDisplayName,
+		"link schema update and delete":       linkSchemaUpdateDelete,
+		"relationships":                       relationships,
+		"proxy package creates":               proxyPackageCreates,
+		"record upserts":                      recordUpserts,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			testFunc(t)
+		})
+	}
+}
+
+// newDataset returns a valid changeset that creates model "new" with records newRecordID, and has an existing model
+// "existing" with record existingRecordID
+func newDataset() (dataset models.Dataset, newRecordID models.ExternalInstanceID, existingRecordID models.ExternalInstanceID) {
+	newRecordID = clienttest.NewExternalInstanceID()
+	existingRecordID = clienttest.NewExternalInstanceID()
+	newModel := clienttest.NewModelCreate()
+	newModel.Name = "new"
+	existingRecordIDMap := models.NewRecordIDMap("existing")
+	existingRecordIDMap.ExternalToPennsieve[existingRecordID] = clienttest.NewPennsieveInstanceID()
+	dataset = models.Dataset{
+		Models: models.ModelChanges{
+			Creates: []models.ModelCreate{{
+				Create:  models.ModelPropsCreate{Model: newModel},
+				Records: []models.RecordCreate{{ExternalID: newRecordID}},
+			}},
+		},
+		ExistingModelIDMap: map[string]models.PennsieveSchemaID{"existing": clienttest.NewPennsieveSchemaID()},
+		RecordIDMaps:       []models.RecordIDMap{existingRecordIDMap},
+	}
+	return
+}
+
+func newLinkChanges(fromModelName, toModelName string, from, to models.ExternalInstanceID) models.LinkedPropertyChanges {
+	linkCreate := clienttest.NewSchemaLinkedPropertyCreate()
+	return models.LinkedPropertyChanges{
+		FromModelName: fromModelName,
+		ToModelName:   toModelName,
+		Create:        &linkCreate,
+		Instances: models.InstanceChanges{
+			Create: []models.InstanceLinkedPropertyCreate{{FromExternalID: from, ToExternalID: to}},
+		},
+	}
+}
+
+func requireProblems(t *testing.T, err error) []validation.Problem {
+	var validationErr *validation.Error
+	require.ErrorAs(t, err, &validationErr)
+	return validationErr.Problems
+}
+
+func emptyChangesetValid(t *testing.T) {
+	assert.NoError(t, validation.Validate(models.Dataset{}))
+}
+
+func validChangeset(t *testing.T) {
+	dataset, newRecordID, existingRecordID := newDataset()
+	dataset.LinkedProperties = []models.LinkedPropertyChanges{newLinkChanges("new", "existing", newRecordID, existingRecordID)}
+	dataset.Proxies = &models.ProxyChanges{RecordChanges: []models.ProxyRecordChanges{{
+		ModelName:        "new",
+		RecordExternalID: newRecordID,
+		NodeIDCreates:    []string{"N:package:1234"},
+	}}}
+	assert.NoError(t, validation.Validate(dataset))
+}
+
+func unknownModelNames(t *testing.T) {
+	dataset, newRecordID, existingRecordID := newDataset()
+	dataset.LinkedProperties = []models.LinkedPropertyChanges{newLinkChanges("missingFrom", "missingTo", newRecordID, existingRecordID)}
+	dataset.Proxies = &models.ProxyChanges{RecordChanges: []models.ProxyRecordChanges{{
+		ModelName:        "missingProxy",
+		RecordExternalID: newRecordID,
+	}}}
+
+	problems := requireProblems(t, validation.Validate(dataset))
+	// external IDs are not checked against unknown models
+	require.Len(t, problems, 3)
+	assert.Equal(t, "linked_properties[0].from_model_name", problems[0].Path)
+	assert.Contains(t, problems[0].Message, "missingFrom")
+	assert.Equal(t, "linked_properties[0].to_model_name", problems[1].Path)
+	assert.Contains(t, problems[1].Message, "missingTo")
+	assert.Equal(t, "proxies.record_changes[0].model_name", problems[2].Path)
+	assert.Contains(t, problems[2].Message, "missingProxy")
+}
+
+func unknownLinkExternalIDs(t *testing.T) {
+	dataset, newRecordID, existingRecordID := newDataset()
+	unknownFrom := clienttest.NewExternalInstanceID()
+	unknownTo := clienttest.NewExternalInstanceID()
+	linkChanges := newLinkChanges("new", "existing", newRecordID, existingRecordID)
+	linkChanges.Instances.Create = append(linkChanges.Instances.Create, models.InstanceLinkedPropertyCreate{
+		FromExternalID: unknownFrom,
+		ToExternalID:   unknownTo,
+	})
+	dataset.LinkedProperties = []models.LinkedPropertyChanges{linkChanges}
+
+	problems := requireProblems(t, validation.Validate(dataset))
+	require.Len(t, problems, 2)
+	assert.Equal(t, "linked_properties[0].instances.create[1].from_external_id", problems[0].Path)
+	assert.Contains(t, problems[0].Message, string(unknownFrom))
+	assert.Equal(t, "linked_properties[0].instances.create[1].to_external_id", problems[1].Path)
+	assert.Contains(t, problems[1].Message, string(unknownTo))
+}
+
+func linkWithoutIDOrCreate(t *testing.T) {
+	dataset, newRecordID, existingRecordID := newDataset()
+	linkChanges := newLinkChanges("new", "existing", newRecordID, existingRecordID)
+	linkChanges.Create = nil
+	dataset.LinkedProperties = []models.LinkedPropertyChanges{linkChanges}
+
+	problems := requireProblems(t, validation.Validate(dataset))
+	require.Len(t, problems, 1)
+	assert.Equal(t, "linked_properties[0]", problems[0].Path)
+
+	dataset.LinkedProperties[0].ID = clienttest.NewPennsieveSchemaID()
+	assert.NoError(t, validation.Validate(dataset))
+}
+
+func duplicateModelNames(t *testing.T) {
+	dataset, _, _ := newDataset()
+	duplicateNew := clienttest.NewModelCreate()
+	duplicateNew.Name = "new"
+	duplicateExisting := clienttest.NewModelCreate()
+	duplicateExisting.Name = "existing"
+	dataset.Models.Creates = append(dataset.Models.Creates,
+		models.ModelCreate{Create: models.ModelPropsCreate{Model: duplicateNew}},
+		models.ModelCreate{Create: models.ModelPropsCreate{Model: duplicateExisting}},
+	)
+
+	problems := requireProblems(t, validation.Validate(dataset))
+	require.Len(t, problems, 2)
+	assert.Equal(t, "models.creates[1].create.model.name", problems[0].Path)
+	assert.Equal(t, "models.creates[2].create.model.name", problems[1].Path)
+}
+
+func duplicateExternalIDs(t *testing.T) {
+	dataset, newRecordID, existingRecordID := newDataset()
+	dataset.Models.Creates[0].Records = append(dataset.Models.Creates[0].Records,
+		models.RecordCreate{ExternalID: newRecordID},
+		// empty external IDs are allowed
+		models.RecordCreate{},
+		models.RecordCreate{},
+	)
+	dataset.Models.Updates = []models.ModelUpdate{{
+		ID:      dataset.ExistingModelIDMap["existing"],
+		Records: models.RecordChanges{Create: []models.RecordCreate{{ExternalID: existingRecordID}}},
+	}}
+
+	problems := requireProblems(t, validation.Validate(dataset))
+	require.Len(t, problems, 2)
+	assert.Equal(t, "models.creates[0].records[1].external_id", problems[0].Path)
+	assert.Contains(t, problems[0].Message, string(newRecordID))
+	// RecordIDMaps are validated after models, so the existing record is the duplicate
+	assert.Contains(t, problems[1].Path, "record_id_maps[0].external_to_pennsieve")
+	assert.Contains(t, problems[1].Message, string(existingRecordID))
+}
+
+func unknownProxyExternalID(t *testing.T) {
+	dataset, _, _ := newDataset()
+	unknownID := clienttest.NewExternalInstanceID()
+	dataset.Proxies = &models.ProxyChanges{RecordChanges: []models.ProxyRecordChanges{{
+		ModelName:        "existing",
+		RecordExternalID: unknownID,
+		NodeIDCreates:    []string{"N:package:1234"},
+	}}}
+
+	problems := requireProblems(t, validation.Validate(dataset))
+	require.Len(t, problems, 1)
+	assert.Equal(t, "proxies.record_changes[0].record_external_id", problems[0].Path)
+}
+
+func reportsAllProblems(t *testing.T) {
+	dataset, newRecordID, existingRecordID := newDataset()
+	dataset.Models.Creates = append(dataset.Models.Creates, dataset.Models.Creates[0])
+	linkChanges := newLinkChanges("new", "missing", clienttest.NewExternalInstanceID(), existingRecordID)
+	linkChanges.Create = nil
+	dataset.LinkedProperties = []models.LinkedPropertyChanges{linkChanges}
+	dataset.Proxies = &models.ProxyChanges{RecordChanges: []models.ProxyRecordChanges{{
+		ModelName:        "new",
+		RecordExternalID: newRecordID,
+	}}}
+
+	err := validation.Validate(dataset)
+	problems := requireProblems(t, err)
+	// duplicate model name, duplicate record in the duplicate model, unknown to model, missing id or create,
+	// unknown from external ID
+	assert.Len(t, problems, 5)
+	for _, problem := range problems {
+		assert.Contains(t, err.Error(), problem.String())
+	}
+}
+
+func updateRecordsCanBeLinkTargets(t *testing.T) {
+	dataset, newRecordID, _ := newDataset()
+	updateRecordID := clienttest.NewExternalInstanceID()
+	dataset.Models.Updates = []models.ModelUpdate{{
+		ID:      dataset.ExistingModelIDMap["existing"],
+		Records: models.RecordChanges{Create: []models.RecordCreate{{ExternalID: updateRecordID}}},
+	}}
+	dataset.LinkedProperties = []models.LinkedPropertyChanges{newLinkChanges("new", "existing", newRecordID, updateRecordID)}
+
+	assert.NoError(t, validation.Validate(dataset))
+}
+
+func validatorAcceptsContinuedCreates(t *testing.T) {
+	dataset, _, existingRecordID := newDataset()
+	continued := models.Dataset{Models: models.ModelChanges{Creates: []models.ModelCreate{{
+		Create:  dataset.Models.Creates[0].Create,
+		Records: []models.RecordCreate{{ExternalID: clienttest.NewExternalInstanceID()}},
+	}}}}
+	differentNew := clienttest.NewModelCreate()
+	differentNew.Name = "new"
+	duplicate := models.Dataset{Models: models.ModelChanges{Creates: []models.ModelCreate{{
+		Create: models.ModelPropsCreate{Model: differentNew},
+	}}}}
+	links := models.Dataset{
+		LinkedProperties: []models.LinkedPropertyChanges{newLinkChanges("new", "existing", continued.Models.Creates[0].Records[0].ExternalID, existingRecordID)},
+	}
+
+	validator := validation.NewValidator()
+	validator.Add("sections[0].", dataset)
+	validator.Add("sections[1].", continued)
+	validator.Add("sections[2].", links)
+	require.NoError(t, validator.Err())
+
+	validator.Add("sections[3].", duplicate)
+	problems := requireProblems(t, validator.Err())
+	require.Len(t, problems, 1)
+	assert.Equal(t, "sections[3].models.creates[0].create.model.name", problems[0].Path)
+
+	// the same continuation is a duplicate in a single changeset
+	dataset.Models.Creates = append(dataset.Models.Creates, continued.Models.Creates[0])
+	problems = requireProblems(t, validation.Validate(dataset))
+	require.Len(t, problems, 1)
+	assert.Equal(t, "models.creates[1].create.model.name", problems[0].Path)
+}
+
+func propertyChanges(t *testing.T) {
+	dataset, _, _ := newDataset()
+	propertyCreate := clienttest.NewPropertyCreateSimple(t, datatypes.StringType)
+	dataset.Models.Updates = []models.ModelUpdate{{
+		ID: dataset.ExistingModelIDMap["existing"],
+		Properties: models.PropertyChanges{
+			Delete: []models.PennsieveSchemaID{clienttest.NewPennsieveSchemaID()},
+			Create: models.PropertiesCreateParams{propertyCreate},
+			Update: []models.PropertyUpdate{{ID: clienttest.NewPennsieveSchemaID(), PropertyCreateParams: propertyCreate}},
+		},
+	}}
+	require.NoError(t, validation.Validate(dataset))
+
+	dataset.Models.Updates[0].Properties.Delete = append(dataset.Models.Updates[0].Properties.Delete, "")
+	dataset.Models.Updates[0].Properties.Create = append(dataset.Models.Updates[0].Properties.Create, propertyCreate)
+	dataset.Models.Updates[0].Properties.Update = append(dataset.Models.Updates[0].Properties.Update, models.PropertyUpdate{PropertyCreateParams: propertyCreate})
+
+	problems := requireProblems(t, validation.Validate(dataset))
+	require.Len(t, problems, 3)
+	assert.Equal(t, "models.updates[0].properties.delete[1]", problems[0].Path)
+	assert.Equal(t, "models.updates[0].properties.create[1].name", problems[1].Path)
+	assert.Contains(t, problems[1].Message, propertyCreate.Name)
+	assert.Equal(t, "models.updates[0].properties.update[1].id", problems[2].Path)
+}
+
+func emptyModelDisplayName(t *testing.T) {
+	dataset, _, _ := newDataset()
+	displayName := "New Name"
+	dataset.Models.Updates = []models.ModelUpdate{{
+		ID:    dataset.ExistingModelIDMap["existing"],
+		Model: &models.ModelUpdateParams{DisplayName: &displayName},
+	}}
+	require.NoError(t, validation.Validate(dataset))
+
+	displayName = ""
+	problems := requireProblems(t, validation.Validate(dataset))
+	require.Len(t, problems, 1)
+	assert.Equal(t, "models.updates[0].model.display_name", problems[0].Path)
+}
+
+func linkSchemaUpdateDelete(t *testing.T) {
+	dataset, newRecordID, existingRecordID := newDataset()
+	linkChanges := newLinkChanges("new", "existing", newRecordID, existingRecordID)
+	linkChanges.Create = nil
+	linkChanges.ID = clienttest.NewPennsieveSchemaID()
+	linkChanges.Update = &models.SchemaLinkedPropertyUpdate{Name: "renamed", DisplayName: "Renamed", Position: 2}
+	dataset.LinkedProperties = []models.LinkedPropertyChanges{linkChanges, {
+		FromModelName: "new",
+		ToModelName:   "existing",
+		ID:            clienttest.NewPennsieveSchemaID(),
+		Delete:        true,
+	}}
+	require.NoError(t, validation.Validate(dataset))
+
+	dataset.LinkedProperties[0].ID = ""
+	dataset.LinkedProperties[0].Delete = true
+	problems := requireProblems(t, validation.Validate(dataset))
+	// no id for the instances, no id for the update or the delete, and a delete with an update and instance creates
+	require.Len(t, problems, 4)
+	assert.Equal(t, "linked_properties[0]", problems[0].Path)
+	assert.Equal(t, "linked_properties[0].update", problems[1].Path)
+	assert.Equal(t, "linked_properties[0].delete", problems[2].Path)
+	assert.Equal(t, "linked_properties[0].delete", problems[3].Path)
+}
+
+func relationships(t *testing.T) {
+	dataset, newRecordID, existingRecordID := newDataset()
+	relationshipCreate := clienttest.NewRelationshipSchemaCreate()
+	relationshipCreate.FromModelName = "new"
+	dataset.Relationships = []models.RelationshipChanges{{
+		Create: &relationshipCreate,
+		Instances: models.RelationshipInstanceChanges{Create: []models.RelationshipInstanceCreate{{
+			FromModelName:  "new",
+			FromExternalID: newRecordID,
+			ToModelName:    "existing",
+			ToExternalID:   existingRecordID,
+		}}},
+	}}
+	require.NoError(t, validation.Validate(dataset))
+
+	unknownTo := clienttest.NewExternalInstanceID()
+	dataset.Relationships = append(dataset.Relationships, models.RelationshipChanges{
+		Instances: models.RelationshipInstanceChanges{Create: []models.RelationshipInstanceCreate{{
+			FromModelName:  "missing",
+			FromExternalID: newRecordID,
+			ToModelName:    "existing",
+			ToExternalID:   unknownTo,
+		}}},
+	})
+	problems := requireProblems(t, validation.Validate(dataset))
+	require.Len(t, problems, 3)
+	assert.Equal(t, "relationships[1]", problems[0].Path)
+	assert.Equal(t, "relationships[1].instances.create[0].from_model_name", problems[1].Path)
+	assert.Equal(t, "relationships[1].instances.create[0].to_external_id", problems[2].Path)
+	assert.Contains(t, problems[2].Message, string(unknownTo))
+}
+
+func proxyPackageCreates(t *testing.T) {
+	dataset, newRecordID, existingRecordID := newDataset()
+	dataset.Proxies = &models.ProxyChanges{
+		RelationshipSchemaCreates: []models.RelationshipSchemaCreate{clienttest.NewRelationshipSchemaCreate()},
+		RecordChanges: []models.ProxyRecordChanges{{
+			ModelName:        "new",
+			RecordExternalID: newRecordID,
+			PackageCreates: []models.ProxyPackageCreate{{
+				NodeID:            "N:package:1234",
+				ProxyRelationship: models.ProxyRelationship{RelationshipType: "derived_from", Direction: models.ToTarget},
+				AdditionalTargets: []models.ProxyTarget{{ModelName: "existing", RecordExternalID: existingRecordID}},
+			}},
+		}},
+	}
+	require.NoError(t, validation.Validate(dataset))
+
+	unknownTarget := clienttest.NewExternalInstanceID()
+	dataset.Proxies.RelationshipSchemaCreates[0].Name = models.ProxyRelationshipSchemaName
+	dataset.Proxies.RecordChanges[0].PackageCreates = append(dataset.Proxies.RecordChanges[0].PackageCreates, models.ProxyPackageCreate{
+		ProxyRelationship: models.ProxyRelationship{Direction: "Sideways"},
+		AdditionalTargets: []models.ProxyTarget{{ModelName: "existing", RecordExternalID: unknownTarget}},
+	})
+	problems := requireProblems(t, validation.Validate(dataset))
+	require.Len(t, problems, 4)
+	assert.Equal(t, "proxies.relationship_schema_creates[0].name", problems[0].Path)
+	assert.Contains(t, problems[0].Message, "create_proxy_relationship_schema")
+	assert.Equal(t, "proxies.record_changes[0].package_creates[1].node_id", problems[1].Path)
+	assert.Equal(t, "proxies.record_changes[0].package_creates[1].direction", problems[2].Path)
+	assert.Contains(t, problems[2].Message, "Sideways")
+	assert.Equal(t, "proxies.record_changes[0].package_creates[1].additional_targets[0].record_external_id", problems[3].Path)
+}
+
+func recordUpserts(t *testing.T) {
+	dataset, newRecordID, _ := newDataset()
+	upsertedID := clienttest.NewExternalInstanceID()
+	dataset.Models.Updates = []models.ModelUpdate{{
+		ID: dataset.ExistingModelIDMap["existing"],
+		Records: models.RecordChanges{Upsert: []models.RecordUpsert{{
+			ExternalID:   upsertedID,
+			KeyProperty:  "name",
+			RecordValues: models.RecordValues{Values: []models.RecordValue{{Name: "name", Value: "subject-1"}}},
+		}}},
+	}}
+	// the upserted record can be a link target
+	dataset.LinkedProperties = []models.LinkedPropertyChanges{newLinkChanges("new", "existing", newRecordID, upsertedID)}
+	require.NoError(t, validation.Validate(dataset))
+
+	dataset.Models.Updates[0].Records.Upsert = append(dataset.Models.Updates[0].Records.Upsert,
+		models.RecordUpsert{KeyProperty: "missing", RecordValues: models.RecordValues{Values: []models.RecordValue{{Name: "name", Value: "subject-2"}}}},
+		models.RecordUpsert{ExternalID: upsertedID, RecordValues: models.RecordValues{}},
+	)
+	problems := requireProblems(t, validation.Validate(dataset))
+	require.Len(t, problems, 4)
+	assert.Equal(t, "models.updates[0].records.upsert[1].values", problems[0].Path)
+	assert.Contains(t, problems[0].Message, "missing")
+	assert.Equal(t, "models.updates[0].records.upsert[1].external_id", problems[1].Path)
+	assert.Equal(t, "models.updates[0].records.upsert[2].key_property", problems[2].Path)
+	assert.Equal(t, "models.updates[0].records.upsert[2].external_id", problems[3].Path)
+	assert.Contains(t, problems[3].Message, "duplicate")
+}
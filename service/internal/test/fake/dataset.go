@@ -0,0 +1,217 @@
+package fake
+
+import (
+	clientmodels "github.com/pennsieve/processor-post-metadata/client/models"
+	"slices"
+)
+
+// Dataset is the graph of one dataset held by a ModelService. Every slice is in creation order.
+type Dataset struct {
+	ID                    string
+	Models                []Model
+	LinkInstances         []LinkInstance
+	RelationshipSchemas   []RelationshipSchema
+	RelationshipInstances []RelationshipInstance
+	Proxies               []Proxy
+}
+
+type Model struct {
+	ID clientmodels.PennsieveSchemaID
+	clientmodels.ModelCreateParams
+	Properties  []Property
+	LinkSchemas []LinkSchema
+	Records     []Record
+}
+
+type Property struct {
+	ID clientmodels.PennsieveSchemaID
+	clientmodels.PropertyCreateParams
+}
+
+type LinkSchema struct {
+	ID          clientmodels.PennsieveSchemaID
+	Name        string
+	DisplayName string
+	To          clientmodels.PennsieveSchemaID
+	Position    int
+}
+
+type Record struct {
+	ID     clientmodels.PennsieveInstanceID
+	Values []clientmodels.RecordValue
+}
+
+type LinkInstance struct {
+	ID       clientmodels.PennsieveInstanceID
+	SchemaID clientmodels.PennsieveSchemaID
+	From     clientmodels.PennsieveInstanceID
+	To       clientmodels.PennsieveInstanceID
+}
+
+type RelationshipSchema struct {
+	ID          clientmodels.PennsieveSchemaID
+	Name        string
+	DisplayName string
+	Description string
+	// From and To, if not nil, restrict the models of the records that instances relate
+	From *clientmodels.PennsieveSchemaID
+	To   *clientmodels.PennsieveSchemaID
+}
+
+type RelationshipInstance struct {
+	ID       clientmodels.PennsieveInstanceID
+	SchemaID clientmodels.PennsieveSchemaID
+	From     clientmodels.PennsieveInstanceID
+	To       clientmodels.PennsieveInstanceID
+	Values   []any
+}
+
+// Proxy links a package to a record
+type Proxy struct {
+	ID               clientmodels.PennsieveInstanceID
+	PackageNodeID    string
+	RecordID         clientmodels.PennsieveInstanceID
+	Direction        clientmodels.ProxyDirection
+	RelationshipType string
+	RelationshipData []clientmodels.RecordValue
+}
+
+// Model returns the model with the given name
+func (d Dataset) Model(name string) (Model, bool) {
+	index := slices.IndexFunc(d.Models, func(m Model) bool { return m.Name == name })
+	if index < 0 {
+		return Model{}, false
+	}
+	return d.Models[index], true
+}
+
+// RelationshipSchema returns the relationship schema with the given name. If there are several, the first one created
+// is returned.
+func (d Dataset) RelationshipSchema(name string) (RelationshipSchema, bool) {
+	index := slices.IndexFunc(d.RelationshipSchemas, func(r RelationshipSchema) bool { return r.Name == name })
+	if index < 0 {
+		return RelationshipSchema{}, false
+	}
+	return d.RelationshipSchemas[index], true
+}
+
+// Property returns the property of the model with the given name
+func (m Model) Property(name string) (Property, bool) {
+	index := slices.IndexFunc(m.Properties, func(p Property) bool { return p.Name == name })
+	if index < 0 {
+		return Property{}, false
+	}
+	return m.Properties[index], true
+}
+
+// LinkSchema returns the linked property schema of the model with the given name
+func (m Model) LinkSchema(name string) (LinkSchema, bool) {
+	index := slices.IndexFunc(m.LinkSchemas, func(l LinkSchema) bool { return l.Name == name })
+	if index < 0 {
+		return LinkSchema{}, false
+	}
+	return m.LinkSchemas[index], true
+}
+
+// RecordWithValue returns the first record of the model whose value for the named property equals value
+func (m Model) RecordWithValue(name string, value any) (Record, bool) {
+	index := slices.IndexFunc(m.Records, func(r Record) bool {
+		recordValue, found := r.Value(name)
+		return found && recordValue == value
+	})
+	if index < 0 {
+		return Record{}, false
+	}
+	return m.Records[index], true
+}
+
+// Value returns the record's value for the named property
+func (r Record) Value(name string) (any, bool) {
+	index := slices.IndexFunc(r.Values, func(v clientmodels.RecordValue) bool { return v.Name == name })
+	if index < 0 {
+		return nil, false
+	}
+	return r.Values[index].Value, true
+}
+
+// clone returns a copy of d that shares no slices with it, so that it can be handed out while d keeps changing
+func (d *Dataset) clone() Dataset {
+	clone := Dataset{
+		ID:                    d.ID,
+		Models:                make([]Model, len(d.Models)),
+		LinkInstances:         slices.Clone(d.LinkInstances),
+		RelationshipSchemas:   slices.Clone(d.RelationshipSchemas),
+		RelationshipInstances: make([]RelationshipInstance, len(d.RelationshipInstances)),
+		Proxies:               make([]Proxy, len(d.Proxies)),
+	}
+	for i, model := range d.Models {
+		model.Properties = slices.Clone(model.Properties)
+		model.LinkSchemas = slices.Clone(model.LinkSchemas)
+		records := make([]Record, len(model.Records))
+		for j, record := range model.Records {
+			record.Values = slices.Clone(record.Values)
+			records[j] = record
+		}
+		model.Records = records
+		clone.Models[i] = model
+	}
+	for i, instance := range d.RelationshipInstances {
+		instance.Values = slices.Clone(instance.Values)
+		clone.RelationshipInstances[i] = instance
+	}
+	for i, proxy := range d.Proxies {
+		proxy.RelationshipData = slices.Clone(proxy.RelationshipData)
+		clone.Proxies[i] = proxy
+	}
+	return clone
+}
+
+func (d *Dataset) model(modelID clientmodels.PennsieveSchemaID) *Model {
+	index := slices.IndexFunc(d.Models, func(m Model) bool { return m.ID == modelID })
+	if index < 0 {
+		return nil
+	}
+	return &d.Models[index]
+}
+
+// record returns the record with the given ID and the model it belongs to
+func (d *Dataset) record(recordID clientmodels.PennsieveInstanceID) (*Model, *Record) {
+	for i := range d.Models {
+		if record := d.Models[i].record(recordID); record != nil {
+			return &d.Models[i], record
+		}
+	}
+	return nil, nil
+}
+
+func (d *Dataset) relationshipSchema(schemaID clientmodels.PennsieveSchemaID) *RelationshipSchema {
+	index := slices.IndexFunc(d.RelationshipSchemas, func(r RelationshipSchema) bool { return r.ID == schemaID })
+	if index < 0 {
+		return nil
+	}
+	return &d.RelationshipSchemas[index]
+}
+
+func (m *Model) record(recordID clientmodels.PennsieveInstanceID) *Record {
+	index := slices.IndexFunc(m.Records, func(r Record) bool { return r.ID == recordID })
+	if index < 0 {
+		return nil
+	}
+	return &m.Records[index]
+}
+
+func (m *Model) property(propertyID clientmodels.PennsieveSchemaID) *Property {
+	index := slices.IndexFunc(m.Properties, func(p Property) bool { return p.ID == propertyID })
+	if index < 0 {
+		return nil
+	}
+	return &m.Properties[index]
+}
+
+func (m *Model) linkSchema(linkSchemaID clientmodels.PennsieveSchemaID) *LinkSchema {
+	index := slices.IndexFunc(m.LinkSchemas, func(l LinkSchema) bool { return l.ID == linkSchemaID })
+	if index < 0 {
+		return nil
+	}
+	return &m.LinkSchemas[index]
+}
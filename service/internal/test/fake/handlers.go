@@ -0,0 +1,695 @@
+package fake
+
+import (
+	clientmodels "github.com/pennsieve/processor-post-metadata/client/models"
+	"github.com/pennsieve/processor-post-metadata/service/models"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+func getIntegration(m *ModelService, _ *http.Request, params []string) (any, error) {
+	datasetID, found := m.integrations[params[0]]
+	if !found {
+		return nil, notFound("no integration %s", params[0])
+	}
+	return models.Integration{Uuid: params[0], DatasetNodeID: datasetID}, nil
+}
+
+// Models
+
+func createModel(dataset *Dataset, modelCreate clientmodels.ModelCreateParams) (clientmodels.PennsieveSchemaID, error) {
+	if len(modelCreate.Name) == 0 {
+		return "", badRequest("model name is empty")
+	}
+	if _, exists := dataset.Model(modelCreate.Name); exists {
+		return "", conflict("model %s already exists in dataset %s", modelCreate.Name, dataset.ID)
+	}
+	model := Model{ID: newSchemaID(), ModelCreateParams: modelCreate}
+	dataset.Models = append(dataset.Models, model)
+	return model.ID, nil
+}
+
+func modelResponse(model *Model) models.ModelResponse {
+	return models.ModelResponse{
+		APIResponse: models.APIResponse{Name: model.Name, ID: string(model.ID)},
+		DisplayName: model.DisplayName,
+		Description: model.Description,
+		Locked:      model.Locked,
+	}
+}
+
+func handleCreateModel(m *ModelService, request *http.Request, params []string) (any, error) {
+	dataset, err := m.dataset(params[0])
+	if err != nil {
+		return nil, err
+	}
+	body, err := decodeBody[clientmodels.ModelCreateParams](request)
+	if err != nil {
+		return nil, err
+	}
+	modelID, err := createModel(dataset, body)
+	if err != nil {
+		return nil, err
+	}
+	return models.APIResponse{Name: body.Name, ID: string(modelID)}, nil
+}
+
+func handleGetModel(m *ModelService, _ *http.Request, params []string) (any, error) {
+	_, model, err := m.datasetModel(params[0], params[1])
+	if err != nil {
+		return nil, err
+	}
+	return modelResponse(model), nil
+}
+
+func handleUpdateModel(m *ModelService, request *http.Request, params []string) (any, error) {
+	dataset, model, err := m.datasetModel(params[0], params[1])
+	if err != nil {
+		return nil, err
+	}
+	body, err := decodeBody[clientmodels.ModelCreateParams](request)
+	if err != nil {
+		return nil, err
+	}
+	if len(body.Name) == 0 {
+		return nil, badRequest("model name is empty")
+	}
+	if other, exists := dataset.Model(body.Name); exists && other.ID != model.ID {
+		return nil, conflict("model %s already exists in dataset %s", body.Name, dataset.ID)
+	}
+	model.ModelCreateParams = body
+	return modelResponse(model), nil
+}
+
+func handleDeleteModel(m *ModelService, _ *http.Request, params []string) (any, error) {
+	dataset, model, err := m.datasetModel(params[0], params[1])
+	if err != nil {
+		return nil, err
+	}
+	if len(model.Records) > 0 {
+		return nil, conflict("cannot delete model %s: it still has records", model.ID)
+	}
+	for _, other := range dataset.Models {
+		for _, linkSchema := range other.LinkSchemas {
+			if linkSchema.To == model.ID && other.ID != model.ID {
+				return nil, conflict("cannot delete model %s: linked property %s of model %s refers to it",
+					model.ID, linkSchema.Name, other.ID)
+			}
+		}
+	}
+	for _, relationshipSchema := range dataset.RelationshipSchemas {
+		if (relationshipSchema.From != nil && *relationshipSchema.From == model.ID) ||
+			(relationshipSchema.To != nil && *relationshipSchema.To == model.ID) {
+			return nil, conflict("cannot delete model %s: relationship schema %s refers to it",
+				model.ID, relationshipSchema.ID)
+		}
+	}
+	modelID := model.ID
+	dataset.Models = slices.DeleteFunc(dataset.Models, func(other Model) bool { return other.ID == modelID })
+	return nil, nil
+}
+
+// Properties
+
+// createProperties adds the properties to the model. A property with the same name as an existing one replaces it,
+// keeping its ID.
+func createProperties(model *Model, propertyCreates clientmodels.PropertiesCreateParams) ([]models.APIResponse, error) {
+	for _, propertyCreate := range propertyCreates {
+		if len(propertyCreate.Name) == 0 {
+			return nil, badRequest("property name is empty")
+		}
+	}
+	responses := make([]models.APIResponse, 0, len(propertyCreates))
+	for _, propertyCreate := range propertyCreates {
+		index := slices.IndexFunc(model.Properties, func(p Property) bool { return p.Name == propertyCreate.Name })
+		if index < 0 {
+			model.Properties = append(model.Properties, Property{ID: newSchemaID()})
+			index = len(model.Properties) - 1
+		}
+		model.Properties[index].PropertyCreateParams = propertyCreate
+		responses = append(responses, models.APIResponse{Name: propertyCreate.Name, ID: string(model.Properties[index].ID)})
+	}
+	return responses, nil
+}
+
+func handleCreateProperties(m *ModelService, request *http.Request, params []string) (any, error) {
+	_, model, err := m.datasetModel(params[0], params[1])
+	if err != nil {
+		return nil, err
+	}
+	body, err := decodeBody[clientmodels.PropertiesCreateParams](request)
+	if err != nil {
+		return nil, err
+	}
+	return createProperties(model, body)
+}
+
+func handleUpdateProperty(m *ModelService, request *http.Request, params []string) (any, error) {
+	_, model, err := m.datasetModel(params[0], params[1])
+	if err != nil {
+		return nil, err
+	}
+	property := model.property(clientmodels.PennsieveSchemaID(params[2]))
+	if property == nil {
+		return nil, notFound("no property %s in model %s", params[2], model.ID)
+	}
+	body, err := decodeBody[clientmodels.PropertyCreateParams](request)
+	if err != nil {
+		return nil, err
+	}
+	if body.Name != property.Name {
+		return nil, badRequest("cannot rename property %s of model %s from %s to %s", property.ID, model.ID, property.Name, body.Name)
+	}
+	property.PropertyCreateParams = body
+	return nil, nil
+}
+
+// handleDeleteProperty removes the property and its values from the records of the model
+func handleDeleteProperty(m *ModelService, _ *http.Request, params []string) (any, error) {
+	_, model, err := m.datasetModel(params[0], params[1])
+	if err != nil {
+		return nil, err
+	}
+	property := model.property(clientmodels.PennsieveSchemaID(params[2]))
+	if property == nil {
+		return nil, notFound("no property %s in model %s", params[2], model.ID)
+	}
+	propertyID, propertyName := property.ID, property.Name
+	model.Properties = slices.DeleteFunc(model.Properties, func(p Property) bool { return p.ID == propertyID })
+	for i := range model.Records {
+		model.Records[i].Values = slices.DeleteFunc(model.Records[i].Values, func(v clientmodels.RecordValue) bool {
+			return v.Name == propertyName
+		})
+	}
+	return nil, nil
+}
+
+// Records
+
+// validateValues checks that each value is for a property of the model, and that no property has two values
+func validateValues(model *Model, values clientmodels.RecordValues) error {
+	seen := map[string]bool{}
+	for _, value := range values.Values {
+		if _, found := model.Property(value.Name); !found {
+			return badRequest("model %s has no property %s", model.ID, value.Name)
+		}
+		if seen[value.Name] {
+			return badRequest("more than one value for property %s of model %s", value.Name, model.ID)
+		}
+		seen[value.Name] = true
+	}
+	return nil
+}
+
+func createRecord(model *Model, values clientmodels.RecordValues) (clientmodels.PennsieveInstanceID, error) {
+	if err := validateValues(model, values); err != nil {
+		return "", err
+	}
+	record := Record{ID: newInstanceID(), Values: slices.Clone(values.Values)}
+	model.Records = append(model.Records, record)
+	return record.ID, nil
+}
+
+func handleCreateRecord(m *ModelService, request *http.Request, params []string) (any, error) {
+	_, model, err := m.datasetModel(params[0], params[1])
+	if err != nil {
+		return nil, err
+	}
+	body, err := decodeBody[clientmodels.RecordValues](request)
+	if err != nil {
+		return nil, err
+	}
+	recordID, err := createRecord(model, body)
+	if err != nil {
+		return nil, err
+	}
+	return models.APIResponse{Name: model.Name, ID: string(recordID)}, nil
+}
+
+// handleCreateRecords creates each record it can. A record that cannot be created gets an entry with the error in the
+// response.
+func handleCreateRecords(m *ModelService, request *http.Request, params []string) (any, error) {
+	_, model, err := m.datasetModel(params[0], params[1])
+	if err != nil {
+		return nil, err
+	}
+	body, err := decodeBody[[]clientmodels.RecordValues](request)
+	if err != nil {
+		return nil, err
+	}
+	response := make(models.BatchCreateRecordsResponse, len(body))
+	for i, values := range body {
+		recordID, err := createRecord(model, values)
+		if err != nil {
+			response[i] = models.BatchCreateRecordResponse{Error: err.Error()}
+			continue
+		}
+		response[i] = models.BatchCreateRecordResponse{APIResponse: models.APIResponse{Name: model.Name, ID: string(recordID)}}
+	}
+	return response, nil
+}
+
+// handleQueryRecords returns the page of records given by the limit and offset query parameters, in creation order
+func handleQueryRecords(m *ModelService, request *http.Request, params []string) (any, error) {
+	_, model, err := m.datasetModel(params[0], params[1])
+	if err != nil {
+		return nil, err
+	}
+	offset, err := queryInt(request, "offset", 0)
+	if err != nil {
+		return nil, err
+	}
+	limit, err := queryInt(request, "limit", len(model.Records))
+	if err != nil {
+		return nil, err
+	}
+	records := make([]models.RecordResponse, 0, limit)
+	for i := offset; i < len(model.Records) && len(records) < limit; i++ {
+		record := model.Records[i]
+		records = append(records, models.RecordResponse{
+			APIResponse: models.APIResponse{Name: model.Name, ID: string(record.ID)},
+			Values:      slices.Clone(record.Values),
+		})
+	}
+	return records, nil
+}
+
+func queryInt(request *http.Request, name string, defaultValue int) (int, error) {
+	value := request.URL.Query().Get(name)
+	if len(value) == 0 {
+		return defaultValue, nil
+	}
+	intValue, err := strconv.Atoi(value)
+	if err != nil || intValue < 0 {
+		return 0, badRequest("invalid %s %q", name, value)
+	}
+	return intValue, nil
+}
+
+// handleUpdateRecord replaces the values of the record
+func handleUpdateRecord(m *ModelService, request *http.Request, params []string) (any, error) {
+	_, model, err := m.datasetModel(params[0], params[1])
+	if err != nil {
+		return nil, err
+	}
+	record := model.record(clientmodels.PennsieveInstanceID(params[2]))
+	if record == nil {
+		return nil, notFound("no record %s in model %s", params[2], model.ID)
+	}
+	body, err := decodeBody[clientmodels.RecordValues](request)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateValues(model, body); err != nil {
+		return nil, err
+	}
+	record.Values = slices.Clone(body.Values)
+	return models.APIResponse{Name: string(record.ID), ID: string(record.ID)}, nil
+}
+
+// handleDeleteRecords deletes each record it can. A record that is missing, or that still has links, relationships
+// or proxies, is reported in the errors of the response instead.
+func handleDeleteRecords(m *ModelService, request *http.Request, params []string) (any, error) {
+	dataset, model, err := m.datasetModel(params[0], params[1])
+	if err != nil {
+		return nil, err
+	}
+	body, err := decodeBody[[]clientmodels.PennsieveInstanceID](request)
+	if err != nil {
+		return nil, err
+	}
+	response := models.BulkDeleteRecordsResponse{Success: []clientmodels.PennsieveInstanceID{}}
+	for _, recordID := range body {
+		if model.record(recordID) == nil {
+			response.Errors = append(response.Errors, []string{string(recordID), "record not found"})
+			continue
+		}
+		if dependent := recordDependent(dataset, recordID); len(dependent) > 0 {
+			response.Errors = append(response.Errors, []string{string(recordID), "record still has " + dependent})
+			continue
+		}
+		model.Records = slices.DeleteFunc(model.Records, func(r Record) bool { return r.ID == recordID })
+		response.Success = append(response.Success, recordID)
+	}
+	return response, nil
+}
+
+// recordDependent describes the first kind of object that refers to the record, or returns an empty string if
+// nothing does
+func recordDependent(dataset *Dataset, recordID clientmodels.PennsieveInstanceID) string {
+	if slices.ContainsFunc(dataset.LinkInstances, func(l LinkInstance) bool { return l.From == recordID || l.To == recordID }) {
+		return "linked property instances"
+	}
+	if slices.ContainsFunc(dataset.RelationshipInstances, func(r RelationshipInstance) bool {
+		return r.From == recordID || r.To == recordID
+	}) {
+		return "relationship instances"
+	}
+	if slices.ContainsFunc(dataset.Proxies, func(p Proxy) bool { return p.RecordID == recordID }) {
+		return "package proxies"
+	}
+	return ""
+}
+
+// Linked properties
+
+func validateLinkSchema(dataset *Dataset, model *Model, linkSchemaID clientmodels.PennsieveSchemaID, body models.CreateLinkSchemaBody) error {
+	if len(body.Name) == 0 {
+		return badRequest("linked property name is empty")
+	}
+	if dataset.model(body.To) == nil {
+		return badRequest("no model %s for linked property %s to refer to", body.To, body.Name)
+	}
+	if other, exists := model.LinkSchema(body.Name); exists && other.ID != linkSchemaID {
+		return conflict("linked property %s already exists in model %s", body.Name, model.ID)
+	}
+	return nil
+}
+
+func handleCreateLinkSchema(m *ModelService, request *http.Request, params []string) (any, error) {
+	dataset, model, err := m.datasetModel(params[0], params[1])
+	if err != nil {
+		return nil, err
+	}
+	body, err := decodeBody[models.CreateLinkSchemaBody](request)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateLinkSchema(dataset, model, "", body); err != nil {
+		return nil, err
+	}
+	linkSchema := LinkSchema{
+		ID:          newSchemaID(),
+		Name:        body.Name,
+		DisplayName: body.DisplayName,
+		To:          body.To,
+		Position:    body.Position,
+	}
+	model.LinkSchemas = append(model.LinkSchemas, linkSchema)
+	return models.APIResponse{Name: linkSchema.Name, ID: string(linkSchema.ID)}, nil
+}
+
+func handleUpdateLinkSchema(m *ModelService, request *http.Request, params []string) (any, error) {
+	dataset, model, err := m.datasetModel(params[0], params[1])
+	if err != nil {
+		return nil, err
+	}
+	linkSchema := model.linkSchema(clientmodels.PennsieveSchemaID(params[2]))
+	if linkSchema == nil {
+		return nil, notFound("no linked property %s in model %s", params[2], model.ID)
+	}
+	body, err := decodeBody[models.UpdateLinkSchemaBody](request)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateLinkSchema(dataset, model, linkSchema.ID, models.CreateLinkSchemaBody(body)); err != nil {
+		return nil, err
+	}
+	linkSchema.Name = body.Name
+	linkSchema.DisplayName = body.DisplayName
+	linkSchema.To = body.To
+	linkSchema.Position = body.Position
+	return nil, nil
+}
+
+func handleDeleteLinkSchema(m *ModelService, _ *http.Request, params []string) (any, error) {
+	dataset, model, err := m.datasetModel(params[0], params[1])
+	if err != nil {
+		return nil, err
+	}
+	linkSchemaID := clientmodels.PennsieveSchemaID(params[2])
+	if model.linkSchema(linkSchemaID) == nil {
+		return nil, notFound("no linked property %s in model %s", linkSchemaID, model.ID)
+	}
+	if slices.ContainsFunc(dataset.LinkInstances, func(l LinkInstance) bool { return l.SchemaID == linkSchemaID }) {
+		return nil, conflict("cannot delete linked property %s: it still has instances", linkSchemaID)
+	}
+	model.LinkSchemas = slices.DeleteFunc(model.LinkSchemas, func(l LinkSchema) bool { return l.ID == linkSchemaID })
+	return nil, nil
+}
+
+// handleCreateLinkInstance sets the linked property of a record. A record has at most one instance of each linked
+// property.
+func handleCreateLinkInstance(m *ModelService, request *http.Request, params []string) (any, error) {
+	dataset, model, err := m.datasetModel(params[0], params[1])
+	if err != nil {
+		return nil, err
+	}
+	fromRecordID := clientmodels.PennsieveInstanceID(params[2])
+	if model.record(fromRecordID) == nil {
+		return nil, notFound("no record %s in model %s", fromRecordID, model.ID)
+	}
+	body, err := decodeBody[models.CreateLinkInstanceBody](request)
+	if err != nil {
+		return nil, err
+	}
+	linkSchema := model.linkSchema(body.SchemaLinkedPropertyId)
+	if linkSchema == nil {
+		return nil, badRequest("no linked property %s in model %s", body.SchemaLinkedPropertyId, model.ID)
+	}
+	if toModel := dataset.model(linkSchema.To); toModel == nil || toModel.record(body.To) == nil {
+		return nil, badRequest("no record %s in model %s for linked property %s to refer to", body.To, linkSchema.To, linkSchema.Name)
+	}
+	if slices.ContainsFunc(dataset.LinkInstances, func(l LinkInstance) bool {
+		return l.SchemaID == linkSchema.ID && l.From == fromRecordID
+	}) {
+		return nil, conflict("record %s already has an instance of linked property %s", fromRecordID, linkSchema.Name)
+	}
+	linkInstance := LinkInstance{ID: newInstanceID(), SchemaID: linkSchema.ID, From: fromRecordID, To: body.To}
+	dataset.LinkInstances = append(dataset.LinkInstances, linkInstance)
+	return models.APIResponse{Name: linkSchema.Name, ID: string(linkInstance.ID)}, nil
+}
+
+func handleDeleteLinkInstance(m *ModelService, _ *http.Request, params []string) (any, error) {
+	dataset, _, err := m.datasetModel(params[0], params[1])
+	if err != nil {
+		return nil, err
+	}
+	fromRecordID, linkInstanceID := clientmodels.PennsieveInstanceID(params[2]), clientmodels.PennsieveInstanceID(params[3])
+	isInstance := func(l LinkInstance) bool { return l.ID == linkInstanceID && l.From == fromRecordID }
+	if !slices.ContainsFunc(dataset.LinkInstances, isInstance) {
+		return nil, notFound("no linked property instance %s from record %s", linkInstanceID, fromRecordID)
+	}
+	dataset.LinkInstances = slices.DeleteFunc(dataset.LinkInstances, isInstance)
+	return nil, nil
+}
+
+// Relationships
+
+func createRelationshipSchema(dataset *Dataset, body models.CreateRelationshipSchemaBody) (clientmodels.PennsieveSchemaID, error) {
+	if len(body.Name) == 0 {
+		return "", badRequest("relationship name is empty")
+	}
+	for _, modelID := range []*clientmodels.PennsieveSchemaID{body.From, body.To} {
+		if modelID != nil && dataset.model(*modelID) == nil {
+			return "", badRequest("no model %s for relationship %s to refer to", *modelID, body.Name)
+		}
+	}
+	if slices.ContainsFunc(dataset.RelationshipSchemas, func(r RelationshipSchema) bool {
+		return r.Name == body.Name && equalModelIDs(r.From, body.From) && equalModelIDs(r.To, body.To)
+	}) {
+		return "", conflict("relationship %s between the same models already exists in dataset %s", body.Name, dataset.ID)
+	}
+	relationshipSchema := RelationshipSchema{
+		ID:          newSchemaID(),
+		Name:        body.Name,
+		DisplayName: body.DisplayName,
+		Description: body.Description,
+		From:        body.From,
+		To:          body.To,
+	}
+	dataset.RelationshipSchemas = append(dataset.RelationshipSchemas, relationshipSchema)
+	return relationshipSchema.ID, nil
+}
+
+func equalModelIDs(a, b *clientmodels.PennsieveSchemaID) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func handleCreateRelationshipSchema(m *ModelService, request *http.Request, params []string) (any, error) {
+	dataset, err := m.dataset(params[0])
+	if err != nil {
+		return nil, err
+	}
+	body, err := decodeBody[models.CreateRelationshipSchemaBody](request)
+	if err != nil {
+		return nil, err
+	}
+	schemaID, err := createRelationshipSchema(dataset, body)
+	if err != nil {
+		return nil, err
+	}
+	return models.APIResponse{Name: body.Name, ID: string(schemaID)}, nil
+}
+
+func handleDeleteRelationshipSchema(m *ModelService, _ *http.Request, params []string) (any, error) {
+	dataset, err := m.dataset(params[0])
+	if err != nil {
+		return nil, err
+	}
+	relationshipSchema := dataset.relationshipSchema(clientmodels.PennsieveSchemaID(params[1]))
+	if relationshipSchema == nil {
+		return nil, notFound("no relationship schema %s in dataset %s", params[1], dataset.ID)
+	}
+	schemaID, schemaName := relationshipSchema.ID, relationshipSchema.Name
+	if slices.ContainsFunc(dataset.RelationshipInstances, func(r RelationshipInstance) bool { return r.SchemaID == schemaID }) {
+		return nil, conflict("cannot delete relationship schema %s: it still has instances", schemaID)
+	}
+	if slices.ContainsFunc(dataset.Proxies, func(p Proxy) bool { return p.RelationshipType == schemaName }) {
+		return nil, conflict("cannot delete relationship schema %s: package proxies still use it", schemaID)
+	}
+	dataset.RelationshipSchemas = slices.DeleteFunc(dataset.RelationshipSchemas, func(r RelationshipSchema) bool {
+		return r.ID == schemaID
+	})
+	return nil, nil
+}
+
+func handleCreateRelationshipInstance(m *ModelService, request *http.Request, params []string) (any, error) {
+	dataset, err := m.dataset(params[0])
+	if err != nil {
+		return nil, err
+	}
+	relationshipSchema := dataset.relationshipSchema(clientmodels.PennsieveSchemaID(params[1]))
+	if relationshipSchema == nil {
+		return nil, notFound("no relationship schema %s in dataset %s", params[1], dataset.ID)
+	}
+	body, err := decodeBody[models.CreateRelationshipInstanceBody](request)
+	if err != nil {
+		return nil, err
+	}
+	for _, end := range []struct {
+		role     string
+		recordID clientmodels.PennsieveInstanceID
+		modelID  *clientmodels.PennsieveSchemaID
+	}{{"from", body.From, relationshipSchema.From}, {"to", body.To, relationshipSchema.To}} {
+		model, _ := dataset.record(end.recordID)
+		if model == nil {
+			return nil, badRequest("no %s record %s for relationship %s", end.role, end.recordID, relationshipSchema.Name)
+		}
+		if end.modelID != nil && model.ID != *end.modelID {
+			return nil, badRequest("%s record %s of relationship %s belongs to model %s, not %s",
+				end.role, end.recordID, relationshipSchema.Name, model.ID, *end.modelID)
+		}
+	}
+	instance := RelationshipInstance{
+		ID:       newInstanceID(),
+		SchemaID: relationshipSchema.ID,
+		From:     body.From,
+		To:       body.To,
+		Values:   slices.Clone(body.Values),
+	}
+	dataset.RelationshipInstances = append(dataset.RelationshipInstances, instance)
+	return models.APIResponse{Name: relationshipSchema.Name, ID: string(instance.ID)}, nil
+}
+
+func handleDeleteRelationshipInstance(m *ModelService, _ *http.Request, params []string) (any, error) {
+	dataset, err := m.dataset(params[0])
+	if err != nil {
+		return nil, err
+	}
+	schemaID, instanceID := clientmodels.PennsieveSchemaID(params[1]), clientmodels.PennsieveInstanceID(params[2])
+	isInstance := func(r RelationshipInstance) bool { return r.ID == instanceID && r.SchemaID == schemaID }
+	if !slices.ContainsFunc(dataset.RelationshipInstances, isInstance) {
+		return nil, notFound("no instance %s of relationship schema %s", instanceID, schemaID)
+	}
+	dataset.RelationshipInstances = slices.DeleteFunc(dataset.RelationshipInstances, isInstance)
+	return nil, nil
+}
+
+// Proxies
+
+// createProxies creates one proxy per target of body, or none if any target is invalid. Each target needs an existing
+// record and a relationship schema named by its relationship type.
+func createProxies(dataset *Dataset, body models.CreateProxyInstanceBody) (models.CreateProxyInstanceResponse, error) {
+	if len(body.ExternalID) == 0 {
+		return nil, badRequest("package node id is empty")
+	}
+	if len(body.Targets) == 0 {
+		return nil, badRequest("no targets for proxy of package %s", body.ExternalID)
+	}
+	for _, target := range body.Targets {
+		recordID := target.LinkTarget.ConceptInstance.ID
+		if model, _ := dataset.record(recordID); model == nil {
+			return nil, badRequest("no record %s for proxy of package %s", recordID, body.ExternalID)
+		}
+		if _, exists := dataset.RelationshipSchema(target.RelationshipType); !exists {
+			return nil, badRequest("no relationship schema %s for proxy of package %s", target.RelationshipType, body.ExternalID)
+		}
+	}
+	response := make(models.CreateProxyInstanceResponse, 0, len(body.Targets))
+	for _, target := range body.Targets {
+		proxy := Proxy{
+			ID:               newInstanceID(),
+			PackageNodeID:    body.ExternalID,
+			RecordID:         target.LinkTarget.ConceptInstance.ID,
+			Direction:        target.Direction,
+			RelationshipType: target.RelationshipType,
+			RelationshipData: slices.Clone(target.RelationshipData),
+		}
+		dataset.Proxies = append(dataset.Proxies, proxy)
+		response = append(response, models.ProxyInstanceResponse{
+			ProxyInstance: models.APIResponse{Name: proxy.PackageNodeID, ID: string(proxy.ID)},
+		})
+	}
+	return response, nil
+}
+
+func handleCreateProxies(m *ModelService, request *http.Request, params []string) (any, error) {
+	dataset, err := m.dataset(params[0])
+	if err != nil {
+		return nil, err
+	}
+	body, err := decodeBody[models.CreateProxyInstanceBody](request)
+	if err != nil {
+		return nil, err
+	}
+	return createProxies(dataset, body)
+}
+
+// handleCreateProxiesBulk creates the proxies of each package it can. A package whose proxies cannot be created gets
+// an entry with the error in the response.
+func handleCreateProxiesBulk(m *ModelService, request *http.Request, params []string) (any, error) {
+	dataset, err := m.dataset(params[0])
+	if err != nil {
+		return nil, err
+	}
+	body, err := decodeBody[[]models.CreateProxyInstanceBody](request)
+	if err != nil {
+		return nil, err
+	}
+	response := make(models.BatchCreateProxyInstancesResponse, len(body))
+	for i, packageBody := range body {
+		proxyResponse, err := createProxies(dataset, packageBody)
+		if err != nil {
+			response[i] = models.BatchCreateProxyInstancesEntry{Error: err.Error()}
+			continue
+		}
+		response[i] = models.BatchCreateProxyInstancesEntry{Proxies: proxyResponse}
+	}
+	return response, nil
+}
+
+// handleDeleteProxies deletes the proxies of the source record, or none if any of them is not a proxy of that record
+func handleDeleteProxies(m *ModelService, request *http.Request, params []string) (any, error) {
+	dataset, err := m.dataset(params[0])
+	if err != nil {
+		return nil, err
+	}
+	body, err := decodeBody[models.DeleteProxyInstancesBody](request)
+	if err != nil {
+		return nil, err
+	}
+	for _, proxyID := range body.ProxyInstanceIDs {
+		if !slices.ContainsFunc(dataset.Proxies, func(p Proxy) bool { return p.ID == proxyID && p.RecordID == body.SourceRecordID }) {
+			return nil, notFound("no proxy %s of record %s", proxyID, body.SourceRecordID)
+		}
+	}
+	dataset.Proxies = slices.DeleteFunc(dataset.Proxies, func(p Proxy) bool {
+		return slices.Contains(body.ProxyInstanceIDs, p.ID)
+	})
+	return nil, nil
+}
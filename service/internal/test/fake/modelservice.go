@@ -0,0 +1,268 @@
+// Package fake provides a stateful, in-memory stand-in for the Pennsieve model service. Unlike the mock package, which
+// checks each expected call, the fake answers any sequence of calls the way Pennsieve would, so that tests can assert
+// on the final state of a dataset instead of on the calls that produced it.
+package fake
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	clientmodels "github.com/pennsieve/processor-post-metadata/client/models"
+	"github.com/pennsieve/processor-post-metadata/service/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// ModelService serves the integration, concepts, properties, instances, linked, relationships and proxy endpoints
+// used by pennsieve.Session from an in-memory graph per dataset. It generates IDs for what it creates and rejects
+// changes that would break referential integrity, such as deleting a model that still has records, with an error
+// status, as Pennsieve does.
+type ModelService struct {
+	Server       *httptest.Server
+	t            *testing.T
+	mu           sync.Mutex
+	integrations map[string]string
+	datasets     map[string]*Dataset
+}
+
+func NewModelService(t *testing.T) *ModelService {
+	m := &ModelService{
+		t:            t,
+		integrations: map[string]string{},
+		datasets:     map[string]*Dataset{},
+	}
+	m.Server = httptest.NewServer(http.HandlerFunc(m.serveHTTP))
+	return m
+}
+
+func (m *ModelService) Close() {
+	m.Server.Close()
+}
+
+func (m *ModelService) URL() string {
+	return m.Server.URL
+}
+
+// AddIntegration makes the integration belong to the dataset, creating an empty dataset if it does not exist yet
+func (m *ModelService) AddIntegration(integrationID string, datasetID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.integrations[integrationID] = datasetID
+	if _, found := m.datasets[datasetID]; !found {
+		m.datasets[datasetID] = &Dataset{ID: datasetID}
+	}
+}
+
+// AddModel creates a model with the given properties in the dataset, as if it had existed before the test
+func (m *ModelService) AddModel(datasetID string, model clientmodels.ModelCreateParams, properties ...clientmodels.PropertyCreateParams) clientmodels.PennsieveSchemaID {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	dataset := m.requireDataset(datasetID)
+	modelID, err := createModel(dataset, model)
+	require.NoError(m.t, err)
+	_, err = createProperties(dataset.model(modelID), properties)
+	require.NoError(m.t, err)
+	return modelID
+}
+
+// AddRecord creates a record of the model in the dataset, as if it had existed before the test
+func (m *ModelService) AddRecord(datasetID string, modelID clientmodels.PennsieveSchemaID, values ...clientmodels.RecordValue) clientmodels.PennsieveInstanceID {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	dataset := m.requireDataset(datasetID)
+	model := dataset.model(modelID)
+	require.NotNil(m.t, model, "no model %s in dataset %s", modelID, datasetID)
+	recordID, err := createRecord(model, clientmodels.RecordValues{Values: values})
+	require.NoError(m.t, err)
+	return recordID
+}
+
+// AddRelationshipSchema creates a relationship schema in the dataset, as if it had existed before the test
+func (m *ModelService) AddRelationshipSchema(datasetID string, body models.CreateRelationshipSchemaBody) clientmodels.PennsieveSchemaID {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	dataset := m.requireDataset(datasetID)
+	schemaID, err := createRelationshipSchema(dataset, body)
+	require.NoError(m.t, err)
+	return schemaID
+}
+
+// Dataset returns a copy of the current graph of the dataset
+func (m *ModelService) Dataset(datasetID string) Dataset {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.requireDataset(datasetID).clone()
+}
+
+func (m *ModelService) requireDataset(datasetID string) *Dataset {
+	dataset, found := m.datasets[datasetID]
+	require.True(m.t, found, "no dataset %s; add an integration for it first", datasetID)
+	return dataset
+}
+
+// statusError is an error that the ModelService answers with its status code
+type statusError struct {
+	statusCode int
+	message    string
+}
+
+func (e *statusError) Error() string {
+	return e.message
+}
+
+func notFound(format string, args ...any) error {
+	return &statusError{statusCode: http.StatusNotFound, message: fmt.Sprintf(format, args...)}
+}
+
+func badRequest(format string, args ...any) error {
+	return &statusError{statusCode: http.StatusBadRequest, message: fmt.Sprintf(format, args...)}
+}
+
+func conflict(format string, args ...any) error {
+	return &statusError{statusCode: http.StatusConflict, message: fmt.Sprintf(format, args...)}
+}
+
+func newSchemaID() clientmodels.PennsieveSchemaID {
+	return clientmodels.PennsieveSchemaID(uuid.NewString())
+}
+
+func newInstanceID() clientmodels.PennsieveInstanceID {
+	return clientmodels.PennsieveInstanceID(uuid.NewString())
+}
+
+// handler answers a request to a route. params are the path segments matched by the wildcards of the route, in order.
+// The returned response, if not nil, is written as JSON.
+type handler func(m *ModelService, request *http.Request, params []string) (response any, err error)
+
+type route struct {
+	method string
+	// pattern is the path split on "/". A "*" segment matches any one segment.
+	pattern []string
+	handle  handler
+}
+
+// datasetPath is the pattern of the path of a dataset, followed by segments
+func datasetPath(segments ...string) []string {
+	return append([]string{"models", "datasets", "*"}, segments...)
+}
+
+var routes = []route{
+	{http.MethodGet, []string{"integrations", "*"}, getIntegration},
+	{http.MethodPost, datasetPath("concepts"), handleCreateModel},
+	{http.MethodGet, datasetPath("concepts", "*"), handleGetModel},
+	{http.MethodPut, datasetPath("concepts", "*"), handleUpdateModel},
+	{http.MethodDelete, datasetPath("concepts", "*"), handleDeleteModel},
+	{http.MethodPut, datasetPath("concepts", "*", "properties"), handleCreateProperties},
+	{http.MethodPut, datasetPath("concepts", "*", "properties", "*"), handleUpdateProperty},
+	{http.MethodDelete, datasetPath("concepts", "*", "properties", "*"), handleDeleteProperty},
+	{http.MethodPost, datasetPath("concepts", "*", "instances"), handleCreateRecord},
+	{http.MethodPost, datasetPath("concepts", "*", "instances", "batch"), handleCreateRecords},
+	{http.MethodGet, datasetPath("concepts", "*", "instances"), handleQueryRecords},
+	{http.MethodPut, datasetPath("concepts", "*", "instances", "*"), handleUpdateRecord},
+	{http.MethodDelete, datasetPath("concepts", "*", "instances"), handleDeleteRecords},
+	{http.MethodPost, datasetPath("concepts", "*", "linked"), handleCreateLinkSchema},
+	{http.MethodPut, datasetPath("concepts", "*", "linked", "*"), handleUpdateLinkSchema},
+	{http.MethodDelete, datasetPath("concepts", "*", "linked", "*"), handleDeleteLinkSchema},
+	{http.MethodPost, datasetPath("concepts", "*", "instances", "*", "linked"), handleCreateLinkInstance},
+	{http.MethodDelete, datasetPath("concepts", "*", "instances", "*", "linked", "*"), handleDeleteLinkInstance},
+	{http.MethodPost, datasetPath("relationships"), handleCreateRelationshipSchema},
+	{http.MethodDelete, datasetPath("relationships", "*"), handleDeleteRelationshipSchema},
+	{http.MethodPost, datasetPath("relationships", "*", "instances"), handleCreateRelationshipInstance},
+	{http.MethodDelete, datasetPath("relationships", "*", "instances", "*"), handleDeleteRelationshipInstance},
+	{http.MethodPost, datasetPath("proxy", "package", "instances"), handleCreateProxies},
+	{http.MethodPost, datasetPath("proxy", "package", "instances", "bulk"), handleCreateProxiesBulk},
+	{http.MethodDelete, datasetPath("proxy", "package", "instances", "bulk"), handleDeleteProxies},
+}
+
+// match returns the segments of path matched by the wildcards of pattern, and whether path matches at all
+func match(pattern []string, path []string) ([]string, bool) {
+	if len(pattern) != len(path) {
+		return nil, false
+	}
+	var params []string
+	for i, segment := range pattern {
+		if segment == "*" {
+			params = append(params, path[i])
+			continue
+		}
+		if segment != path[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+func (m *ModelService) serveHTTP(writer http.ResponseWriter, request *http.Request) {
+	path := strings.Split(strings.Trim(request.URL.Path, "/"), "/")
+	for _, route := range routes {
+		if route.method != request.Method {
+			continue
+		}
+		params, matched := match(route.pattern, path)
+		if !matched {
+			continue
+		}
+		m.mu.Lock()
+		response, err := route.handle(m, request, params)
+		m.mu.Unlock()
+		m.respond(writer, request, response, err)
+		return
+	}
+	assert.Fail(m.t, "call to Pennsieve not supported by the fake model service", "%s %s", request.Method, request.URL)
+	http.Error(writer, fmt.Sprintf("no fake for %s %s", request.Method, request.URL.Path), http.StatusNotFound)
+}
+
+func (m *ModelService) respond(writer http.ResponseWriter, request *http.Request, response any, err error) {
+	if err != nil {
+		var statusErr *statusError
+		if !errors.As(err, &statusErr) {
+			statusErr = &statusError{statusCode: http.StatusInternalServerError, message: err.Error()}
+		}
+		http.Error(writer, statusErr.message, statusErr.statusCode)
+		return
+	}
+	if response == nil {
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(writer).Encode(response); err != nil {
+		assert.NoError(m.t, err, "error writing response to %s %s", request.Method, request.URL)
+	}
+}
+
+// decodeBody decodes the JSON body of the request into a T
+func decodeBody[T any](request *http.Request) (T, error) {
+	var body T
+	if err := json.NewDecoder(request.Body).Decode(&body); err != nil {
+		return body, badRequest("error decoding body of %s %s: %v", request.Method, request.URL.Path, err)
+	}
+	return body, nil
+}
+
+// dataset returns the dataset with the given ID, or a not found error
+func (m *ModelService) dataset(datasetID string) (*Dataset, error) {
+	dataset, found := m.datasets[datasetID]
+	if !found {
+		return nil, notFound("no dataset %s", datasetID)
+	}
+	return dataset, nil
+}
+
+// datasetModel returns the dataset and one of its models, or a not found error
+func (m *ModelService) datasetModel(datasetID string, modelID string) (*Dataset, *Model, error) {
+	dataset, err := m.dataset(datasetID)
+	if err != nil {
+		return nil, nil, err
+	}
+	model := dataset.model(clientmodels.PennsieveSchemaID(modelID))
+	if model == nil {
+		return nil, nil, notFound("no model %s in dataset %s", modelID, datasetID)
+	}
+	return dataset, model, nil
+}
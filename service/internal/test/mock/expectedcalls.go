@@ -11,6 +11,7 @@ import (
 	"reflect"
 	"slices"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -76,13 +77,17 @@ func (e *ExpectedAPICall[_, _]) Signature() string {
 type ExpectedAPICallMulti[IN, OUT any] struct {
 	APIPath string
 	Calls   []ExpectedAPICallData[IN, OUT]
+	// mu guards the call counts, since the processor may make calls concurrently
+	mu sync.Mutex
 }
 
 type ExpectedAPICallData[IN, OUT any] struct {
 	Method              string
 	ExpectedRequestBody *IN
 	APIResponse         OUT
-	callCount           int
+	// StatusCode, if non-zero, is an error status to respond with instead of APIResponse
+	StatusCode int
+	callCount  int
 }
 
 func (e *ExpectedAPICallMulti[I, O]) HandlerFunction(t *testing.T) func(http.ResponseWriter, *http.Request) {
@@ -101,7 +106,7 @@ func (e *ExpectedAPICallMulti[I, O]) HandlerFunction(t *testing.T) func(http.Res
 			require.NoError(t, requestBodyDecodeError)
 		}
 
-		callIndex := slices.IndexFunc(e.Calls, func(e ExpectedAPICallData[I, O]) bool {
+		matches := func(e ExpectedAPICallData[I, O]) bool {
 			if e.Method != request.Method {
 				return false
 			}
@@ -109,10 +114,25 @@ func (e *ExpectedAPICallMulti[I, O]) HandlerFunction(t *testing.T) func(http.Res
 				return errors.Is(requestBodyDecodeError, io.EOF)
 			}
 			return reflect.DeepEqual(*e.ExpectedRequestBody, actualRequestBody)
+		}
+		e.mu.Lock()
+		// Calls that cannot be told apart, such as GETs of successive pages, are matched in order
+		callIndex := slices.IndexFunc(e.Calls, func(e ExpectedAPICallData[I, O]) bool {
+			return e.callCount == 0 && matches(e)
 		})
+		if callIndex < 0 {
+			callIndex = slices.IndexFunc(e.Calls, matches)
+		}
+		if callIndex >= 0 {
+			e.Calls[callIndex].callCount += 1
+		}
+		e.mu.Unlock()
 		require.GreaterOrEqual(t, callIndex, 0, "unexpected call to %s: method: %s, body %s", e.APIPath, request.Method, actualRequestBodyBytes.String())
 		call := &e.Calls[callIndex]
-		call.callCount += 1
+		if call.StatusCode != 0 {
+			http.Error(writer, fmt.Sprintf("mock failure of %s %s", request.Method, e.APIPath), call.StatusCode)
+			return
+		}
 		responseBytes, err := json.Marshal(call.APIResponse)
 		require.NoError(t, err)
 		// can't see if e.APIResponse is nil because of generics, so
@@ -125,6 +145,8 @@ func (e *ExpectedAPICallMulti[I, O]) HandlerFunction(t *testing.T) func(http.Res
 }
 
 func (e *ExpectedAPICallMulti[_, _]) CallCounts() []int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
 	var counts []int
 	for _, call := range e.Calls {
 		counts = append(counts, call.callCount)
@@ -133,6 +155,8 @@ func (e *ExpectedAPICallMulti[_, _]) CallCounts() []int {
 }
 
 func (e *ExpectedAPICallMulti[_, _]) AllCalledExactlyOnce() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
 	for _, call := range e.Calls {
 		if call.callCount != 1 {
 			return false
@@ -153,3 +177,35 @@ func (e *ExpectedAPICallMulti[_, _]) Signature() string {
 	pieces = append(pieces, e.APIPath)
 	return strings.Join(pieces, " ")
 }
+
+// ExpectedFailedAPICall is for cases where you expect a call that Pennsieve answers with an error status
+type ExpectedFailedAPICall struct {
+	Method     string
+	APIPath    string
+	StatusCode int
+	callCount  int
+}
+
+func (e *ExpectedFailedAPICall) HandlerFunction(t *testing.T) func(http.ResponseWriter, *http.Request) {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		e.callCount += 1
+		require.Equal(t, e.Method, request.Method, "expected method %s for %s, got %s", e.Method, request.URL, request.Method)
+		http.Error(writer, fmt.Sprintf("mock failure of %s", e.Signature()), e.StatusCode)
+	}
+}
+
+func (e *ExpectedFailedAPICall) CallCounts() []int {
+	return []int{e.callCount}
+}
+
+func (e *ExpectedFailedAPICall) AllCalledExactlyOnce() bool {
+	return e.callCount == 1
+}
+
+func (e *ExpectedFailedAPICall) PathHandler(t *testing.T) (string, http.HandlerFunc) {
+	return e.APIPath, e.HandlerFunction(t)
+}
+
+func (e *ExpectedFailedAPICall) Signature() string {
+	return fmt.Sprintf("%s %s", e.Method, e.APIPath)
+}
@@ -21,6 +21,18 @@ func CreateLinkSchema(datasetID string, fromModelID clientmodels.PennsieveSchema
 	}
 }
 
+func UpdateLinkSchema(datasetID string, fromModelID clientmodels.PennsieveSchemaID, linkSchemaID clientmodels.PennsieveSchemaID, expectedRequestBody models.UpdateLinkSchemaBody) *mock.ExpectedAPICall[models.UpdateLinkSchemaBody, models.APIResponse] {
+	return &mock.ExpectedAPICall[models.UpdateLinkSchemaBody, models.APIResponse]{
+		Method:              http.MethodPut,
+		APIPath:             fmt.Sprintf("/models/datasets/%s/concepts/%s/linked/%s", datasetID, fromModelID, linkSchemaID),
+		ExpectedRequestBody: &expectedRequestBody,
+		APIResponse: models.APIResponse{
+			Name: expectedRequestBody.Name,
+			ID:   linkSchemaID.String(),
+		},
+	}
+}
+
 func CreateLinkInstance(datasetID string, fromModelID clientmodels.PennsieveSchemaID, fromRecordID clientmodels.PennsieveInstanceID, expectedRequestBody models.CreateLinkInstanceBody) *mock.ExpectedAPICall[models.CreateLinkInstanceBody, models.APIResponse] {
 	return &mock.ExpectedAPICall[models.CreateLinkInstanceBody, models.APIResponse]{
 		Method:              http.MethodPost,
@@ -39,3 +51,10 @@ func DeleteLinkInstance(datasetID string, modelID clientmodels.PennsieveSchemaID
 			datasetID, modelID, fromRecordID, linkInstanceID),
 	}
 }
+
+func DeleteLinkSchema(datasetID string, fromModelID clientmodels.PennsieveSchemaID, linkSchemaID clientmodels.PennsieveSchemaID) *mock.ExpectedAPICall[any, any] {
+	return &mock.ExpectedAPICall[any, any]{
+		Method:  http.MethodDelete,
+		APIPath: fmt.Sprintf("/models/datasets/%s/concepts/%s/linked/%s", datasetID, fromModelID, linkSchemaID),
+	}
+}
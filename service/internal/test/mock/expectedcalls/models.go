@@ -37,6 +37,53 @@ func PropertiesCreate(expectedDatasetID string, modelID clientmodels.PennsieveSc
 	}
 }
 
+// ModelUpdate expects the current model to be fetched, and then the update. Both calls are to the same path.
+func ModelUpdate(datasetID string, modelID clientmodels.PennsieveSchemaID, current models.ModelResponse, expectedUpdate clientmodels.ModelCreateParams) *mock.ExpectedAPICallMulti[clientmodels.ModelCreateParams, models.ModelResponse] {
+	current.ID = modelID.String()
+	updated := models.ModelResponse{
+		APIResponse: current.APIResponse,
+		DisplayName: expectedUpdate.DisplayName,
+		Description: expectedUpdate.Description,
+		Locked:      expectedUpdate.Locked,
+	}
+	return &mock.ExpectedAPICallMulti[clientmodels.ModelCreateParams, models.ModelResponse]{
+		APIPath: fmt.Sprintf("/models/datasets/%s/concepts/%s", datasetID, modelID),
+		Calls: []mock.ExpectedAPICallData[clientmodels.ModelCreateParams, models.ModelResponse]{
+			{
+				Method:      http.MethodGet,
+				APIResponse: current,
+			},
+			{
+				Method:              http.MethodPut,
+				ExpectedRequestBody: &expectedUpdate,
+				APIResponse:         updated,
+			},
+		},
+	}
+}
+
+func PropertyUpdate(datasetID string, modelID clientmodels.PennsieveSchemaID, expectedUpdate clientmodels.PropertyUpdate) *mock.ExpectedAPICall[clientmodels.PropertyCreateParams, any] {
+	return &mock.ExpectedAPICall[clientmodels.PropertyCreateParams, any]{
+		Method:              http.MethodPut,
+		APIPath:             fmt.Sprintf("/models/datasets/%s/concepts/%s/properties/%s", datasetID, modelID, expectedUpdate.ID),
+		ExpectedRequestBody: &expectedUpdate.PropertyCreateParams,
+	}
+}
+
+func PropertyDelete(datasetID string, modelID clientmodels.PennsieveSchemaID, propertyID clientmodels.PennsieveSchemaID) *mock.ExpectedAPICall[any, any] {
+	return &mock.ExpectedAPICall[any, any]{
+		Method:  http.MethodDelete,
+		APIPath: fmt.Sprintf("/models/datasets/%s/concepts/%s/properties/%s", datasetID, modelID, propertyID),
+	}
+}
+
+func ModelDelete(datasetID string, modelID clientmodels.PennsieveSchemaID) *mock.ExpectedAPICall[any, any] {
+	return &mock.ExpectedAPICall[any, any]{
+		Method:  http.MethodDelete,
+		APIPath: fmt.Sprintf("/models/datasets/%s/concepts/%s", datasetID, modelID),
+	}
+}
+
 func RecordCreate(datasetID string, modelID clientmodels.PennsieveSchemaID, expectedCreate clientmodels.RecordValues) *mock.ExpectedAPICall[clientmodels.RecordValues, models.APIResponse] {
 	return &mock.ExpectedAPICall[clientmodels.RecordValues, models.APIResponse]{
 		Method:              http.MethodPost,
@@ -49,6 +96,75 @@ func RecordCreate(datasetID string, modelID clientmodels.PennsieveSchemaID, expe
 	}
 }
 
+// RecordCreates is for when several records are created in the same model. Each expected
+// create should have different values so that the calls can be told apart.
+func RecordCreates(datasetID string, modelID clientmodels.PennsieveSchemaID, expectedCreates ...clientmodels.RecordValues) *mock.ExpectedAPICallMulti[clientmodels.RecordValues, models.APIResponse] {
+	var calls []mock.ExpectedAPICallData[clientmodels.RecordValues, models.APIResponse]
+	for i := range expectedCreates {
+		calls = append(calls, mock.ExpectedAPICallData[clientmodels.RecordValues, models.APIResponse]{
+			Method:              http.MethodPost,
+			ExpectedRequestBody: &expectedCreates[i],
+			APIResponse: models.APIResponse{
+				Name: uuid.NewString(),
+				ID:   uuid.NewString(),
+			},
+		})
+	}
+	return &mock.ExpectedAPICallMulti[clientmodels.RecordValues, models.APIResponse]{
+		APIPath: fmt.Sprintf("/models/datasets/%s/concepts/%s/instances", datasetID, modelID),
+		Calls:   calls,
+	}
+}
+
+// RecordBatchCreates expects one batch create call per element of expectedBatches
+func RecordBatchCreates(datasetID string, modelID clientmodels.PennsieveSchemaID, expectedBatches ...[]clientmodels.RecordValues) *mock.ExpectedAPICallMulti[[]clientmodels.RecordValues, models.BatchCreateRecordsResponse] {
+	var calls []mock.ExpectedAPICallData[[]clientmodels.RecordValues, models.BatchCreateRecordsResponse]
+	for i := range expectedBatches {
+		var apiResponse models.BatchCreateRecordsResponse
+		for range expectedBatches[i] {
+			apiResponse = append(apiResponse, models.BatchCreateRecordResponse{APIResponse: models.APIResponse{
+				Name: uuid.NewString(),
+				ID:   uuid.NewString(),
+			}})
+		}
+		calls = append(calls, mock.ExpectedAPICallData[[]clientmodels.RecordValues, models.BatchCreateRecordsResponse]{
+			Method:              http.MethodPost,
+			ExpectedRequestBody: &expectedBatches[i],
+			APIResponse:         apiResponse,
+		})
+	}
+	return &mock.ExpectedAPICallMulti[[]clientmodels.RecordValues, models.BatchCreateRecordsResponse]{
+		APIPath: fmt.Sprintf("/models/datasets/%s/concepts/%s/instances/batch", datasetID, modelID),
+		Calls:   calls,
+	}
+}
+
+// RecordUpserts expects one GET of existing records per page in existingPages, followed by one record create per
+// expected create, since both use the same path
+func RecordUpserts(datasetID string, modelID clientmodels.PennsieveSchemaID, existingPages [][]models.RecordResponse, expectedCreates ...clientmodels.RecordValues) *mock.ExpectedAPICallMulti[clientmodels.RecordValues, any] {
+	var calls []mock.ExpectedAPICallData[clientmodels.RecordValues, any]
+	for _, page := range existingPages {
+		calls = append(calls, mock.ExpectedAPICallData[clientmodels.RecordValues, any]{
+			Method:      http.MethodGet,
+			APIResponse: page,
+		})
+	}
+	for i := range expectedCreates {
+		calls = append(calls, mock.ExpectedAPICallData[clientmodels.RecordValues, any]{
+			Method:              http.MethodPost,
+			ExpectedRequestBody: &expectedCreates[i],
+			APIResponse: models.APIResponse{
+				Name: uuid.NewString(),
+				ID:   uuid.NewString(),
+			},
+		})
+	}
+	return &mock.ExpectedAPICallMulti[clientmodels.RecordValues, any]{
+		APIPath: fmt.Sprintf("/models/datasets/%s/concepts/%s/instances", datasetID, modelID),
+		Calls:   calls,
+	}
+}
+
 func RecordUpdate(datasetID string, modelID clientmodels.PennsieveSchemaID, recordID clientmodels.PennsieveInstanceID, expectedUpdate clientmodels.RecordValues) *mock.ExpectedAPICall[clientmodels.RecordValues, models.APIResponse] {
 	return &mock.ExpectedAPICall[clientmodels.RecordValues, models.APIResponse]{
 		Method:              http.MethodPut,
@@ -72,6 +188,22 @@ func RecordDelete(datasetID string, modelID clientmodels.PennsieveSchemaID, expe
 	}
 }
 
+// RecordDeletes expects one delete call per element of expectedBatches. Set StatusCode on a call to fail it.
+func RecordDeletes(datasetID string, modelID clientmodels.PennsieveSchemaID, expectedBatches ...[]clientmodels.PennsieveInstanceID) *mock.ExpectedAPICallMulti[[]clientmodels.PennsieveInstanceID, models.BulkDeleteRecordsResponse] {
+	var calls []mock.ExpectedAPICallData[[]clientmodels.PennsieveInstanceID, models.BulkDeleteRecordsResponse]
+	for i := range expectedBatches {
+		calls = append(calls, mock.ExpectedAPICallData[[]clientmodels.PennsieveInstanceID, models.BulkDeleteRecordsResponse]{
+			Method:              http.MethodDelete,
+			ExpectedRequestBody: &expectedBatches[i],
+			APIResponse:         models.BulkDeleteRecordsResponse{Success: expectedBatches[i]},
+		})
+	}
+	return &mock.ExpectedAPICallMulti[[]clientmodels.PennsieveInstanceID, models.BulkDeleteRecordsResponse]{
+		APIPath: fmt.Sprintf("/models/datasets/%s/concepts/%s/instances", datasetID, modelID),
+		Calls:   calls,
+	}
+}
+
 func RecordDeleteFailure(datasetID string, modelID clientmodels.PennsieveSchemaID, expectedDelete []clientmodels.PennsieveInstanceID) *mock.ExpectedAPICall[[]clientmodels.PennsieveInstanceID, models.BulkDeleteRecordsResponse] {
 	var errs [][]string
 	for _, recordID := range expectedDelete {
@@ -8,9 +8,9 @@ import (
 	"net/http"
 )
 
-func CreateProxyRelationshipSchema(datasetID string) *mock.ExpectedAPICall[models.CreateProxyRelationshipSchemaBody, models.APIResponse] {
+func CreateProxyRelationshipSchema(datasetID string) *mock.ExpectedAPICall[models.CreateRelationshipSchemaBody, models.APIResponse] {
 	expectedBody := models.NewCreateProxyRelationshipSchemaBody()
-	return &mock.ExpectedAPICall[models.CreateProxyRelationshipSchemaBody, models.APIResponse]{
+	return &mock.ExpectedAPICall[models.CreateRelationshipSchemaBody, models.APIResponse]{
 		Method:              http.MethodPost,
 		APIPath:             fmt.Sprintf("/models/datasets/%s/relationships", datasetID),
 		ExpectedRequestBody: &expectedBody,
@@ -35,16 +35,48 @@ func DeleteProxyInstances(datasetID string, expectedBody ...models.DeleteProxyIn
 	}
 }
 
-func CreateProxyInstance(datasetID string, expectedBody ...models.CreateProxyInstanceBody) *mock.ExpectedAPICallMulti[models.CreateProxyInstanceBody, any] {
-	var calls []mock.ExpectedAPICallData[models.CreateProxyInstanceBody, any]
+// CreateProxyInstance expects one proxy instance create per expectedBody. Each response contains a new proxy instance ID
+// for each target in the body.
+func CreateProxyInstance(datasetID string, expectedBody ...models.CreateProxyInstanceBody) *mock.ExpectedAPICallMulti[models.CreateProxyInstanceBody, models.CreateProxyInstanceResponse] {
+	var calls []mock.ExpectedAPICallData[models.CreateProxyInstanceBody, models.CreateProxyInstanceResponse]
 	for i := range expectedBody {
-		calls = append(calls, mock.ExpectedAPICallData[models.CreateProxyInstanceBody, any]{
+		var response models.CreateProxyInstanceResponse
+		for range expectedBody[i].Targets {
+			response = append(response, models.ProxyInstanceResponse{
+				ProxyInstance: models.APIResponse{ID: uuid.NewString()},
+			})
+		}
+		calls = append(calls, mock.ExpectedAPICallData[models.CreateProxyInstanceBody, models.CreateProxyInstanceResponse]{
 			Method:              http.MethodPost,
 			ExpectedRequestBody: &expectedBody[i],
+			APIResponse:         response,
 		})
 	}
-	return &mock.ExpectedAPICallMulti[models.CreateProxyInstanceBody, any]{
+	return &mock.ExpectedAPICallMulti[models.CreateProxyInstanceBody, models.CreateProxyInstanceResponse]{
 		APIPath: fmt.Sprintf("/models/datasets/%s/proxy/package/instances", datasetID),
 		Calls:   calls,
 	}
 }
+
+// CreateProxyInstances expects one bulk proxy instance create per expected batch. Each response contains a new proxy
+// instance ID for each package in the batch.
+func CreateProxyInstances(datasetID string, expectedBatches ...[]models.CreateProxyInstanceBody) *mock.ExpectedAPICallMulti[[]models.CreateProxyInstanceBody, models.BatchCreateProxyInstancesResponse] {
+	var calls []mock.ExpectedAPICallData[[]models.CreateProxyInstanceBody, models.BatchCreateProxyInstancesResponse]
+	for i := range expectedBatches {
+		var response models.BatchCreateProxyInstancesResponse
+		for range expectedBatches[i] {
+			response = append(response, models.BatchCreateProxyInstancesEntry{Proxies: models.CreateProxyInstanceResponse{{
+				ProxyInstance: models.APIResponse{ID: uuid.NewString()},
+			}}})
+		}
+		calls = append(calls, mock.ExpectedAPICallData[[]models.CreateProxyInstanceBody, models.BatchCreateProxyInstancesResponse]{
+			Method:              http.MethodPost,
+			ExpectedRequestBody: &expectedBatches[i],
+			APIResponse:         response,
+		})
+	}
+	return &mock.ExpectedAPICallMulti[[]models.CreateProxyInstanceBody, models.BatchCreateProxyInstancesResponse]{
+		APIPath: fmt.Sprintf("/models/datasets/%s/proxy/package/instances/bulk", datasetID),
+		Calls:   calls,
+	}
+}
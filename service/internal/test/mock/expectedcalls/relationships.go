@@ -0,0 +1,53 @@
+package expectedcalls
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	clientmodels "github.com/pennsieve/processor-post-metadata/client/models"
+	"github.com/pennsieve/processor-post-metadata/service/internal/test/mock"
+	"github.com/pennsieve/processor-post-metadata/service/models"
+	"net/http"
+)
+
+// CreateRelationshipSchema has the same path as CreateProxyRelationshipSchema, so the two cannot be expected by the same mock server
+func CreateRelationshipSchema(datasetID string, expectedRequestBody models.CreateRelationshipSchemaBody) *mock.ExpectedAPICall[models.CreateRelationshipSchemaBody, models.APIResponse] {
+	return &mock.ExpectedAPICall[models.CreateRelationshipSchemaBody, models.APIResponse]{
+		Method:              http.MethodPost,
+		APIPath:             fmt.Sprintf("/models/datasets/%s/relationships", datasetID),
+		ExpectedRequestBody: &expectedRequestBody,
+		APIResponse: models.APIResponse{
+			Name: expectedRequestBody.Name,
+			ID:   uuid.NewString(),
+		},
+	}
+}
+
+func DeleteRelationshipSchema(datasetID string, relationshipSchemaID clientmodels.PennsieveSchemaID) *mock.ExpectedAPICall[any, any] {
+	return &mock.ExpectedAPICall[any, any]{
+		Method:  http.MethodDelete,
+		APIPath: fmt.Sprintf("/models/datasets/%s/relationships/%s", datasetID, relationshipSchemaID),
+	}
+}
+
+// CreateRelationshipInstance expects one relationship instance create per expectedBody. Each response contains a new relationship instance ID.
+func CreateRelationshipInstance(datasetID string, relationshipSchemaID clientmodels.PennsieveSchemaID, expectedBody ...models.CreateRelationshipInstanceBody) *mock.ExpectedAPICallMulti[models.CreateRelationshipInstanceBody, models.APIResponse] {
+	var calls []mock.ExpectedAPICallData[models.CreateRelationshipInstanceBody, models.APIResponse]
+	for i := range expectedBody {
+		calls = append(calls, mock.ExpectedAPICallData[models.CreateRelationshipInstanceBody, models.APIResponse]{
+			Method:              http.MethodPost,
+			ExpectedRequestBody: &expectedBody[i],
+			APIResponse:         models.APIResponse{ID: uuid.NewString()},
+		})
+	}
+	return &mock.ExpectedAPICallMulti[models.CreateRelationshipInstanceBody, models.APIResponse]{
+		APIPath: fmt.Sprintf("/models/datasets/%s/relationships/%s/instances", datasetID, relationshipSchemaID),
+		Calls:   calls,
+	}
+}
+
+func DeleteRelationshipInstance(datasetID string, relationshipSchemaID clientmodels.PennsieveSchemaID, relationshipInstanceID clientmodels.PennsieveInstanceID) *mock.ExpectedAPICall[any, any] {
+	return &mock.ExpectedAPICall[any, any]{
+		Method:  http.MethodDelete,
+		APIPath: fmt.Sprintf("/models/datasets/%s/relationships/%s/instances/%s", datasetID, relationshipSchemaID, relationshipInstanceID),
+	}
+}
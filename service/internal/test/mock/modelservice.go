@@ -5,15 +5,19 @@ import (
 	"github.com/stretchr/testify/require"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"testing"
 )
 
 type ModelService struct {
 	Server        *httptest.Server
 	ExpectedCalls []ExpectedCall
+	mu            sync.Mutex
+	headers       []http.Header
 }
 
 func NewModelService(t *testing.T, expectedCall ...ExpectedCall) *ModelService {
+	m := &ModelService{ExpectedCalls: expectedCall}
 	mux := http.NewServeMux()
 	for _, ph := range expectedCall {
 		mux.HandleFunc(ph.PathHandler(t))
@@ -21,10 +25,20 @@ func NewModelService(t *testing.T, expectedCall ...ExpectedCall) *ModelService {
 	mux.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
 		require.Fail(t, "unexpected call to Pennsieve", "%s %s", request.Method, request.URL)
 	})
-	return &ModelService{
-		Server:        httptest.NewServer(mux),
-		ExpectedCalls: expectedCall,
-	}
+	m.Server = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		m.mu.Lock()
+		m.headers = append(m.headers, request.Header.Clone())
+		m.mu.Unlock()
+		mux.ServeHTTP(writer, request)
+	}))
+	return m
+}
+
+// RequestHeaders returns the headers of the requests received so far, in the order received
+func (m *ModelService) RequestHeaders() []http.Header {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]http.Header(nil), m.headers...)
 }
 
 func (m *ModelService) AssertAllCalledExactlyOnce(t *testing.T) bool {
@@ -1,14 +1,22 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"github.com/pennsieve/processor-post-metadata/service/logging"
+	"github.com/pennsieve/processor-post-metadata/service/pennsieve"
 	"github.com/pennsieve/processor-post-metadata/service/processor"
 	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
 )
 
 var logger = logging.PackageLogger("main")
 
+// errTerminated is the cause of a run stopped by SIGTERM or SIGINT
+var errTerminated = errors.New("received termination signal")
+
 func main() {
 	m, err := processor.FromEnv()
 	if err != nil {
@@ -16,16 +24,48 @@ func main() {
 		os.Exit(1)
 	}
 
-	logger.Info("created MetadataPostProcessor",
+	logAttrs := []any{
 		slog.String("integrationID", m.IntegrationID),
 		slog.String("inputDirectory", m.InputDirectory),
 		slog.String("outputDirectory", m.OutputDirectory),
-		slog.String("apiHost", m.Pennsieve.APIHost),
-		slog.String("api2Host", m.Pennsieve.API2Host),
-	)
+		slog.Bool("planMode", m.PlanMode),
+		slog.Bool("rollbackOnFailure", m.RollbackOnFailure),
+		slog.String("metricsAddress", m.MetricsAddress),
+		slog.String("tracesExporter", os.Getenv(processor.TracesExporterKey)),
+		slog.Duration("runTimeout", m.RunTimeout),
+	}
+	if session, isSession := m.Pennsieve.(*pennsieve.Session); isSession {
+		logAttrs = append(logAttrs,
+			slog.String("apiHost", session.APIHost),
+			slog.String("api2Host", session.API2Host),
+			slog.Duration("requestTimeout", session.HTTPClient.Timeout),
+		)
+	}
+	logger.Info("created MetadataPostProcessor", logAttrs...)
 
-	if err := m.Run(); err != nil {
+	ctx := stopOnSignal()
+	run := m.Run
+	if m.PlanMode {
+		run = m.Plan
+	}
+	if err := run(ctx); err != nil {
 		logger.Error("error running processor", slog.Any("error", err))
 		os.Exit(1)
 	}
 }
+
+// stopOnSignal returns a context that is cancelled on SIGTERM or SIGINT. The processor then stops starting new
+// operations, lets those in flight finish, and writes its report, journal and metrics before Run returns.
+func stopOnSignal() context.Context {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		received := <-signals
+		logger.Warn("stopping processor", slog.String("signal", received.String()))
+		cancel(errTerminated)
+		// a second signal is not caught, so that it kills the process as usual
+		signal.Stop(signals)
+	}()
+	return ctx
+}
@@ -0,0 +1,276 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the content type of the text written by Registry.Write
+const ContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// DefaultLatencyBuckets are histogram bucket upper bounds in seconds suitable for Pennsieve API calls
+var DefaultLatencyBuckets = []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+type metricType string
+
+const (
+	counterType   metricType = "counter"
+	gaugeType     metricType = "gauge"
+	histogramType metricType = "histogram"
+)
+
+// Registry holds metric families and writes them in the OpenMetrics text format. Safe for concurrent use.
+// A nil *Registry is valid and records nothing, so that instrumented code does not need to check whether
+// metrics are enabled.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: map[string]*family{}}
+}
+
+type family struct {
+	name       string
+	help       string
+	metricType metricType
+	labelNames []string
+	// buckets are the upper bounds of a histogram, not including +Inf
+	buckets []float64
+	series  map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	// value is the value of a counter or gauge, or the sum of a histogram
+	value        float64
+	count        uint64
+	bucketCounts []uint64
+}
+
+// Counter is a monotonically increasing metric family. The family name should not end in _total; the suffix is added
+// when written.
+type Counter struct {
+	family *family
+	r      *Registry
+}
+
+// Gauge is a metric family whose values can be set to anything
+type Gauge struct {
+	family *family
+	r      *Registry
+}
+
+// Histogram is a metric family that counts observations in buckets
+type Histogram struct {
+	family *family
+	r      *Registry
+}
+
+// Counter returns the counter family with the given name, registering it if this is the first call for name.
+func (r *Registry) Counter(name, help string, labelNames ...string) *Counter {
+	return &Counter{family: r.family(name, help, counterType, labelNames, nil), r: r}
+}
+
+// Gauge returns the gauge family with the given name, registering it if this is the first call for name.
+func (r *Registry) Gauge(name, help string, labelNames ...string) *Gauge {
+	return &Gauge{family: r.family(name, help, gaugeType, labelNames, nil), r: r}
+}
+
+// Histogram returns the histogram family with the given name, registering it with buckets if this is the first call
+// for name. buckets are upper bounds in increasing order; the +Inf bucket is implicit.
+func (r *Registry) Histogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	return &Histogram{family: r.family(name, help, histogramType, labelNames, buckets), r: r}
+}
+
+func (r *Registry) family(name, help string, metricType metricType, labelNames []string, buckets []float64) *family {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if existing, found := r.families[name]; found {
+		if existing.metricType != metricType || len(existing.labelNames) != len(labelNames) {
+			panic(fmt.Sprintf("metric %s registered twice with different types or labels", name))
+		}
+		return existing
+	}
+	f := &family{
+		name:       name,
+		help:       help,
+		metricType: metricType,
+		labelNames: labelNames,
+		buckets:    buckets,
+		series:     map[string]*series{},
+	}
+	r.families[name] = f
+	return f
+}
+
+// seriesFor returns the series of f for labelValues, creating it if necessary. Must be called with r.mu held.
+func (f *family) seriesFor(labelValues []string) *series {
+	if len(labelValues) != len(f.labelNames) {
+		panic(fmt.Sprintf("metric %s has labels %v but got %d values", f.name, f.labelNames, len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, found := f.series[key]
+	if !found {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		if f.metricType == histogramType {
+			s.bucketCounts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// Inc adds one to the counter with the given label values
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds value, which must not be negative, to the counter with the given label values
+func (c *Counter) Add(value float64, labelValues ...string) {
+	if c.family == nil {
+		return
+	}
+	if value < 0 {
+		panic(fmt.Sprintf("counter %s cannot decrease", c.family.name))
+	}
+	c.r.mu.Lock()
+	defer c.r.mu.Unlock()
+	c.family.seriesFor(labelValues).value += value
+}
+
+// Set sets the gauge with the given label values
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	if g.family == nil {
+		return
+	}
+	g.r.mu.Lock()
+	defer g.r.mu.Unlock()
+	g.family.seriesFor(labelValues).value = value
+}
+
+// Observe records value in the histogram with the given label values
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	if h.family == nil {
+		return
+	}
+	h.r.mu.Lock()
+	defer h.r.mu.Unlock()
+	s := h.family.seriesFor(labelValues)
+	s.value += value
+	s.count++
+	for i, upperBound := range h.family.buckets {
+		if value <= upperBound {
+			s.bucketCounts[i]++
+		}
+	}
+}
+
+// Write writes every family in the OpenMetrics text format, ending with the # EOF marker.
+// Families and series are sorted so that the output is stable.
+func (r *Registry) Write(w io.Writer) error {
+	var builder strings.Builder
+	if r != nil {
+		r.mu.Lock()
+		names := make([]string, 0, len(r.families))
+		for name := range r.families {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			r.families[name].write(&builder)
+		}
+		r.mu.Unlock()
+	}
+	builder.WriteString("# EOF\n")
+	if _, err := io.WriteString(w, builder.String()); err != nil {
+		return fmt.Errorf("error writing metrics: %w", err)
+	}
+	return nil
+}
+
+func (f *family) write(builder *strings.Builder) {
+	fmt.Fprintf(builder, "# TYPE %s %s\n", f.name, f.metricType)
+	if len(f.help) > 0 {
+		fmt.Fprintf(builder, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	}
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := f.series[key]
+		switch f.metricType {
+		case counterType:
+			writeSample(builder, f.name+"_total", f.labelNames, s.labelValues, "", "", s.value)
+		case gaugeType:
+			writeSample(builder, f.name, f.labelNames, s.labelValues, "", "", s.value)
+		case histogramType:
+			for i, upperBound := range f.buckets {
+				writeSample(builder, f.name+"_bucket", f.labelNames, s.labelValues, "le", formatFloat(upperBound), float64(s.bucketCounts[i]))
+			}
+			writeSample(builder, f.name+"_bucket", f.labelNames, s.labelValues, "le", "+Inf", float64(s.count))
+			writeSample(builder, f.name+"_count", f.labelNames, s.labelValues, "", "", float64(s.count))
+			writeSample(builder, f.name+"_sum", f.labelNames, s.labelValues, "", "", s.value)
+		}
+	}
+}
+
+// writeSample writes one sample line. If extraLabelName is not empty, it is added after the family's labels, as
+// for the le label of histogram buckets.
+func writeSample(builder *strings.Builder, name string, labelNames, labelValues []string, extraLabelName, extraLabelValue string, value float64) {
+	builder.WriteString(name)
+	var labels []string
+	for i, labelName := range labelNames {
+		labels = append(labels, fmt.Sprintf("%s=%q", labelName, escapeLabelValue(labelValues[i])))
+	}
+	if len(extraLabelName) > 0 {
+		labels = append(labels, fmt.Sprintf("%s=%q", extraLabelName, extraLabelValue))
+	}
+	if len(labels) > 0 {
+		builder.WriteString("{")
+		builder.WriteString(strings.Join(labels, ","))
+		builder.WriteString("}")
+	}
+	builder.WriteString(" ")
+	builder.WriteString(formatFloat(value))
+	builder.WriteString("\n")
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
+
+// escapeLabelValue drops characters that fmt's %q would escape differently from OpenMetrics. Label values here are
+// endpoints, phases and similar identifiers, so nothing meaningful is lost.
+func escapeLabelValue(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7f || r > 0x7e {
+			return -1
+		}
+		return r
+	}, value)
+}
+
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
@@ -0,0 +1,97 @@
+package metrics_test
+
+import (
+	"github.com/pennsieve/processor-post-metadata/service/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	for scenario, testFunc := range map[string]func(t *testing.T){
+		"writes OpenMetrics text":      writesOpenMetricsText,
+		"nil registry records nothing": nilRegistryRecordsNothing,
+		"writes file":                  writesFile,
+		"serves metrics":               servesMetrics,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			testFunc(t)
+		})
+	}
+}
+
+func writesOpenMetricsText(t *testing.T) {
+	registry := metrics.NewRegistry()
+	requests := registry.Counter("requests", "Requests sent", "method", "status")
+	requests.Inc("POST", "201")
+	requests.Inc("GET", "200")
+	requests.Add(2, "GET", "200")
+	registry.Gauge("success", "1 if it worked").Set(1)
+	latency := registry.Histogram("latency_seconds", "Latency", []float64{0.1, 1}, "method")
+	latency.Observe(0.05, "GET")
+	latency.Observe(0.5, "GET")
+
+	var builder strings.Builder
+	require.NoError(t, registry.Write(&builder))
+	assert.Equal(t, `# TYPE latency_seconds histogram
+# HELP latency_seconds Latency
+latency_seconds_bucket{method="GET",le="0.1"} 1
+latency_seconds_bucket{method="GET",le="1"} 2
+latency_seconds_bucket{method="GET",le="+Inf"} 2
+latency_seconds_count{method="GET"} 2
+latency_seconds_sum{method="GET"} 0.55
+# TYPE requests counter
+# HELP requests Requests sent
+requests_total{method="GET",status="200"} 3
+requests_total{method="POST",status="201"} 1
+# TYPE success gauge
+# HELP success 1 if it worked
+success 1
+# EOF
+`, builder.String())
+}
+
+func nilRegistryRecordsNothing(t *testing.T) {
+	var registry *metrics.Registry
+	registry.Counter("requests", "Requests sent", "method").Inc("GET")
+	registry.Gauge("success", "1 if it worked").Set(1)
+	registry.Histogram("latency_seconds", "Latency", metrics.DefaultLatencyBuckets).Observe(1)
+
+	var builder strings.Builder
+	require.NoError(t, registry.Write(&builder))
+	assert.Equal(t, "# EOF\n", builder.String())
+}
+
+func writesFile(t *testing.T) {
+	registry := metrics.NewRegistry()
+	registry.Gauge("success", "1 if it worked").Set(1)
+	filePath := filepath.Join(t.TempDir(), "metrics.prom")
+
+	require.NoError(t, registry.WriteFile(filePath))
+	content, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "success 1\n")
+	assert.NoFileExists(t, filePath+".tmp")
+}
+
+func servesMetrics(t *testing.T) {
+	registry := metrics.NewRegistry()
+	server, err := metrics.Serve("localhost:0", registry)
+	require.NoError(t, err)
+	defer server.Close()
+	// recorded after the server started, so the response shows the current values
+	registry.Counter("requests", "Requests sent").Inc()
+
+	response, err := http.Get("http://" + server.Address() + metrics.MetricsPath)
+	require.NoError(t, err)
+	defer response.Body.Close()
+	assert.Equal(t, metrics.ContentType, response.Header.Get("Content-Type"))
+	body, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "requests_total 1\n")
+}
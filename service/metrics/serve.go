@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/pennsieve/processor-post-metadata/service/logging"
+	"github.com/pennsieve/processor-post-metadata/service/util"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+var logger = logging.PackageLogger("metrics")
+
+// MetricsPath is the path on which a Server serves metrics
+const MetricsPath = "/metrics"
+
+const shutdownTimeout = 5 * time.Second
+
+// WriteFile writes the metrics in r to filePath. The file is written under a temporary name and then renamed, so that
+// a textfile collector never reads a partly written file.
+func (r *Registry) WriteFile(filePath string) error {
+	tempPath := filePath + ".tmp"
+	file, err := os.Create(tempPath)
+	if err != nil {
+		return fmt.Errorf("error creating metrics file %s: %w", tempPath, err)
+	}
+	writeErr := r.Write(file)
+	util.CloseFileAndWarn(file)
+	if writeErr != nil {
+		return fmt.Errorf("error writing metrics file %s: %w", tempPath, writeErr)
+	}
+	if err := os.Rename(tempPath, filePath); err != nil {
+		return fmt.Errorf("error renaming metrics file %s to %s: %w", tempPath, filePath, err)
+	}
+	return nil
+}
+
+// Handler returns an http.Handler that responds with the current metrics in r
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		if err := r.Write(w); err != nil {
+			logger.Warn("error serving metrics", slog.Any("error", err))
+		}
+	})
+}
+
+// Server serves the metrics of a Registry on MetricsPath while a run is in progress
+type Server struct {
+	server   *http.Server
+	listener net.Listener
+}
+
+// Serve starts serving the metrics in r on MetricsPath at address, for example "localhost:9464".
+// Call Close to stop.
+func Serve(address string, r *Registry) (*Server, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("error listening for metrics requests on %s: %w", address, err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle(MetricsPath, r.Handler())
+	s := &Server{
+		server:   &http.Server{Handler: mux, ReadHeaderTimeout: shutdownTimeout},
+		listener: listener,
+	}
+	go func() {
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Warn("metrics server stopped", slog.Any("error", err))
+		}
+	}()
+	logger.Info("serving metrics", slog.String("address", s.Address()), slog.String("path", MetricsPath))
+	return s, nil
+}
+
+// Address returns the address the Server is listening on. Useful if Serve was called with port 0.
+func (s *Server) Address() string {
+	return s.listener.Addr().String()
+}
+
+// Close stops the Server, waiting a short time for in-flight requests to finish
+func (s *Server) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := s.server.Shutdown(ctx); err != nil {
+		return fmt.Errorf("error stopping metrics server: %w", err)
+	}
+	return nil
+}
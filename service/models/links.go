@@ -9,6 +9,9 @@ type CreateLinkSchemaBody struct {
 	Position    int                            `json:"position"`
 }
 
+// UpdateLinkSchemaBody is the same as CreateLinkSchemaBody, but all values should be given, changed or not
+type UpdateLinkSchemaBody CreateLinkSchemaBody
+
 type CreateLinkInstanceBody struct {
 	SchemaLinkedPropertyId clientmodels.PennsieveSchemaID   `json:"schemaLinkedPropertyId"`
 	To                     clientmodels.PennsieveInstanceID `json:"to"`
@@ -1,22 +1,15 @@
 package models
 
-import clientmodels "github.com/pennsieve/processor-post-metadata/client/models"
+import (
+	"bytes"
+	"encoding/json"
+	clientmodels "github.com/pennsieve/processor-post-metadata/client/models"
+)
 
-const ProxyRelationshipSchemaName = "belongs_to"
+const ProxyRelationshipSchemaName = clientmodels.ProxyRelationshipSchemaName
 
-type CreateProxyRelationshipSchemaBody struct {
-	Name        string   `json:"name"`
-	DisplayName string   `json:"displayName"`
-	Description string   `json:"description"`
-	Schema      []string `json:"schema"`
-}
-
-func NewCreateProxyRelationshipSchemaBody() CreateProxyRelationshipSchemaBody {
-	return CreateProxyRelationshipSchemaBody{
-		Name:        ProxyRelationshipSchemaName,
-		DisplayName: "Belongs To",
-		Schema:      make([]string, 0),
-	}
+func NewCreateProxyRelationshipSchemaBody() CreateRelationshipSchemaBody {
+	return NewCreateRelationshipSchemaBody(ProxyRelationshipSchemaName, "Belongs To", "")
 }
 
 type CreateProxyInstanceBody struct {
@@ -25,26 +18,37 @@ type CreateProxyInstanceBody struct {
 	Targets    []CreateProxyInstanceTarget `json:"targets"`
 }
 
+// NewCreateProxyInstanceBody links the package to the record with the default "belongs_to" relationship
 func NewCreateProxyInstanceBody(recordID clientmodels.PennsieveInstanceID, packageNodeID string) CreateProxyInstanceBody {
-	recordLinkTarget := linkTarget{ConceptInstance: conceptInstance{ID: recordID}}
+	return NewCreateProxyInstanceBodyWithTargets(packageNodeID, NewCreateProxyInstanceTarget(recordID, clientmodels.ProxyRelationship{}))
+}
+
+func NewCreateProxyInstanceBodyWithTargets(packageNodeID string, targets ...CreateProxyInstanceTarget) CreateProxyInstanceBody {
 	return CreateProxyInstanceBody{
 		ExternalID: packageNodeID,
-		Targets: []CreateProxyInstanceTarget{
-			{
-				Direction:        "FromTarget",
-				LinkTarget:       recordLinkTarget,
-				RelationshipType: ProxyRelationshipSchemaName,
-				RelationshipData: make([]any, 0),
-			},
-		},
+		Targets:    targets,
 	}
 }
 
 type CreateProxyInstanceTarget struct {
-	Direction        string     `json:"direction"`
-	LinkTarget       linkTarget `json:"linkTarget"`
-	RelationshipType string     `json:"relationshipType"`
-	RelationshipData []any      `json:"relationshipData"`
+	Direction        clientmodels.ProxyDirection `json:"direction"`
+	LinkTarget       linkTarget                  `json:"linkTarget"`
+	RelationshipType string                      `json:"relationshipType"`
+	RelationshipData []clientmodels.RecordValue  `json:"relationshipData"`
+}
+
+// NewCreateProxyInstanceTarget fills in the defaults for any empty fields of relationship
+func NewCreateProxyInstanceTarget(recordID clientmodels.PennsieveInstanceID, relationship clientmodels.ProxyRelationship) CreateProxyInstanceTarget {
+	relationshipData := relationship.RelationshipData
+	if relationshipData == nil {
+		relationshipData = make([]clientmodels.RecordValue, 0)
+	}
+	return CreateProxyInstanceTarget{
+		Direction:        relationship.DirectionOrDefault(),
+		LinkTarget:       linkTarget{ConceptInstance: conceptInstance{ID: recordID}},
+		RelationshipType: relationship.RelationshipTypeOrDefault(),
+		RelationshipData: relationshipData,
+	}
 }
 
 type linkTarget struct {
@@ -56,6 +60,52 @@ type conceptInstance struct {
 	ID clientmodels.PennsieveInstanceID `json:"id"`
 }
 
+// CreateProxyInstanceResponse has one entry per target in the create proxy instance request
+type CreateProxyInstanceResponse []ProxyInstanceResponse
+
+// BatchCreateProxyInstancesResponse has one entry per package in the bulk create proxy instances request, in the
+// same order. An entry with an Error, an empty entry, or a missing entry means that the proxy of the corresponding
+// package was not created.
+type BatchCreateProxyInstancesResponse []BatchCreateProxyInstancesEntry
+
+// BatchCreateProxyInstancesEntry is one package's entry in a BatchCreateProxyInstancesResponse. It is encoded as the
+// package's CreateProxyInstanceResponse if its proxies were created, or as an object with an "error" message if not.
+type BatchCreateProxyInstancesEntry struct {
+	Proxies CreateProxyInstanceResponse
+	Error   string
+}
+
+type batchErrorResponse struct {
+	Error string `json:"error"`
+}
+
+func (e BatchCreateProxyInstancesEntry) MarshalJSON() ([]byte, error) {
+	if len(e.Error) > 0 {
+		return json.Marshal(batchErrorResponse{Error: e.Error})
+	}
+	if e.Proxies == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(e.Proxies)
+}
+
+func (e *BatchCreateProxyInstancesEntry) UnmarshalJSON(data []byte) error {
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return json.Unmarshal(data, &e.Proxies)
+	}
+	var errorResponse batchErrorResponse
+	if err := json.Unmarshal(data, &errorResponse); err != nil {
+		return err
+	}
+	e.Error = errorResponse.Error
+	return nil
+}
+
+// ProxyInstanceResponse only captures the created proxy instance, not the relationship instance
+type ProxyInstanceResponse struct {
+	ProxyInstance APIResponse `json:"proxyInstance"`
+}
+
 type DeleteProxyInstancesBody struct {
 	SourceRecordID   clientmodels.PennsieveInstanceID   `json:"sourceRecordId"`
 	ProxyInstanceIDs []clientmodels.PennsieveInstanceID `json:"proxyInstanceIds"`
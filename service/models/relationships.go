@@ -0,0 +1,37 @@
+package models
+
+import clientmodels "github.com/pennsieve/processor-post-metadata/client/models"
+
+type CreateRelationshipSchemaBody struct {
+	Name        string   `json:"name"`
+	DisplayName string   `json:"displayName"`
+	Description string   `json:"description"`
+	Schema      []string `json:"schema"`
+	// From, if not nil, restricts the relationship to records of this model in the "from" role
+	From *clientmodels.PennsieveSchemaID `json:"from,omitempty"`
+	// To, if not nil, restricts the relationship to records of this model in the "to" role
+	To *clientmodels.PennsieveSchemaID `json:"to,omitempty"`
+}
+
+func NewCreateRelationshipSchemaBody(name string, displayName string, description string) CreateRelationshipSchemaBody {
+	return CreateRelationshipSchemaBody{
+		Name:        name,
+		DisplayName: displayName,
+		Description: description,
+		Schema:      make([]string, 0),
+	}
+}
+
+type CreateRelationshipInstanceBody struct {
+	From   clientmodels.PennsieveInstanceID `json:"from"`
+	To     clientmodels.PennsieveInstanceID `json:"to"`
+	Values []any                            `json:"values"`
+}
+
+func NewCreateRelationshipInstanceBody(fromRecordID clientmodels.PennsieveInstanceID, toRecordID clientmodels.PennsieveInstanceID) CreateRelationshipInstanceBody {
+	return CreateRelationshipInstanceBody{
+		From:   fromRecordID,
+		To:     toRecordID,
+		Values: make([]any, 0),
+	}
+}
@@ -10,8 +10,33 @@ type APIResponse struct {
 	ID   string `json:"id"`
 }
 
+// ModelResponse adds the model metadata to APIResponse
+type ModelResponse struct {
+	APIResponse
+	DisplayName string `json:"displayName"`
+	Description string `json:"description"`
+	Locked      bool   `json:"locked"`
+}
+
 type BulkDeleteRecordsResponse struct {
 	Success []clientmodels.PennsieveInstanceID `json:"success"`
 	// Errors is a slice of slices. Each slice in the outer slice should be of the form [instance-id, error-message]
 	Errors [][]string `json:"errors"`
 }
+
+// BatchCreateRecordsResponse has one entry per record in the batch create request, in the same order.
+// An entry with an empty ID, or a missing entry, means that the corresponding record was not created.
+type BatchCreateRecordsResponse []BatchCreateRecordResponse
+
+// BatchCreateRecordResponse is one record's entry in a BatchCreateRecordsResponse
+type BatchCreateRecordResponse struct {
+	APIResponse
+	// Error is the reason the record was not created, if Pennsieve gave one
+	Error string `json:"error,omitempty"`
+}
+
+// RecordResponse adds the values of a record to APIResponse
+type RecordResponse struct {
+	APIResponse
+	Values []clientmodels.RecordValue `json:"values"`
+}
@@ -0,0 +1,77 @@
+package pennsieve
+
+import (
+	"context"
+	clientmodels "github.com/pennsieve/processor-post-metadata/client/models"
+	"github.com/pennsieve/processor-post-metadata/service/models"
+)
+
+// Backend is the set of Pennsieve operations used by the processor. Session implements it over HTTP. Other
+// implementations may decorate a Session, for example to cache, audit or skip calls, or replace it entirely.
+type Backend interface {
+	IntegrationBackend
+	ModelBackend
+	RecordBackend
+	LinkBackend
+	RelationshipBackend
+	ProxyBackend
+}
+
+// IntegrationBackend looks up the integration that a run belongs to
+type IntegrationBackend interface {
+	GetIntegration(ctx context.Context, integrationID string) (models.Integration, error)
+}
+
+// ModelBackend manages models and their properties
+type ModelBackend interface {
+	CreateModel(ctx context.Context, datasetID string, modelCreate clientmodels.ModelCreateParams) (clientmodels.PennsieveSchemaID, error)
+	GetModel(ctx context.Context, datasetID string, modelID clientmodels.PennsieveSchemaID) (models.ModelResponse, error)
+	UpdateModel(ctx context.Context, datasetID string, modelID clientmodels.PennsieveSchemaID, modelUpdate clientmodels.ModelCreateParams) error
+	DeleteModel(ctx context.Context, datasetID string, modelID clientmodels.PennsieveSchemaID) error
+	CreateModelProperties(ctx context.Context, datasetID string, modelID clientmodels.PennsieveSchemaID, propsCreate clientmodels.PropertiesCreateParams) ([]clientmodels.PennsieveSchemaID, error)
+	UpdateModelProperty(ctx context.Context, datasetID string, modelID clientmodels.PennsieveSchemaID, propertyUpdate clientmodels.PropertyUpdate) error
+	DeleteModelProperty(ctx context.Context, datasetID string, modelID clientmodels.PennsieveSchemaID, propertyID clientmodels.PennsieveSchemaID) error
+}
+
+// RecordBackend manages the records of a model
+type RecordBackend interface {
+	CreateRecord(ctx context.Context, datasetID string, modelID clientmodels.PennsieveSchemaID, values clientmodels.RecordValues) (clientmodels.PennsieveInstanceID, error)
+	CreateRecords(ctx context.Context, datasetID string, modelID clientmodels.PennsieveSchemaID, recordCreates []clientmodels.RecordCreate) ([]clientmodels.PennsieveInstanceID, error)
+	QueryRecords(ctx context.Context, datasetID string, modelID clientmodels.PennsieveSchemaID, limit int, offset int) ([]models.RecordResponse, error)
+	UpdateRecord(ctx context.Context, datasetID string, modelID clientmodels.PennsieveSchemaID, recordID clientmodels.PennsieveInstanceID, values clientmodels.RecordValues) (clientmodels.PennsieveInstanceID, error)
+	DeleteRecords(ctx context.Context, datasetID string, modelID clientmodels.PennsieveSchemaID, recordIDs []clientmodels.PennsieveInstanceID) error
+}
+
+// LinkBackend manages linked property schemas and their instances
+type LinkBackend interface {
+	CreateLinkedPropertySchema(ctx context.Context, datasetID string, fromModelID clientmodels.PennsieveSchemaID, body models.CreateLinkSchemaBody) (clientmodels.PennsieveSchemaID, error)
+	UpdateLinkedPropertySchema(ctx context.Context, datasetID string, fromModelID clientmodels.PennsieveSchemaID, linkSchemaID clientmodels.PennsieveSchemaID, body models.UpdateLinkSchemaBody) error
+	DeleteLinkedPropertySchema(ctx context.Context, datasetID string, fromModelID clientmodels.PennsieveSchemaID, linkSchemaID clientmodels.PennsieveSchemaID) error
+	CreateLinkedPropertyInstance(ctx context.Context, datasetID string, fromModelID clientmodels.PennsieveSchemaID, fromRecordID clientmodels.PennsieveInstanceID, body models.CreateLinkInstanceBody) (clientmodels.PennsieveInstanceID, error)
+	DeleteLinkedPropertyInstance(ctx context.Context, datasetID string, fromModelID clientmodels.PennsieveSchemaID, linkDelete clientmodels.InstanceLinkedPropertyDelete) error
+}
+
+// RelationshipBackend manages relationship schemas and their instances
+type RelationshipBackend interface {
+	CreateRelationshipSchema(ctx context.Context, datasetID string, body models.CreateRelationshipSchemaBody) (clientmodels.PennsieveSchemaID, error)
+	DeleteRelationshipSchema(ctx context.Context, datasetID string, relationshipSchemaID clientmodels.PennsieveSchemaID) error
+	CreateRelationshipInstance(ctx context.Context, datasetID string, relationshipSchemaID clientmodels.PennsieveSchemaID, body models.CreateRelationshipInstanceBody) (clientmodels.PennsieveInstanceID, error)
+	DeleteRelationshipInstance(ctx context.Context, datasetID string, relationshipSchemaID clientmodels.PennsieveSchemaID, relationshipInstanceID clientmodels.PennsieveInstanceID) error
+}
+
+// ProxyBackend manages package proxies, the relationships between records and packages
+type ProxyBackend interface {
+	CreateProxyRelationshipSchema(ctx context.Context, datasetID string) (clientmodels.PennsieveSchemaID, error)
+	CreateProxyInstance(ctx context.Context, datasetID string, body models.CreateProxyInstanceBody) ([]clientmodels.PennsieveInstanceID, error)
+	CreateProxyInstances(ctx context.Context, datasetID string, bodies []models.CreateProxyInstanceBody) ([]clientmodels.PennsieveInstanceID, error)
+	DeleteProxyInstances(ctx context.Context, datasetID string, body models.DeleteProxyInstancesBody) error
+}
+
+// Planner is implemented by a Backend that can record mutating calls instead of making them. The processor's Plan
+// requires it.
+type Planner interface {
+	EnablePlanning() *Plan
+}
+
+var _ Backend = (*Session)(nil)
+var _ Planner = (*Session)(nil)
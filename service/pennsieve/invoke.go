@@ -2,35 +2,59 @@ package pennsieve
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/pennsieve/processor-post-metadata/service/metrics"
+	"github.com/pennsieve/processor-post-metadata/service/tracing"
 	"github.com/pennsieve/processor-post-metadata/service/util"
 	"io"
 	"net/http"
+	"strings"
+	"time"
 )
 
 const ApplicationJSON = "application/json"
 
+// DefaultRequestTimeout is the time allowed for each attempt of a request, including reading the response body,
+// if not configured otherwise
+const DefaultRequestTimeout = 2 * time.Minute
+
 type Session struct {
-	Token    string
-	APIHost  string
-	API2Host string
+	Token       string
+	APIHost     string
+	API2Host    string
+	RetryPolicy util.RetryPolicy
+	// HTTPClient sends the requests. Its Timeout limits each attempt of a request
+	HTTPClient *http.Client
+	// APIRateLimiter limits requests to APIHost. nil means no limit
+	APIRateLimiter *util.RateLimiter
+	// API2RateLimiter limits requests to API2Host. nil means no limit
+	API2RateLimiter *util.RateLimiter
+	// Metrics records the requests sent to Pennsieve. nil means no metrics
+	Metrics *metrics.Registry
+	// Tracing traces the requests sent to Pennsieve. nil means no tracing
+	Tracing *tracing.Provider
+	// plan is non-nil if the Session is in plan mode. See EnablePlanning
+	plan *Plan
 }
 
 func NewSession(sessionToken, apiHost, api2Host string) *Session {
 	return &Session{
-		Token:    sessionToken,
-		APIHost:  apiHost,
-		API2Host: api2Host}
+		Token:       sessionToken,
+		APIHost:     apiHost,
+		API2Host:    api2Host,
+		RetryPolicy: util.DefaultRetryPolicy(),
+		HTTPClient:  &http.Client{Timeout: DefaultRequestTimeout}}
 }
 
-func (s *Session) newPennsieveRequest(method string, url string, structBody any) (*http.Request, error) {
+func (s *Session) newPennsieveRequest(ctx context.Context, method string, url string, structBody any) (*http.Request, error) {
 	body, err := makeJSONBody(structBody)
 	if err != nil {
 		return nil, fmt.Errorf("error for %s %s request: %w",
 			method, url, err)
 	}
-	request, err := http.NewRequest(method, url, body)
+	request, err := http.NewRequestWithContext(ctx, method, url, body)
 	if err != nil {
 		return nil, fmt.Errorf("error creating %s %s request: %w", method, url, err)
 	}
@@ -40,12 +64,44 @@ func (s *Session) newPennsieveRequest(method string, url string, structBody any)
 	return request, nil
 }
 
-func (s *Session) InvokePennsieve(method string, url string, structBody any) (*http.Response, error) {
-	req, err := s.newPennsieveRequest(method, url, structBody)
+// InvokePennsieve sends the request unless ctx is already done, so that a stopped run starts no new operations.
+// A request that has been sent is not cancelled with ctx. It runs until it completes or HTTPClient times out, so that
+// what the processor records agrees with what Pennsieve did.
+func (s *Session) InvokePennsieve(ctx context.Context, method string, url string, structBody any) (*http.Response, error) {
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("not sending %s %s: %w", method, url, context.Cause(ctx))
+	}
+	req, err := s.newPennsieveRequest(context.WithoutCancel(ctx), method, url, structBody)
 	if err != nil {
 		return nil, fmt.Errorf("error creating %s %s request: %w", method, url, err)
 	}
-	return util.Invoke(req)
+	if s.plan != nil && method != http.MethodGet {
+		return s.plan.record(method, url, structBody)
+	}
+	span := s.startRequestSpan(ctx, req)
+	start := time.Now()
+	res, err := util.InvokeWithRetry(ctx, s.httpClient(), req, s.RetryPolicy, s.rateLimiter(url))
+	s.observeRequest(ctx, req, res, err, time.Since(start))
+	endRequestSpan(span, res, err)
+	return res, err
+}
+
+func (s *Session) httpClient() *http.Client {
+	if s.HTTPClient == nil {
+		return http.DefaultClient
+	}
+	return s.HTTPClient
+}
+
+// rateLimiter returns the RateLimiter for the host of url
+func (s *Session) rateLimiter(url string) *util.RateLimiter {
+	if len(s.API2Host) > 0 && strings.HasPrefix(url, s.API2Host) {
+		return s.API2RateLimiter
+	}
+	if len(s.APIHost) > 0 && strings.HasPrefix(url, s.APIHost) {
+		return s.APIRateLimiter
+	}
+	return nil
 }
 
 func makeJSONBody(structBody any) (io.Reader, error) {
@@ -1,15 +1,16 @@
 package pennsieve
 
 import (
+	"context"
 	"fmt"
 	clientmodels "github.com/pennsieve/processor-post-metadata/client/models"
 	"github.com/pennsieve/processor-post-metadata/service/models"
 	"net/http"
 )
 
-func (s *Session) CreateLinkedPropertySchema(datasetID string, fromModelID clientmodels.PennsieveSchemaID, body models.CreateLinkSchemaBody) (clientmodels.PennsieveSchemaID, error) {
+func (s *Session) CreateLinkedPropertySchema(ctx context.Context, datasetID string, fromModelID clientmodels.PennsieveSchemaID, body models.CreateLinkSchemaBody) (clientmodels.PennsieveSchemaID, error) {
 	url := fmt.Sprintf("%s/models/datasets/%s/concepts/%s/linked", s.APIHost, datasetID, fromModelID)
-	response, err := s.InvokePennsieve(http.MethodPost, url, body)
+	response, err := s.InvokePennsieve(ctx, http.MethodPost, url, body)
 	if err != nil {
 		return "", fmt.Errorf("error creating linked property schema %s: %w", body.Name, err)
 	}
@@ -20,19 +21,42 @@ func (s *Session) CreateLinkedPropertySchema(datasetID string, fromModelID clien
 	return clientmodels.PennsieveSchemaID(apiResponse.ID), nil
 }
 
-func (s *Session) CreateLinkedPropertyInstance(datasetID string, fromModelID clientmodels.PennsieveSchemaID, fromRecordID clientmodels.PennsieveInstanceID, body models.CreateLinkInstanceBody) error {
+func (s *Session) UpdateLinkedPropertySchema(ctx context.Context, datasetID string, fromModelID clientmodels.PennsieveSchemaID, linkSchemaID clientmodels.PennsieveSchemaID, body models.UpdateLinkSchemaBody) error {
+	url := fmt.Sprintf("%s/models/datasets/%s/concepts/%s/linked/%s", s.APIHost, datasetID, fromModelID, linkSchemaID)
+	_, err := s.InvokePennsieve(ctx, http.MethodPut, url, body)
+	if err != nil {
+		return fmt.Errorf("error updating linked property schema %s: %w", linkSchemaID, err)
+	}
+	return nil
+}
+
+func (s *Session) DeleteLinkedPropertySchema(ctx context.Context, datasetID string, fromModelID clientmodels.PennsieveSchemaID, linkSchemaID clientmodels.PennsieveSchemaID) error {
+	url := fmt.Sprintf("%s/models/datasets/%s/concepts/%s/linked/%s", s.APIHost, datasetID, fromModelID, linkSchemaID)
+	_, err := s.InvokePennsieve(ctx, http.MethodDelete, url, nil)
+	if err != nil {
+		return fmt.Errorf("error deleting linked property schema %s: %w", linkSchemaID, err)
+	}
+	return nil
+}
+
+func (s *Session) CreateLinkedPropertyInstance(ctx context.Context, datasetID string, fromModelID clientmodels.PennsieveSchemaID, fromRecordID clientmodels.PennsieveInstanceID, body models.CreateLinkInstanceBody) (clientmodels.PennsieveInstanceID, error) {
 	url := fmt.Sprintf("%s/models/datasets/%s/concepts/%s/instances/%s/linked", s.APIHost, datasetID, fromModelID, fromRecordID)
-	_, err := s.InvokePennsieve(http.MethodPost, url, body)
+	response, err := s.InvokePennsieve(ctx, http.MethodPost, url, body)
 	if err != nil {
-		return fmt.Errorf("error creating linked property %s instance from record %s to record %s: %w",
+		return "", fmt.Errorf("error creating linked property %s instance from record %s to record %s: %w",
 			body.SchemaLinkedPropertyId, fromRecordID, body.To, err)
 	}
-	return nil
+	apiResponse, err := handleResponseBody(response)
+	if err != nil {
+		return "", fmt.Errorf("error decoding create linked property %s instance response from record %s to record %s: %w",
+			body.SchemaLinkedPropertyId, fromRecordID, body.To, err)
+	}
+	return clientmodels.PennsieveInstanceID(apiResponse.ID), nil
 }
 
-func (s *Session) DeleteLinkedPropertyInstance(datasetID string, fromModelID clientmodels.PennsieveSchemaID, linkDelete clientmodels.InstanceLinkedPropertyDelete) error {
+func (s *Session) DeleteLinkedPropertyInstance(ctx context.Context, datasetID string, fromModelID clientmodels.PennsieveSchemaID, linkDelete clientmodels.InstanceLinkedPropertyDelete) error {
 	url := fmt.Sprintf("%s/models/datasets/%s/concepts/%s/instances/%s/linked/%s", s.APIHost, datasetID, fromModelID, linkDelete.FromRecordID, linkDelete.InstanceLinkedPropertyID)
-	_, err := s.InvokePennsieve(http.MethodDelete, url, nil)
+	_, err := s.InvokePennsieve(ctx, http.MethodDelete, url, nil)
 	if err != nil {
 		return fmt.Errorf("error deleting linked property instance %s: %w", linkDelete.InstanceLinkedPropertyID, err)
 	}
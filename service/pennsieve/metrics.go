@@ -0,0 +1,83 @@
+package pennsieve
+
+import (
+	"context"
+	"errors"
+	"github.com/pennsieve/processor-post-metadata/service/metrics"
+	"github.com/pennsieve/processor-post-metadata/service/util"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Metric family names recorded by a Session with non-nil Metrics
+const (
+	RequestsMetric        = "pennsieve_requests"
+	RequestLatencyMetric  = "pennsieve_request_duration_seconds"
+	RequestErrorsMetric   = "pennsieve_request_errors"
+	connectionErrorStatus = "error"
+	cancelledStatus       = "cancelled"
+)
+
+// endpointPathSegments are the fixed segments of Pennsieve API paths. Every other segment is an ID.
+var endpointPathSegments = map[string]bool{
+	"integrations":  true,
+	"models":        true,
+	"datasets":      true,
+	"concepts":      true,
+	"properties":    true,
+	"instances":     true,
+	"batch":         true,
+	"linked":        true,
+	"relationships": true,
+	"proxy":         true,
+	"package":       true,
+	"bulk":          true,
+}
+
+// observeRequest records a request sent by InvokePennsieve, whether it succeeded or not. A request that ended because
+// ctx was done, without being sent or before a retry, is counted as cancelled rather than as a connection error.
+func (s *Session) observeRequest(ctx context.Context, request *http.Request, response *http.Response, err error, elapsed time.Duration) {
+	if s.Metrics == nil {
+		return
+	}
+	endpoint := Endpoint(request.URL.Path)
+	status := connectionErrorStatus
+	if response != nil {
+		status = strconv.Itoa(response.StatusCode)
+	}
+	if err != nil {
+		errorClass := "connection"
+		var statusErr *util.HTTPStatusError
+		if ctx.Err() != nil && errors.Is(err, context.Cause(ctx)) {
+			status = cancelledStatus
+			errorClass = cancelledStatus
+		} else if errors.As(err, &statusErr) {
+			status = strconv.Itoa(statusErr.StatusCode)
+			errorClass = util.StatusClass(statusErr.StatusCode)
+		}
+		s.Metrics.Counter(RequestErrorsMetric,
+			"Failed Pennsieve API requests by class: client (4xx), server (5xx), connection or cancelled",
+			"class").Inc(errorClass)
+	}
+	s.Metrics.Counter(RequestsMetric,
+		"Pennsieve API requests by endpoint and final status, after any retries",
+		"method", "endpoint", "status").Inc(request.Method, endpoint, status)
+	s.Metrics.Histogram(RequestLatencyMetric,
+		"Latency of Pennsieve API requests in seconds, including retries",
+		metrics.DefaultLatencyBuckets,
+		"method", "endpoint").Observe(elapsed.Seconds(), request.Method, endpoint)
+}
+
+// Endpoint replaces the IDs in a Pennsieve API path with {id}, so that requests to the same endpoint share a label value.
+// For example, /models/datasets/N:dataset:1234/concepts/5678/instances becomes /models/datasets/{id}/concepts/{id}/instances
+func Endpoint(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if len(segment) > 0 && !endpointPathSegments[segment] {
+			segments[i] = "{id}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
@@ -1,6 +1,7 @@
 package pennsieve
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,20 +11,20 @@ import (
 	"net/http"
 )
 
-func (s *Session) CreateModelAndProps(datasetID string, modelPropsCreate clientmodels.ModelPropsCreate) (clientmodels.PennsieveSchemaID, error) {
-	modelID, err := s.CreateModel(datasetID, modelPropsCreate.Model)
+func (s *Session) CreateModelAndProps(ctx context.Context, datasetID string, modelPropsCreate clientmodels.ModelPropsCreate) (clientmodels.PennsieveSchemaID, error) {
+	modelID, err := s.CreateModel(ctx, datasetID, modelPropsCreate.Model)
 	if err != nil {
 		return "", err
 	}
-	if err := s.CreateModelProperties(datasetID, modelID, modelPropsCreate.Properties); err != nil {
+	if _, err := s.CreateModelProperties(ctx, datasetID, modelID, modelPropsCreate.Properties); err != nil {
 		return "", fmt.Errorf("model %s created; error creating properties: %w", modelPropsCreate.Model.Name, err)
 	}
 	return modelID, nil
 }
 
-func (s *Session) CreateModel(datasetID string, modelCreate clientmodels.ModelCreateParams) (clientmodels.PennsieveSchemaID, error) {
+func (s *Session) CreateModel(ctx context.Context, datasetID string, modelCreate clientmodels.ModelCreateParams) (clientmodels.PennsieveSchemaID, error) {
 	url := fmt.Sprintf("%s/models/datasets/%s/concepts", s.APIHost, datasetID)
-	response, err := s.InvokePennsieve(http.MethodPost, url, modelCreate)
+	response, err := s.InvokePennsieve(ctx, http.MethodPost, url, modelCreate)
 	if err != nil {
 		return "", fmt.Errorf("error creating model %s: %w", modelCreate.Name, err)
 	}
@@ -34,30 +35,90 @@ func (s *Session) CreateModel(datasetID string, modelCreate clientmodels.ModelCr
 	return clientmodels.PennsieveSchemaID(apiResponse.ID), nil
 }
 
-func (s *Session) DeleteModel(datasetID string, modelID clientmodels.PennsieveSchemaID) error {
+func (s *Session) GetModel(ctx context.Context, datasetID string, modelID clientmodels.PennsieveSchemaID) (models.ModelResponse, error) {
 	url := fmt.Sprintf("%s/models/datasets/%s/concepts/%s", s.APIHost, datasetID, modelID)
-	_, err := s.InvokePennsieve(http.MethodDelete, url, nil)
+	response, err := s.InvokePennsieve(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return models.ModelResponse{}, fmt.Errorf("error getting model %s: %w", modelID, err)
+	}
+
+	defer util.CloseAndWarn(response)
+
+	var modelResponse models.ModelResponse
+	if err := json.NewDecoder(response.Body).Decode(&modelResponse); err != nil {
+		return models.ModelResponse{}, fmt.Errorf("error decoding get model response for %s: %w", modelID, err)
+	}
+	return modelResponse, nil
+}
+
+// UpdateModel changes the metadata of the model. Get the current values with GetModel, since those in modelUpdate
+// replace all of them.
+func (s *Session) UpdateModel(ctx context.Context, datasetID string, modelID clientmodels.PennsieveSchemaID, modelUpdate clientmodels.ModelCreateParams) error {
+	url := fmt.Sprintf("%s/models/datasets/%s/concepts/%s", s.APIHost, datasetID, modelID)
+	_, err := s.InvokePennsieve(ctx, http.MethodPut, url, modelUpdate)
+	if err != nil {
+		return fmt.Errorf("error updating model %s: %w", modelID, err)
+	}
+	return nil
+}
+
+func (s *Session) DeleteModel(ctx context.Context, datasetID string, modelID clientmodels.PennsieveSchemaID) error {
+	url := fmt.Sprintf("%s/models/datasets/%s/concepts/%s", s.APIHost, datasetID, modelID)
+	_, err := s.InvokePennsieve(ctx, http.MethodDelete, url, nil)
 	if err != nil {
 		return fmt.Errorf("error deleting model %s: %w", modelID, err)
 	}
 	return nil
 }
 
-func (s *Session) CreateModelProperties(datasetID string, modelID clientmodels.PennsieveSchemaID, propsCreate clientmodels.PropertiesCreateParams) error {
+// CreateModelProperties adds the properties to the model with one request. The returned IDs are in the same order as propsCreate.
+func (s *Session) CreateModelProperties(ctx context.Context, datasetID string, modelID clientmodels.PennsieveSchemaID, propsCreate clientmodels.PropertiesCreateParams) ([]clientmodels.PennsieveSchemaID, error) {
 	if len(propsCreate) == 0 {
-		return nil
+		return nil, nil
 	}
 	url := fmt.Sprintf("%s/models/datasets/%s/concepts/%s/properties", s.APIHost, datasetID, modelID)
-	_, err := s.InvokePennsieve(http.MethodPut, url, propsCreate)
+	response, err := s.InvokePennsieve(ctx, http.MethodPut, url, propsCreate)
 	if err != nil {
-		return fmt.Errorf("error creating properties for modelID %s: %w", modelID, err)
+		return nil, fmt.Errorf("error creating properties for modelID %s: %w", modelID, err)
+	}
+
+	defer util.CloseAndWarn(response)
+
+	var propsResponse []models.APIResponse
+	if err := json.NewDecoder(response.Body).Decode(&propsResponse); err != nil {
+		return nil, fmt.Errorf("error decoding create properties response for modelID %s: %w", modelID, err)
+	}
+	propertyIDs := make([]clientmodels.PennsieveSchemaID, len(propsCreate))
+	for i := range propsCreate {
+		if i < len(propsResponse) {
+			propertyIDs[i] = clientmodels.PennsieveSchemaID(propsResponse[i].ID)
+		}
+	}
+	return propertyIDs, nil
+}
+
+func (s *Session) UpdateModelProperty(ctx context.Context, datasetID string, modelID clientmodels.PennsieveSchemaID, propertyUpdate clientmodels.PropertyUpdate) error {
+	url := fmt.Sprintf("%s/models/datasets/%s/concepts/%s/properties/%s", s.APIHost, datasetID, modelID, propertyUpdate.ID)
+	_, err := s.InvokePennsieve(ctx, http.MethodPut, url, propertyUpdate.PropertyCreateParams)
+	if err != nil {
+		return fmt.Errorf("error updating property %s for modelID %s: %w", propertyUpdate.ID, modelID, err)
 	}
 	return nil
 }
 
-func (s *Session) CreateRecord(datasetID string, modelID clientmodels.PennsieveSchemaID, values clientmodels.RecordValues) (clientmodels.PennsieveInstanceID, error) {
+// DeleteModelProperty deletes the property from the model, and its values from any records of the model
+func (s *Session) DeleteModelProperty(ctx context.Context, datasetID string, modelID clientmodels.PennsieveSchemaID, propertyID clientmodels.PennsieveSchemaID) error {
+	url := fmt.Sprintf("%s/models/datasets/%s/concepts/%s/properties/%s?modifyInstances=true", s.APIHost, datasetID, modelID, propertyID)
+	_, err := s.InvokePennsieve(ctx, http.MethodDelete, url, nil)
+	if err != nil {
+		return fmt.Errorf("error deleting property %s for modelID %s: %w", propertyID, modelID, err)
+	}
+	return nil
+}
+
+func (s *Session) CreateRecord(ctx context.Context, datasetID string, modelID clientmodels.PennsieveSchemaID, values clientmodels.RecordValues) (clientmodels.PennsieveInstanceID, error) {
 	url := fmt.Sprintf("%s/models/datasets/%s/concepts/%s/instances", s.APIHost, datasetID, modelID)
-	response, err := s.InvokePennsieve(http.MethodPost, url, values)
+	response, err := s.InvokePennsieve(ctx, http.MethodPost, url, values)
 	if err != nil {
 		return "", fmt.Errorf("error creating record for model %s: %w", modelID, err)
 	}
@@ -68,13 +129,81 @@ func (s *Session) CreateRecord(datasetID string, modelID clientmodels.PennsieveS
 	return clientmodels.PennsieveInstanceID(apiResponse.ID), nil
 }
 
-func (s *Session) UpdateRecord(datasetID string, modelID clientmodels.PennsieveSchemaID, recordID clientmodels.PennsieveInstanceID, values clientmodels.RecordValues) (clientmodels.PennsieveInstanceID, error) {
+// CreateRecords creates all the given records with one request. The returned IDs are in the same order as recordCreates.
+// If some records could not be created, their IDs are empty, and the returned error describes each failure.
+func (s *Session) CreateRecords(ctx context.Context, datasetID string, modelID clientmodels.PennsieveSchemaID, recordCreates []clientmodels.RecordCreate) ([]clientmodels.PennsieveInstanceID, error) {
+	url := fmt.Sprintf("%s/models/datasets/%s/concepts/%s/instances/batch", s.APIHost, datasetID, modelID)
+	values := make([]clientmodels.RecordValues, len(recordCreates))
+	for i, recordCreate := range recordCreates {
+		values[i] = recordCreate.RecordValues
+	}
+	response, err := s.InvokePennsieve(ctx, http.MethodPost, url, values)
+	if err != nil {
+		return nil, fmt.Errorf("error creating %d records for model %s: %w", len(recordCreates), modelID, err)
+	}
+
+	defer util.CloseAndWarn(response)
+
+	var batchResponse models.BatchCreateRecordsResponse
+	if err := json.NewDecoder(response.Body).Decode(&batchResponse); err != nil {
+		return nil, fmt.Errorf("error decoding response from creating %d records for model %s: %w", len(recordCreates), modelID, err)
+	}
+
+	recordIDs := make([]clientmodels.PennsieveInstanceID, len(recordCreates))
+	var recordErrs []error
+	for i, recordCreate := range recordCreates {
+		reason := "no entry in response"
+		if i < len(batchResponse) {
+			entry := batchResponse[i]
+			if len(entry.Error) == 0 && len(entry.ID) > 0 {
+				recordIDs[i] = clientmodels.PennsieveInstanceID(entry.ID)
+				continue
+			}
+			reason = entry.Error
+			if len(reason) == 0 {
+				reason = "no id in response"
+			}
+		}
+		recordErrs = append(recordErrs, fmt.Errorf("error creating record with external id %s: %s",
+			recordCreate.ExternalID,
+			reason))
+	}
+	if len(recordErrs) == 0 {
+		return recordIDs, nil
+	}
+
+	errs := []error{fmt.Errorf("errors creating %d of %d records for model %s",
+		len(recordErrs),
+		len(recordCreates),
+		modelID)}
+	return recordIDs, errors.Join(append(errs, recordErrs...)...)
+}
+
+// QueryRecords returns up to limit records of the model, starting at offset. Fewer than limit records means there
+// are no more.
+func (s *Session) QueryRecords(ctx context.Context, datasetID string, modelID clientmodels.PennsieveSchemaID, limit int, offset int) ([]models.RecordResponse, error) {
+	url := fmt.Sprintf("%s/models/datasets/%s/concepts/%s/instances?limit=%d&offset=%d", s.APIHost, datasetID, modelID, limit, offset)
+	response, err := s.InvokePennsieve(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error querying records for model %s at offset %d: %w", modelID, offset, err)
+	}
+
+	defer util.CloseAndWarn(response)
+
+	var records []models.RecordResponse
+	if err := json.NewDecoder(response.Body).Decode(&records); err != nil {
+		return nil, fmt.Errorf("error decoding query records response for model %s at offset %d: %w", modelID, offset, err)
+	}
+	return records, nil
+}
+
+func (s *Session) UpdateRecord(ctx context.Context, datasetID string, modelID clientmodels.PennsieveSchemaID, recordID clientmodels.PennsieveInstanceID, values clientmodels.RecordValues) (clientmodels.PennsieveInstanceID, error) {
 	url := fmt.Sprintf("%s/models/datasets/%s/concepts/%s/instances/%s",
 		s.APIHost,
 		datasetID,
 		modelID,
 		recordID)
-	response, err := s.InvokePennsieve(http.MethodPut, url, values)
+	response, err := s.InvokePennsieve(ctx, http.MethodPut, url, values)
 	if err != nil {
 		return "", fmt.Errorf("error updating record %s for model %s: %w", recordID, modelID, err)
 	}
@@ -85,9 +214,9 @@ func (s *Session) UpdateRecord(datasetID string, modelID clientmodels.PennsieveS
 	return clientmodels.PennsieveInstanceID(apiResponse.Name), nil
 }
 
-func (s *Session) DeleteRecords(datasetID string, modelID clientmodels.PennsieveSchemaID, recordIDs []clientmodels.PennsieveInstanceID) error {
+func (s *Session) DeleteRecords(ctx context.Context, datasetID string, modelID clientmodels.PennsieveSchemaID, recordIDs []clientmodels.PennsieveInstanceID) error {
 	url := fmt.Sprintf("%s/models/datasets/%s/concepts/%s/instances", s.APIHost, datasetID, modelID)
-	response, err := s.InvokePennsieve(http.MethodDelete, url, recordIDs)
+	response, err := s.InvokePennsieve(ctx, http.MethodDelete, url, recordIDs)
 	if err != nil {
 		return fmt.Errorf("error deleting %d records for model %s: %w", len(recordIDs), modelID, err)
 	}
@@ -0,0 +1,102 @@
+package pennsieve
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/pennsieve/processor-post-metadata/service/models"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sync"
+)
+
+// PlaceholderIDPrefix starts every ID handed out by a planning Session in place of a real Pennsieve ID.
+const PlaceholderIDPrefix = "placeholder-"
+
+// PlannedCall is a Pennsieve API call that a planning Session recorded instead of sending.
+type PlannedCall struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Body   any    `json:"body,omitempty"`
+}
+
+// Plan holds the mutating calls made through a planning Session, in the order they were made.
+type Plan struct {
+	Calls []PlannedCall `json:"calls"`
+	mu    sync.Mutex
+}
+
+// EnablePlanning puts the Session into plan mode. GET requests are still sent to Pennsieve, but every other
+// request is appended to the returned Plan instead and answered with a placeholder response whose
+// ID is a new placeholder value.
+func (s *Session) EnablePlanning() *Plan {
+	s.plan = &Plan{}
+	return s.plan
+}
+
+func (p *Plan) record(method string, rawURL string, structBody any) (*http.Response, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing url %s for planned %s: %w", rawURL, method, err)
+	}
+	p.mu.Lock()
+	p.Calls = append(p.Calls, PlannedCall{
+		Method: method,
+		Path:   parsed.RequestURI(),
+		Body:   structBody,
+	})
+	callNumber := len(p.Calls)
+	p.mu.Unlock()
+
+	responseBody, err := placeholderResponseBody(method, callNumber, structBody)
+	if err != nil {
+		return nil, fmt.Errorf("error creating placeholder response for planned %s %s: %w", method, rawURL, err)
+	}
+	return &http.Response{
+		Status:     http.StatusText(http.StatusOK),
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{ApplicationJSON}},
+		Body:       io.NopCloser(bytes.NewReader(responseBody)),
+	}, nil
+}
+
+// placeholderResponseBody returns a body that decodes into models.APIResponse for most calls. A POST or PUT of a
+// slice is assumed to be a batch create, such as records or model properties, so the response is a slice of
+// placeholders with one entry per item. A proxy instance create is answered with a
+// models.CreateProxyInstanceResponse, and a bulk one with a models.BatchCreateProxyInstancesResponse.
+func placeholderResponseBody(method string, callNumber int, structBody any) ([]byte, error) {
+	placeholder := func(index int) map[string]string {
+		id := fmt.Sprintf("%s%d-%d", PlaceholderIDPrefix, callNumber, index)
+		return map[string]string{"id": id, "name": id}
+	}
+	proxyPlaceholders := func(index int, proxyBody models.CreateProxyInstanceBody) models.CreateProxyInstanceResponse {
+		proxyResponse := make(models.CreateProxyInstanceResponse, len(proxyBody.Targets))
+		for i := range proxyResponse {
+			id := fmt.Sprintf("%s%d-%d-%d", PlaceholderIDPrefix, callNumber, index, i)
+			proxyResponse[i].ProxyInstance = models.APIResponse{ID: id, Name: proxyBody.ExternalID}
+		}
+		return proxyResponse
+	}
+	switch proxyBody := structBody.(type) {
+	case models.CreateProxyInstanceBody:
+		return json.Marshal(proxyPlaceholders(0, proxyBody))
+	case []models.CreateProxyInstanceBody:
+		batchResponse := make(models.BatchCreateProxyInstancesResponse, len(proxyBody))
+		for i := range proxyBody {
+			batchResponse[i] = models.BatchCreateProxyInstancesEntry{Proxies: proxyPlaceholders(i, proxyBody[i])}
+		}
+		return json.Marshal(batchResponse)
+	}
+	if (method == http.MethodPost || method == http.MethodPut) && structBody != nil {
+		if value := reflect.ValueOf(structBody); value.Kind() == reflect.Slice {
+			placeholders := make([]map[string]string, value.Len())
+			for i := range placeholders {
+				placeholders[i] = placeholder(i)
+			}
+			return json.Marshal(placeholders)
+		}
+	}
+	return json.Marshal(placeholder(0))
+}
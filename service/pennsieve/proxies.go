@@ -1,40 +1,121 @@
 package pennsieve
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	clientmodels "github.com/pennsieve/processor-post-metadata/client/models"
 	"github.com/pennsieve/processor-post-metadata/service/models"
+	"github.com/pennsieve/processor-post-metadata/service/util"
 	"net/http"
+	"strings"
 )
 
-func (s *Session) CreateProxyRelationshipSchema(datasetID string) (clientmodels.PennsieveSchemaID, error) {
-	url := fmt.Sprintf("%s/models/datasets/%s/relationships", s.APIHost, datasetID)
-	response, err := s.InvokePennsieve(http.MethodPost, url, models.NewCreateProxyRelationshipSchemaBody())
+func (s *Session) CreateProxyRelationshipSchema(ctx context.Context, datasetID string) (clientmodels.PennsieveSchemaID, error) {
+	schemaID, err := s.CreateRelationshipSchema(ctx, datasetID, models.NewCreateProxyRelationshipSchemaBody())
 	if err != nil {
 		return "", fmt.Errorf("error creating proxy relationship schema: %w", err)
 	}
-	apiResponse, err := handleResponseBody(response)
-	if err != nil {
-		return "", fmt.Errorf("error decoding response from create proxy relationship schema: %w", err)
-
-	}
-	return clientmodels.PennsieveSchemaID(apiResponse.ID), nil
+	return schemaID, nil
 }
 
-func (s *Session) CreateProxyInstance(datasetID string, body models.CreateProxyInstanceBody) error {
+// CreateProxyInstance returns the IDs of the new proxy instances, in the same order as the targets in body. Returns an
+// error if the response does not have an ID for every target, since a proxy may then exist that cannot be deleted.
+func (s *Session) CreateProxyInstance(ctx context.Context, datasetID string, body models.CreateProxyInstanceBody) ([]clientmodels.PennsieveInstanceID, error) {
 	url := fmt.Sprintf("%s/models/datasets/%s/proxy/package/instances", s.APIHost, datasetID)
-	_, err := s.InvokePennsieve(http.MethodPost, url, body)
+	response, err := s.InvokePennsieve(ctx, http.MethodPost, url, body)
 	if err != nil {
-		return fmt.Errorf("error creating proxy instance for package %s: %w",
+		return nil, fmt.Errorf("error creating proxy instance for package %s: %w",
 			body.ExternalID,
 			err)
 	}
-	return nil
+	defer util.CloseAndWarn(response)
+	var proxyResponse models.CreateProxyInstanceResponse
+	if err := json.NewDecoder(response.Body).Decode(&proxyResponse); err != nil {
+		return nil, fmt.Errorf("error decoding create proxy instance response for package %s: %w", body.ExternalID, err)
+	}
+	var proxyIDs []clientmodels.PennsieveInstanceID
+	for _, proxyInstanceResponse := range proxyResponse {
+		if len(proxyInstanceResponse.ProxyInstance.ID) > 0 {
+			proxyIDs = append(proxyIDs, clientmodels.PennsieveInstanceID(proxyInstanceResponse.ProxyInstance.ID))
+		}
+	}
+	if len(proxyIDs) != len(body.Targets) {
+		return proxyIDs, fmt.Errorf("error creating proxy instance for package %s: response has %d ids for %d targets",
+			body.ExternalID,
+			len(proxyIDs),
+			len(body.Targets))
+	}
+	return proxyIDs, nil
+}
+
+// CreateProxyInstances creates the proxy instances of several packages with one request. Returns the ID of the
+// proxy instance for the first target of each body, in the same order as bodies. An ID is empty if that package's
+// proxy was not created, and the returned error is then a *ProxyInstancesError with the reason for each such package.
+func (s *Session) CreateProxyInstances(ctx context.Context, datasetID string, bodies []models.CreateProxyInstanceBody) ([]clientmodels.PennsieveInstanceID, error) {
+	url := fmt.Sprintf("%s/models/datasets/%s/proxy/package/instances/bulk", s.APIHost, datasetID)
+	response, err := s.InvokePennsieve(ctx, http.MethodPost, url, bodies)
+	if err != nil {
+		return nil, fmt.Errorf("error creating proxy instances for %d packages: %w", len(bodies), err)
+	}
+	defer util.CloseAndWarn(response)
+
+	var batchResponse models.BatchCreateProxyInstancesResponse
+	if err := json.NewDecoder(response.Body).Decode(&batchResponse); err != nil {
+		return nil, fmt.Errorf("error decoding response from creating proxy instances for %d packages: %w", len(bodies), err)
+	}
+
+	proxyIDs := make([]clientmodels.PennsieveInstanceID, len(bodies))
+	proxiesErr := &ProxyInstancesError{NodeIDs: make([]string, len(bodies)), Reasons: make([]string, len(bodies))}
+	var failed bool
+	for i, body := range bodies {
+		proxiesErr.NodeIDs[i] = body.ExternalID
+		reason := "no entry in response"
+		if i < len(batchResponse) {
+			entry := batchResponse[i]
+			switch {
+			case len(entry.Error) > 0:
+				reason = entry.Error
+			case len(entry.Proxies) > 0 && len(entry.Proxies[0].ProxyInstance.ID) > 0:
+				proxyIDs[i] = clientmodels.PennsieveInstanceID(entry.Proxies[0].ProxyInstance.ID)
+				continue
+			default:
+				reason = "no id in response"
+			}
+		}
+		proxiesErr.Reasons[i] = reason
+		failed = true
+	}
+	if !failed {
+		return proxyIDs, nil
+	}
+	return proxyIDs, proxiesErr
+}
+
+// ProxyInstancesError is returned by CreateProxyInstances if the proxies of some packages were not created
+type ProxyInstancesError struct {
+	// NodeIDs are the packages of the request, in order
+	NodeIDs []string
+	// Reasons holds why the proxies of each package were not created, in the same order as NodeIDs. A reason is
+	// empty if the package's proxies were created.
+	Reasons []string
+}
+
+func (e *ProxyInstancesError) Error() string {
+	var builder strings.Builder
+	var failedCount int
+	for i, reason := range e.Reasons {
+		if len(reason) > 0 {
+			failedCount++
+			_, _ = fmt.Fprintf(&builder, "\nerror creating proxy instance for package %s: %s", e.NodeIDs[i], reason)
+		}
+	}
+	return fmt.Sprintf("errors creating proxy instances for %d of %d packages%s", failedCount, len(e.NodeIDs), builder.String())
 }
 
-func (s *Session) DeleteProxyInstances(datasetID string, body models.DeleteProxyInstancesBody) error {
+func (s *Session) DeleteProxyInstances(ctx context.Context, datasetID string, body models.DeleteProxyInstancesBody) error {
 	url := fmt.Sprintf("%s/models/datasets/%s/proxy/package/instances/bulk", s.APIHost, datasetID)
-	_, err := s.InvokePennsieve(http.MethodDelete, url, body)
+	_, err := s.InvokePennsieve(ctx, http.MethodDelete, url, body)
 	if err != nil {
 		return fmt.Errorf("error deleting proxy instances for record %s: %w", body.SourceRecordID, err)
 	}
@@ -0,0 +1,55 @@
+package pennsieve
+
+import (
+	"context"
+	"fmt"
+	clientmodels "github.com/pennsieve/processor-post-metadata/client/models"
+	"github.com/pennsieve/processor-post-metadata/service/models"
+	"net/http"
+)
+
+func (s *Session) CreateRelationshipSchema(ctx context.Context, datasetID string, body models.CreateRelationshipSchemaBody) (clientmodels.PennsieveSchemaID, error) {
+	url := fmt.Sprintf("%s/models/datasets/%s/relationships", s.APIHost, datasetID)
+	response, err := s.InvokePennsieve(ctx, http.MethodPost, url, body)
+	if err != nil {
+		return "", fmt.Errorf("error creating relationship schema %s: %w", body.Name, err)
+	}
+	apiResponse, err := handleResponseBody(response)
+	if err != nil {
+		return "", fmt.Errorf("error decoding create relationship schema response for %s: %w", body.Name, err)
+	}
+	return clientmodels.PennsieveSchemaID(apiResponse.ID), nil
+}
+
+func (s *Session) DeleteRelationshipSchema(ctx context.Context, datasetID string, relationshipSchemaID clientmodels.PennsieveSchemaID) error {
+	url := fmt.Sprintf("%s/models/datasets/%s/relationships/%s", s.APIHost, datasetID, relationshipSchemaID)
+	_, err := s.InvokePennsieve(ctx, http.MethodDelete, url, nil)
+	if err != nil {
+		return fmt.Errorf("error deleting relationship schema %s: %w", relationshipSchemaID, err)
+	}
+	return nil
+}
+
+func (s *Session) CreateRelationshipInstance(ctx context.Context, datasetID string, relationshipSchemaID clientmodels.PennsieveSchemaID, body models.CreateRelationshipInstanceBody) (clientmodels.PennsieveInstanceID, error) {
+	url := fmt.Sprintf("%s/models/datasets/%s/relationships/%s/instances", s.APIHost, datasetID, relationshipSchemaID)
+	response, err := s.InvokePennsieve(ctx, http.MethodPost, url, body)
+	if err != nil {
+		return "", fmt.Errorf("error creating relationship %s instance from record %s to record %s: %w",
+			relationshipSchemaID, body.From, body.To, err)
+	}
+	apiResponse, err := handleResponseBody(response)
+	if err != nil {
+		return "", fmt.Errorf("error decoding create relationship %s instance response from record %s to record %s: %w",
+			relationshipSchemaID, body.From, body.To, err)
+	}
+	return clientmodels.PennsieveInstanceID(apiResponse.ID), nil
+}
+
+func (s *Session) DeleteRelationshipInstance(ctx context.Context, datasetID string, relationshipSchemaID clientmodels.PennsieveSchemaID, relationshipInstanceID clientmodels.PennsieveInstanceID) error {
+	url := fmt.Sprintf("%s/models/datasets/%s/relationships/%s/instances/%s", s.APIHost, datasetID, relationshipSchemaID, relationshipInstanceID)
+	_, err := s.InvokePennsieve(ctx, http.MethodDelete, url, nil)
+	if err != nil {
+		return fmt.Errorf("error deleting relationship %s instance %s: %w", relationshipSchemaID, relationshipInstanceID, err)
+	}
+	return nil
+}
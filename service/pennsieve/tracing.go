@@ -0,0 +1,86 @@
+package pennsieve
+
+import (
+	"context"
+	"errors"
+	"github.com/pennsieve/processor-post-metadata/service/tracing"
+	"github.com/pennsieve/processor-post-metadata/service/util"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"strings"
+)
+
+// Span attribute keys set on the span of each request, and by the processor on its own spans
+const (
+	DatasetIDKey  = attribute.Key("pennsieve.dataset_id")
+	ModelIDKey    = attribute.Key("pennsieve.model_id")
+	ExternalIDKey = attribute.Key("pennsieve.external_id")
+	EndpointKey   = attribute.Key("pennsieve.endpoint")
+)
+
+var noopTracing = tracing.NoopProvider()
+
+type spanAttributesKey struct{}
+
+// WithSpanAttributes returns a copy of ctx whose request spans get attributes, in addition to any from the parent.
+// Use it to attribute requests to a record, for example by its external ID.
+func WithSpanAttributes(ctx context.Context, attributes ...attribute.KeyValue) context.Context {
+	parentAttributes, _ := ctx.Value(spanAttributesKey{}).([]attribute.KeyValue)
+	return context.WithValue(ctx, spanAttributesKey{}, append(append([]attribute.KeyValue(nil), parentAttributes...), attributes...))
+}
+
+// startRequestSpan starts the span of an outgoing request as a child of the span in ctx, and adds its traceparent header
+func (s *Session) startRequestSpan(ctx context.Context, request *http.Request) trace.Span {
+	tracer := s.Tracing
+	if tracer == nil {
+		tracer = noopTracing
+	}
+	endpoint := Endpoint(request.URL.Path)
+	attributes := append([]attribute.KeyValue{
+		semconv.HTTPRequestMethodKey.String(request.Method),
+		semconv.URLFull(request.URL.String()),
+		EndpointKey.String(endpoint),
+	}, pathAttributes(request.URL.Path)...)
+	contextAttributes, _ := ctx.Value(spanAttributesKey{}).([]attribute.KeyValue)
+	spanCtx, span := tracer.Tracer().Start(ctx, request.Method+" "+endpoint,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(append(attributes, contextAttributes...)...))
+	tracing.Propagator.Inject(spanCtx, propagation.HeaderCarrier(request.Header))
+	return span
+}
+
+// endRequestSpan records the outcome of a request sent by InvokePennsieve and ends its span
+func endRequestSpan(span trace.Span, response *http.Response, err error) {
+	var statusErr *util.HTTPStatusError
+	if response != nil {
+		span.SetAttributes(semconv.HTTPResponseStatusCode(response.StatusCode))
+	} else if errors.As(err, &statusErr) {
+		span.SetAttributes(semconv.HTTPResponseStatusCode(statusErr.StatusCode))
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// pathAttributes returns the dataset and model IDs in a Pennsieve API path, if any
+func pathAttributes(path string) []attribute.KeyValue {
+	var attributes []attribute.KeyValue
+	segments := strings.Split(path, "/")
+	for i := 0; i+1 < len(segments); i++ {
+		switch segments[i] {
+		case "datasets":
+			attributes = append(attributes, DatasetIDKey.String(segments[i+1]))
+		case "concepts":
+			if !endpointPathSegments[segments[i+1]] {
+				attributes = append(attributes, ModelIDKey.String(segments[i+1]))
+			}
+		}
+	}
+	return attributes
+}
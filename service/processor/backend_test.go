@@ -0,0 +1,171 @@
+package processor_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/pennsieve/processor-post-metadata/client/clienttest"
+	clientmodels "github.com/pennsieve/processor-post-metadata/client/models"
+	"github.com/pennsieve/processor-post-metadata/service/internal/test/mock"
+	"github.com/pennsieve/processor-post-metadata/service/internal/test/mock/expectedcalls"
+	"github.com/pennsieve/processor-post-metadata/service/models"
+	"github.com/pennsieve/processor-post-metadata/service/pennsieve"
+	"github.com/pennsieve/processor-post-metadata/service/processor"
+	"github.com/pennsieve/processor-post-metadata/service/processor/internal/processortest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+)
+
+func TestMetadataPostProcessor_Backend(t *testing.T) {
+	for scenario, testFunc := range map[string]func(t *testing.T){
+		"decorator sees the calls made through it": decoratorSeesCalls,
+		"decorator can answer without Pennsieve":   decoratorAnswersWithoutPennsieve,
+		"plan requires a Planner":                  planRequiresPlanner,
+		"processor requires a backend":             processorRequiresBackend,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			testFunc(t)
+		})
+	}
+}
+
+// auditingBackend records the integration and record create calls it passes on to the wrapped Backend. If dryRun is
+// true, record creates are answered with made up IDs instead of being passed on.
+type auditingBackend struct {
+	pennsieve.Backend
+	dryRun bool
+	calls  []string
+	mu     sync.Mutex
+}
+
+// audit records call and returns the number of calls recorded so far
+func (a *auditingBackend) audit(call string) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.calls = append(a.calls, call)
+	return len(a.calls)
+}
+
+func (a *auditingBackend) GetIntegration(ctx context.Context, integrationID string) (models.Integration, error) {
+	a.audit(fmt.Sprintf("GetIntegration %s", integrationID))
+	return a.Backend.GetIntegration(ctx, integrationID)
+}
+
+func (a *auditingBackend) CreateRecord(ctx context.Context, datasetID string, modelID clientmodels.PennsieveSchemaID, values clientmodels.RecordValues) (clientmodels.PennsieveInstanceID, error) {
+	callNumber := a.audit(fmt.Sprintf("CreateRecord %s", modelID))
+	if a.dryRun {
+		return clientmodels.PennsieveInstanceID(fmt.Sprintf("dry-run-%d", callNumber)), nil
+	}
+	return a.Backend.CreateRecord(ctx, datasetID, modelID, values)
+}
+
+func decoratorSeesCalls(t *testing.T) {
+	integrationID := uuid.NewString()
+	datasetID := processortest.NewDatasetID()
+	outputDirectory := t.TempDir()
+	modelID := clienttest.NewPennsieveSchemaID()
+
+	recordCreates, recordValues := newRecordCreates(t, 2)
+	changeset := clientmodels.Dataset{
+		Models: clientmodels.ModelChanges{
+			Updates: []clientmodels.ModelUpdate{{
+				ID:      modelID,
+				Records: clientmodels.RecordChanges{Create: recordCreates},
+			}},
+		},
+	}
+	writeChangeset(t, changeset, processor.ChangesetFilePath(outputDirectory))
+
+	expectedRecordCreates := expectedcalls.RecordCreates(datasetID, modelID, recordValues...)
+	mockServer := mock.NewModelService(t,
+		expectedcalls.GetIntegration(integrationID, datasetID),
+		expectedRecordCreates)
+	defer mockServer.Close()
+
+	auditor := &auditingBackend{}
+	testProcessor := processortest.NewBuilder().
+		WithIntegrationID(integrationID).
+		WithOutputDirectory(outputDirectory).
+		WithRecordConcurrency(1).
+		WithBackend(func(session *pennsieve.Session) pennsieve.Backend {
+			auditor.Backend = session
+			return auditor
+		}).
+		Build(t, mockServer.URL())
+
+	require.NoError(t, testProcessor.Run(context.Background()))
+	mockServer.AssertAllCalledExactlyOnce(t)
+
+	assert.Equal(t, []string{
+		fmt.Sprintf("GetIntegration %s", integrationID),
+		fmt.Sprintf("CreateRecord %s", modelID),
+		fmt.Sprintf("CreateRecord %s", modelID),
+	}, auditor.calls)
+}
+
+func decoratorAnswersWithoutPennsieve(t *testing.T) {
+	integrationID := uuid.NewString()
+	datasetID := processortest.NewDatasetID()
+	outputDirectory := t.TempDir()
+	modelID := clienttest.NewPennsieveSchemaID()
+
+	recordCreates, _ := newRecordCreates(t, 2)
+	changeset := clientmodels.Dataset{
+		Models: clientmodels.ModelChanges{
+			Updates: []clientmodels.ModelUpdate{{
+				ID:      modelID,
+				Records: clientmodels.RecordChanges{Create: recordCreates},
+			}},
+		},
+	}
+	writeChangeset(t, changeset, processor.ChangesetFilePath(outputDirectory))
+
+	// only the integration is looked up in Pennsieve
+	mockServer := mock.NewModelService(t, expectedcalls.GetIntegration(integrationID, datasetID))
+	defer mockServer.Close()
+
+	auditor := &auditingBackend{dryRun: true}
+	testProcessor := processortest.NewBuilder().
+		WithIntegrationID(integrationID).
+		WithOutputDirectory(outputDirectory).
+		WithRecordConcurrency(1).
+		WithBackend(func(session *pennsieve.Session) pennsieve.Backend {
+			auditor.Backend = session
+			return auditor
+		}).
+		Build(t, mockServer.URL())
+
+	require.NoError(t, testProcessor.Run(context.Background()))
+	mockServer.AssertAllCalledExactlyOnce(t)
+
+	report := readReport(t, processor.ReportFilePath(outputDirectory))
+	require.Len(t, report.Entries, 2)
+	assert.Equal(t, "dry-run-2", report.Entries[0].ID)
+	assert.Equal(t, "dry-run-3", report.Entries[1].ID)
+}
+
+func planRequiresPlanner(t *testing.T) {
+	outputDirectory := t.TempDir()
+	writeChangeset(t, clientmodels.Dataset{}, processor.ChangesetFilePath(outputDirectory))
+
+	mockServer := mock.NewModelService(t)
+	defer mockServer.Close()
+
+	testProcessor := processortest.NewBuilder().
+		WithOutputDirectory(outputDirectory).
+		WithBackend(func(session *pennsieve.Session) pennsieve.Backend {
+			return &auditingBackend{Backend: session}
+		}).
+		Build(t, mockServer.URL())
+
+	err := testProcessor.Plan(context.Background())
+	assert.ErrorContains(t, err, "does not implement pennsieve.Planner")
+	assert.NoFileExists(t, processor.PlanFilePath(outputDirectory))
+}
+
+func processorRequiresBackend(t *testing.T) {
+	_, err := processor.NewMetadataPostProcessor(uuid.NewString(), t.TempDir(), t.TempDir(), nil, processor.NewIDStoreBuilder().Build())
+	assert.ErrorContains(t, err, "no Pennsieve backend")
+}
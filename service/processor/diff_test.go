@@ -0,0 +1,279 @@
+package processor_test
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/pennsieve/processor-post-metadata/client/clienttest"
+	"github.com/pennsieve/processor-post-metadata/client/diff"
+	clientmodels "github.com/pennsieve/processor-post-metadata/client/models"
+	"github.com/pennsieve/processor-post-metadata/service/internal/test/fake"
+	"github.com/pennsieve/processor-post-metadata/service/processor"
+	"github.com/pennsieve/processor-post-metadata/service/processor/internal/processortest"
+	"github.com/pennsieve/processor-pre-metadata/client/models/datatypes"
+	"github.com/pennsieve/processor-pre-metadata/client/models/instance"
+	"github.com/pennsieve/processor-pre-metadata/client/models/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+// TestMetadataPostProcessor_Diff checks that running a changeset computed by diff.Changeset takes a dataset to the
+// desired state, so that diffing again finds nothing to change.
+func TestMetadataPostProcessor_Diff(t *testing.T) {
+	for scenario, testFunc := range map[string]func(t *testing.T){
+		"desired state is reached from an empty dataset": diffFromEmptyDataset,
+		"desired state is reached from an existing one":  diffFromExistingDataset,
+		"undesired model is deleted":                     diffDeletesUndesiredModel,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			testFunc(t)
+		})
+	}
+}
+
+// diffFixture is a fake model service with a dataset, and a desired state for it with a subject model and a sample
+// model linked to it
+type diffFixture struct {
+	server        *fake.ModelService
+	integrationID string
+	datasetID     string
+	desired       diff.Desired
+}
+
+func newDiffFixture(t *testing.T) *diffFixture {
+	f := &diffFixture{
+		server:        fake.NewModelService(t),
+		integrationID: uuid.NewString(),
+		datasetID:     processortest.NewDatasetID(),
+	}
+	f.server.AddIntegration(f.integrationID, f.datasetID)
+	nameCreate := clienttest.NewPropertyCreateSimple(t, datatypes.StringType)
+	nameCreate.Name = nameProperty
+	countCreate := clienttest.NewPropertyCreateSimple(t, datatypes.LongType)
+	countCreate.Name = "count"
+	countCreate.ConceptTitle = false
+	countCreate.Required = false
+	f.desired = diff.Desired{
+		Models: []diff.Model{
+			{
+				ModelCreateParams: clientmodels.ModelCreateParams{Name: "subject", DisplayName: "Subject"},
+				Properties:        clientmodels.PropertiesCreateParams{nameCreate},
+				Records: map[clientmodels.ExternalInstanceID]diff.Record{
+					"s1": {Values: []clientmodels.RecordValue{{Name: nameProperty, Value: "subject-1"}}},
+					"s2": {Values: []clientmodels.RecordValue{{Name: nameProperty, Value: "subject-2"}}},
+				},
+			},
+			{
+				ModelCreateParams: clientmodels.ModelCreateParams{Name: "sample", DisplayName: "Sample"},
+				Properties:        clientmodels.PropertiesCreateParams{nameCreate, countCreate},
+				Records: map[clientmodels.ExternalInstanceID]diff.Record{
+					"a1": {
+						Values:         []clientmodels.RecordValue{{Name: nameProperty, Value: "sample-1"}, {Name: "count", Value: 3}},
+						PackageNodeIDs: []string{NewPackageNodeID()},
+					},
+					"a2": {Values: []clientmodels.RecordValue{{Name: nameProperty, Value: "sample-2"}}},
+				},
+			},
+		},
+		Links: []diff.Link{{
+			SchemaLinkedPropertyCreate: clientmodels.SchemaLinkedPropertyCreate{Name: "subject", DisplayName: "Subject", Position: 1},
+			FromModelName:              "sample",
+			ToModelName:                "subject",
+			Instances: []clientmodels.InstanceLinkedPropertyCreate{
+				{FromExternalID: "a1", ToExternalID: "s1"},
+				{FromExternalID: "a2", ToExternalID: "s1"},
+			},
+		}},
+	}
+	return f
+}
+
+// current returns the dataset held by the fake in the form processor-pre-metadata would download it
+func (f *diffFixture) current() diff.Current {
+	dataset := f.server.Dataset(f.datasetID)
+	var current diff.Current
+	for _, model := range dataset.Models {
+		currentModel := diff.CurrentModel{
+			Model: schema.Model{Element: schema.Element{
+				ID:          model.ID.String(),
+				Type:        string(schema.ModelType),
+				Name:        model.Name,
+				DisplayName: model.DisplayName,
+			}},
+			Proxies: map[string][]instance.Proxy{},
+		}
+		for _, property := range model.Properties {
+			currentModel.Properties = append(currentModel.Properties, schema.Property{
+				ID:          property.ID.String(),
+				Name:        property.Name,
+				DisplayName: property.DisplayName,
+				DataType:    property.DataType,
+				Required:    property.Required,
+			})
+		}
+		for _, record := range model.Records {
+			currentRecord := instance.Record{ID: string(record.ID)}
+			for _, value := range record.Values {
+				currentRecord.Values = append(currentRecord.Values, instance.Property{Name: value.Name, Value: value.Value})
+			}
+			currentModel.Records = append(currentModel.Records, currentRecord)
+			for _, proxy := range dataset.Proxies {
+				if proxy.RecordID == record.ID {
+					currentModel.Proxies[string(record.ID)] = append(currentModel.Proxies[string(record.ID)], instance.Proxy{
+						ProxyID:      instance.ProxyID{ID: string(proxy.ID)},
+						ProxyPackage: instance.ProxyPackage{Content: instance.ProxyPackageContent{NodeID: proxy.PackageNodeID}},
+					})
+				}
+			}
+		}
+		current.Models = append(current.Models, currentModel)
+		for _, linkSchema := range model.LinkSchemas {
+			currentLink := diff.CurrentLinkedProperty{LinkedProperty: schema.LinkedProperty{
+				Element: schema.Element{
+					ID:          linkSchema.ID.String(),
+					Type:        string(schema.LinkedPropertyType),
+					Name:        linkSchema.Name,
+					DisplayName: linkSchema.DisplayName,
+				},
+				From:     model.ID.String(),
+				To:       linkSchema.To.String(),
+				Position: linkSchema.Position,
+			}}
+			for _, linkInstance := range dataset.LinkInstances {
+				if linkInstance.SchemaID == linkSchema.ID {
+					currentLink.Instances = append(currentLink.Instances, instance.LinkedProperty{
+						ID:                   string(linkInstance.ID),
+						Name:                 linkSchema.Name,
+						From:                 string(linkInstance.From),
+						To:                   string(linkInstance.To),
+						SchemaRelationshipID: linkSchema.ID.String(),
+					})
+				}
+			}
+			current.LinkedProperties = append(current.LinkedProperties, currentLink)
+		}
+	}
+	if proxySchema, found := dataset.RelationshipSchema(clientmodels.ProxyRelationshipSchemaName); found {
+		current.ProxySchema = &schema.NullableRelationship{
+			ID:          proxySchema.ID.String(),
+			Name:        proxySchema.Name,
+			DisplayName: proxySchema.DisplayName,
+		}
+	}
+	return current
+}
+
+// reachDesired runs the changeset that takes the dataset to the desired state, and checks that there is nothing
+// left to change afterwards
+func (f *diffFixture) reachDesired(t *testing.T) {
+	changeset, err := diff.Changeset(f.current(), f.desired)
+	require.NoError(t, err)
+
+	outputDirectory := t.TempDir()
+	writeChangeset(t, changeset, processor.ChangesetFilePath(outputDirectory))
+	testProcessor := processortest.NewBuilder().
+		WithIntegrationID(f.integrationID).
+		WithOutputDirectory(outputDirectory).
+		Build(t, f.server.URL())
+	require.NoError(t, testProcessor.Run(context.Background()))
+
+	remaining, err := diff.Changeset(f.current(), f.desired)
+	require.NoError(t, err)
+	assert.Equal(t, clientmodels.Dataset{}, remaining)
+}
+
+func diffFromEmptyDataset(t *testing.T) {
+	f := newDiffFixture(t)
+	defer f.server.Close()
+
+	f.reachDesired(t)
+
+	dataset := f.server.Dataset(f.datasetID)
+	sample, found := dataset.Model("sample")
+	require.True(t, found)
+	sample1, found := sample.RecordWithValue(nameProperty, "sample-1")
+	require.True(t, found)
+	count, _ := sample1.Value("count")
+	assert.Equal(t, float64(3), count)
+	assert.Len(t, dataset.LinkInstances, 2)
+	require.Len(t, dataset.Proxies, 1)
+	assert.Equal(t, sample1.ID, dataset.Proxies[0].RecordID)
+}
+
+func diffFromExistingDataset(t *testing.T) {
+	f := newDiffFixture(t)
+	defer f.server.Close()
+	f.reachDesired(t)
+
+	subject := &f.desired.Models[0]
+	sample := &f.desired.Models[1]
+	// sample-1 has a new count, and another package instead of the first one
+	newPackageNodeID := NewPackageNodeID()
+	sample.Records["a1"] = diff.Record{
+		Values:         []clientmodels.RecordValue{{Name: nameProperty, Value: "sample-1"}, {Name: "count", Value: 4}},
+		PackageNodeIDs: []string{newPackageNodeID},
+	}
+	// sample-2 and subject-2 are no longer desired, and sample-3 is new
+	delete(sample.Records, "a2")
+	delete(subject.Records, "s2")
+	sample.Records["a3"] = diff.Record{Values: []clientmodels.RecordValue{{Name: nameProperty, Value: "sample-3"}}}
+	// sample-1 now comes from a new subject
+	subject.Records["s3"] = diff.Record{Values: []clientmodels.RecordValue{{Name: nameProperty, Value: "subject-3"}}}
+	f.desired.Links[0].Instances = []clientmodels.InstanceLinkedPropertyCreate{
+		{FromExternalID: "a1", ToExternalID: "s3"},
+		{FromExternalID: "a3", ToExternalID: "s1"},
+	}
+	sample.DisplayName = "Specimen"
+
+	f.reachDesired(t)
+
+	dataset := f.server.Dataset(f.datasetID)
+	sampleModel, found := dataset.Model("sample")
+	require.True(t, found)
+	assert.Equal(t, "Specimen", sampleModel.DisplayName)
+	assert.Len(t, sampleModel.Records, 2)
+	_, found = sampleModel.RecordWithValue(nameProperty, "sample-2")
+	assert.False(t, found)
+	sample1, found := sampleModel.RecordWithValue(nameProperty, "sample-1")
+	require.True(t, found)
+	count, _ := sample1.Value("count")
+	assert.Equal(t, float64(4), count)
+
+	subjectModel, found := dataset.Model("subject")
+	require.True(t, found)
+	subject3, found := subjectModel.RecordWithValue(nameProperty, "subject-3")
+	require.True(t, found)
+	assert.Len(t, dataset.LinkInstances, 2)
+	assert.Equal(t, subject3.ID, findLinkInstance(t, dataset, sample1.ID).To)
+	require.Len(t, dataset.Proxies, 1)
+	assert.Equal(t, newPackageNodeID, dataset.Proxies[0].PackageNodeID)
+	assert.Equal(t, sample1.ID, dataset.Proxies[0].RecordID)
+}
+
+func diffDeletesUndesiredModel(t *testing.T) {
+	f := newDiffFixture(t)
+	defer f.server.Close()
+	f.reachDesired(t)
+
+	f.desired.Models = f.desired.Models[:1]
+	f.desired.Links = nil
+
+	f.reachDesired(t)
+
+	dataset := f.server.Dataset(f.datasetID)
+	require.Len(t, dataset.Models, 1)
+	assert.Equal(t, "subject", dataset.Models[0].Name)
+	assert.Empty(t, dataset.Models[0].LinkSchemas)
+	assert.Empty(t, dataset.LinkInstances)
+	assert.Empty(t, dataset.Proxies)
+}
+
+func findLinkInstance(t *testing.T, dataset fake.Dataset, fromRecordID clientmodels.PennsieveInstanceID) fake.LinkInstance {
+	for _, linkInstance := range dataset.LinkInstances {
+		if linkInstance.From == fromRecordID {
+			return linkInstance
+		}
+	}
+	require.Fail(t, "no link instance", "from record %s", fromRecordID)
+	return fake.LinkInstance{}
+}
@@ -0,0 +1,447 @@
+package processor_test
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/pennsieve/processor-post-metadata/client/changeset"
+	"github.com/pennsieve/processor-post-metadata/client/clienttest"
+	clientmodels "github.com/pennsieve/processor-post-metadata/client/models"
+	"github.com/pennsieve/processor-post-metadata/service/internal/test/fake"
+	"github.com/pennsieve/processor-post-metadata/service/models"
+	"github.com/pennsieve/processor-post-metadata/service/processor"
+	"github.com/pennsieve/processor-post-metadata/service/processor/internal/processortest"
+	"github.com/pennsieve/processor-pre-metadata/client/models/datatypes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"slices"
+	"testing"
+)
+
+func TestMetadataPostProcessor_EndToEnd(t *testing.T) {
+	for scenario, testFunc := range map[string]func(t *testing.T){
+		"creates models, records, links, relationships and proxies": endToEndCreates,
+		"rollback leaves the dataset as it was":                     endToEndRollback,
+		"rollback of a resumed run removes earlier creates":         endToEndRollbackResumed,
+		"model with records is not deleted":                         endToEndModelWithRecordsNotDeleted,
+		"bulk proxy failures are reported per package":              endToEndBulkProxyFailuresReported,
+		"records deleted by a model update and a model delete":      endToEndRecordDeletesInUpdateAndDelete,
+		"proxy relationship types are created if missing":           endToEndProxyRelationshipTypesCreated,
+		"built changeset attaches packages with relationship types": endToEndBuiltProxyRelationships,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			testFunc(t)
+		})
+	}
+}
+
+const nameProperty = "name"
+
+func newNamedModelCreate(t *testing.T, modelName string, recordNames ...string) clientmodels.ModelCreate {
+	model := clienttest.NewModelCreate()
+	model.Name = modelName
+	property := clienttest.NewPropertyCreateSimple(t, datatypes.StringType)
+	property.Name = nameProperty
+	var records []clientmodels.RecordCreate
+	for _, recordName := range recordNames {
+		records = append(records, clientmodels.RecordCreate{
+			ExternalID:   clientmodels.ExternalInstanceID(recordName),
+			RecordValues: clienttest.NewRecordValues(clientmodels.RecordValue{Name: nameProperty, Value: recordName}),
+		})
+	}
+	return clientmodels.ModelCreate{
+		Create: clientmodels.ModelPropsCreate{
+			Model:      model,
+			Properties: clientmodels.PropertiesCreateParams{property},
+		},
+		Records: records,
+	}
+}
+
+// newSubjectSampleChangeset creates a subject and a sample model, each with one record, links the sample to its
+// subject, relates them with derived_from, and attaches a package to the sample
+func newSubjectSampleChangeset(t *testing.T, packageNodeID string, createProxySchema bool) clientmodels.Dataset {
+	return clientmodels.Dataset{
+		Models: clientmodels.ModelChanges{
+			Creates: []clientmodels.ModelCreate{
+				newNamedModelCreate(t, "subject", "subject-1"),
+				newNamedModelCreate(t, "sample", "sample-1"),
+			},
+		},
+		LinkedProperties: []clientmodels.LinkedPropertyChanges{{
+			FromModelName: "sample",
+			ToModelName:   "subject",
+			Create:        &clientmodels.SchemaLinkedPropertyCreate{Name: "subject", DisplayName: "Subject"},
+			Instances: clientmodels.InstanceChanges{
+				Create: []clientmodels.InstanceLinkedPropertyCreate{{FromExternalID: "sample-1", ToExternalID: "subject-1"}},
+			},
+		}},
+		Relationships: []clientmodels.RelationshipChanges{{
+			Create: &clientmodels.RelationshipSchemaCreate{
+				Name:          "derived_from",
+				DisplayName:   "Derived From",
+				FromModelName: "sample",
+				ToModelName:   "subject",
+			},
+			Instances: clientmodels.RelationshipInstanceChanges{
+				Create: []clientmodels.RelationshipInstanceCreate{{
+					FromModelName:  "sample",
+					FromExternalID: "sample-1",
+					ToModelName:    "subject",
+					ToExternalID:   "subject-1",
+				}},
+			},
+		}},
+		Proxies: &clientmodels.ProxyChanges{
+			CreateProxyRelationshipSchema: createProxySchema,
+			RecordChanges: []clientmodels.ProxyRecordChanges{{
+				ModelName:        "sample",
+				RecordExternalID: "sample-1",
+				NodeIDCreates:    []string{packageNodeID},
+			}},
+		},
+	}
+}
+
+func endToEndCreates(t *testing.T) {
+	integrationID := uuid.NewString()
+	datasetID := processortest.NewDatasetID()
+	outputDirectory := t.TempDir()
+	packageNodeID := NewPackageNodeID()
+
+	writeChangeset(t, newSubjectSampleChangeset(t, packageNodeID, true), processor.ChangesetFilePath(outputDirectory))
+
+	fakeServer := fake.NewModelService(t)
+	defer fakeServer.Close()
+	fakeServer.AddIntegration(integrationID, datasetID)
+
+	testProcessor := processortest.NewBuilder().
+		WithIntegrationID(integrationID).
+		WithOutputDirectory(outputDirectory).
+		Build(t, fakeServer.URL())
+
+	require.NoError(t, testProcessor.Run(context.Background()))
+
+	dataset := fakeServer.Dataset(datasetID)
+	require.Len(t, dataset.Models, 2)
+	subject, found := dataset.Model("subject")
+	require.True(t, found)
+	sample, found := dataset.Model("sample")
+	require.True(t, found)
+
+	subjectRecord, found := subject.RecordWithValue(nameProperty, "subject-1")
+	require.True(t, found)
+	sampleRecord, found := sample.RecordWithValue(nameProperty, "sample-1")
+	require.True(t, found)
+
+	linkSchema, found := sample.LinkSchema("subject")
+	require.True(t, found)
+	assert.Equal(t, subject.ID, linkSchema.To)
+	assert.Equal(t, []fake.LinkInstance{{
+		ID:       dataset.LinkInstances[0].ID,
+		SchemaID: linkSchema.ID,
+		From:     sampleRecord.ID,
+		To:       subjectRecord.ID,
+	}}, dataset.LinkInstances)
+
+	derivedFrom, found := dataset.RelationshipSchema("derived_from")
+	require.True(t, found)
+	require.Len(t, dataset.RelationshipInstances, 1)
+	assert.Equal(t, derivedFrom.ID, dataset.RelationshipInstances[0].SchemaID)
+	assert.Equal(t, sampleRecord.ID, dataset.RelationshipInstances[0].From)
+	assert.Equal(t, subjectRecord.ID, dataset.RelationshipInstances[0].To)
+
+	_, found = dataset.RelationshipSchema(clientmodels.ProxyRelationshipSchemaName)
+	assert.True(t, found)
+	require.Len(t, dataset.Proxies, 1)
+	assert.Equal(t, packageNodeID, dataset.Proxies[0].PackageNodeID)
+	assert.Equal(t, sampleRecord.ID, dataset.Proxies[0].RecordID)
+}
+
+func endToEndRollback(t *testing.T) {
+	integrationID := uuid.NewString()
+	datasetID := processortest.NewDatasetID()
+	outputDirectory := t.TempDir()
+
+	// without a proxy relationship schema, Pennsieve refuses the proxy, which is created last
+	writeChangeset(t, newSubjectSampleChangeset(t, NewPackageNodeID(), false), processor.ChangesetFilePath(outputDirectory))
+
+	fakeServer := fake.NewModelService(t)
+	defer fakeServer.Close()
+	fakeServer.AddIntegration(integrationID, datasetID)
+
+	testProcessor := processortest.NewBuilder().
+		WithIntegrationID(integrationID).
+		WithOutputDirectory(outputDirectory).
+		WithRollbackOnFailure().
+		Build(t, fakeServer.URL())
+
+	require.ErrorContains(t, testProcessor.Run(context.Background()), "no relationship schema")
+
+	report := readReport(t, processor.ReportFilePath(outputDirectory))
+	assert.True(t, report.RolledBack)
+	assert.Equal(t, fake.Dataset{ID: datasetID}, emptySlicesToNil(fakeServer.Dataset(datasetID)))
+}
+
+func endToEndBulkProxyFailuresReported(t *testing.T) {
+	integrationID := uuid.NewString()
+	datasetID := processortest.NewDatasetID()
+	outputDirectory := t.TempDir()
+	packageNodeID := NewPackageNodeID()
+
+	// without a proxy relationship schema, Pennsieve refuses the proxy
+	writeChangeset(t, newSubjectSampleChangeset(t, packageNodeID, false), processor.ChangesetFilePath(outputDirectory))
+
+	fakeServer := fake.NewModelService(t)
+	defer fakeServer.Close()
+	fakeServer.AddIntegration(integrationID, datasetID)
+
+	testProcessor := processortest.NewBuilder().
+		WithIntegrationID(integrationID).
+		WithOutputDirectory(outputDirectory).
+		WithProxyBatchSize(10).
+		Build(t, fakeServer.URL())
+
+	require.ErrorContains(t, testProcessor.Run(context.Background()), "no relationship schema")
+
+	report := readReport(t, processor.ReportFilePath(outputDirectory))
+	var failed []processor.ReportEntry
+	for _, entry := range report.Entries {
+		if entry.Action == processor.Failed {
+			failed = append(failed, entry)
+		}
+	}
+	require.Len(t, failed, 1)
+	assert.Equal(t, processor.ProxyEntity, failed[0].Type)
+	assert.Equal(t, packageNodeID, failed[0].PackageNodeID)
+	assert.Contains(t, failed[0].Error, "no relationship schema")
+}
+
+func endToEndProxyRelationshipTypesCreated(t *testing.T) {
+	integrationID := uuid.NewString()
+	datasetID := processortest.NewDatasetID()
+	outputDirectory := t.TempDir()
+	packageNodeID := NewPackageNodeID()
+
+	// derived_from is created by the changeset's relationships, is_about exists already, and describes is created
+	// for the proxies
+	changeset := newSubjectSampleChangeset(t, packageNodeID, true)
+	relationshipTypes := []string{"derived_from", "is_about", "describes"}
+	packageNodeIDs := map[string]string{}
+	for _, relationshipType := range relationshipTypes {
+		packageNodeIDs[relationshipType] = NewPackageNodeID()
+		changeset.Proxies.RecordChanges[0].PackageCreates = append(changeset.Proxies.RecordChanges[0].PackageCreates,
+			clientmodels.ProxyPackageCreate{
+				NodeID:            packageNodeIDs[relationshipType],
+				ProxyRelationship: clientmodels.ProxyRelationship{RelationshipType: relationshipType},
+			})
+	}
+	writeChangeset(t, changeset, processor.ChangesetFilePath(outputDirectory))
+
+	fakeServer := fake.NewModelService(t)
+	defer fakeServer.Close()
+	fakeServer.AddIntegration(integrationID, datasetID)
+	fakeServer.AddRelationshipSchema(datasetID, models.NewCreateRelationshipSchemaBody("is_about", "Is About", ""))
+
+	testProcessor := processortest.NewBuilder().
+		WithIntegrationID(integrationID).
+		WithOutputDirectory(outputDirectory).
+		Build(t, fakeServer.URL())
+
+	require.NoError(t, testProcessor.Run(context.Background()))
+
+	dataset := fakeServer.Dataset(datasetID)
+	// belongs_to and one schema for each relationship type
+	require.Len(t, dataset.RelationshipSchemas, 4)
+	describes, found := dataset.RelationshipSchema("describes")
+	require.True(t, found)
+	assert.Equal(t, "Describes", describes.DisplayName)
+
+	require.Len(t, dataset.Proxies, 4)
+	for _, relationshipType := range relationshipTypes {
+		assert.True(t, slices.ContainsFunc(dataset.Proxies, func(proxy fake.Proxy) bool {
+			return proxy.PackageNodeID == packageNodeIDs[relationshipType] && proxy.RelationshipType == relationshipType
+		}), "no %s proxy", relationshipType)
+	}
+}
+
+func endToEndBuiltProxyRelationships(t *testing.T) {
+	integrationID := uuid.NewString()
+	datasetID := processortest.NewDatasetID()
+	outputDirectory := t.TempDir()
+
+	fakeServer := fake.NewModelService(t)
+	defer fakeServer.Close()
+	fakeServer.AddIntegration(integrationID, datasetID)
+	sampleModel := clienttest.NewModelCreate()
+	sampleID := fakeServer.AddModel(datasetID, sampleModel)
+	sampleRecordID := fakeServer.AddRecord(datasetID, sampleID)
+
+	// derived_from is declared, is_about is not
+	builder := changeset.NewBuilder()
+	sample := builder.ExistingModel(sampleModel.Name, sampleID)
+	builder.NewProxyRelationshipSchema(changeset.RelationshipSchema{Name: "derived_from", DisplayName: "Derived From", From: sample})
+	sampleRecord := sample.ExistingRecord(sampleRecordID)
+	derivedFromNodeID, isAboutNodeID := NewPackageNodeID(), NewPackageNodeID()
+	sample.AttachPackage(sampleRecord, derivedFromNodeID, clientmodels.ProxyRelationship{RelationshipType: "derived_from"})
+	sample.AttachPackage(sampleRecord, isAboutNodeID, clientmodels.ProxyRelationship{RelationshipType: "is_about", Direction: clientmodels.ToTarget})
+	built, err := builder.Build()
+	require.NoError(t, err)
+	writeChangeset(t, built, processor.ChangesetFilePath(outputDirectory))
+
+	testProcessor := processortest.NewBuilder().
+		WithIntegrationID(integrationID).
+		WithOutputDirectory(outputDirectory).
+		Build(t, fakeServer.URL())
+
+	require.NoError(t, testProcessor.Run(context.Background()))
+
+	dataset := fakeServer.Dataset(datasetID)
+	require.Len(t, dataset.RelationshipSchemas, 2)
+	derivedFrom, found := dataset.RelationshipSchema("derived_from")
+	require.True(t, found)
+	assert.Equal(t, "Derived From", derivedFrom.DisplayName)
+	assert.Equal(t, &sampleID, derivedFrom.From)
+	isAbout, found := dataset.RelationshipSchema("is_about")
+	require.True(t, found)
+	assert.Equal(t, "Is About", isAbout.DisplayName)
+
+	// the packages are attached in order
+	require.Len(t, dataset.Proxies, 2)
+	assert.Equal(t, fake.Proxy{
+		ID:               dataset.Proxies[0].ID,
+		PackageNodeID:    derivedFromNodeID,
+		RecordID:         sampleRecordID,
+		Direction:        clientmodels.FromTarget,
+		RelationshipType: "derived_from",
+		RelationshipData: []clientmodels.RecordValue{},
+	}, dataset.Proxies[0])
+	assert.Equal(t, fake.Proxy{
+		ID:               dataset.Proxies[1].ID,
+		PackageNodeID:    isAboutNodeID,
+		RecordID:         sampleRecordID,
+		Direction:        clientmodels.ToTarget,
+		RelationshipType: "is_about",
+		RelationshipData: []clientmodels.RecordValue{},
+	}, dataset.Proxies[1])
+}
+
+func endToEndRecordDeletesInUpdateAndDelete(t *testing.T) {
+	integrationID := uuid.NewString()
+	datasetID := processortest.NewDatasetID()
+	outputDirectory := t.TempDir()
+
+	fakeServer := fake.NewModelService(t)
+	defer fakeServer.Close()
+	fakeServer.AddIntegration(integrationID, datasetID)
+	property := clienttest.NewPropertyCreateSimple(t, datatypes.StringType)
+	property.Name = nameProperty
+	modelID := fakeServer.AddModel(datasetID, clienttest.NewModelCreate(), property)
+	recordID1 := fakeServer.AddRecord(datasetID, modelID, clientmodels.RecordValue{Name: nameProperty, Value: "subject-1"})
+	recordID2 := fakeServer.AddRecord(datasetID, modelID, clientmodels.RecordValue{Name: nameProperty, Value: "subject-2"})
+
+	// each delete of the model's records is a separate operation
+	changeset := clientmodels.Dataset{
+		Models: clientmodels.ModelChanges{
+			Updates: []clientmodels.ModelUpdate{{
+				ID:      modelID,
+				Records: clientmodels.RecordChanges{Delete: []clientmodels.PennsieveInstanceID{recordID1}},
+			}},
+			Deletes: []clientmodels.ModelDelete{{ID: modelID, Records: []clientmodels.PennsieveInstanceID{recordID2}}},
+		},
+	}
+	writeChangeset(t, changeset, processor.ChangesetFilePath(outputDirectory))
+
+	testProcessor := processortest.NewBuilder().
+		WithIntegrationID(integrationID).
+		WithOutputDirectory(outputDirectory).
+		Build(t, fakeServer.URL())
+
+	require.NoError(t, testProcessor.Run(context.Background()))
+	assert.Empty(t, fakeServer.Dataset(datasetID).Models)
+}
+
+func endToEndRollbackResumed(t *testing.T) {
+	integrationID := uuid.NewString()
+	datasetID := processortest.NewDatasetID()
+	outputDirectory := t.TempDir()
+
+	// without a proxy relationship schema, Pennsieve refuses the proxy, which is created last
+	writeChangeset(t, newSubjectSampleChangeset(t, NewPackageNodeID(), false), processor.ChangesetFilePath(outputDirectory))
+
+	fakeServer := fake.NewModelService(t)
+	defer fakeServer.Close()
+	fakeServer.AddIntegration(integrationID, datasetID)
+
+	// the first run leaves everything but the proxy behind
+	firstProcessor := processortest.NewBuilder().
+		WithIntegrationID(integrationID).
+		WithOutputDirectory(outputDirectory).
+		Build(t, fakeServer.URL())
+	require.ErrorContains(t, firstProcessor.Run(context.Background()), "no relationship schema")
+	require.Len(t, fakeServer.Dataset(datasetID).Models, 2)
+
+	// the resumed run creates nothing new before failing the same way, but rolls back what the first run created
+	secondProcessor := processortest.NewBuilder().
+		WithIntegrationID(integrationID).
+		WithOutputDirectory(outputDirectory).
+		WithRollbackOnFailure().
+		Build(t, fakeServer.URL())
+	require.ErrorContains(t, secondProcessor.Run(context.Background()), "no relationship schema")
+
+	report := readReport(t, processor.ReportFilePath(outputDirectory))
+	assert.True(t, report.RolledBack)
+	assert.Equal(t, fake.Dataset{ID: datasetID}, emptySlicesToNil(fakeServer.Dataset(datasetID)))
+}
+
+// emptySlicesToNil makes a dataset whose objects have all been deleted equal to a new one
+func emptySlicesToNil(dataset fake.Dataset) fake.Dataset {
+	if len(dataset.Models) == 0 {
+		dataset.Models = nil
+	}
+	if len(dataset.LinkInstances) == 0 {
+		dataset.LinkInstances = nil
+	}
+	if len(dataset.RelationshipSchemas) == 0 {
+		dataset.RelationshipSchemas = nil
+	}
+	if len(dataset.RelationshipInstances) == 0 {
+		dataset.RelationshipInstances = nil
+	}
+	if len(dataset.Proxies) == 0 {
+		dataset.Proxies = nil
+	}
+	return dataset
+}
+
+func endToEndModelWithRecordsNotDeleted(t *testing.T) {
+	integrationID := uuid.NewString()
+	datasetID := processortest.NewDatasetID()
+	outputDirectory := t.TempDir()
+
+	fakeServer := fake.NewModelService(t)
+	defer fakeServer.Close()
+	fakeServer.AddIntegration(integrationID, datasetID)
+	property := clienttest.NewPropertyCreateSimple(t, datatypes.StringType)
+	property.Name = nameProperty
+	modelID := fakeServer.AddModel(datasetID, clienttest.NewModelCreate(), property)
+	recordID := fakeServer.AddRecord(datasetID, modelID, clientmodels.RecordValue{Name: nameProperty, Value: "subject-1"})
+
+	changeset := clientmodels.Dataset{
+		Models: clientmodels.ModelChanges{
+			Deletes: []clientmodels.ModelDelete{{ID: modelID}},
+		},
+	}
+	writeChangeset(t, changeset, processor.ChangesetFilePath(outputDirectory))
+
+	testProcessor := processortest.NewBuilder().
+		WithIntegrationID(integrationID).
+		WithOutputDirectory(outputDirectory).
+		Build(t, fakeServer.URL())
+
+	require.ErrorContains(t, testProcessor.Run(context.Background()), "still has records")
+
+	dataset := fakeServer.Dataset(datasetID)
+	require.Len(t, dataset.Models, 1)
+	require.Len(t, dataset.Models[0].Records, 1)
+	assert.Equal(t, recordID, dataset.Models[0].Records[0].ID)
+}
@@ -2,7 +2,13 @@ package processor
 
 import (
 	"fmt"
+	"github.com/pennsieve/processor-post-metadata/service/pennsieve"
+	"github.com/pennsieve/processor-post-metadata/service/tracing"
+	"github.com/pennsieve/processor-post-metadata/service/util"
+	"math"
 	"os"
+	"strconv"
+	"time"
 )
 
 const IntegrationIDKey = "INTEGRATION_ID"
@@ -11,6 +17,23 @@ const OutputDirectoryKey = "OUTPUT_DIR"
 const SessionTokenKey = "SESSION_TOKEN"
 const PennsieveAPIHostKey = "PENNSIEVE_API_HOST"
 const PennsieveAPI2HostKey = "PENNSIEVE_API_HOST2"
+const PlanModeKey = "PLAN_MODE"
+const RollbackOnFailureKey = "ROLLBACK_ON_FAILURE"
+const RecordConcurrencyKey = "RECORD_CONCURRENCY"
+const RecordBatchSizeKey = "RECORD_BATCH_SIZE"
+const ProxyBatchSizeKey = "PROXY_BATCH_SIZE"
+const MetricsAddressKey = "METRICS_ADDRESS"
+const TracesExporterKey = "TRACES_EXPORTER"
+const TracesFileKey = "TRACES_FILE"
+const RunTimeoutKey = "RUN_TIMEOUT"
+const RequestTimeoutKey = "REQUEST_TIMEOUT"
+const RetryMaxAttemptsKey = "RETRY_MAX_ATTEMPTS"
+const RetryBaseDelayKey = "RETRY_BASE_DELAY"
+const RetryMaxDelayKey = "RETRY_MAX_DELAY"
+const PennsieveAPIRateLimitKey = "PENNSIEVE_API_RATE_LIMIT"
+const PennsieveAPIRateBurstKey = "PENNSIEVE_API_RATE_BURST"
+const PennsieveAPI2RateLimitKey = "PENNSIEVE_API2_RATE_LIMIT"
+const PennsieveAPI2RateBurstKey = "PENNSIEVE_API2_RATE_BURST"
 
 func FromEnv() (*MetadataPostProcessor, error) {
 	integrationID, err := LookupRequiredEnvVar(IntegrationIDKey)
@@ -37,15 +60,113 @@ func FromEnv() (*MetadataPostProcessor, error) {
 	if err != nil {
 		return nil, err
 	}
+	planMode, err := LookupOptionalBoolEnvVar(PlanModeKey)
+	if err != nil {
+		return nil, err
+	}
+	rollbackOnFailure, err := LookupOptionalBoolEnvVar(RollbackOnFailureKey)
+	if err != nil {
+		return nil, err
+	}
+	recordConcurrency, err := LookupOptionalIntEnvVar(RecordConcurrencyKey, DefaultRecordConcurrency)
+	if err != nil {
+		return nil, err
+	}
+	recordBatchSize, err := LookupOptionalIntEnvVar(RecordBatchSizeKey, DefaultRecordBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	proxyBatchSize, err := LookupOptionalIntEnvVar(ProxyBatchSizeKey, DefaultProxyBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	runTimeout, err := LookupOptionalDurationEnvVar(RunTimeoutKey, 0)
+	if err != nil {
+		return nil, err
+	}
+	requestTimeout, err := LookupOptionalDurationEnvVar(RequestTimeoutKey, pennsieve.DefaultRequestTimeout)
+	if err != nil {
+		return nil, err
+	}
+	retryPolicy, err := retryPolicyFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	apiRateLimiter, err := rateLimiterFromEnv(PennsieveAPIRateLimitKey, PennsieveAPIRateBurstKey)
+	if err != nil {
+		return nil, err
+	}
+	api2RateLimiter, err := rateLimiterFromEnv(PennsieveAPI2RateLimitKey, PennsieveAPI2RateBurstKey)
+	if err != nil {
+		return nil, err
+	}
+	tracesFile := os.Getenv(TracesFileKey)
+	if len(tracesFile) == 0 {
+		tracesFile = TracesFilePath(outputDirectory)
+	}
+	tracingProvider, err := tracing.NewProvider(os.Getenv(TracesExporterKey), tracesFile)
+	if err != nil {
+		return nil, err
+	}
+	session := pennsieve.NewSession(sessionToken, apiHost, api2Host)
+	session.Tracing = tracingProvider
+	session.HTTPClient.Timeout = requestTimeout
+	session.RetryPolicy = retryPolicy
+	session.APIRateLimiter = apiRateLimiter
+	session.API2RateLimiter = api2RateLimiter
 	idStore := NewIDStoreBuilder().Build()
-	return NewMetadataPostProcessor(integrationID,
+	processor, err := NewMetadataPostProcessor(integrationID,
 		inputDirectory,
 		outputDirectory,
-		sessionToken,
-		apiHost,
-		api2Host,
+		session,
 		idStore,
 	)
+	if err != nil {
+		return nil, err
+	}
+	processor.PlanMode = planMode
+	processor.RollbackOnFailure = rollbackOnFailure
+	processor.RecordConcurrency = recordConcurrency
+	processor.RecordBatchSize = recordBatchSize
+	processor.ProxyBatchSize = proxyBatchSize
+	processor.MetricsAddress = os.Getenv(MetricsAddressKey)
+	processor.Tracing = tracingProvider
+	processor.RunTimeout = runTimeout
+	return processor, nil
+}
+
+func retryPolicyFromEnv() (util.RetryPolicy, error) {
+	maxAttempts, err := LookupOptionalIntEnvVar(RetryMaxAttemptsKey, util.DefaultRetryMaxAttempts)
+	if err != nil {
+		return util.RetryPolicy{}, err
+	}
+	baseDelay, err := LookupOptionalDurationEnvVar(RetryBaseDelayKey, util.DefaultRetryBaseDelay)
+	if err != nil {
+		return util.RetryPolicy{}, err
+	}
+	maxDelay, err := LookupOptionalDurationEnvVar(RetryMaxDelayKey, util.DefaultRetryMaxDelay)
+	if err != nil {
+		return util.RetryPolicy{}, err
+	}
+	return util.RetryPolicy{
+		MaxAttempts: maxAttempts,
+		BaseDelay:   baseDelay,
+		MaxDelay:    maxDelay,
+	}, nil
+}
+
+// rateLimiterFromEnv returns a RateLimiter allowing the number of requests per second given by rateKey, or nil if
+// rateKey is not set. The burst size given by burstKey defaults to one second's worth of requests.
+func rateLimiterFromEnv(rateKey, burstKey string) (*util.RateLimiter, error) {
+	rate, err := LookupOptionalFloatEnvVar(rateKey, 0)
+	if err != nil {
+		return nil, err
+	}
+	burst, err := LookupOptionalIntEnvVar(burstKey, int(math.Ceil(rate)))
+	if err != nil {
+		return nil, err
+	}
+	return util.NewRateLimiter(rate, burst), nil
 }
 
 func LookupRequiredEnvVar(key string) (string, error) {
@@ -55,3 +176,55 @@ func LookupRequiredEnvVar(key string) (string, error) {
 	}
 	return value, nil
 }
+
+// LookupOptionalBoolEnvVar returns false if key is not set, otherwise the value of key parsed by strconv.ParseBool
+func LookupOptionalBoolEnvVar(key string) (bool, error) {
+	value := os.Getenv(key)
+	if len(value) == 0 {
+		return false, nil
+	}
+	boolValue, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid boolean value %q for %s: %w", value, key, err)
+	}
+	return boolValue, nil
+}
+
+// LookupOptionalIntEnvVar returns defaultValue if key is not set, otherwise the value of key parsed by strconv.Atoi
+func LookupOptionalIntEnvVar(key string, defaultValue int) (int, error) {
+	value := os.Getenv(key)
+	if len(value) == 0 {
+		return defaultValue, nil
+	}
+	intValue, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid integer value %q for %s: %w", value, key, err)
+	}
+	return intValue, nil
+}
+
+// LookupOptionalFloatEnvVar returns defaultValue if key is not set, otherwise the value of key parsed by strconv.ParseFloat
+func LookupOptionalFloatEnvVar(key string, defaultValue float64) (float64, error) {
+	value := os.Getenv(key)
+	if len(value) == 0 {
+		return defaultValue, nil
+	}
+	floatValue, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number value %q for %s: %w", value, key, err)
+	}
+	return floatValue, nil
+}
+
+// LookupOptionalDurationEnvVar returns defaultValue if key is not set, otherwise the value of key parsed by time.ParseDuration
+func LookupOptionalDurationEnvVar(key string, defaultValue time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if len(value) == 0 {
+		return defaultValue, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration value %q for %s: %w", value, key, err)
+	}
+	return duration, nil
+}
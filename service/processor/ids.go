@@ -1,8 +1,12 @@
 package processor
 
 import (
+	"encoding/json"
 	"fmt"
 	clientmodels "github.com/pennsieve/processor-post-metadata/client/models"
+	"github.com/pennsieve/processor-post-metadata/service/util"
+	"os"
+	"sync"
 )
 
 type RecordIDKey struct {
@@ -12,13 +16,17 @@ type RecordIDKey struct {
 
 type RecordIDLookup map[RecordIDKey]clientmodels.PennsieveInstanceID
 
-// IDStore will hold maps to the Pennsieve IDs of metadata objects
+// IDStore will hold maps to the Pennsieve IDs of metadata objects.
+// The methods are safe for concurrent use, but direct access to the maps is not.
 type IDStore struct {
 	ModelByName   map[string]clientmodels.PennsieveSchemaID
 	RecordIDbyKey RecordIDLookup
+	mu            sync.RWMutex
 }
 
 func (s *IDStore) AddModel(name string, id clientmodels.PennsieveSchemaID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.ModelByName[name] = id
 }
 
@@ -29,6 +37,8 @@ func (s *IDStore) AddModels(nameToID map[string]clientmodels.PennsieveSchemaID)
 }
 
 func (s *IDStore) AddRecord(modelID clientmodels.PennsieveSchemaID, externalID clientmodels.ExternalInstanceID, id clientmodels.PennsieveInstanceID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.RecordIDbyKey[RecordIDKey{
 		ModelID:    modelID,
 		ExternalID: externalID,
@@ -49,7 +59,22 @@ func (s *IDStore) AddRecordIDMaps(recordIDMaps []clientmodels.RecordIDMap) error
 	return nil
 }
 
+// splitRecordIDMaps splits recordIDMaps into those of the models already in the store, and those of models that are
+// not, because the changeset creates them
+func (s *IDStore) splitRecordIDMaps(recordIDMaps []clientmodels.RecordIDMap) (known []clientmodels.RecordIDMap, pending []clientmodels.RecordIDMap) {
+	for _, recordIDMap := range recordIDMaps {
+		if _, err := s.ModelID(recordIDMap.ModelName); err == nil {
+			known = append(known, recordIDMap)
+		} else {
+			pending = append(pending, recordIDMap)
+		}
+	}
+	return known, pending
+}
+
 func (s *IDStore) RecordID(modelID clientmodels.PennsieveSchemaID, externalID clientmodels.ExternalInstanceID) (clientmodels.PennsieveInstanceID, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	recordID, found := s.RecordIDbyKey[RecordIDKey{
 		ModelID:    modelID,
 		ExternalID: externalID,
@@ -61,6 +86,8 @@ func (s *IDStore) RecordID(modelID clientmodels.PennsieveSchemaID, externalID cl
 }
 
 func (s *IDStore) ModelID(modelName string) (clientmodels.PennsieveSchemaID, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	modelID, found := s.ModelByName[modelName]
 	if !found {
 		return "", fmt.Errorf("id for model %s not found", modelName)
@@ -68,6 +95,91 @@ func (s *IDStore) ModelID(modelName string) (clientmodels.PennsieveSchemaID, err
 	return modelID, nil
 }
 
+// savedIDStore is the on-disk format of an IDStore, since RecordIDLookup's struct keys cannot be JSON object keys
+type savedIDStore struct {
+	Models  map[string]clientmodels.PennsieveSchemaID `json:"models"`
+	Records []savedRecordID                           `json:"records"`
+}
+
+type savedRecordID struct {
+	ModelID    clientmodels.PennsieveSchemaID   `json:"model_id"`
+	ExternalID clientmodels.ExternalInstanceID  `json:"external_id"`
+	ID         clientmodels.PennsieveInstanceID `json:"id"`
+}
+
+func (s *IDStore) MarshalJSON() ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	saved := savedIDStore{
+		Models:  s.ModelByName,
+		Records: make([]savedRecordID, 0, len(s.RecordIDbyKey)),
+	}
+	for key, id := range s.RecordIDbyKey {
+		saved.Records = append(saved.Records, savedRecordID{
+			ModelID:    key.ModelID,
+			ExternalID: key.ExternalID,
+			ID:         id,
+		})
+	}
+	return json.Marshal(saved)
+}
+
+// UnmarshalJSON adds the saved IDs to s rather than replacing its contents
+func (s *IDStore) UnmarshalJSON(data []byte) error {
+	var saved savedIDStore
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	if s.ModelByName == nil {
+		s.ModelByName = make(map[string]clientmodels.PennsieveSchemaID)
+	}
+	if s.RecordIDbyKey == nil {
+		s.RecordIDbyKey = make(RecordIDLookup)
+	}
+	s.mu.Unlock()
+	s.AddModels(saved.Models)
+	for _, record := range saved.Records {
+		s.AddRecord(record.ModelID, record.ExternalID, record.ID)
+	}
+	return nil
+}
+
+// Save writes the store to filePath, replacing any earlier version
+func (s *IDStore) Save(filePath string) error {
+	// write to a temp file first so that a crash mid-write does not destroy the previous save
+	tempFilePath := filePath + ".tmp"
+	file, err := os.Create(tempFilePath)
+	if err != nil {
+		return fmt.Errorf("error creating IDStore file %s: %w", tempFilePath, err)
+	}
+	if err := json.NewEncoder(file).Encode(s); err != nil {
+		util.CloseFileAndWarn(file)
+		return fmt.Errorf("error encoding IDStore file %s: %w", tempFilePath, err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("error closing IDStore file %s: %w", tempFilePath, err)
+	}
+	if err := os.Rename(tempFilePath, filePath); err != nil {
+		return fmt.Errorf("error moving IDStore file %s to %s: %w", tempFilePath, filePath, err)
+	}
+	return nil
+}
+
+// Load adds the IDs saved in filePath to the store. If filePath does not exist, the returned error
+// will satisfy errors.Is(err, os.ErrNotExist)
+func (s *IDStore) Load(filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("error opening IDStore file %s: %w", filePath, err)
+	}
+	defer util.CloseFileAndWarn(file)
+	if err := json.NewDecoder(file).Decode(s); err != nil {
+		return fmt.Errorf("error decoding IDStore file %s: %w", filePath, err)
+	}
+	return nil
+}
+
 type IDStoreBuilder struct {
 	store *IDStore
 }
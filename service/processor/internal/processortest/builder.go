@@ -2,18 +2,28 @@ package processortest
 
 import (
 	"github.com/google/uuid"
+	"github.com/pennsieve/processor-post-metadata/service/pennsieve"
 	"github.com/pennsieve/processor-post-metadata/service/processor"
+	"github.com/pennsieve/processor-post-metadata/service/tracing"
 	"github.com/stretchr/testify/require"
+	"net/http"
 	"testing"
 )
 
 // Builder is a processor.MetadataPostProcessor builder for tests
 type Builder struct {
-	integrationID   *string
-	inputDirectory  *string
-	outputDirectory *string
-	sessionToken    *string
-	idStore         *processor.IDStore
+	integrationID     *string
+	inputDirectory    *string
+	outputDirectory   *string
+	sessionToken      *string
+	idStore           *processor.IDStore
+	recordConcurrency *int
+	recordBatchSize   *int
+	proxyBatchSize    *int
+	rollbackOnFailure bool
+	tracing           *tracing.Provider
+	httpClient        *http.Client
+	backend           func(session *pennsieve.Session) pennsieve.Backend
 }
 
 func NewBuilder() *Builder {
@@ -45,6 +55,44 @@ func (b *Builder) WithIDStore(idStore *processor.IDStore) *Builder {
 	return b
 }
 
+func (b *Builder) WithRecordConcurrency(recordConcurrency int) *Builder {
+	b.recordConcurrency = &recordConcurrency
+	return b
+}
+
+func (b *Builder) WithRecordBatchSize(recordBatchSize int) *Builder {
+	b.recordBatchSize = &recordBatchSize
+	return b
+}
+
+func (b *Builder) WithProxyBatchSize(proxyBatchSize int) *Builder {
+	b.proxyBatchSize = &proxyBatchSize
+	return b
+}
+
+func (b *Builder) WithRollbackOnFailure() *Builder {
+	b.rollbackOnFailure = true
+	return b
+}
+
+func (b *Builder) WithTracing(provider *tracing.Provider) *Builder {
+	b.tracing = provider
+	return b
+}
+
+// WithHTTPClient sets the client the Session uses to send requests to the mock server
+func (b *Builder) WithHTTPClient(httpClient *http.Client) *Builder {
+	b.httpClient = httpClient
+	return b
+}
+
+// WithBackend sets the Pennsieve backend of the processor to the one returned by backend, which may wrap the Session
+// that would otherwise be used
+func (b *Builder) WithBackend(backend func(session *pennsieve.Session) pennsieve.Backend) *Builder {
+	b.backend = backend
+	return b
+}
+
 func (b *Builder) Build(t *testing.T, mockServerURL string) *processor.MetadataPostProcessor {
 	var integrationID string
 	if b.integrationID == nil {
@@ -81,7 +129,32 @@ func (b *Builder) Build(t *testing.T, mockServerURL string) *processor.MetadataP
 		idStore = b.idStore
 	}
 
-	testProcessor, err := processor.NewMetadataPostProcessor(integrationID, inputDirectory, outputDirectory, sessionToken, mockServerURL, mockServerURL, idStore)
+	session := pennsieve.NewSession(sessionToken, mockServerURL, mockServerURL)
+	if b.httpClient != nil {
+		session.HTTPClient = b.httpClient
+	}
+	if b.tracing != nil {
+		session.Tracing = b.tracing
+	}
+	var backend pennsieve.Backend = session
+	if b.backend != nil {
+		backend = b.backend(session)
+	}
+
+	testProcessor, err := processor.NewMetadataPostProcessor(integrationID, inputDirectory, outputDirectory, backend, idStore)
 	require.NoError(t, err)
+	if b.recordConcurrency != nil {
+		testProcessor.RecordConcurrency = *b.recordConcurrency
+	}
+	if b.recordBatchSize != nil {
+		testProcessor.RecordBatchSize = *b.recordBatchSize
+	}
+	if b.proxyBatchSize != nil {
+		testProcessor.ProxyBatchSize = *b.proxyBatchSize
+	}
+	testProcessor.RollbackOnFailure = b.rollbackOnFailure
+	if b.tracing != nil {
+		testProcessor.Tracing = b.tracing
+	}
 	return testProcessor
 }
@@ -0,0 +1,379 @@
+package processor
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	clientmodels "github.com/pennsieve/processor-post-metadata/client/models"
+	"github.com/pennsieve/processor-post-metadata/service/util"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const JournalFilename = "journal.jsonl"
+const IDStoreFilename = "idstore.json"
+
+// JournalEntry records one completed mutating operation, or one object that a run created.
+type JournalEntry struct {
+	// Operation identifies the operation within a changeset. See the *Operation functions below.
+	Operation string `json:"op,omitempty"`
+	// ID is the Pennsieve ID produced by the operation, if any
+	ID string `json:"id,omitempty"`
+	// Created is the Report entry of an object created by a run, so that a failed resumed run can also roll back
+	// what earlier runs created. Entries with Created have no Operation.
+	Created *ReportEntry `json:"created,omitempty"`
+}
+
+// journalHeader is the first line of a journal file. It ties the journal to one changeset.
+type journalHeader struct {
+	ChangesetSHA256 string `json:"changeset_sha256"`
+}
+
+// Journal is an append-only log of completed operations, used to resume a run that was interrupted.
+// Safe for concurrent use.
+type Journal struct {
+	completed map[string]JournalEntry
+	// earlierCreated holds the objects created by earlier runs, in the order they were created
+	earlierCreated []ReportEntry
+	file           *os.File
+	mu             sync.Mutex
+}
+
+// OpenJournal opens the journal at filePath for the changeset with the given hash. If the existing journal
+// was written for the same changeset, its entries are loaded and resuming is true. Otherwise, any existing journal is
+// discarded and a new one started.
+func OpenJournal(filePath string, changesetHash string) (journal *Journal, resuming bool, err error) {
+	completed, earlierCreated, err := readJournalFile(filePath, changesetHash)
+	if err != nil {
+		return nil, false, err
+	}
+	if completed != nil {
+		file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, false, fmt.Errorf("error opening journal file %s: %w", filePath, err)
+		}
+		return &Journal{completed: completed, earlierCreated: earlierCreated, file: file}, true, nil
+	}
+	file, err := os.Create(filePath)
+	if err != nil {
+		return nil, false, fmt.Errorf("error creating journal file %s: %w", filePath, err)
+	}
+	journal = &Journal{completed: make(map[string]JournalEntry), file: file}
+	if err := journal.writeLine(journalHeader{ChangesetSHA256: changesetHash}); err != nil {
+		return nil, false, errors.Join(err, file.Close())
+	}
+	return journal, false, nil
+}
+
+// readJournalFile returns the completed operations and created objects in the journal at filePath, or nil if there is
+// no journal, or it is for a different changeset.
+func readJournalFile(filePath string, changesetHash string) (map[string]JournalEntry, []ReportEntry, error) {
+	file, err := os.Open(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("error opening journal file %s: %w", filePath, err)
+	}
+	defer util.CloseFileAndWarn(file)
+
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	var header journalHeader
+	if err := decoder.Decode(&header); err != nil || header.ChangesetSHA256 != changesetHash {
+		logger.Warn("existing journal is not for this changeset; starting a new one",
+			slog.String("path", filePath),
+			slog.String("journalChangesetSHA256", header.ChangesetSHA256),
+			slog.String("changesetSHA256", changesetHash))
+		return nil, nil, nil
+	}
+	completed := make(map[string]JournalEntry)
+	var created []ReportEntry
+	for {
+		var entry JournalEntry
+		if err := decoder.Decode(&entry); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			// Most likely the last line was only partially written when the previous run was killed.
+			logger.Warn("ignoring unreadable journal entry", slog.String("path", filePath), slog.Any("error", err))
+			break
+		}
+		if entry.Created != nil {
+			created = append(created, *entry.Created)
+			continue
+		}
+		completed[entry.Operation] = entry
+	}
+	return completed, created, nil
+}
+
+// Completed returns the entry for operation if it was completed by this or an earlier run.
+func (j *Journal) Completed(operation string) (JournalEntry, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entry, found := j.completed[operation]
+	return entry, found
+}
+
+// Append records that operation has completed and produced id.
+func (j *Journal) Append(operation string, id string) error {
+	entry := JournalEntry{Operation: operation, ID: id}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.writeLine(entry); err != nil {
+		return err
+	}
+	j.completed[operation] = entry
+	return nil
+}
+
+// AppendCreated records that an object was created, so that it can be rolled back by a later run
+func (j *Journal) AppendCreated(entry ReportEntry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.writeLine(JournalEntry{Created: &entry})
+}
+
+// EarlierCreated returns the objects created by the runs before the one that opened the journal
+func (j *Journal) EarlierCreated() []ReportEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return slices.Clone(j.earlierCreated)
+}
+
+func (j *Journal) writeLine(value any) error {
+	line, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("error encoding journal entry: %w", err)
+	}
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("error writing journal entry to %s: %w", j.file.Name(), err)
+	}
+	return nil
+}
+
+func (j *Journal) Close() error {
+	return j.file.Close()
+}
+
+// journaled runs operation unless the journal shows it was already completed, in which case the ID recorded then
+// is returned and skipped is true. If there is no journal, operation is always run.
+func (p *MetadataPostProcessor) journaled(operationKey string, operation func() (string, error)) (id string, skipped bool, err error) {
+	if p.journal == nil {
+		id, err = operation()
+		return id, false, err
+	}
+	if entry, completed := p.journal.Completed(operationKey); completed {
+		logger.Debug("skipping operation completed by earlier run", slog.String("operation", operationKey))
+		return entry.ID, true, nil
+	}
+	if id, err = operation(); err != nil {
+		return "", false, err
+	}
+	if err := p.journal.Append(operationKey, id); err != nil {
+		return "", false, err
+	}
+	return id, false, nil
+}
+
+// startJournal opens the journal for the current changeset and, if resuming an earlier run, reloads the IDStore
+// saved by that run.
+func (p *MetadataPostProcessor) startJournal() error {
+	changesetHash, err := hashFile(p.changesetFilePath())
+	if err != nil {
+		return err
+	}
+	journal, resuming, err := OpenJournal(p.journalFilePath(), changesetHash)
+	if err != nil {
+		return err
+	}
+	p.journal = journal
+	p.Report.setJournal(journal)
+	if !resuming {
+		return nil
+	}
+	if err := p.IDStore.Load(p.idStoreFilePath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	logger.Info("resuming from journal",
+		slog.String("path", p.journalFilePath()),
+		slog.Int("completedOperations", len(journal.completed)))
+	return nil
+}
+
+// checkpoint saves the IDStore so that it can be reloaded if the run is resumed. A no-op if there is no journal.
+func (p *MetadataPostProcessor) checkpoint() error {
+	if p.journal == nil {
+		return nil
+	}
+	return p.IDStore.Save(p.idStoreFilePath())
+}
+
+func (p *MetadataPostProcessor) stopJournal() error {
+	if p.journal == nil {
+		return nil
+	}
+	err := errors.Join(p.checkpoint(), p.journal.Close())
+	p.journal = nil
+	p.Report.setJournal(nil)
+	return err
+}
+
+// JournalFilePath joins the given output directory with the
+// journal file name.
+// Visible for testing.
+func JournalFilePath(outputDirectory string) string {
+	return filepath.Join(outputDirectory, JournalFilename)
+}
+
+func (p *MetadataPostProcessor) journalFilePath() string {
+	return JournalFilePath(p.OutputDirectory)
+}
+
+// IDStoreFilePath joins the given output directory with the
+// saved IDStore file name.
+// Visible for testing.
+func IDStoreFilePath(outputDirectory string) string {
+	return filepath.Join(outputDirectory, IDStoreFilename)
+}
+
+func (p *MetadataPostProcessor) idStoreFilePath() string {
+	return IDStoreFilePath(p.OutputDirectory)
+}
+
+func hashFile(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", fmt.Errorf("error opening file %s to hash: %w", filePath, err)
+	}
+	defer util.CloseFileAndWarn(file)
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("error hashing file %s: %w", filePath, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Operation keys for the journal. They only need to be unique within one changeset.
+
+func createModelOperation(modelName string) string {
+	return fmt.Sprintf("create_model:%s", modelName)
+}
+
+func createModelPropertiesOperation(modelName string) string {
+	return fmt.Sprintf("create_model_properties:%s", modelName)
+}
+
+func updateModelOperation(modelID clientmodels.PennsieveSchemaID) string {
+	return fmt.Sprintf("update_model:%s", modelID)
+}
+
+func createPropertiesOperation(modelID clientmodels.PennsieveSchemaID, propertiesCreate clientmodels.PropertiesCreateParams) string {
+	names := make([]string, len(propertiesCreate))
+	for i, propertyCreate := range propertiesCreate {
+		names[i] = propertyCreate.Name
+	}
+	return fmt.Sprintf("create_properties:%s:%s", modelID, strings.Join(names, ","))
+}
+
+func updatePropertyOperation(propertyID clientmodels.PennsieveSchemaID) string {
+	return fmt.Sprintf("update_property:%s", propertyID)
+}
+
+func deletePropertyOperation(propertyID clientmodels.PennsieveSchemaID) string {
+	return fmt.Sprintf("delete_property:%s", propertyID)
+}
+
+// createRecordOperation uses the external ID of the record if there is one. Otherwise, the
+// position of the record in its model's list of creates.
+func createRecordOperation(modelID clientmodels.PennsieveSchemaID, index int, externalID clientmodels.ExternalInstanceID) string {
+	if len(externalID) == 0 {
+		return fmt.Sprintf("create_record:%s:#%d", modelID, index)
+	}
+	return fmt.Sprintf("create_record:%s:%s", modelID, externalID)
+}
+
+func updateRecordOperation(recordID clientmodels.PennsieveInstanceID) string {
+	return fmt.Sprintf("update_record:%s", recordID)
+}
+
+func upsertRecordOperation(modelID clientmodels.PennsieveSchemaID, externalID clientmodels.ExternalInstanceID) string {
+	return fmt.Sprintf("upsert_record:%s:%s", modelID, externalID)
+}
+
+// deleteRecordsOperation uses a hash of the record IDs, since the records of one model can be deleted by more than one
+// ModelUpdate, ModelDelete or streamed section
+func deleteRecordsOperation(modelID clientmodels.PennsieveSchemaID, recordIDs []clientmodels.PennsieveInstanceID) string {
+	return fmt.Sprintf("delete_records:%s:%s", modelID, hashIDs(recordIDs))
+}
+
+func deleteModelOperation(modelID clientmodels.PennsieveSchemaID) string {
+	return fmt.Sprintf("delete_model:%s", modelID)
+}
+
+func createLinkSchemaOperation(fromModelID clientmodels.PennsieveSchemaID, linkName string) string {
+	return fmt.Sprintf("create_link_schema:%s:%s", fromModelID, linkName)
+}
+
+func updateLinkSchemaOperation(linkSchemaID clientmodels.PennsieveSchemaID) string {
+	return fmt.Sprintf("update_link_schema:%s", linkSchemaID)
+}
+
+func deleteLinkSchemaOperation(linkSchemaID clientmodels.PennsieveSchemaID) string {
+	return fmt.Sprintf("delete_link_schema:%s", linkSchemaID)
+}
+
+func createLinkInstanceOperation(linkSchemaID clientmodels.PennsieveSchemaID, fromRecordID clientmodels.PennsieveInstanceID, toRecordID clientmodels.PennsieveInstanceID) string {
+	return fmt.Sprintf("create_link_instance:%s:%s:%s", linkSchemaID, fromRecordID, toRecordID)
+}
+
+func deleteLinkInstanceOperation(linkInstanceID clientmodels.PennsieveInstanceID) string {
+	return fmt.Sprintf("delete_link_instance:%s", linkInstanceID)
+}
+
+func createRelationshipSchemaOperation(name string) string {
+	return fmt.Sprintf("create_relationship_schema:%s", name)
+}
+
+func createRelationshipInstanceOperation(relationshipSchemaID clientmodels.PennsieveSchemaID, fromRecordID clientmodels.PennsieveInstanceID, toRecordID clientmodels.PennsieveInstanceID) string {
+	return fmt.Sprintf("create_relationship_instance:%s:%s:%s", relationshipSchemaID, fromRecordID, toRecordID)
+}
+
+func deleteRelationshipInstanceOperation(relationshipInstanceID clientmodels.PennsieveInstanceID) string {
+	return fmt.Sprintf("delete_relationship_instance:%s", relationshipInstanceID)
+}
+
+func createProxyRelationshipSchemaOperation() string {
+	return "create_proxy_relationship_schema"
+}
+
+func createProxyOperation(recordID clientmodels.PennsieveInstanceID, packageNodeID string) string {
+	return fmt.Sprintf("create_proxy:%s:%s", recordID, packageNodeID)
+}
+
+func createProxyPackageOperation(recordID clientmodels.PennsieveInstanceID, packageNodeID string, relationshipType string) string {
+	return fmt.Sprintf("create_proxy_package:%s:%s:%s", recordID, packageNodeID, relationshipType)
+}
+
+// deleteProxiesOperation uses a hash of the proxy IDs, since the proxies of one record can be deleted by more than
+// one streamed section
+func deleteProxiesOperation(recordID clientmodels.PennsieveInstanceID, proxyIDs []clientmodels.PennsieveInstanceID) string {
+	return fmt.Sprintf("delete_proxies:%s:%s", recordID, hashIDs(proxyIDs))
+}
+
+// hashIDs returns a hash of the IDs that does not depend on their order
+func hashIDs(ids []clientmodels.PennsieveInstanceID) string {
+	sorted := make([]string, len(ids))
+	for i, id := range ids {
+		sorted[i] = string(id)
+	}
+	slices.Sort(sorted)
+	hash := sha256.Sum256([]byte(strings.Join(sorted, ",")))
+	return hex.EncodeToString(hash[:])
+}
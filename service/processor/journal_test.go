@@ -0,0 +1,112 @@
+package processor_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/pennsieve/processor-post-metadata/client/clienttest"
+	clientmodels "github.com/pennsieve/processor-post-metadata/client/models"
+	"github.com/pennsieve/processor-post-metadata/service/internal/test/mock"
+	"github.com/pennsieve/processor-post-metadata/service/internal/test/mock/expectedcalls"
+	"github.com/pennsieve/processor-post-metadata/service/models"
+	"github.com/pennsieve/processor-post-metadata/service/processor"
+	"github.com/pennsieve/processor-post-metadata/service/processor/internal/processortest"
+	"github.com/pennsieve/processor-pre-metadata/client/models/datatypes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+)
+
+func TestMetadataPostProcessor_Run_Resume(t *testing.T) {
+	integrationID := uuid.NewString()
+	datasetID := processortest.NewDatasetID()
+	outputDirectory := t.TempDir()
+
+	modelID := clienttest.NewPennsieveSchemaID()
+	modelCreate := clienttest.NewModelCreate()
+	recordCreateValues := clienttest.NewRecordValues(clienttest.NewRecordValueSimple(t, datatypes.StringType))
+	createdExternalID := clienttest.NewExternalInstanceID()
+
+	existingModelName := uuid.NewString()
+	existingModelID := clienttest.NewPennsieveSchemaID()
+	existingExternalID := clienttest.NewExternalInstanceID()
+	existingRecordID := clienttest.NewPennsieveInstanceID()
+	linkSchemaID := clienttest.NewPennsieveSchemaID()
+
+	changeset := clientmodels.Dataset{
+		Models: clientmodels.ModelChanges{
+			Creates: []clientmodels.ModelCreate{{
+				Create: clientmodels.ModelPropsCreate{Model: modelCreate},
+				Records: []clientmodels.RecordCreate{{
+					ExternalID:   createdExternalID,
+					RecordValues: recordCreateValues,
+				}},
+			}},
+		},
+		LinkedProperties: []clientmodels.LinkedPropertyChanges{{
+			FromModelName: modelCreate.Name,
+			ToModelName:   existingModelName,
+			ID:            linkSchemaID,
+			Instances: clientmodels.InstanceChanges{
+				Create: []clientmodels.InstanceLinkedPropertyCreate{{
+					FromExternalID: createdExternalID,
+					ToExternalID:   existingExternalID,
+				}},
+			},
+		}},
+		ExistingModelIDMap: map[string]clientmodels.PennsieveSchemaID{existingModelName: existingModelID},
+		RecordIDMaps: []clientmodels.RecordIDMap{{
+			ModelName:           existingModelName,
+			ExternalToPennsieve: map[clientmodels.ExternalInstanceID]clientmodels.PennsieveInstanceID{existingExternalID: existingRecordID},
+		}},
+	}
+	writeChangeset(t, changeset, processor.ChangesetFilePath(outputDirectory))
+
+	expectedRecordCreateCall := expectedcalls.RecordCreate(datasetID, modelID, recordCreateValues)
+	createdRecordID := clientmodels.PennsieveInstanceID(expectedRecordCreateCall.APIResponse.ID)
+
+	// First attempt creates the model and record but fails creating the link
+	firstMockServer := mock.NewModelService(t,
+		expectedcalls.GetIntegration(integrationID, datasetID),
+		expectedcalls.ModelCreate(datasetID, modelID, modelCreate),
+		expectedRecordCreateCall,
+		&mock.ExpectedFailedAPICall{
+			Method:     http.MethodPost,
+			APIPath:    fmt.Sprintf("/models/datasets/%s/concepts/%s/instances/%s/linked", datasetID, modelID, createdRecordID),
+			StatusCode: http.StatusInternalServerError,
+		})
+	defer firstMockServer.Close()
+
+	firstProcessor := processortest.NewBuilder().
+		WithIntegrationID(integrationID).
+		WithOutputDirectory(outputDirectory).
+		Build(t, firstMockServer.URL())
+	require.Error(t, firstProcessor.Run(context.Background()))
+	firstMockServer.AssertAllCalledExactlyOnce(t)
+
+	// Second attempt should only create the link, using the model and record ids from the first attempt
+	secondMockServer := mock.NewModelService(t,
+		expectedcalls.GetIntegration(integrationID, datasetID),
+		expectedcalls.CreateLinkInstance(datasetID, modelID, createdRecordID, models.CreateLinkInstanceBody{
+			SchemaLinkedPropertyId: linkSchemaID,
+			To:                     existingRecordID,
+		}))
+	defer secondMockServer.Close()
+
+	secondProcessor := processortest.NewBuilder().
+		WithIntegrationID(integrationID).
+		WithOutputDirectory(outputDirectory).
+		Build(t, secondMockServer.URL())
+	require.NoError(t, secondProcessor.Run(context.Background()))
+	secondMockServer.AssertAllCalledExactlyOnce(t)
+
+	recordID, err := secondProcessor.IDStore.RecordID(modelID, createdExternalID)
+	require.NoError(t, err)
+	assert.Equal(t, createdRecordID, recordID)
+
+	savedIDStore := processor.NewIDStoreBuilder().Build()
+	require.NoError(t, savedIDStore.Load(processor.IDStoreFilePath(outputDirectory)))
+	assert.Equal(t, secondProcessor.IDStore.ModelByName, savedIDStore.ModelByName)
+	assert.Equal(t, secondProcessor.IDStore.RecordIDbyKey, savedIDStore.RecordIDbyKey)
+}
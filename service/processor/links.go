@@ -1,26 +1,27 @@
 package processor
 
 import (
+	"context"
 	"fmt"
 	clientmodels "github.com/pennsieve/processor-post-metadata/client/models"
 	"github.com/pennsieve/processor-post-metadata/service/models"
 	"log/slog"
 )
 
-func (p *MetadataPostProcessor) ProcessLinkInstanceDeletes(datasetID string, linkChanges []clientmodels.LinkedPropertyChanges) error {
+func (p *MetadataPostProcessor) ProcessLinkInstanceDeletes(ctx context.Context, datasetID string, linkChanges []clientmodels.LinkedPropertyChanges) error {
 	if len(linkChanges) == 0 {
 		logger.Info("no link deletes")
 		return nil
 	}
 	for _, linkChange := range linkChanges {
-		if err := p.ProcessLinkChangesInstanceDeletes(datasetID, linkChange); err != nil {
+		if err := p.ProcessLinkChangesInstanceDeletes(ctx, datasetID, linkChange); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (p *MetadataPostProcessor) ProcessLinkChangesInstanceDeletes(datasetID string, linkChange clientmodels.LinkedPropertyChanges) error {
+func (p *MetadataPostProcessor) ProcessLinkChangesInstanceDeletes(ctx context.Context, datasetID string, linkChange clientmodels.LinkedPropertyChanges) error {
 	linkLogger := logger.With(slog.Any("linkSchemaID", linkChange.ID))
 	if len(linkChange.Instances.Delete) == 0 {
 		linkLogger.Info("no deletes")
@@ -32,21 +33,66 @@ func (p *MetadataPostProcessor) ProcessLinkChangesInstanceDeletes(datasetID stri
 		return fmt.Errorf("unable to delete linked properties from model %s to model %s: %w", linkChange.FromModelName, linkChange.ToModelName, err)
 	}
 	for _, linkDelete := range linkChange.Instances.Delete {
-		if err := p.Pennsieve.DeleteLinkedPropertyInstance(datasetID, fromModelID, linkDelete); err != nil {
+		_, skipped, err := p.journaled(deleteLinkInstanceOperation(linkDelete.InstanceLinkedPropertyID), func() (string, error) {
+			return "", p.Pennsieve.DeleteLinkedPropertyInstance(ctx, datasetID, fromModelID, linkDelete)
+		})
+		if err != nil {
 			return err
 		}
+		if skipped {
+			continue
+		}
+		p.Report.Add(ReportEntry{
+			Type:     LinkInstanceEntity,
+			Action:   Deleted,
+			ID:       string(linkDelete.InstanceLinkedPropertyID),
+			ModelID:  fromModelID,
+			RecordID: linkDelete.FromRecordID,
+		})
 	}
 	linkLogger.Info("finished link deletes", slog.Int("count", len(linkChange.Instances.Delete)))
 	return nil
 }
-func (p *MetadataPostProcessor) ProcessLinks(datasetID string, linkChanges []clientmodels.LinkedPropertyChanges) error {
+func (p *MetadataPostProcessor) ProcessLinkSchemaDeletes(ctx context.Context, datasetID string, linkChanges []clientmodels.LinkedPropertyChanges) error {
+	for _, linkChange := range linkChanges {
+		if !linkChange.Delete {
+			continue
+		}
+		if err := p.DeleteLinkSchema(ctx, datasetID, linkChange); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *MetadataPostProcessor) DeleteLinkSchema(ctx context.Context, datasetID string, linkChange clientmodels.LinkedPropertyChanges) error {
+	linkLogger := logger.With(slog.Any("linkSchemaID", linkChange.ID))
+	linkLogger.Info("deleting link schema")
+	fromModelID, err := p.IDStore.ModelID(linkChange.FromModelName)
+	if err != nil {
+		return fmt.Errorf("unable to delete link schema %s from model %s: %w", linkChange.ID, linkChange.FromModelName, err)
+	}
+	_, skipped, err := p.journaled(deleteLinkSchemaOperation(linkChange.ID), func() (string, error) {
+		return "", p.Pennsieve.DeleteLinkedPropertySchema(ctx, datasetID, fromModelID, linkChange.ID)
+	})
+	if err != nil {
+		return err
+	}
+	if !skipped {
+		p.Report.Add(ReportEntry{Type: LinkSchemaEntity, Action: Deleted, ID: linkChange.ID.String(), ModelID: fromModelID})
+	}
+	linkLogger.Info("deleted link schema", slog.Bool("deletedByEarlierRun", skipped))
+	return nil
+}
+
+func (p *MetadataPostProcessor) ProcessLinks(ctx context.Context, datasetID string, linkChanges []clientmodels.LinkedPropertyChanges) error {
 	if len(linkChanges) == 0 {
 		logger.Info("no link changes")
 		return nil
 	}
 	logger.Info("starting link changes")
 	for _, linkChange := range linkChanges {
-		if err := p.ProcessLinkChanges(datasetID, linkChange); err != nil {
+		if err := p.ProcessLinkChanges(ctx, datasetID, linkChange); err != nil {
 			return err
 		}
 	}
@@ -54,16 +100,26 @@ func (p *MetadataPostProcessor) ProcessLinks(datasetID string, linkChanges []cli
 	return nil
 }
 
-func (p *MetadataPostProcessor) ProcessLinkChanges(datasetID string, linkChange clientmodels.LinkedPropertyChanges) error {
-	schemaIDs, err := p.CreateLinkSchemaIfNecessary(datasetID, linkChange)
+func (p *MetadataPostProcessor) ProcessLinkChanges(ctx context.Context, datasetID string, linkChange clientmodels.LinkedPropertyChanges) error {
+	if linkChange.Delete {
+		// handled by ProcessLinkSchemaDeletes
+		return nil
+	}
+	schemaIDs, err := p.CreateLinkSchemaIfNecessary(ctx, datasetID, linkChange)
 	if err != nil {
 		return err
 	}
 	linkLogger := logger.With(slog.Any("linkSchemaID", schemaIDs.Link))
 
+	if linkChange.Update != nil {
+		if err := p.UpdateLinkSchema(ctx, datasetID, schemaIDs, *linkChange.Update); err != nil {
+			return err
+		}
+	}
+
 	linkLogger.Info("creating link instances")
 	for _, instanceCreate := range linkChange.Instances.Create {
-		if err := p.CreateLinkInstance(datasetID, schemaIDs, instanceCreate); err != nil {
+		if err := p.CreateLinkInstance(ctx, datasetID, schemaIDs, instanceCreate); err != nil {
 			return err
 		}
 	}
@@ -72,7 +128,29 @@ func (p *MetadataPostProcessor) ProcessLinkChanges(datasetID string, linkChange
 	return nil
 }
 
-func (p *MetadataPostProcessor) CreateLinkInstance(datasetID string, schemaIDs SchemaID, instanceCreate clientmodels.InstanceLinkedPropertyCreate) error {
+func (p *MetadataPostProcessor) UpdateLinkSchema(ctx context.Context, datasetID string, schemaIDs SchemaID, linkUpdate clientmodels.SchemaLinkedPropertyUpdate) error {
+	linkLogger := logger.With(slog.Any("linkSchemaID", schemaIDs.Link))
+	linkLogger.Info("updating link schema")
+	body := models.UpdateLinkSchemaBody{
+		Name:        linkUpdate.Name,
+		DisplayName: linkUpdate.DisplayName,
+		To:          schemaIDs.ToModel,
+		Position:    linkUpdate.Position,
+	}
+	_, skipped, err := p.journaled(updateLinkSchemaOperation(schemaIDs.Link), func() (string, error) {
+		return "", p.Pennsieve.UpdateLinkedPropertySchema(ctx, datasetID, schemaIDs.FromModel, schemaIDs.Link, body)
+	})
+	if err != nil {
+		return err
+	}
+	if !skipped {
+		p.Report.Add(ReportEntry{Type: LinkSchemaEntity, Action: Updated, ID: schemaIDs.Link.String(), Name: linkUpdate.Name, ModelID: schemaIDs.FromModel})
+	}
+	linkLogger.Info("updated link schema", slog.Bool("updatedByEarlierRun", skipped))
+	return nil
+}
+
+func (p *MetadataPostProcessor) CreateLinkInstance(ctx context.Context, datasetID string, schemaIDs SchemaID, instanceCreate clientmodels.InstanceLinkedPropertyCreate) error {
 	fromRecordID, err := p.IDStore.RecordID(schemaIDs.FromModel, instanceCreate.FromExternalID)
 	if err != nil {
 		return fmt.Errorf("'from' record id not found: %w", err)
@@ -85,9 +163,26 @@ func (p *MetadataPostProcessor) CreateLinkInstance(datasetID string, schemaIDs S
 		SchemaLinkedPropertyId: schemaIDs.Link,
 		To:                     toRecordID,
 	}
-	if err := p.Pennsieve.CreateLinkedPropertyInstance(datasetID, schemaIDs.FromModel, fromRecordID, body); err != nil {
+	operationKey := createLinkInstanceOperation(schemaIDs.Link, fromRecordID, toRecordID)
+	linkInstanceID, skipped, err := p.journaled(operationKey, func() (string, error) {
+		linkInstanceID, err := p.Pennsieve.CreateLinkedPropertyInstance(recordContext(ctx, instanceCreate.FromExternalID), datasetID, schemaIDs.FromModel, fromRecordID, body)
+		return string(linkInstanceID), err
+	})
+	if err != nil {
 		return fmt.Errorf("error creating linked property instance: %w", err)
 	}
+	if skipped {
+		return nil
+	}
+	p.Report.Add(ReportEntry{
+		Type:           LinkInstanceEntity,
+		Action:         Created,
+		ID:             linkInstanceID,
+		ModelID:        schemaIDs.FromModel,
+		RecordID:       fromRecordID,
+		FromExternalID: instanceCreate.FromExternalID,
+		ToExternalID:   instanceCreate.ToExternalID,
+	})
 	return nil
 }
 
@@ -98,7 +193,7 @@ type SchemaID struct {
 	ToModel   clientmodels.PennsieveSchemaID
 }
 
-func (p *MetadataPostProcessor) CreateLinkSchemaIfNecessary(datasetID string, linkChange clientmodels.LinkedPropertyChanges) (SchemaID, error) {
+func (p *MetadataPostProcessor) CreateLinkSchemaIfNecessary(ctx context.Context, datasetID string, linkChange clientmodels.LinkedPropertyChanges) (SchemaID, error) {
 	fromModelID, foundFrom := p.IDStore.ModelByName[linkChange.FromModelName]
 	if !foundFrom {
 		return SchemaID{}, fmt.Errorf("from model id for name %s not found", linkChange.FromModelName)
@@ -127,11 +222,18 @@ func (p *MetadataPostProcessor) CreateLinkSchemaIfNecessary(datasetID string, li
 		To:          toModelID,
 		Position:    linkCreate.Position,
 	}
-	linkID, err := p.Pennsieve.CreateLinkedPropertySchema(datasetID, fromModelID, body)
+	id, skipped, err := p.journaled(createLinkSchemaOperation(fromModelID, linkCreate.Name), func() (string, error) {
+		linkID, err := p.Pennsieve.CreateLinkedPropertySchema(ctx, datasetID, fromModelID, body)
+		return linkID.String(), err
+	})
 	if err != nil {
 		return SchemaID{}, fmt.Errorf("error creating link schema: %w", err)
 	}
-	linkLogger.Info("link schema created", slog.Any("linkID", linkID))
+	linkID := clientmodels.PennsieveSchemaID(id)
+	linkLogger.Info("link schema created", slog.Any("linkID", linkID), slog.Bool("createdByEarlierRun", skipped))
+	if !skipped {
+		p.Report.Add(ReportEntry{Type: LinkSchemaEntity, Action: Created, ID: linkID.String(), Name: linkCreate.Name, ModelID: fromModelID})
+	}
 	return SchemaID{
 		FromModel: fromModelID,
 		Link:      linkID,
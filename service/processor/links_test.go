@@ -1,6 +1,7 @@
 package processor_test
 
 import (
+	"context"
 	"github.com/google/uuid"
 	"github.com/pennsieve/processor-post-metadata/client/clienttest"
 	clientmodels "github.com/pennsieve/processor-post-metadata/client/models"
@@ -9,6 +10,7 @@ import (
 	"github.com/pennsieve/processor-post-metadata/service/models"
 	"github.com/pennsieve/processor-post-metadata/service/processor"
 	"github.com/pennsieve/processor-post-metadata/service/processor/internal/processortest"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"testing"
 )
@@ -17,6 +19,7 @@ func TestMetadataPostProcessor_ProcessLinkChanges(t *testing.T) {
 	for scenario, testFunc := range map[string]func(t *testing.T){
 		"create link schema":                       createLinkSchema,
 		"link schema exists; create link instance": createLinkInstance,
+		"update link schema":                       updateLinkSchema,
 	} {
 		t.Run(scenario, func(t *testing.T) {
 			testFunc(t)
@@ -64,7 +67,7 @@ func createLinkSchema(t *testing.T) {
 
 	testProcessor := processortest.NewBuilder().WithIDStore(initialIDStore).Build(t, mockServer.URL())
 
-	require.NoError(t, testProcessor.ProcessLinkChanges(datasetID, clientmodels.LinkedPropertyChanges{
+	require.NoError(t, testProcessor.ProcessLinkChanges(context.Background(), datasetID, clientmodels.LinkedPropertyChanges{
 		FromModelName: fromModelName,
 		ToModelName:   toModelName,
 		Create:        &schemaCreate,
@@ -108,7 +111,7 @@ func createLinkInstance(t *testing.T) {
 
 	testProcessor := processortest.NewBuilder().WithIDStore(initialIDStore).Build(t, mockServer.URL())
 
-	require.NoError(t, testProcessor.ProcessLinkChanges(datasetID, clientmodels.LinkedPropertyChanges{
+	require.NoError(t, testProcessor.ProcessLinkChanges(context.Background(), datasetID, clientmodels.LinkedPropertyChanges{
 		FromModelName: fromModelName,
 		ToModelName:   toModelName,
 		ID:            linkSchemaID,
@@ -122,9 +125,10 @@ func createLinkInstance(t *testing.T) {
 
 func TestMetadataPostProcessor_ProcessLinkChangesInstanceDeletes(t *testing.T) {
 	for scenario, testFunc := range map[string]func(t *testing.T){
-		"no deletes, schema does not exist": noDeletesLinkSchemaDoesNotExist,
-		"no deletes, schema exists":         noDeletesLinkSchemaExists,
-		"deletes":                           linkDeletes,
+		"no deletes, schema does not exist":  noDeletesLinkSchemaDoesNotExist,
+		"no deletes, schema exists":          noDeletesLinkSchemaExists,
+		"deletes":                            linkDeletes,
+		"link schema delete after instances": linkSchemaDeleteAfterInstances,
 	} {
 		t.Run(scenario, func(t *testing.T) {
 			testFunc(t)
@@ -149,7 +153,7 @@ func noDeletesLinkSchemaDoesNotExist(t *testing.T) {
 
 	testProcessor := processortest.NewBuilder().WithIDStore(initialIDStore).Build(t, mockServer.URL())
 
-	require.NoError(t, testProcessor.ProcessLinkChangesInstanceDeletes(datasetID, clientmodels.LinkedPropertyChanges{
+	require.NoError(t, testProcessor.ProcessLinkChangesInstanceDeletes(context.Background(), datasetID, clientmodels.LinkedPropertyChanges{
 		FromModelName: fromModelName,
 		ToModelName:   uuid.NewString(),
 		Create:        &schemaCreate,
@@ -174,7 +178,7 @@ func noDeletesLinkSchemaExists(t *testing.T) {
 
 	testProcessor := processortest.NewBuilder().WithIDStore(initialIDStore).Build(t, mockServer.URL())
 
-	require.NoError(t, testProcessor.ProcessLinkChangesInstanceDeletes(datasetID, clientmodels.LinkedPropertyChanges{
+	require.NoError(t, testProcessor.ProcessLinkChangesInstanceDeletes(context.Background(), datasetID, clientmodels.LinkedPropertyChanges{
 		FromModelName: fromModelName,
 		ToModelName:   uuid.NewString(),
 		ID:            linkSchemaID,
@@ -212,7 +216,7 @@ func linkDeletes(t *testing.T) {
 
 	testProcessor := processortest.NewBuilder().WithIDStore(initialIDStore).Build(t, mockServer.URL())
 
-	require.NoError(t, testProcessor.ProcessLinkChangesInstanceDeletes(datasetID, clientmodels.LinkedPropertyChanges{
+	require.NoError(t, testProcessor.ProcessLinkChangesInstanceDeletes(context.Background(), datasetID, clientmodels.LinkedPropertyChanges{
 		FromModelName: fromModelName,
 		ToModelName:   uuid.NewString(),
 		ID:            linkSchemaID,
@@ -232,3 +236,95 @@ func linkDeletes(t *testing.T) {
 
 	mockServer.AssertAllCalledExactlyOnce(t)
 }
+
+func updateLinkSchema(t *testing.T) {
+	datasetID := processortest.NewDatasetID()
+
+	fromModelName := uuid.NewString()
+	fromModelID := clienttest.NewPennsieveSchemaID()
+	toModelName := uuid.NewString()
+	toModelID := clienttest.NewPennsieveSchemaID()
+
+	initialIDStore := processor.NewIDStoreBuilder().
+		WithModel(fromModelName, fromModelID).
+		WithModel(toModelName, toModelID).
+		Build()
+
+	linkSchemaID := clienttest.NewPennsieveSchemaID()
+	schemaUpdate := clientmodels.SchemaLinkedPropertyUpdate{
+		Name:        uuid.NewString(),
+		DisplayName: uuid.NewString(),
+		Position:    3,
+	}
+
+	mockServer := mock.NewModelService(t, expectedcalls.UpdateLinkSchema(datasetID, fromModelID, linkSchemaID, models.UpdateLinkSchemaBody{
+		Name:        schemaUpdate.Name,
+		DisplayName: schemaUpdate.DisplayName,
+		To:          toModelID,
+		Position:    schemaUpdate.Position,
+	}))
+	defer mockServer.Close()
+
+	testProcessor := processortest.NewBuilder().WithIDStore(initialIDStore).Build(t, mockServer.URL())
+
+	require.NoError(t, testProcessor.ProcessLinkChanges(context.Background(), datasetID, clientmodels.LinkedPropertyChanges{
+		FromModelName: fromModelName,
+		ToModelName:   toModelName,
+		ID:            linkSchemaID,
+		Update:        &schemaUpdate,
+	}))
+
+	mockServer.AssertAllCalledExactlyOnce(t)
+
+	require.Len(t, testProcessor.Report.Entries, 1)
+	assert.Equal(t, processor.Updated, testProcessor.Report.Entries[0].Action)
+	assert.Equal(t, schemaUpdate.Name, testProcessor.Report.Entries[0].Name)
+}
+
+func linkSchemaDeleteAfterInstances(t *testing.T) {
+	datasetID := processortest.NewDatasetID()
+
+	fromModelName := uuid.NewString()
+	fromModelID := clienttest.NewPennsieveSchemaID()
+	fromRecordID := clienttest.NewPennsieveInstanceID()
+	linkInstanceID := clienttest.NewPennsieveInstanceID()
+	linkSchemaID := clienttest.NewPennsieveSchemaID()
+
+	initialIDStore := processor.NewIDStoreBuilder().
+		WithModel(fromModelName, fromModelID).
+		Build()
+
+	mockServer := mock.NewModelService(t,
+		expectedcalls.DeleteLinkInstance(datasetID, fromModelID, fromRecordID, linkInstanceID),
+		expectedcalls.DeleteLinkSchema(datasetID, fromModelID, linkSchemaID))
+	defer mockServer.Close()
+
+	testProcessor := processortest.NewBuilder().WithIDStore(initialIDStore).Build(t, mockServer.URL())
+
+	require.NoError(t, testProcessor.ProcessDeletes(context.Background(), datasetID, clientmodels.Dataset{
+		LinkedProperties: []clientmodels.LinkedPropertyChanges{{
+			FromModelName: fromModelName,
+			ToModelName:   uuid.NewString(),
+			ID:            linkSchemaID,
+			Delete:        true,
+			Instances: clientmodels.InstanceChanges{
+				Delete: []clientmodels.InstanceLinkedPropertyDelete{{
+					FromRecordID:             fromRecordID,
+					InstanceLinkedPropertyID: linkInstanceID,
+				}},
+			},
+		}},
+	}))
+
+	mockServer.AssertAllCalledExactlyOnce(t)
+
+	entries := testProcessor.Report.Entries
+	require.Len(t, entries, 2)
+	assert.Equal(t, processor.LinkInstanceEntity, entries[0].Type)
+	assert.Equal(t, processor.ReportEntry{
+		Type:    processor.LinkSchemaEntity,
+		Action:  processor.Deleted,
+		ID:      linkSchemaID.String(),
+		ModelID: fromModelID,
+	}, entries[1])
+}
@@ -0,0 +1,62 @@
+package processor
+
+import (
+	"github.com/pennsieve/processor-post-metadata/service/metrics"
+	"log/slog"
+	"path/filepath"
+)
+
+// MetricsFilename is the name of the OpenMetrics file written to the output directory at the end of a run, for
+// collection by the node-exporter textfile collector
+const MetricsFilename = "metrics.prom"
+
+// Metric family names recorded by the processor. See also the pennsieve package for request metrics.
+const (
+	EntitiesMetric      = "metadata_processor_entities"
+	PhaseDurationMetric = "metadata_processor_phase_duration_seconds"
+	PhaseSuccessMetric  = "metadata_processor_phase_success"
+	RunDurationMetric   = "metadata_processor_run_duration_seconds"
+	RunSuccessMetric    = "metadata_processor_run_success"
+	RunTimestampMetric  = "metadata_processor_run_timestamp_seconds"
+)
+
+// noPhaseLabel is the phase label of entries added outside a phase, such as rollback deletes
+const noPhaseLabel = "none"
+
+// MetricsFilePath joins the given output directory with the
+// metrics file name.
+// Visible for testing.
+func MetricsFilePath(outputDirectory string) string {
+	return filepath.Join(outputDirectory, MetricsFilename)
+}
+
+func (p *MetadataPostProcessor) metricsFilePath() string {
+	return MetricsFilePath(p.OutputDirectory)
+}
+
+// startMetrics starts serving metrics on MetricsAddress if it is set. Metrics are not essential to the run, so a
+// listener that cannot be started is only logged. The returned func stops the listener and writes the metrics file.
+func (p *MetadataPostProcessor) startMetrics() (finish func()) {
+	if p.Metrics == nil {
+		return func() {}
+	}
+	var server *metrics.Server
+	if len(p.MetricsAddress) > 0 {
+		var err error
+		if server, err = metrics.Serve(p.MetricsAddress, p.Metrics); err != nil {
+			logger.Warn("unable to serve metrics", slog.Any("error", err))
+		}
+	}
+	return func() {
+		if server != nil {
+			if err := server.Close(); err != nil {
+				logger.Warn("error stopping metrics server", slog.Any("error", err))
+			}
+		}
+		if err := p.Metrics.WriteFile(p.metricsFilePath()); err != nil {
+			logger.Warn("unable to write metrics file", slog.Any("error", err))
+			return
+		}
+		logger.Info("wrote metrics file", slog.String("path", p.metricsFilePath()))
+	}
+}
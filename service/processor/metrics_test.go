@@ -0,0 +1,101 @@
+package processor_test
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/pennsieve/processor-post-metadata/client/clienttest"
+	clientmodels "github.com/pennsieve/processor-post-metadata/client/models"
+	"github.com/pennsieve/processor-post-metadata/service/internal/test/mock"
+	"github.com/pennsieve/processor-post-metadata/service/internal/test/mock/expectedcalls"
+	"github.com/pennsieve/processor-post-metadata/service/processor"
+	"github.com/pennsieve/processor-post-metadata/service/processor/internal/processortest"
+	"github.com/pennsieve/processor-pre-metadata/client/models/datatypes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestMetadataPostProcessor_Run_Metrics(t *testing.T) {
+	integrationID := uuid.NewString()
+	datasetID := processortest.NewDatasetID()
+	outputDirectory := t.TempDir()
+
+	modelID := clienttest.NewPennsieveSchemaID()
+	modelCreate := clienttest.NewModelCreate()
+	recordCreateValues := clienttest.NewRecordValues(clienttest.NewRecordValueSimple(t, datatypes.StringType))
+
+	changeset := clientmodels.Dataset{
+		Models: clientmodels.ModelChanges{
+			Creates: []clientmodels.ModelCreate{{
+				Create: clientmodels.ModelPropsCreate{Model: modelCreate},
+				Records: []clientmodels.RecordCreate{{
+					ExternalID:   clienttest.NewExternalInstanceID(),
+					RecordValues: recordCreateValues,
+				}},
+			}},
+		},
+	}
+	writeChangeset(t, changeset, processor.ChangesetFilePath(outputDirectory))
+
+	mockServer := mock.NewModelService(t,
+		expectedcalls.GetIntegration(integrationID, datasetID),
+		expectedcalls.ModelCreate(datasetID, modelID, modelCreate),
+		expectedcalls.RecordCreate(datasetID, modelID, recordCreateValues))
+	defer mockServer.Close()
+
+	testProcessor := processortest.NewBuilder().
+		WithIntegrationID(integrationID).
+		WithOutputDirectory(outputDirectory).
+		Build(t, mockServer.URL())
+
+	require.NoError(t, testProcessor.Run(context.Background()))
+
+	content, err := os.ReadFile(processor.MetricsFilePath(outputDirectory))
+	require.NoError(t, err)
+	metricsText := string(content)
+	assert.Contains(t, metricsText, `pennsieve_requests_total{method="GET",endpoint="/integrations/{id}",status="200"} 1`)
+	assert.Contains(t, metricsText, `pennsieve_requests_total{method="POST",endpoint="/models/datasets/{id}/concepts",status="200"} 1`)
+	assert.Contains(t, metricsText, `pennsieve_request_duration_seconds_count{method="POST",endpoint="/models/datasets/{id}/concepts/{id}/instances"} 1`)
+	assert.Contains(t, metricsText, `metadata_processor_entities_total{phase="model_changes",type="record",action="created"} 1`)
+	assert.Contains(t, metricsText, `metadata_processor_phase_success{phase="links"} 1`)
+	assert.Contains(t, metricsText, "metadata_processor_run_success 1\n")
+	assert.NotContains(t, metricsText, "pennsieve_request_errors_total")
+	assert.Regexp(t, "# EOF\n$", metricsText)
+}
+
+func TestMetadataPostProcessor_Run_Metrics_Cancelled(t *testing.T) {
+	integrationID := uuid.NewString()
+	outputDirectory := t.TempDir()
+	writeChangeset(t, clientmodels.Dataset{}, processor.ChangesetFilePath(outputDirectory))
+
+	mockServer := mock.NewModelService(t)
+	defer mockServer.Close()
+
+	stopCause := errors.New("stopped by test")
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	// the integration request fails in a way that is retried, but the run is stopped before the retry
+	httpClient := &http.Client{Transport: roundTripperFunc(func(request *http.Request) (*http.Response, error) {
+		cancel(stopCause)
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	})}
+
+	testProcessor := processortest.NewBuilder().
+		WithIntegrationID(integrationID).
+		WithOutputDirectory(outputDirectory).
+		WithHTTPClient(httpClient).
+		Build(t, mockServer.URL())
+
+	require.ErrorIs(t, testProcessor.Run(ctx), stopCause)
+
+	content, err := os.ReadFile(processor.MetricsFilePath(outputDirectory))
+	require.NoError(t, err)
+	metricsText := string(content)
+	assert.Contains(t, metricsText, `pennsieve_requests_total{method="GET",endpoint="/integrations/{id}",status="cancelled"} 1`)
+	assert.Contains(t, metricsText, `pennsieve_request_errors_total{class="cancelled"} 1`)
+	assert.NotContains(t, metricsText, `class="connection"`)
+}
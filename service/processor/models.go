@@ -1,66 +1,86 @@
 package processor
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	clientmodels "github.com/pennsieve/processor-post-metadata/client/models"
 	"log/slog"
 )
 
-func (p *MetadataPostProcessor) ProcessRecordModelDeletes(datasetID string, modelUpdates []clientmodels.ModelUpdate, modelDeletes []clientmodels.ModelDelete) error {
+func (p *MetadataPostProcessor) ProcessRecordModelDeletes(ctx context.Context, datasetID string, modelUpdates []clientmodels.ModelUpdate, modelDeletes []clientmodels.ModelDelete) error {
 	for _, modelChange := range modelUpdates {
-		if err := p.ProcessRecordDeletes(datasetID, modelChange.ID, modelChange.Records.Delete); err != nil {
+		if err := p.ProcessRecordDeletes(ctx, datasetID, modelChange.ID, modelChange.Records.Delete); err != nil {
 			return err
 		}
 	}
 	for _, modelChange := range modelDeletes {
-		if err := p.ProcessRecordDeletes(datasetID, modelChange.ID, modelChange.Records); err != nil {
+		if err := p.ProcessRecordDeletes(ctx, datasetID, modelChange.ID, modelChange.Records); err != nil {
 			return err
 		}
 		// Now that records are deleted, we can delete the model
-		if err := p.ProcessModelDelete(datasetID, modelChange.ID); err != nil {
+		if err := p.ProcessModelDelete(ctx, datasetID, modelChange.ID); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (p *MetadataPostProcessor) ProcessRecordDeletes(datasetID string, modelID clientmodels.PennsieveSchemaID, recordIDs []clientmodels.PennsieveInstanceID) error {
+func (p *MetadataPostProcessor) ProcessRecordDeletes(ctx context.Context, datasetID string, modelID clientmodels.PennsieveSchemaID, recordIDs []clientmodels.PennsieveInstanceID) error {
 	modelLogger := logger.With(slog.Any("modelID", modelID))
 	if len(recordIDs) == 0 {
 		modelLogger.Info("no record deletes")
 		return nil
 	}
 	modelLogger.Info("starting record deletes")
-	if err := p.Pennsieve.DeleteRecords(datasetID, modelID, recordIDs); err != nil {
+	_, skipped, err := p.journaled(deleteRecordsOperation(modelID, recordIDs), func() (string, error) {
+		return "", p.Pennsieve.DeleteRecords(ctx, datasetID, modelID, recordIDs)
+	})
+	if err != nil {
 		return err
 	}
+	if skipped {
+		modelLogger.Info("record deletes completed by earlier run")
+		return nil
+	}
+	for _, recordID := range recordIDs {
+		p.Report.Add(ReportEntry{Type: RecordEntity, Action: Deleted, ID: string(recordID), ModelID: modelID})
+	}
 	modelLogger.Info("finished record deletes", slog.Int("count", len(recordIDs)))
 	return nil
 }
 
-func (p *MetadataPostProcessor) ProcessModelDelete(datasetID string, modelID clientmodels.PennsieveSchemaID) error {
+func (p *MetadataPostProcessor) ProcessModelDelete(ctx context.Context, datasetID string, modelID clientmodels.PennsieveSchemaID) error {
 	modelLogger := logger.With(slog.Any("modelID", modelID))
 	modelLogger.Info("deleting model")
-	if err := p.Pennsieve.DeleteModel(datasetID, modelID); err != nil {
+	_, skipped, err := p.journaled(deleteModelOperation(modelID), func() (string, error) {
+		return "", p.Pennsieve.DeleteModel(ctx, datasetID, modelID)
+	})
+	if err != nil {
 		return err
 	}
+	if skipped {
+		modelLogger.Info("model delete completed by earlier run")
+		return nil
+	}
+	p.Report.Add(ReportEntry{Type: ModelEntity, Action: Deleted, ID: modelID.String()})
 	modelLogger.Info("deleted model")
 	return nil
 }
 
-func (p *MetadataPostProcessor) ProcessModelCreatesUpdates(datasetID string, modelCreates []clientmodels.ModelCreate, modelUpdates []clientmodels.ModelUpdate) error {
+func (p *MetadataPostProcessor) ProcessModelCreatesUpdates(ctx context.Context, datasetID string, modelCreates []clientmodels.ModelCreate, modelUpdates []clientmodels.ModelUpdate) error {
 	if len(modelCreates)+len(modelUpdates) == 0 {
 		logger.Info("no model changes")
 		return nil
 	}
 	logger.Info("starting model changes")
 	for _, modelChange := range modelCreates {
-		if err := p.ProcessModelCreate(datasetID, modelChange); err != nil {
+		if err := p.ProcessModelCreate(ctx, datasetID, modelChange); err != nil {
 			return err
 		}
 	}
 	for _, modelChange := range modelUpdates {
-		if err := p.ProcessModelUpdate(datasetID, modelChange); err != nil {
+		if err := p.ProcessModelUpdate(ctx, datasetID, modelChange); err != nil {
 			return err
 		}
 	}
@@ -68,68 +88,312 @@ func (p *MetadataPostProcessor) ProcessModelCreatesUpdates(datasetID string, mod
 	return nil
 }
 
-func (p *MetadataPostProcessor) ProcessModelCreate(datasetID string, modelCreate clientmodels.ModelCreate) error {
-	modelID, err := p.CreateModel(datasetID, modelCreate.Create)
+func (p *MetadataPostProcessor) ProcessModelCreate(ctx context.Context, datasetID string, modelCreate clientmodels.ModelCreate) (err error) {
+	ctx, endSpan := p.startModelSpan(ctx, modelCreate.Create.Model.Name, "")
+	defer func() { endSpan(err) }()
+	modelID, err := p.CreateModel(ctx, datasetID, modelCreate.Create)
 	if err != nil {
 		return err
 	}
-	modelLogger := logger.With(slog.Any("modelID", modelID))
-	modelLogger.Info("creating records")
-	for _, recordCreate := range modelCreate.Records {
-		if err := p.CreateRecord(datasetID, modelID, recordCreate); err != nil {
+	setSpanModelID(ctx, modelID)
+	return p.CreateRecords(ctx, datasetID, modelID, modelCreate.Records)
+}
+
+func (p *MetadataPostProcessor) ProcessModelUpdate(ctx context.Context, datasetID string, modelUpdate clientmodels.ModelUpdate) (err error) {
+	modelID := modelUpdate.ID
+	ctx, endSpan := p.startModelSpan(ctx, "", modelID)
+	defer func() { endSpan(err) }()
+	// record creates and updates may depend on the new or modified properties
+	if err := p.processModelSchemaChanges(ctx, datasetID, modelUpdate); err != nil {
+		return err
+	}
+	if err := p.CreateRecords(ctx, datasetID, modelID, modelUpdate.Records.Create); err != nil {
+		return err
+	}
+	if err := p.UpdateRecords(ctx, datasetID, modelID, modelUpdate.Records.Update); err != nil {
+		return err
+	}
+	return p.UpsertRecords(ctx, datasetID, modelID, modelUpdate.Records.Upsert)
+}
+
+// processModelSchemaChanges makes the changes in modelUpdate to the model itself and its properties
+func (p *MetadataPostProcessor) processModelSchemaChanges(ctx context.Context, datasetID string, modelUpdate clientmodels.ModelUpdate) error {
+	if modelUpdate.Model != nil {
+		if err := p.UpdateModel(ctx, datasetID, modelUpdate.ID, *modelUpdate.Model); err != nil {
 			return err
 		}
 	}
-	modelLogger.Info("created records", slog.Int("count", len(modelCreate.Records)))
+	return p.ProcessPropertyChanges(ctx, datasetID, modelUpdate.ID, modelUpdate.Properties)
+}
+
+// UpdateModel applies modelUpdate to the current metadata of the model
+func (p *MetadataPostProcessor) UpdateModel(ctx context.Context, datasetID string, modelID clientmodels.PennsieveSchemaID, modelUpdate clientmodels.ModelUpdateParams) error {
+	modelLogger := logger.With(slog.Any("modelID", modelID))
+	modelLogger.Info("updating model")
+	var modelName string
+	_, skipped, err := p.journaled(updateModelOperation(modelID), func() (string, error) {
+		current, err := p.Pennsieve.GetModel(ctx, datasetID, modelID)
+		if err != nil {
+			return "", err
+		}
+		modelName = current.Name
+		updated := modelUpdate.Apply(clientmodels.ModelCreateParams{
+			Name:        current.Name,
+			DisplayName: current.DisplayName,
+			Description: current.Description,
+			Locked:      current.Locked,
+		})
+		return "", p.Pennsieve.UpdateModel(ctx, datasetID, modelID, updated)
+	})
+	if err != nil {
+		return err
+	}
+	if !skipped {
+		p.Report.Add(ReportEntry{Type: ModelEntity, Action: Updated, ID: modelID.String(), Name: modelName})
+	}
+	modelLogger.Info("updated model", slog.Bool("updatedByEarlierRun", skipped))
 	return nil
 }
 
-func (p *MetadataPostProcessor) ProcessModelUpdate(datasetID string, modelUpdate clientmodels.ModelUpdate) error {
-	modelID := modelUpdate.ID
+// ProcessPropertyChanges deletes, then updates, then creates properties of an existing model. Deletes go first so that
+// the name of a deleted property can be reused.
+func (p *MetadataPostProcessor) ProcessPropertyChanges(ctx context.Context, datasetID string, modelID clientmodels.PennsieveSchemaID, propertyChanges clientmodels.PropertyChanges) error {
+	if propertyChanges.IsEmpty() {
+		return nil
+	}
 	modelLogger := logger.With(slog.Any("modelID", modelID))
-	modelLogger.Info("creating records")
-	for _, recordCreate := range modelUpdate.Records.Create {
-		if err := p.CreateRecord(datasetID, modelID, recordCreate); err != nil {
+	modelLogger.Info("starting property changes")
+	for _, propertyID := range propertyChanges.Delete {
+		if err := p.DeleteProperty(ctx, datasetID, modelID, propertyID); err != nil {
 			return err
 		}
 	}
-	modelLogger.Info("created records", slog.Int("count", len(modelUpdate.Records.Create)))
-	modelLogger.Info("updating records")
-	for _, recordUpdate := range modelUpdate.Records.Update {
-		if err := p.UpdateRecord(datasetID, modelID, recordUpdate); err != nil {
+	for _, propertyUpdate := range propertyChanges.Update {
+		if err := p.UpdateProperty(ctx, datasetID, modelID, propertyUpdate); err != nil {
 			return err
 		}
 	}
-	modelLogger.Info("updated models", slog.Int("count", len(modelUpdate.Records.Update)))
+	if err := p.CreateProperties(ctx, datasetID, modelID, propertyChanges.Create); err != nil {
+		return err
+	}
+	modelLogger.Info("finished property changes",
+		slog.Int("deleteCount", len(propertyChanges.Delete)),
+		slog.Int("updateCount", len(propertyChanges.Update)),
+		slog.Int("createCount", len(propertyChanges.Create)))
+	return nil
+}
+
+func (p *MetadataPostProcessor) DeleteProperty(ctx context.Context, datasetID string, modelID clientmodels.PennsieveSchemaID, propertyID clientmodels.PennsieveSchemaID) error {
+	_, skipped, err := p.journaled(deletePropertyOperation(propertyID), func() (string, error) {
+		return "", p.Pennsieve.DeleteModelProperty(ctx, datasetID, modelID, propertyID)
+	})
+	if err != nil {
+		return err
+	}
+	if !skipped {
+		p.Report.Add(ReportEntry{Type: PropertyEntity, Action: Deleted, ID: propertyID.String(), ModelID: modelID})
+	}
+	return nil
+}
+
+func (p *MetadataPostProcessor) UpdateProperty(ctx context.Context, datasetID string, modelID clientmodels.PennsieveSchemaID, propertyUpdate clientmodels.PropertyUpdate) error {
+	_, skipped, err := p.journaled(updatePropertyOperation(propertyUpdate.ID), func() (string, error) {
+		return "", p.Pennsieve.UpdateModelProperty(ctx, datasetID, modelID, propertyUpdate)
+	})
+	if err != nil {
+		return err
+	}
+	if !skipped {
+		p.Report.Add(ReportEntry{Type: PropertyEntity, Action: Updated, ID: propertyUpdate.ID.String(), Name: propertyUpdate.Name, ModelID: modelID})
+	}
+	return nil
+}
+
+// CreateProperties adds properties to an existing model. Properties of a new model are created by CreateModel.
+func (p *MetadataPostProcessor) CreateProperties(ctx context.Context, datasetID string, modelID clientmodels.PennsieveSchemaID, propertiesCreate clientmodels.PropertiesCreateParams) error {
+	if len(propertiesCreate) == 0 {
+		return nil
+	}
+	var propertyIDs []clientmodels.PennsieveSchemaID
+	_, skipped, err := p.journaled(createPropertiesOperation(modelID, propertiesCreate), func() (string, error) {
+		var err error
+		propertyIDs, err = p.Pennsieve.CreateModelProperties(ctx, datasetID, modelID, propertiesCreate)
+		return "", err
+	})
+	if err != nil {
+		return err
+	}
+	if skipped {
+		return nil
+	}
+	for i, propertyCreate := range propertiesCreate {
+		p.Report.Add(ReportEntry{Type: PropertyEntity, Action: Created, ID: propertyIDs[i].String(), Name: propertyCreate.Name, ModelID: modelID})
+	}
+	return nil
+}
 
+// UpdateRecords updates the records of one model, RecordConcurrency requests at a time
+func (p *MetadataPostProcessor) UpdateRecords(ctx context.Context, datasetID string, modelID clientmodels.PennsieveSchemaID, recordUpdates []clientmodels.RecordUpdate) error {
+	modelLogger := logger.With(slog.Any("modelID", modelID))
+	modelLogger.Info("updating records", slog.Int("concurrency", p.RecordConcurrency))
+	if err := forEachConcurrently(ctx, len(recordUpdates), p.RecordConcurrency, func(i int) error {
+		return p.UpdateRecord(ctx, datasetID, modelID, recordUpdates[i])
+	}); err != nil {
+		return err
+	}
+	modelLogger.Info("updated records", slog.Int("count", len(recordUpdates)))
 	return nil
 }
 
-func (p *MetadataPostProcessor) CreateModel(datasetID string, modelCreate clientmodels.ModelPropsCreate) (clientmodels.PennsieveSchemaID, error) {
-	modelLogger := logger.With(slog.String("modelName", modelCreate.Model.Name))
+func (p *MetadataPostProcessor) CreateModel(ctx context.Context, datasetID string, modelCreate clientmodels.ModelPropsCreate) (clientmodels.PennsieveSchemaID, error) {
+	modelName := modelCreate.Model.Name
+	modelLogger := logger.With(slog.String("modelName", modelName))
 	modelLogger.Info("creating model")
-	modelID, err := p.Pennsieve.CreateModelAndProps(datasetID, modelCreate)
+	id, skipped, err := p.journaled(createModelOperation(modelName), func() (string, error) {
+		modelID, err := p.Pennsieve.CreateModel(ctx, datasetID, modelCreate.Model)
+		return modelID.String(), err
+	})
 	if err != nil {
 		return "", fmt.Errorf("error creating model: %w", err)
 	}
-	p.IDStore.AddModel(modelCreate.Model.Name, modelID)
-	modelLogger.Info("model created", slog.Any("modelID", modelID))
+	modelID := clientmodels.PennsieveSchemaID(id)
+	p.IDStore.AddModel(modelName, modelID)
+	if !skipped {
+		p.Report.Add(ReportEntry{Type: ModelEntity, Action: Created, ID: modelID.String(), Name: modelName})
+	}
+	if _, _, err := p.journaled(createModelPropertiesOperation(modelName), func() (string, error) {
+		_, err := p.Pennsieve.CreateModelProperties(ctx, datasetID, modelID, modelCreate.Properties)
+		return "", err
+	}); err != nil {
+		return "", fmt.Errorf("error creating model: model %s created; error creating properties: %w", modelName, err)
+	}
+	modelLogger.Info("model created", slog.Any("modelID", modelID), slog.Bool("createdByEarlierRun", skipped))
 	return modelID, nil
 }
 
-func (p *MetadataPostProcessor) CreateRecord(datasetID string, modelID clientmodels.PennsieveSchemaID, recordCreate clientmodels.RecordCreate) error {
-	recordID, err := p.Pennsieve.CreateRecord(datasetID, modelID, recordCreate.RecordValues)
+// CreateRecords creates the records of one model, RecordConcurrency requests at a time. If RecordBatchSize > 1,
+// each request creates a batch of up to RecordBatchSize records. Otherwise, each request creates one record.
+func (p *MetadataPostProcessor) CreateRecords(ctx context.Context, datasetID string, modelID clientmodels.PennsieveSchemaID, recordCreates []clientmodels.RecordCreate) error {
+	return p.createRecords(ctx, datasetID, modelID, recordCreates, 0)
+}
+
+// createRecords is CreateRecords for creates that start at position firstIndex among all the creates for the model,
+// which is needed when the creates are split across sections of a streamed changeset.
+func (p *MetadataPostProcessor) createRecords(ctx context.Context, datasetID string, modelID clientmodels.PennsieveSchemaID, recordCreates []clientmodels.RecordCreate, firstIndex int) error {
+	modelLogger := logger.With(slog.Any("modelID", modelID))
+	modelLogger.Info("creating records",
+		slog.Int("concurrency", p.RecordConcurrency),
+		slog.Int("batchSize", p.RecordBatchSize))
+	var err error
+	if p.RecordBatchSize > 1 {
+		err = p.createRecordBatches(ctx, datasetID, modelID, recordCreates, firstIndex)
+	} else {
+		err = forEachConcurrently(ctx, len(recordCreates), p.RecordConcurrency, func(i int) error {
+			return p.CreateRecord(ctx, datasetID, modelID, firstIndex+i, recordCreates[i])
+		})
+	}
 	if err != nil {
 		return err
 	}
-	p.IDStore.AddRecord(modelID, recordCreate.ExternalID, recordID)
+	modelLogger.Info("created records", slog.Int("count", len(recordCreates)))
+	return nil
+}
+
+// indexedRecordCreate remembers the position of a RecordCreate among the creates for its model, which is
+// needed for its journal operation key.
+type indexedRecordCreate struct {
+	index int
+	clientmodels.RecordCreate
+}
+
+func (p *MetadataPostProcessor) createRecordBatches(ctx context.Context, datasetID string, modelID clientmodels.PennsieveSchemaID, recordCreates []clientmodels.RecordCreate, firstIndex int) error {
+	var pending []indexedRecordCreate
+	for i, recordCreate := range recordCreates {
+		index := firstIndex + i
+		if recordID, completed := p.completedRecordCreate(modelID, index, recordCreate); completed {
+			p.IDStore.AddRecord(modelID, recordCreate.ExternalID, recordID)
+			continue
+		}
+		pending = append(pending, indexedRecordCreate{index: index, RecordCreate: recordCreate})
+	}
+	batchSize := p.RecordBatchSize
+	batchCount := (len(pending) + batchSize - 1) / batchSize
+	return forEachConcurrently(ctx, batchCount, p.RecordConcurrency, func(b int) error {
+		return p.createRecordBatch(ctx, datasetID, modelID, pending[b*batchSize:min((b+1)*batchSize, len(pending))])
+	})
+}
+
+// createRecordBatch creates the batch of records with one request. Records that were created are added to the IDStore
+// even if others in the batch failed.
+func (p *MetadataPostProcessor) createRecordBatch(ctx context.Context, datasetID string, modelID clientmodels.PennsieveSchemaID, batch []indexedRecordCreate) error {
+	recordCreates := make([]clientmodels.RecordCreate, len(batch))
+	for i := range batch {
+		recordCreates[i] = batch[i].RecordCreate
+	}
+	recordIDs, createErr := p.Pennsieve.CreateRecords(ctx, datasetID, modelID, recordCreates)
+	errs := []error{createErr}
+	for i, recordID := range recordIDs {
+		if len(recordID) == 0 {
+			continue
+		}
+		if p.journal != nil {
+			errs = append(errs, p.journal.Append(createRecordOperation(modelID, batch[i].index, batch[i].ExternalID), string(recordID)))
+		}
+		p.recordCreated(modelID, batch[i].RecordCreate, recordID)
+	}
+	return errors.Join(errs...)
+}
+
+// completedRecordCreate returns the ID of the record if it was already created by an earlier run
+func (p *MetadataPostProcessor) completedRecordCreate(modelID clientmodels.PennsieveSchemaID, index int, recordCreate clientmodels.RecordCreate) (clientmodels.PennsieveInstanceID, bool) {
+	if p.journal == nil {
+		return "", false
+	}
+	entry, completed := p.journal.Completed(createRecordOperation(modelID, index, recordCreate.ExternalID))
+	return clientmodels.PennsieveInstanceID(entry.ID), completed
+}
+
+// CreateRecord creates the record and adds its ID to the IDStore. index is the position of recordCreate among
+// the creates for its model.
+func (p *MetadataPostProcessor) CreateRecord(ctx context.Context, datasetID string, modelID clientmodels.PennsieveSchemaID, index int, recordCreate clientmodels.RecordCreate) error {
+	id, skipped, err := p.journaled(createRecordOperation(modelID, index, recordCreate.ExternalID), func() (string, error) {
+		recordID, err := p.Pennsieve.CreateRecord(recordContext(ctx, recordCreate.ExternalID), datasetID, modelID, recordCreate.RecordValues)
+		return string(recordID), err
+	})
+	if err != nil {
+		return err
+	}
+	recordID := clientmodels.PennsieveInstanceID(id)
+	if skipped {
+		p.IDStore.AddRecord(modelID, recordCreate.ExternalID, recordID)
+		return nil
+	}
+	p.recordCreated(modelID, recordCreate, recordID)
 	return nil
 }
 
-func (p *MetadataPostProcessor) UpdateRecord(datasetID string, modelID clientmodels.PennsieveSchemaID, recordUpdate clientmodels.RecordUpdate) error {
-	_, err := p.Pennsieve.UpdateRecord(datasetID, modelID, recordUpdate.PennsieveID, recordUpdate.RecordValues)
+// recordCreated adds a newly created record to the IDStore and Report
+func (p *MetadataPostProcessor) recordCreated(modelID clientmodels.PennsieveSchemaID, recordCreate clientmodels.RecordCreate, recordID clientmodels.PennsieveInstanceID) {
+	p.IDStore.AddRecord(modelID, recordCreate.ExternalID, recordID)
+	p.Report.Add(ReportEntry{
+		Type:       RecordEntity,
+		Action:     Created,
+		ID:         string(recordID),
+		ExternalID: recordCreate.ExternalID,
+		ModelID:    modelID,
+	})
+}
+
+func (p *MetadataPostProcessor) UpdateRecord(ctx context.Context, datasetID string, modelID clientmodels.PennsieveSchemaID, recordUpdate clientmodels.RecordUpdate) error {
+	_, skipped, err := p.journaled(updateRecordOperation(recordUpdate.PennsieveID), func() (string, error) {
+		_, err := p.Pennsieve.UpdateRecord(ctx, datasetID, modelID, recordUpdate.PennsieveID, recordUpdate.RecordValues)
+		return "", err
+	})
 	if err != nil {
 		return err
 	}
+	if !skipped {
+		p.Report.Add(ReportEntry{Type: RecordEntity, Action: Updated, ID: string(recordUpdate.PennsieveID), ModelID: modelID})
+	}
 	return nil
 }
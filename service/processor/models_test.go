@@ -1,11 +1,14 @@
 package processor_test
 
 import (
+	"context"
+	"fmt"
 	"github.com/google/uuid"
 	"github.com/pennsieve/processor-post-metadata/client/clienttest"
 	clientmodels "github.com/pennsieve/processor-post-metadata/client/models"
 	"github.com/pennsieve/processor-post-metadata/service/internal/test/mock"
 	"github.com/pennsieve/processor-post-metadata/service/internal/test/mock/expectedcalls"
+	"github.com/pennsieve/processor-post-metadata/service/models"
 	"github.com/pennsieve/processor-post-metadata/service/processor"
 	"github.com/pennsieve/processor-post-metadata/service/processor/internal/processortest"
 	"github.com/pennsieve/processor-pre-metadata/client/models/datatypes"
@@ -19,6 +22,11 @@ func TestMetadataPostProcessor_ProcessModelCreatesUpdates(t *testing.T) {
 		"create model and record":             createModel,
 		"create record; model already exists": createRecordModelExists,
 		"update record":                       updateRecord,
+		"create records concurrently":         createRecordsConcurrently,
+		"create records in batches":           createRecordsInBatches,
+		"batch create partial failure":        batchCreatePartialFailure,
+		"change properties":                   changeProperties,
+		"update model":                        updateModel,
 	} {
 		t.Run(scenario, func(t *testing.T) {
 			testFunc(t)
@@ -52,7 +60,7 @@ func createModel(t *testing.T) {
 	testProcessor := processortest.NewBuilder().WithIDStore(processor.NewIDStoreBuilder().Build()).Build(t, mockServer.URL())
 
 	createdRecordExternalID := clienttest.NewExternalInstanceID()
-	require.NoError(t, testProcessor.ProcessModelCreatesUpdates(datasetID,
+	require.NoError(t, testProcessor.ProcessModelCreatesUpdates(context.Background(), datasetID,
 		[]clientmodels.ModelCreate{{
 			Create: clientmodels.ModelPropsCreate{
 				Model:      modelCreate,
@@ -93,7 +101,7 @@ func createRecordModelExists(t *testing.T) {
 		WithIDStore(processor.NewIDStoreBuilder().WithModel(modelName, modelID).Build()).
 		Build(t, mockServer.URL())
 
-	require.NoError(t, testProcessor.ProcessModelCreatesUpdates(datasetID, nil,
+	require.NoError(t, testProcessor.ProcessModelCreatesUpdates(context.Background(), datasetID, nil,
 		[]clientmodels.ModelUpdate{{
 			ID: modelID,
 			Records: clientmodels.RecordChanges{
@@ -139,7 +147,7 @@ func updateRecord(t *testing.T) {
 		WithIDStore(processor.NewIDStoreBuilder().WithModel(uuid.NewString(), modelID).Build()).
 		Build(t, mockServer.URL())
 
-	require.NoError(t, testProcessor.ProcessModelCreatesUpdates(datasetID, nil,
+	require.NoError(t, testProcessor.ProcessModelCreatesUpdates(context.Background(), datasetID, nil,
 		[]clientmodels.ModelUpdate{{
 			ID: modelID,
 			Records: clientmodels.RecordChanges{
@@ -156,6 +164,126 @@ func updateRecord(t *testing.T) {
 
 }
 
+func createRecordsConcurrently(t *testing.T) {
+	datasetID := processortest.NewDatasetID()
+	modelID := clienttest.NewPennsieveSchemaID()
+
+	var recordCreates []clientmodels.RecordCreate
+	var recordCreateValues []clientmodels.RecordValues
+	for i := 0; i < 20; i++ {
+		values := clienttest.NewRecordValues(clienttest.NewRecordValueSimple(t, datatypes.StringType))
+		recordCreateValues = append(recordCreateValues, values)
+		recordCreates = append(recordCreates, clientmodels.RecordCreate{
+			ExternalID:   clienttest.NewExternalInstanceID(),
+			RecordValues: values,
+		})
+	}
+	expectedRecordCreateCalls := expectedcalls.RecordCreates(datasetID, modelID, recordCreateValues...)
+
+	mockServer := mock.NewModelService(t, expectedRecordCreateCalls)
+	defer mockServer.Close()
+
+	testProcessor := processortest.NewBuilder().
+		WithIDStore(processor.NewIDStoreBuilder().WithModel(uuid.NewString(), modelID).Build()).
+		WithRecordConcurrency(4).
+		Build(t, mockServer.URL())
+
+	require.NoError(t, testProcessor.ProcessModelCreatesUpdates(context.Background(), datasetID, nil,
+		[]clientmodels.ModelUpdate{{
+			ID:      modelID,
+			Records: clientmodels.RecordChanges{Create: recordCreates},
+		}}))
+
+	mockServer.AssertAllCalledExactlyOnce(t)
+
+	for i, recordCreate := range recordCreates {
+		recordID, err := testProcessor.IDStore.RecordID(modelID, recordCreate.ExternalID)
+		require.NoError(t, err)
+		assert.Equal(t, clientmodels.PennsieveInstanceID(expectedRecordCreateCalls.Calls[i].APIResponse.ID), recordID)
+	}
+}
+
+func newRecordCreates(t *testing.T, count int) ([]clientmodels.RecordCreate, []clientmodels.RecordValues) {
+	var recordCreates []clientmodels.RecordCreate
+	var recordValues []clientmodels.RecordValues
+	for i := 0; i < count; i++ {
+		values := clienttest.NewRecordValues(clienttest.NewRecordValueSimple(t, datatypes.StringType))
+		recordValues = append(recordValues, values)
+		recordCreates = append(recordCreates, clientmodels.RecordCreate{
+			ExternalID:   clienttest.NewExternalInstanceID(),
+			RecordValues: values,
+		})
+	}
+	return recordCreates, recordValues
+}
+
+func createRecordsInBatches(t *testing.T) {
+	datasetID := processortest.NewDatasetID()
+	modelID := clienttest.NewPennsieveSchemaID()
+
+	recordCreates, recordValues := newRecordCreates(t, 5)
+	expectedBatchCalls := expectedcalls.RecordBatchCreates(datasetID, modelID, recordValues[0:2], recordValues[2:4], recordValues[4:])
+
+	mockServer := mock.NewModelService(t, expectedBatchCalls)
+	defer mockServer.Close()
+
+	testProcessor := processortest.NewBuilder().
+		WithRecordBatchSize(2).
+		WithRecordConcurrency(2).
+		Build(t, mockServer.URL())
+
+	require.NoError(t, testProcessor.ProcessModelCreatesUpdates(context.Background(), datasetID, nil,
+		[]clientmodels.ModelUpdate{{
+			ID:      modelID,
+			Records: clientmodels.RecordChanges{Create: recordCreates},
+		}}))
+
+	mockServer.AssertAllCalledExactlyOnce(t)
+
+	for i, recordCreate := range recordCreates {
+		recordID, err := testProcessor.IDStore.RecordID(modelID, recordCreate.ExternalID)
+		require.NoError(t, err)
+		expectedID := expectedBatchCalls.Calls[i/2].APIResponse[i%2].ID
+		assert.Equal(t, clientmodels.PennsieveInstanceID(expectedID), recordID)
+	}
+}
+
+func batchCreatePartialFailure(t *testing.T) {
+	datasetID := processortest.NewDatasetID()
+	modelID := clienttest.NewPennsieveSchemaID()
+
+	recordCreates, recordValues := newRecordCreates(t, 3)
+	expectedBatchCall := expectedcalls.RecordBatchCreates(datasetID, modelID, recordValues)
+	// second record fails
+	expectedBatchCall.Calls[0].APIResponse[1] = models.BatchCreateRecordResponse{Error: "missing required property"}
+
+	mockServer := mock.NewModelService(t, expectedBatchCall)
+	defer mockServer.Close()
+
+	testProcessor := processortest.NewBuilder().
+		WithRecordBatchSize(10).
+		Build(t, mockServer.URL())
+
+	err := testProcessor.ProcessModelCreatesUpdates(context.Background(), datasetID, nil,
+		[]clientmodels.ModelUpdate{{
+			ID:      modelID,
+			Records: clientmodels.RecordChanges{Create: recordCreates},
+		}})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, fmt.Sprintf("external id %s: missing required property", recordCreates[1].ExternalID))
+	assert.NotContains(t, err.Error(), string(recordCreates[0].ExternalID))
+
+	mockServer.AssertAllCalledExactlyOnce(t)
+
+	for _, i := range []int{0, 2} {
+		recordID, err := testProcessor.IDStore.RecordID(modelID, recordCreates[i].ExternalID)
+		require.NoError(t, err)
+		assert.Equal(t, clientmodels.PennsieveInstanceID(expectedBatchCall.Calls[0].APIResponse[i].ID), recordID)
+	}
+	_, err = testProcessor.IDStore.RecordID(modelID, recordCreates[1].ExternalID)
+	assert.Error(t, err)
+}
+
 func TestMetadataPostProcessor_ProcessModelRecordDeletes(t *testing.T) {
 	for scenario, testFunc := range map[string]func(t *testing.T){
 		"no deletes":      noDeletes,
@@ -178,8 +306,8 @@ func noDeletes(t *testing.T) {
 
 	testProcessor := processortest.NewBuilder().Build(t, mockServer.URL())
 
-	require.NoError(t, testProcessor.ProcessRecordDeletes(datasetID, modelID, []clientmodels.PennsieveInstanceID{}))
-	require.NoError(t, testProcessor.ProcessRecordDeletes(datasetID, modelID, nil))
+	require.NoError(t, testProcessor.ProcessRecordDeletes(context.Background(), datasetID, modelID, []clientmodels.PennsieveInstanceID{}))
+	require.NoError(t, testProcessor.ProcessRecordDeletes(context.Background(), datasetID, modelID, nil))
 
 }
 
@@ -196,7 +324,7 @@ func oneRecordDelete(t *testing.T) {
 
 	testProcessor := processortest.NewBuilder().Build(t, mockServer.URL())
 
-	require.NoError(t, testProcessor.ProcessRecordDeletes(datasetID, modelID, toDelete))
+	require.NoError(t, testProcessor.ProcessRecordDeletes(context.Background(), datasetID, modelID, toDelete))
 
 	mockServer.AssertAllCalledExactlyOnce(t)
 }
@@ -214,7 +342,7 @@ func severalRecordDeletes(t *testing.T) {
 
 	testProcessor := processortest.NewBuilder().Build(t, mockServer.URL())
 
-	require.NoError(t, testProcessor.ProcessRecordDeletes(datasetID, modelID, toDelete))
+	require.NoError(t, testProcessor.ProcessRecordDeletes(context.Background(), datasetID, modelID, toDelete))
 
 	mockServer.AssertAllCalledExactlyOnce(t)
 }
@@ -232,7 +360,7 @@ func failedRecordDeletes(t *testing.T) {
 
 	testProcessor := processortest.NewBuilder().Build(t, mockServer.URL())
 
-	err := testProcessor.ProcessRecordDeletes(datasetID, modelID, toDelete)
+	err := testProcessor.ProcessRecordDeletes(context.Background(), datasetID, modelID, toDelete)
 
 	require.Error(t, err)
 	for _, recordID := range toDelete {
@@ -241,3 +369,106 @@ func failedRecordDeletes(t *testing.T) {
 
 	mockServer.AssertAllCalledExactlyOnce(t)
 }
+
+func changeProperties(t *testing.T) {
+	datasetID := processortest.NewDatasetID()
+	modelID := clienttest.NewPennsieveSchemaID()
+
+	deletedPropertyID := clienttest.NewPennsieveSchemaID()
+	propertyUpdate := clientmodels.PropertyUpdate{
+		ID:                   clienttest.NewPennsieveSchemaID(),
+		PropertyCreateParams: clienttest.NewPropertyCreateSimple(t, datatypes.StringType),
+	}
+	propertyUpdate.Description = uuid.NewString()
+	propertiesCreate := clientmodels.PropertiesCreateParams{clienttest.NewPropertyCreateSimple(t, datatypes.DoubleType)}
+	propertiesCreate[0].ConceptTitle = false
+
+	recordCreates, recordValues := newRecordCreates(t, 1)
+
+	expectedPropsCreateCall := expectedcalls.PropertiesCreate(datasetID, modelID, propertiesCreate)
+	mockServer := mock.NewModelService(t,
+		expectedcalls.PropertyDelete(datasetID, modelID, deletedPropertyID),
+		expectedcalls.PropertyUpdate(datasetID, modelID, propertyUpdate),
+		expectedPropsCreateCall,
+		expectedcalls.RecordCreate(datasetID, modelID, recordValues[0]))
+	defer mockServer.Close()
+
+	testProcessor := processortest.NewBuilder().Build(t, mockServer.URL())
+
+	require.NoError(t, testProcessor.ProcessModelCreatesUpdates(context.Background(), datasetID, nil,
+		[]clientmodels.ModelUpdate{{
+			ID: modelID,
+			Properties: clientmodels.PropertyChanges{
+				Delete: []clientmodels.PennsieveSchemaID{deletedPropertyID},
+				Create: propertiesCreate,
+				Update: []clientmodels.PropertyUpdate{propertyUpdate},
+			},
+			Records: clientmodels.RecordChanges{Create: recordCreates},
+		}}))
+
+	mockServer.AssertAllCalledExactlyOnce(t)
+
+	// properties are changed before records are created
+	entries := testProcessor.Report.Entries
+	require.Len(t, entries, 4)
+	assert.Equal(t, processor.ReportEntry{
+		Type:    processor.PropertyEntity,
+		Action:  processor.Deleted,
+		ID:      deletedPropertyID.String(),
+		ModelID: modelID,
+	}, entries[0])
+	assert.Equal(t, processor.ReportEntry{
+		Type:    processor.PropertyEntity,
+		Action:  processor.Updated,
+		ID:      propertyUpdate.ID.String(),
+		Name:    propertyUpdate.Name,
+		ModelID: modelID,
+	}, entries[1])
+	assert.Equal(t, processor.ReportEntry{
+		Type:    processor.PropertyEntity,
+		Action:  processor.Created,
+		ID:      expectedPropsCreateCall.APIResponse[0].ID,
+		Name:    propertiesCreate[0].Name,
+		ModelID: modelID,
+	}, entries[2])
+	assert.Equal(t, processor.RecordEntity, entries[3].Type)
+}
+
+func updateModel(t *testing.T) {
+	datasetID := processortest.NewDatasetID()
+	modelID := clienttest.NewPennsieveSchemaID()
+
+	current := clienttest.NewModelCreate()
+	newDisplayName := uuid.NewString()
+	locked := true
+	// description is unchanged
+	expectedUpdate := current
+	expectedUpdate.DisplayName = newDisplayName
+	expectedUpdate.Locked = locked
+
+	mockServer := mock.NewModelService(t,
+		expectedcalls.ModelUpdate(datasetID, modelID, models.ModelResponse{
+			APIResponse: models.APIResponse{Name: current.Name},
+			DisplayName: current.DisplayName,
+			Description: current.Description,
+			Locked:      current.Locked,
+		}, expectedUpdate))
+	defer mockServer.Close()
+
+	testProcessor := processortest.NewBuilder().Build(t, mockServer.URL())
+
+	require.NoError(t, testProcessor.ProcessModelCreatesUpdates(context.Background(), datasetID, nil,
+		[]clientmodels.ModelUpdate{{
+			ID:    modelID,
+			Model: &clientmodels.ModelUpdateParams{DisplayName: &newDisplayName, Locked: &locked},
+		}}))
+
+	mockServer.AssertAllCalledExactlyOnce(t)
+
+	assert.Equal(t, []processor.ReportEntry{{
+		Type:   processor.ModelEntity,
+		Action: processor.Updated,
+		ID:     modelID.String(),
+		Name:   current.Name,
+	}}, testProcessor.Report.Entries)
+}
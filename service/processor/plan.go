@@ -0,0 +1,67 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/pennsieve/processor-post-metadata/service/pennsieve"
+	"github.com/pennsieve/processor-post-metadata/service/util"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+const PlanFilename = "plan.json"
+
+// Plan walks the same phases as Run without changing anything in Pennsieve. The model, record, link and
+// proxy calls that Run would make are written in order to PlanFilePath in the output directory instead.
+// Anything that would have been created is given a placeholder ID in the IDStore so that later calls can refer to it.
+// If planning fails partway through, the calls planned so far are still written.
+// Plan requires the Pennsieve backend to be a pennsieve.Planner, such as a *pennsieve.Session.
+func (p *MetadataPostProcessor) Plan(ctx context.Context) error {
+	return p.traced(ctx, "Plan", p.plan)
+}
+
+func (p *MetadataPostProcessor) plan(ctx context.Context) error {
+	planner, canPlan := p.Pennsieve.(pennsieve.Planner)
+	if !canPlan {
+		return fmt.Errorf("cannot plan with Pennsieve backend %T: it does not implement pennsieve.Planner", p.Pennsieve)
+	}
+	defer p.startMetrics()()
+	plan := planner.EnablePlanning()
+	logger.Info("planning metadata changes")
+	processErr := p.process(ctx)
+	if err := writePlanFile(p.planFilePath(), plan); err != nil {
+		return errors.Join(processErr, err)
+	}
+	logger.Info("wrote plan file",
+		slog.String("path", p.planFilePath()),
+		slog.Int("callCount", len(plan.Calls)))
+	return processErr
+}
+
+// PlanFilePath joins the given output directory with the
+// plan file name.
+// Visible for testing.
+func PlanFilePath(outputDirectory string) string {
+	return filepath.Join(outputDirectory, PlanFilename)
+}
+
+func (p *MetadataPostProcessor) planFilePath() string {
+	return PlanFilePath(p.OutputDirectory)
+}
+
+func writePlanFile(filePath string, plan *pennsieve.Plan) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return fmt.Errorf("error creating plan file %s: %w", filePath, err)
+	}
+	defer util.CloseFileAndWarn(file)
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(plan); err != nil {
+		return fmt.Errorf("error encoding plan file %s: %w", filePath, err)
+	}
+	return nil
+}
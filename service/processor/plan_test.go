@@ -0,0 +1,195 @@
+package processor_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/pennsieve/processor-post-metadata/client/clienttest"
+	clientmodels "github.com/pennsieve/processor-post-metadata/client/models"
+	"github.com/pennsieve/processor-post-metadata/service/internal/test/mock"
+	"github.com/pennsieve/processor-post-metadata/service/internal/test/mock/expectedcalls"
+	"github.com/pennsieve/processor-post-metadata/service/pennsieve"
+	"github.com/pennsieve/processor-post-metadata/service/processor"
+	"github.com/pennsieve/processor-post-metadata/service/processor/internal/processortest"
+	"github.com/pennsieve/processor-pre-metadata/client/models/datatypes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMetadataPostProcessor_Plan(t *testing.T) {
+	integrationID := uuid.NewString()
+	datasetID := processortest.NewDatasetID()
+	outputDirectory := t.TempDir()
+
+	existingModelName := uuid.NewString()
+	existingModelID := clienttest.NewPennsieveSchemaID()
+	existingExternalID := clienttest.NewExternalInstanceID()
+	existingRecordID := clienttest.NewPennsieveInstanceID()
+	recordToDelete := clienttest.NewPennsieveInstanceID()
+
+	modelCreate := clienttest.NewModelCreate()
+	propertiesCreate := clientmodels.PropertiesCreateParams{clienttest.NewPropertyCreateSimple(t, datatypes.StringType)}
+	createdExternalID := clienttest.NewExternalInstanceID()
+	linkCreate := clienttest.NewSchemaLinkedPropertyCreate()
+	packageNodeID := NewPackageNodeID()
+
+	changeset := clientmodels.Dataset{
+		Models: clientmodels.ModelChanges{
+			Creates: []clientmodels.ModelCreate{{
+				Create: clientmodels.ModelPropsCreate{
+					Model:      modelCreate,
+					Properties: propertiesCreate,
+				},
+				Records: []clientmodels.RecordCreate{{
+					ExternalID:   createdExternalID,
+					RecordValues: clienttest.NewRecordValues(clienttest.NewRecordValueSimple(t, datatypes.StringType)),
+				}},
+			}},
+			Updates: []clientmodels.ModelUpdate{{
+				ID: existingModelID,
+				Records: clientmodels.RecordChanges{
+					Delete: []clientmodels.PennsieveInstanceID{recordToDelete},
+				},
+			}},
+		},
+		LinkedProperties: []clientmodels.LinkedPropertyChanges{{
+			FromModelName: modelCreate.Name,
+			ToModelName:   existingModelName,
+			Create:        &linkCreate,
+			Instances: clientmodels.InstanceChanges{
+				Create: []clientmodels.InstanceLinkedPropertyCreate{{
+					FromExternalID: createdExternalID,
+					ToExternalID:   existingExternalID,
+				}},
+			},
+		}},
+		Proxies: &clientmodels.ProxyChanges{
+			RecordChanges: []clientmodels.ProxyRecordChanges{{
+				ModelName:        modelCreate.Name,
+				RecordExternalID: createdExternalID,
+				NodeIDCreates:    []string{packageNodeID},
+			}},
+		},
+		ExistingModelIDMap: map[string]clientmodels.PennsieveSchemaID{existingModelName: existingModelID},
+		RecordIDMaps: []clientmodels.RecordIDMap{{
+			ModelName:           existingModelName,
+			ExternalToPennsieve: map[clientmodels.ExternalInstanceID]clientmodels.PennsieveInstanceID{existingExternalID: existingRecordID},
+		}},
+	}
+	writeChangeset(t, changeset, processor.ChangesetFilePath(outputDirectory))
+
+	// Only the read-only integration lookup should reach Pennsieve
+	mockServer := mock.NewModelService(t, expectedcalls.GetIntegration(integrationID, datasetID))
+	defer mockServer.Close()
+
+	testProcessor := processortest.NewBuilder().
+		WithIntegrationID(integrationID).
+		WithOutputDirectory(outputDirectory).
+		Build(t, mockServer.URL())
+
+	require.NoError(t, testProcessor.Plan(context.Background()))
+	mockServer.AssertAllCalledExactlyOnce(t)
+
+	createdModelID, err := testProcessor.IDStore.ModelID(modelCreate.Name)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(createdModelID.String(), pennsieve.PlaceholderIDPrefix))
+	createdRecordID, err := testProcessor.IDStore.RecordID(createdModelID, createdExternalID)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(createdRecordID), pennsieve.PlaceholderIDPrefix))
+
+	plan := readPlan(t, processor.PlanFilePath(outputDirectory))
+	datasetPath := fmt.Sprintf("/models/datasets/%s", datasetID)
+	expectedCalls := []struct{ method, path string }{
+		{http.MethodDelete, fmt.Sprintf("%s/concepts/%s/instances", datasetPath, existingModelID)},
+		{http.MethodPost, fmt.Sprintf("%s/concepts", datasetPath)},
+		{http.MethodPut, fmt.Sprintf("%s/concepts/%s/properties", datasetPath, createdModelID)},
+		{http.MethodPost, fmt.Sprintf("%s/concepts/%s/instances", datasetPath, createdModelID)},
+		{http.MethodPost, fmt.Sprintf("%s/concepts/%s/linked", datasetPath, createdModelID)},
+		{http.MethodPost, fmt.Sprintf("%s/concepts/%s/instances/%s/linked", datasetPath, createdModelID, createdRecordID)},
+		{http.MethodPost, fmt.Sprintf("%s/proxy/package/instances", datasetPath)},
+	}
+	require.Len(t, plan.Calls, len(expectedCalls))
+	for i, expected := range expectedCalls {
+		assert.Equal(t, expected.method, plan.Calls[i].Method, "call %d", i)
+		assert.Equal(t, expected.path, plan.Calls[i].Path, "call %d", i)
+	}
+	assert.Contains(t, string(plan.Calls[6].Body), packageNodeID)
+}
+
+type plannedCall struct {
+	Method string          `json:"method"`
+	Path   string          `json:"path"`
+	Body   json.RawMessage `json:"body"`
+}
+
+type planFile struct {
+	Calls []plannedCall `json:"calls"`
+}
+
+func readPlan(t *testing.T, filePath string) planFile {
+	file, err := os.Open(filePath)
+	require.NoError(t, err)
+	defer file.Close()
+	var plan planFile
+	require.NoError(t, json.NewDecoder(file).Decode(&plan))
+	return plan
+}
+
+func TestMetadataPostProcessor_PlanProxyBatches(t *testing.T) {
+	integrationID := uuid.NewString()
+	datasetID := processortest.NewDatasetID()
+	outputDirectory := t.TempDir()
+
+	modelName := uuid.NewString()
+	modelID := clienttest.NewPennsieveSchemaID()
+	externalID := clienttest.NewExternalInstanceID()
+	recordID := clienttest.NewPennsieveInstanceID()
+	nodeIDs := []string{NewPackageNodeID(), NewPackageNodeID(), NewPackageNodeID()}
+
+	changeset := clientmodels.Dataset{
+		Proxies: &clientmodels.ProxyChanges{
+			RecordChanges: []clientmodels.ProxyRecordChanges{{
+				ModelName:        modelName,
+				RecordExternalID: externalID,
+				NodeIDCreates:    nodeIDs,
+			}},
+		},
+		ExistingModelIDMap: map[string]clientmodels.PennsieveSchemaID{modelName: modelID},
+		RecordIDMaps: []clientmodels.RecordIDMap{{
+			ModelName:           modelName,
+			ExternalToPennsieve: map[clientmodels.ExternalInstanceID]clientmodels.PennsieveInstanceID{externalID: recordID},
+		}},
+	}
+	writeChangeset(t, changeset, processor.ChangesetFilePath(outputDirectory))
+
+	mockServer := mock.NewModelService(t, expectedcalls.GetIntegration(integrationID, datasetID))
+	defer mockServer.Close()
+
+	testProcessor := processortest.NewBuilder().
+		WithIntegrationID(integrationID).
+		WithOutputDirectory(outputDirectory).
+		WithProxyBatchSize(2).
+		Build(t, mockServer.URL())
+
+	require.NoError(t, testProcessor.Plan(context.Background()))
+	mockServer.AssertAllCalledExactlyOnce(t)
+
+	plan := readPlan(t, processor.PlanFilePath(outputDirectory))
+	require.Len(t, plan.Calls, 2)
+	for _, call := range plan.Calls {
+		assert.Equal(t, fmt.Sprintf("/models/datasets/%s/proxy/package/instances/bulk", datasetID), call.Path)
+	}
+
+	// every planned proxy gets a placeholder ID
+	entries := testProcessor.Report.Entries
+	require.Len(t, entries, len(nodeIDs))
+	for i, entry := range entries {
+		assert.Equal(t, nodeIDs[i], entry.PackageNodeID)
+		assert.True(t, strings.HasPrefix(entry.ID, pennsieve.PlaceholderIDPrefix))
+	}
+}
@@ -1,15 +1,22 @@
 package processor
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/pennsieve/processor-post-metadata/client"
 	clientmodels "github.com/pennsieve/processor-post-metadata/client/models"
+	"github.com/pennsieve/processor-post-metadata/client/validation"
 	"github.com/pennsieve/processor-post-metadata/service/logging"
+	"github.com/pennsieve/processor-post-metadata/service/metrics"
 	"github.com/pennsieve/processor-post-metadata/service/pennsieve"
+	"github.com/pennsieve/processor-post-metadata/service/tracing"
+	"go.opentelemetry.io/otel/trace"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 var logger = logging.PackageLogger("processor")
@@ -18,83 +25,234 @@ type MetadataPostProcessor struct {
 	IntegrationID   string
 	InputDirectory  string
 	OutputDirectory string
-	Pennsieve       *pennsieve.Session
-	IDStore         *IDStore
+	// Pennsieve makes the changes. Usually a *pennsieve.Session, but any pennsieve.Backend will do. Plan also
+	// requires a pennsieve.Planner
+	Pennsieve pennsieve.Backend
+	IDStore   *IDStore
+	// Report collects what the processor did. Written to ReportFilePath when Run returns
+	Report *Report
+	// Metrics collects request, entity and phase metrics. Written to MetricsFilePath when Run or Plan returns.
+	// nil means no metrics
+	Metrics *metrics.Registry
+	// Tracing exports the spans of Run or Plan and of the requests they make. nil means no tracing
+	Tracing *tracing.Provider
+	// MetricsAddress, if not empty, is the address of a listener serving Metrics on /metrics while Run or Plan is in progress
+	MetricsAddress string
+	// journal is only set during Run. Used to skip operations completed by an earlier, interrupted run
+	journal *Journal
+	// recordKeyIndexes holds, for record upserts, the records of a model by their value for a key property
+	recordKeyIndexes map[recordKey]*recordKeyIndex
+	// RecordConcurrency is the maximum number of record creates or updates sent to Pennsieve at the same time
+	RecordConcurrency int
+	// RecordBatchSize is the maximum number of records created by one request. Values <= 1 mean one request per record.
+	RecordBatchSize int
+	// ProxyBatchSize is the maximum number of package proxies created by one request. Values <= 1 mean one request per
+	// package. Only NodeIDCreates are batched: a batch only returns the proxy of each package's first target, and
+	// PackageCreates with additional targets need the IDs of all of their proxies.
+	ProxyBatchSize int
+	// PlanMode is true if this processor should only plan the changes with Plan rather than apply them with Run
+	PlanMode bool
+	// RollbackOnFailure is true if a failed Run should delete everything it, and any earlier run it resumed, created
+	RollbackOnFailure bool
+	// RunTimeout, if positive, is the time after which Run stops as if its context had been cancelled
+	RunTimeout time.Duration
 }
 
+// ErrRunTimeout is the cause of a Run stopped by RunTimeout
+var ErrRunTimeout = errors.New("run timed out")
+
 func NewMetadataPostProcessor(
 	integrationID string,
 	inputDirectory string,
 	outputDirectory string,
-	sessionToken string,
-	apiHost string,
-	api2Host string,
+	backend pennsieve.Backend,
 	idStore *IDStore) (*MetadataPostProcessor, error) {
-	session := pennsieve.NewSession(sessionToken, apiHost, api2Host)
+	if backend == nil {
+		return nil, errors.New("no Pennsieve backend given")
+	}
+	registry := metrics.NewRegistry()
+	// a Session records its requests in the processor's metrics unless it was given a registry of its own
+	if session, isSession := backend.(*pennsieve.Session); isSession && session.Metrics == nil {
+		session.Metrics = registry
+	}
+	report := NewReport()
+	report.metrics = registry
 	return &MetadataPostProcessor{
-		IntegrationID:   integrationID,
-		InputDirectory:  inputDirectory,
-		OutputDirectory: outputDirectory,
-		Pennsieve:       session,
-		IDStore:         idStore,
+		IntegrationID:     integrationID,
+		InputDirectory:    inputDirectory,
+		OutputDirectory:   outputDirectory,
+		Pennsieve:         backend,
+		IDStore:           idStore,
+		Report:            report,
+		Metrics:           registry,
+		RecordConcurrency: DefaultRecordConcurrency,
+		RecordBatchSize:   DefaultRecordBatchSize,
+		ProxyBatchSize:    DefaultProxyBatchSize,
 	}, nil
 }
 
-func (p *MetadataPostProcessor) Run() error {
-	integration, err := p.Pennsieve.GetIntegration(p.IntegrationID)
-	if err != nil {
-		return fmt.Errorf("error getting integration %s from Pennsieve: %w", p.IntegrationID, err)
+// Run applies the changeset to the dataset. Whether it succeeds or fails, a Report
+// of what was done is written to ReportFilePath in the output directory.
+// If it fails and RollbackOnFailure is true, the objects created by Run, and by any earlier run that it resumed, are
+// deleted again.
+// Metrics are written to MetricsFilePath after the Report. The run is traced with Tracing, which is shut down when
+// Run returns.
+//
+// Once ctx is done, or RunTimeout has passed, Run starts no new Pennsieve requests, but lets those in flight finish.
+// It then returns with the Report, journal and metrics written, so that a later run can resume. A stopped run is
+// not rolled back.
+func (p *MetadataPostProcessor) Run(ctx context.Context) error {
+	if p.RunTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, p.RunTimeout, ErrRunTimeout)
+		defer cancel()
+	}
+	return p.traced(ctx, "Run", p.run)
+}
+
+func (p *MetadataPostProcessor) run(ctx context.Context) (err error) {
+	defer p.startMetrics()()
+	defer func() {
+		p.Report.Finish(err)
+		if writeErr := writeReportFile(p.reportFilePath(), p.Report); writeErr != nil {
+			err = errors.Join(err, writeErr)
+			return
+		}
+		logger.Info("wrote report file", slog.String("path", p.reportFilePath()))
+	}()
+	if err := p.startJournal(); err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, p.stopJournal())
+	}()
+	if err := p.process(ctx); err != nil {
+		if ctx.Err() != nil {
+			p.Report.setStopped()
+			logger.Warn("run stopped before finishing", slog.Any("cause", context.Cause(ctx)))
+			return err
+		}
+		if p.RollbackOnFailure {
+			return errors.Join(err, p.rollback(ctx))
+		}
+		return err
+	}
+	return nil
+}
+
+// process walks through the phases of the changeset. Shared by Run and Plan.
+func (p *MetadataPostProcessor) process(ctx context.Context) error {
+	if p.streaming() {
+		return p.processStream(ctx)
 	}
-	datasetID := integration.DatasetNodeID
-	logger.Info("starting metadata processing", slog.String("datasetID", datasetID))
 	datasetChanges, err := readChangesetFile(p.changesetFilePath())
 	if err != nil {
 		return err
 	}
 	logger.Info("read dataset changeset file", slog.String("path", p.changesetFilePath()))
+	// Validate before any API calls so that an invalid changeset does not leave the dataset half changed
+	if err := validation.Validate(datasetChanges); err != nil {
+		return fmt.Errorf("invalid changeset file %s: %w", p.changesetFilePath(), err)
+	}
+	datasetID, err := p.getDatasetID(ctx)
+	if err != nil {
+		return err
+	}
 	// initialize the IDStore with model name -> id map for existing models
 	// If we create models in this changeset, those name -> id entries will be added as well
 	p.IDStore.AddModels(datasetChanges.ExistingModelIDMap)
-	if err := p.ProcessDeletes(datasetID, datasetChanges); err != nil {
+	// RecordIDMaps of existing models are needed as soon as the deletes, for example to find the record of a proxy
+	// delete. Those of models created by this changeset can only be added once the models are created.
+	existingRecordIDMaps, createdRecordIDMaps := p.IDStore.splitRecordIDMaps(datasetChanges.RecordIDMaps)
+	if err := p.IDStore.AddRecordIDMaps(existingRecordIDMaps); err != nil {
+		return err
+	}
+	if err := p.runPhase(ctx, DeletesPhase, func(ctx context.Context) error {
+		return p.ProcessDeletes(ctx, datasetID, datasetChanges)
+	}); err != nil {
 		return err
 	}
-	if err := p.ProcessModelCreatesUpdates(datasetID, datasetChanges.Models.Creates, datasetChanges.Models.Updates); err != nil {
+	if err := p.runPhase(ctx, ModelChangesPhase, func(ctx context.Context) error {
+		return p.ProcessModelCreatesUpdates(ctx, datasetID, datasetChanges.Models.Creates, datasetChanges.Models.Updates)
+	}); err != nil {
 		return err
 	}
 	// Wait til after ProcessModels to add these so that the IDStore now should have the complete mapping
 	// of model names to model IDs for any models that were created.
-	// ProcessLinks and ProcessProxies will need these record ID maps.
-	if err := p.IDStore.AddRecordIDMaps(datasetChanges.RecordIDMaps); err != nil {
+	// ProcessLinks, ProcessRelationships, and ProcessProxies will need these record ID maps.
+	if err := p.IDStore.AddRecordIDMaps(createdRecordIDMaps); err != nil {
+		return err
+	}
+	if err := p.runPhase(ctx, LinksPhase, func(ctx context.Context) error {
+		return p.ProcessLinks(ctx, datasetID, datasetChanges.LinkedProperties)
+	}); err != nil {
 		return err
 	}
-	if err := p.ProcessLinks(datasetID, datasetChanges.LinkedProperties); err != nil {
+	if err := p.runPhase(ctx, RelationshipsPhase, func(ctx context.Context) error {
+		return p.ProcessRelationships(ctx, datasetID, datasetChanges.Relationships)
+	}); err != nil {
 		return err
 	}
-	if err := p.ProcessProxyChanges(datasetID, datasetChanges.Proxies); err != nil {
+	if err := p.runPhase(ctx, ProxiesPhase, func(ctx context.Context) error {
+		return p.ProcessProxyChanges(ctx, datasetID, datasetChanges.Proxies)
+	}); err != nil {
 		return err
 	}
 	logger.Info("finished metadata processing")
 	return nil
 }
 
-func (p *MetadataPostProcessor) ProcessDeletes(datasetID string, datasetChanges clientmodels.Dataset) error {
-	// Delete dependent objects, links and proxies before deleting records
+// getDatasetID looks up the dataset of the integration and starts the Report for it
+func (p *MetadataPostProcessor) getDatasetID(ctx context.Context) (string, error) {
+	integration, err := p.Pennsieve.GetIntegration(ctx, p.IntegrationID)
+	if err != nil {
+		return "", fmt.Errorf("error getting integration %s from Pennsieve: %w", p.IntegrationID, err)
+	}
+	datasetID := integration.DatasetNodeID
+	p.Report.setDatasetID(datasetID)
+	trace.SpanFromContext(ctx).SetAttributes(pennsieve.DatasetIDKey.String(datasetID))
+	logger.Info("starting metadata processing", slog.String("datasetID", datasetID))
+	return datasetID, nil
+}
+
+func (p *MetadataPostProcessor) ProcessDeletes(ctx context.Context, datasetID string, datasetChanges clientmodels.Dataset) error {
+	// Delete dependent objects, links, relationships and proxies before deleting records
 	logger.Info("starting deletes")
-	if err := p.ProcessLinkInstanceDeletes(datasetID, datasetChanges.LinkedProperties); err != nil {
+	if err := p.ProcessLinkInstanceDeletes(ctx, datasetID, datasetChanges.LinkedProperties); err != nil {
+		return err
+	}
+	// Link schemas can only be deleted once they have no instances
+	if err := p.ProcessLinkSchemaDeletes(ctx, datasetID, datasetChanges.LinkedProperties); err != nil {
+		return err
+	}
+	if err := p.ProcessRelationshipInstanceDeletes(ctx, datasetID, datasetChanges.Relationships); err != nil {
 		return err
 	}
 	if proxyChanges := datasetChanges.Proxies; proxyChanges != nil {
-		if err := p.ProcessProxyInstanceDeletes(datasetID, *proxyChanges); err != nil {
+		if err := p.ProcessProxyInstanceDeletes(ctx, datasetID, *proxyChanges); err != nil {
 			return err
 		}
 	}
-	if err := p.ProcessRecordModelDeletes(datasetID, datasetChanges.Models.Updates, datasetChanges.Models.Deletes); err != nil {
+	if err := p.ProcessRecordModelDeletes(ctx, datasetID, datasetChanges.Models.Updates, datasetChanges.Models.Deletes); err != nil {
 		return err
 	}
 	logger.Info("finished deletes")
 	return nil
 }
 
+// runPhase runs phaseFunc as the named phase of the Report, in a span of the same name, and then saves a checkpoint.
+func (p *MetadataPostProcessor) runPhase(ctx context.Context, name string, phaseFunc func(ctx context.Context) error) error {
+	phaseCtx, endSpan := p.startSpan(ctx, name, PhaseAttribute.String(name))
+	err := p.Report.Phase(name, func() error {
+		return phaseFunc(phaseCtx)
+	})
+	endSpan(err)
+	if err != nil {
+		return err
+	}
+	return p.checkpoint()
+}
+
 // ChangesetFilePath joins the given output directory with the
 // changeset file name.
 // Visible for testing.
@@ -102,7 +260,11 @@ func ChangesetFilePath(outputDirectory string) string {
 	return filepath.Join(outputDirectory, client.Filename)
 }
 
+// changesetFilePath returns the path of the streamed changeset if there is one, otherwise the path of the changeset
 func (p *MetadataPostProcessor) changesetFilePath() string {
+	if p.streaming() {
+		return p.streamChangesetFilePath()
+	}
 	return ChangesetFilePath(p.OutputDirectory)
 }
 
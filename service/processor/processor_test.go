@@ -1,10 +1,12 @@
 package processor_test
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/google/uuid"
 	"github.com/pennsieve/processor-post-metadata/client/clienttest"
 	clientmodels "github.com/pennsieve/processor-post-metadata/client/models"
+	"github.com/pennsieve/processor-post-metadata/client/validation"
 	"github.com/pennsieve/processor-post-metadata/service/internal/test/mock"
 	"github.com/pennsieve/processor-post-metadata/service/internal/test/mock/expectedcalls"
 	"github.com/pennsieve/processor-post-metadata/service/models"
@@ -23,6 +25,9 @@ func TestCurationExportSyncProcessor_Run(t *testing.T) {
 		"create model and record":                  testCreateModelAndRecord,
 		"create link between two existing records": testCreateLinkBetweenTwoExistingRecords,
 		"link package to existing record":          testLinkPackageToExistingRecord,
+		"invalid changeset makes no API calls":     testInvalidChangeset,
+		"delete proxy of existing record":          testDeleteProxyOfExistingRecord,
+		"record ID map of created model":           testRecordIDMapOfCreatedModel,
 	} {
 		t.Run(scenario, func(t *testing.T) {
 			testFunc(t)
@@ -47,7 +52,44 @@ func testEmptyChangeset(t *testing.T) {
 		WithOutputDirectory(outputDirectory).
 		Build(t, mockServer.URL())
 
-	require.NoError(t, testProcessor.Run())
+	require.NoError(t, testProcessor.Run(context.Background()))
+
+	mockServer.AssertAllCalledExactlyOnce(t)
+}
+
+func testInvalidChangeset(t *testing.T) {
+	integrationID := uuid.NewString()
+	outputDirectory := t.TempDir()
+
+	modelID := clienttest.NewPennsieveSchemaID()
+	changeset := clientmodels.Dataset{
+		Models: clientmodels.ModelChanges{
+			Updates: []clientmodels.ModelUpdate{{
+				ID:      modelID,
+				Records: clientmodels.RecordChanges{Delete: []clientmodels.PennsieveInstanceID{clienttest.NewPennsieveInstanceID()}},
+			}},
+		},
+		// link references models that are neither existing nor created, and has no ID or Create
+		LinkedProperties: []clientmodels.LinkedPropertyChanges{{
+			FromModelName: uuid.NewString(),
+			ToModelName:   uuid.NewString(),
+		}},
+	}
+	writeChangeset(t, changeset, processor.ChangesetFilePath(outputDirectory))
+
+	// not even the integration is requested
+	mockServer := mock.NewModelService(t)
+	defer mockServer.Close()
+
+	testProcessor := processortest.NewBuilder().
+		WithIntegrationID(integrationID).
+		WithOutputDirectory(outputDirectory).
+		Build(t, mockServer.URL())
+
+	err := testProcessor.Run(context.Background())
+	var validationErr *validation.Error
+	require.ErrorAs(t, err, &validationErr)
+	assert.Len(t, validationErr.Problems, 3)
 
 	mockServer.AssertAllCalledExactlyOnce(t)
 }
@@ -105,7 +147,7 @@ func testCreateModelAndRecord(t *testing.T) {
 		WithOutputDirectory(outputDirectory).
 		Build(t, mockServer.URL())
 
-	require.NoError(t, testProcessor.Run())
+	require.NoError(t, testProcessor.Run(context.Background()))
 
 	mockServer.AssertAllCalledExactlyOnce(t)
 
@@ -188,7 +230,7 @@ func testCreateLinkBetweenTwoExistingRecords(t *testing.T) {
 		WithOutputDirectory(outputDirectory).
 		Build(t, mockServer.URL())
 
-	require.NoError(t, testProcessor.Run())
+	require.NoError(t, testProcessor.Run(context.Background()))
 
 	mockServer.AssertAllCalledExactlyOnce(t)
 }
@@ -243,7 +285,116 @@ func testLinkPackageToExistingRecord(t *testing.T) {
 		WithOutputDirectory(outputDirectory).
 		Build(t, mockServer.URL())
 
-	require.NoError(t, testProcessor.Run())
+	require.NoError(t, testProcessor.Run(context.Background()))
+
+	mockServer.AssertAllCalledExactlyOnce(t)
+}
+
+func testDeleteProxyOfExistingRecord(t *testing.T) {
+	integrationID := uuid.NewString()
+	datasetID := processortest.NewDatasetID()
+	outputDirectory := t.TempDir()
+
+	modelName := uuid.NewString()
+	modelID := clienttest.NewPennsieveSchemaID()
+
+	targetExternalID := clienttest.NewExternalInstanceID()
+	targetRecordID := clienttest.NewPennsieveInstanceID()
+
+	proxyID := clienttest.NewPennsieveInstanceID()
+
+	// the record of a proxy delete is found through RecordIDMaps, which the deletes phase needs
+	changeset := clientmodels.Dataset{
+		ExistingModelIDMap: map[string]clientmodels.PennsieveSchemaID{
+			modelName: modelID,
+		},
+		RecordIDMaps: []clientmodels.RecordIDMap{
+			{
+				ModelName: modelName,
+				ExternalToPennsieve: map[clientmodels.ExternalInstanceID]clientmodels.PennsieveInstanceID{
+					targetExternalID: targetRecordID,
+				},
+			},
+		},
+		Proxies: &clientmodels.ProxyChanges{
+			RecordChanges: []clientmodels.ProxyRecordChanges{
+				{
+					ModelName:         modelName,
+					RecordExternalID:  targetExternalID,
+					InstanceIDDeletes: []clientmodels.PennsieveInstanceID{proxyID},
+				},
+			},
+		},
+	}
+	changesetFilePath := processor.ChangesetFilePath(outputDirectory)
+	writeChangeset(t, changeset, changesetFilePath)
+
+	mockServer := mock.NewModelService(t,
+		expectedcalls.GetIntegration(integrationID, datasetID),
+		expectedcalls.DeleteProxyInstances(datasetID, models.NewDeleteProxyInstancesBody(targetRecordID, proxyID)),
+	)
+	defer mockServer.Close()
+
+	testProcessor := processortest.NewBuilder().
+		WithIntegrationID(integrationID).
+		WithOutputDirectory(outputDirectory).
+		Build(t, mockServer.URL())
+
+	require.NoError(t, testProcessor.Run(context.Background()))
+
+	mockServer.AssertAllCalledExactlyOnce(t)
+}
+
+func testRecordIDMapOfCreatedModel(t *testing.T) {
+	integrationID := uuid.NewString()
+	datasetID := processortest.NewDatasetID()
+	outputDirectory := t.TempDir()
+
+	modelID := clienttest.NewPennsieveSchemaID()
+	modelCreate := clienttest.NewModelCreate()
+	externalID := clienttest.NewExternalInstanceID()
+	recordID := clienttest.NewPennsieveInstanceID()
+	packageNodeID := NewPackageNodeID()
+
+	// the RecordIDMap can only be added once the model is created
+	changeset := clientmodels.Dataset{
+		Models: clientmodels.ModelChanges{
+			Creates: []clientmodels.ModelCreate{{Create: clientmodels.ModelPropsCreate{Model: modelCreate}}},
+		},
+		RecordIDMaps: []clientmodels.RecordIDMap{
+			{
+				ModelName: modelCreate.Name,
+				ExternalToPennsieve: map[clientmodels.ExternalInstanceID]clientmodels.PennsieveInstanceID{
+					externalID: recordID,
+				},
+			},
+		},
+		Proxies: &clientmodels.ProxyChanges{
+			RecordChanges: []clientmodels.ProxyRecordChanges{
+				{
+					ModelName:        modelCreate.Name,
+					RecordExternalID: externalID,
+					NodeIDCreates:    []string{packageNodeID},
+				},
+			},
+		},
+	}
+	changesetFilePath := processor.ChangesetFilePath(outputDirectory)
+	writeChangeset(t, changeset, changesetFilePath)
+
+	mockServer := mock.NewModelService(t,
+		expectedcalls.GetIntegration(integrationID, datasetID),
+		expectedcalls.ModelCreate(datasetID, modelID, modelCreate),
+		expectedcalls.CreateProxyInstance(datasetID, models.NewCreateProxyInstanceBody(recordID, packageNodeID)),
+	)
+	defer mockServer.Close()
+
+	testProcessor := processortest.NewBuilder().
+		WithIntegrationID(integrationID).
+		WithOutputDirectory(outputDirectory).
+		Build(t, mockServer.URL())
+
+	require.NoError(t, testProcessor.Run(context.Background()))
 
 	mockServer.AssertAllCalledExactlyOnce(t)
 }
@@ -1,26 +1,35 @@
 package processor
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	clientmodels "github.com/pennsieve/processor-post-metadata/client/models"
 	"github.com/pennsieve/processor-post-metadata/service/models"
+	"github.com/pennsieve/processor-post-metadata/service/pennsieve"
+	"github.com/pennsieve/processor-post-metadata/service/util"
 	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
-func (p *MetadataPostProcessor) ProcessProxyInstanceDeletes(datasetID string, proxyChanges clientmodels.ProxyChanges) error {
+func (p *MetadataPostProcessor) ProcessProxyInstanceDeletes(ctx context.Context, datasetID string, proxyChanges clientmodels.ProxyChanges) error {
 	if len(proxyChanges.RecordChanges) == 0 {
 		logger.Info("no proxy deletes")
 		return nil
 	}
 	for _, proxyRecordChanges := range proxyChanges.RecordChanges {
-		if err := p.ProcessProxyRecordChangesDeletes(datasetID, proxyRecordChanges); err != nil {
+		if err := p.ProcessProxyRecordChangesDeletes(ctx, datasetID, proxyRecordChanges); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (p *MetadataPostProcessor) ProcessProxyRecordChangesDeletes(datasetID string, proxyRecordChanges clientmodels.ProxyRecordChanges) error {
+func (p *MetadataPostProcessor) ProcessProxyRecordChangesDeletes(ctx context.Context, datasetID string, proxyRecordChanges clientmodels.ProxyRecordChanges) error {
 	proxyLogger := logger.With(slog.Any("modelName", proxyRecordChanges.ModelName))
 	if len(proxyRecordChanges.InstanceIDDeletes) == 0 {
 		proxyLogger.Info("no proxy deletes")
@@ -33,17 +42,33 @@ func (p *MetadataPostProcessor) ProcessProxyRecordChangesDeletes(datasetID strin
 	}
 	proxyLogger = proxyLogger.With(slog.Any("targetRecordID", targetRecordID))
 	body := models.NewDeleteProxyInstancesBody(targetRecordID, proxyRecordChanges.InstanceIDDeletes...)
-	if err := p.Pennsieve.DeleteProxyInstances(datasetID, body); err != nil {
+	_, skipped, err := p.journaled(deleteProxiesOperation(targetRecordID, proxyRecordChanges.InstanceIDDeletes), func() (string, error) {
+		return "", p.Pennsieve.DeleteProxyInstances(ctx, datasetID, body)
+	})
+	if err != nil {
 		return fmt.Errorf("error deleting proxy instances for model %s record %s: %w",
 			proxyRecordChanges.ModelName,
 			targetRecordID,
 			err)
 	}
+	if skipped {
+		proxyLogger.Info("proxy deletes completed by earlier run")
+		return nil
+	}
+	for _, proxyID := range proxyRecordChanges.InstanceIDDeletes {
+		p.Report.Add(ReportEntry{
+			Type:       ProxyEntity,
+			Action:     Deleted,
+			ID:         string(proxyID),
+			ExternalID: proxyRecordChanges.RecordExternalID,
+			RecordID:   targetRecordID,
+		})
+	}
 	proxyLogger.Info("finished proxy deletes", slog.Int("count", len(proxyRecordChanges.InstanceIDDeletes)))
 	return nil
 }
 
-func (p *MetadataPostProcessor) ProcessProxyChanges(datasetID string, proxyChanges *clientmodels.ProxyChanges) error {
+func (p *MetadataPostProcessor) ProcessProxyChanges(ctx context.Context, datasetID string, proxyChanges *clientmodels.ProxyChanges) error {
 	if proxyChanges == nil {
 		logger.Info("no proxy changes")
 		return nil
@@ -51,37 +76,129 @@ func (p *MetadataPostProcessor) ProcessProxyChanges(datasetID string, proxyChang
 	logger.Info("starting proxy changes")
 	if proxyChanges.CreateProxyRelationshipSchema {
 		logger.Info("creating proxy relationship schema")
-		_, err := p.Pennsieve.CreateProxyRelationshipSchema(datasetID)
+		schemaID, skipped, err := p.journaled(createProxyRelationshipSchemaOperation(), func() (string, error) {
+			schemaID, err := p.Pennsieve.CreateProxyRelationshipSchema(ctx, datasetID)
+			return schemaID.String(), err
+		})
 		if err != nil {
 			return fmt.Errorf("error creating proxy relationship schema: %w", err)
 		}
+		if !skipped {
+			p.Report.Add(ReportEntry{Type: RelationshipSchemaEntity, Action: Created, ID: schemaID, Name: models.ProxyRelationshipSchemaName})
+		}
 	}
-	if err := p.ProcessProxyRecordChanges(datasetID, proxyChanges.RecordChanges); err != nil {
+	if err := p.createProxyRelationshipSchemas(ctx, datasetID, *proxyChanges); err != nil {
+		return err
+	}
+	if err := p.ProcessProxyRecordChanges(ctx, datasetID, proxyChanges.RecordChanges); err != nil {
 		return err
 	}
 	logger.Info("finished proxy changes")
 	return nil
 }
 
-func (p *MetadataPostProcessor) ProcessProxyRecordChanges(datasetID string, proxyRecordChanges []clientmodels.ProxyRecordChanges) error {
+// createProxyRelationshipSchemas creates the relationship schemas of the relationship types other than "belongs_to"
+// used by PackageCreates. A RelationshipSchemaCreate supplies the display name, description and models of its schema.
+// A type without one is created with a display name made from the type, unless Pennsieve already has it.
+func (p *MetadataPostProcessor) createProxyRelationshipSchemas(ctx context.Context, datasetID string, proxyChanges clientmodels.ProxyChanges) error {
+	declared := map[string]bool{}
+	for _, schemaCreate := range proxyChanges.RelationshipSchemaCreates {
+		declared[schemaCreate.Name] = true
+		if _, err := p.CreateRelationshipSchemaIfNecessary(ctx, datasetID, clientmodels.RelationshipChanges{Create: &schemaCreate}); err != nil {
+			return fmt.Errorf("error creating proxy relationship schema %s: %w", schemaCreate.Name, err)
+		}
+	}
+	for _, relationshipType := range proxyRelationshipTypes(proxyChanges.RecordChanges) {
+		if declared[relationshipType] {
+			continue
+		}
+		schemaCreate := clientmodels.RelationshipSchemaCreate{
+			Name:        relationshipType,
+			DisplayName: relationshipDisplayName(relationshipType),
+		}
+		_, err := p.CreateRelationshipSchemaIfNecessary(ctx, datasetID, clientmodels.RelationshipChanges{Create: &schemaCreate})
+		var statusErr *util.HTTPStatusError
+		if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusConflict {
+			logger.Info("proxy relationship schema already exists", slog.String("relationshipName", relationshipType))
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error creating proxy relationship schema %s: %w", relationshipType, err)
+		}
+	}
+	return nil
+}
+
+// proxyRelationshipTypes returns the relationship types other than "belongs_to" of the PackageCreates and their
+// AdditionalTargets, each once, in the order they are first used
+func proxyRelationshipTypes(proxyRecordChanges []clientmodels.ProxyRecordChanges) []string {
+	var relationshipTypes []string
+	add := func(relationship clientmodels.ProxyRelationship) {
+		relationshipType := relationship.RelationshipTypeOrDefault()
+		if relationshipType != clientmodels.ProxyRelationshipSchemaName && !slices.Contains(relationshipTypes, relationshipType) {
+			relationshipTypes = append(relationshipTypes, relationshipType)
+		}
+	}
+	for _, recordChanges := range proxyRecordChanges {
+		for _, packageCreate := range recordChanges.PackageCreates {
+			add(packageCreate.ProxyRelationship)
+			for _, target := range packageCreate.AdditionalTargets {
+				add(target.ProxyRelationship)
+			}
+		}
+	}
+	return relationshipTypes
+}
+
+// relationshipDisplayName makes a display name from a relationship type, for example "Derived From" from
+// "derived_from"
+func relationshipDisplayName(relationshipType string) string {
+	words := strings.FieldsFunc(relationshipType, func(r rune) bool { return r == '_' || r == '-' || r == ' ' })
+	for i, word := range words {
+		first, size := utf8.DecodeRuneInString(word)
+		words[i] = string(unicode.ToUpper(first)) + word[size:]
+	}
+	return strings.Join(words, " ")
+}
+
+// ProcessProxyRecordChanges creates the package proxies of each record. If ProxyBatchSize > 1, the proxies of the
+// NodeIDCreates of all the records are created first, in batches that may mix records.
+func (p *MetadataPostProcessor) ProcessProxyRecordChanges(ctx context.Context, datasetID string, proxyRecordChanges []clientmodels.ProxyRecordChanges) error {
 	if len(proxyRecordChanges) == 0 {
 		logger.Info("no proxy changes")
 		return nil
 	}
+	if p.ProxyBatchSize > 1 {
+		if err := p.createProxiesInBatches(ctx, datasetID, proxyRecordChanges); err != nil {
+			return err
+		}
+	}
 	for _, changes := range proxyRecordChanges {
-		if err := p.ProcessProxyInstanceCreates(datasetID, changes.ModelName, changes.RecordExternalID, changes.NodeIDCreates); err != nil {
+		if p.ProxyBatchSize <= 1 {
+			if err := p.ProcessProxyInstanceCreates(ctx, datasetID, changes.ModelName, changes.RecordExternalID, changes.NodeIDCreates); err != nil {
+				return err
+			}
+		}
+		if err := p.ProcessProxyPackageCreates(ctx, datasetID, changes.ModelName, changes.RecordExternalID, changes.PackageCreates); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (p *MetadataPostProcessor) ProcessProxyInstanceCreates(datasetID string, modelName string, recordExternalID clientmodels.ExternalInstanceID, packageNodeIDs []string) error {
+func (p *MetadataPostProcessor) ProcessProxyInstanceCreates(ctx context.Context, datasetID string, modelName string, recordExternalID clientmodels.ExternalInstanceID, packageNodeIDs []string) error {
 	proxyLogger := logger.With(slog.Any("modelName", modelName))
 	if len(packageNodeIDs) == 0 {
 		proxyLogger.Info("no proxy creates")
 		return nil
 	}
+	if p.ProxyBatchSize > 1 {
+		return p.createProxiesInBatches(ctx, datasetID, []clientmodels.ProxyRecordChanges{{
+			ModelName:        modelName,
+			RecordExternalID: recordExternalID,
+			NodeIDCreates:    packageNodeIDs,
+		}})
+	}
 	proxyLogger.Info("starting proxy creates")
 	targetRecordID, err := p.lookupTargetID(modelName, recordExternalID)
 	if err != nil {
@@ -90,18 +207,204 @@ func (p *MetadataPostProcessor) ProcessProxyInstanceCreates(datasetID string, mo
 	proxyLogger = proxyLogger.With(slog.Any("targetRecordID", targetRecordID))
 	for _, packageID := range packageNodeIDs {
 		body := models.NewCreateProxyInstanceBody(targetRecordID, packageID)
-		if err := p.Pennsieve.CreateProxyInstance(datasetID, body); err != nil {
+		proxyID, skipped, err := p.journaled(createProxyOperation(targetRecordID, packageID), func() (string, error) {
+			proxyIDs, err := p.Pennsieve.CreateProxyInstance(recordContext(ctx, recordExternalID), datasetID, body)
+			if len(proxyIDs) == 0 {
+				return "", err
+			}
+			return string(proxyIDs[0]), err
+		})
+		if err != nil {
 			return fmt.Errorf("error creating proxy instance for model %s record %s package %s: %w",
 				modelName,
 				targetRecordID,
 				packageID,
 				err)
 		}
+		if skipped {
+			continue
+		}
+		p.Report.Add(ReportEntry{
+			Type:          ProxyEntity,
+			Action:        Created,
+			ID:            proxyID,
+			ExternalID:    recordExternalID,
+			RecordID:      targetRecordID,
+			PackageNodeID: packageID,
+		})
 	}
 	proxyLogger.Info("finished proxy creates", slog.Int("count", len(packageNodeIDs)))
 	return nil
 }
 
+// proxyCreate is the proxy of one package to create for a record
+type proxyCreate struct {
+	target    proxyTarget
+	packageID string
+}
+
+// createProxiesInBatches creates the proxies of the NodeIDCreates of the records that were not created by an earlier
+// run, ProxyBatchSize packages per request. A batch may hold the packages of more than one record.
+func (p *MetadataPostProcessor) createProxiesInBatches(ctx context.Context, datasetID string, proxyRecordChanges []clientmodels.ProxyRecordChanges) error {
+	var pending []proxyCreate
+	count := 0
+	for _, changes := range proxyRecordChanges {
+		if len(changes.NodeIDCreates) == 0 {
+			continue
+		}
+		targetRecordID, err := p.lookupTargetID(changes.ModelName, changes.RecordExternalID)
+		if err != nil {
+			return fmt.Errorf("unable to create package proxies for model %s: %w", changes.ModelName, err)
+		}
+		target := proxyTarget{externalID: changes.RecordExternalID, recordID: targetRecordID}
+		for _, packageID := range changes.NodeIDCreates {
+			count++
+			if p.journal != nil {
+				if _, completed := p.journal.Completed(createProxyOperation(targetRecordID, packageID)); completed {
+					continue
+				}
+			}
+			pending = append(pending, proxyCreate{target: target, packageID: packageID})
+		}
+	}
+	if count == 0 {
+		logger.Info("no proxy creates")
+		return nil
+	}
+	logger.Info("starting proxy creates", slog.Int("count", count), slog.Int("batchSize", p.ProxyBatchSize))
+	batchSize := p.ProxyBatchSize
+	for start := 0; start < len(pending); start += batchSize {
+		if err := p.createProxyBatch(ctx, datasetID, pending[start:min(start+batchSize, len(pending))]); err != nil {
+			return fmt.Errorf("error creating proxy instances: %w", err)
+		}
+	}
+	logger.Info("finished proxy creates", slog.Int("count", count), slog.Int("batchSize", batchSize))
+	return nil
+}
+
+// createProxyBatch creates the proxies of the batch with one request. Proxies that were created are journaled and
+// reported even if others in the batch failed, and each package whose proxy was not created is reported as Failed
+// with the reason Pennsieve gave.
+func (p *MetadataPostProcessor) createProxyBatch(ctx context.Context, datasetID string, batch []proxyCreate) error {
+	bodies := make([]models.CreateProxyInstanceBody, len(batch))
+	for i, create := range batch {
+		bodies[i] = models.NewCreateProxyInstanceBody(create.target.recordID, create.packageID)
+	}
+	proxyIDs, createErr := p.Pennsieve.CreateProxyInstances(ctx, datasetID, bodies)
+	errs := []error{createErr}
+	var proxiesErr *pennsieve.ProxyInstancesError
+	errors.As(createErr, &proxiesErr)
+	for i, proxyID := range proxyIDs {
+		create := batch[i]
+		if len(proxyID) == 0 {
+			if proxiesErr != nil && len(proxiesErr.Reasons[i]) > 0 {
+				p.Report.Add(ReportEntry{
+					Type:          ProxyEntity,
+					Action:        Failed,
+					ExternalID:    create.target.externalID,
+					RecordID:      create.target.recordID,
+					PackageNodeID: create.packageID,
+					Error:         proxiesErr.Reasons[i],
+				})
+			}
+			continue
+		}
+		if p.journal != nil {
+			errs = append(errs, p.journal.Append(createProxyOperation(create.target.recordID, create.packageID), string(proxyID)))
+		}
+		p.Report.Add(ReportEntry{
+			Type:          ProxyEntity,
+			Action:        Created,
+			ID:            string(proxyID),
+			ExternalID:    create.target.externalID,
+			RecordID:      create.target.recordID,
+			PackageNodeID: create.packageID,
+		})
+	}
+	return errors.Join(errs...)
+}
+
+// proxyTarget is a record that a ProxyPackageCreate links its package to
+type proxyTarget struct {
+	externalID clientmodels.ExternalInstanceID
+	recordID   clientmodels.PennsieveInstanceID
+}
+
+// ProcessProxyPackageCreates creates one proxy instance request per package, targeting the record given by modelName
+// and recordExternalID, and any additional targets of the package
+func (p *MetadataPostProcessor) ProcessProxyPackageCreates(ctx context.Context, datasetID string, modelName string, recordExternalID clientmodels.ExternalInstanceID, packageCreates []clientmodels.ProxyPackageCreate) error {
+	if len(packageCreates) == 0 {
+		return nil
+	}
+	proxyLogger := logger.With(slog.Any("modelName", modelName))
+	proxyLogger.Info("starting proxy package creates")
+	targetRecordID, err := p.lookupTargetID(modelName, recordExternalID)
+	if err != nil {
+		return fmt.Errorf("unable to create package proxies for model %s: %w", modelName, err)
+	}
+	proxyLogger = proxyLogger.With(slog.Any("targetRecordID", targetRecordID))
+	for _, packageCreate := range packageCreates {
+		if err := p.createProxyPackage(ctx, datasetID, proxyTarget{externalID: recordExternalID, recordID: targetRecordID}, packageCreate); err != nil {
+			return err
+		}
+	}
+	proxyLogger.Info("finished proxy package creates", slog.Int("count", len(packageCreates)))
+	return nil
+}
+
+func (p *MetadataPostProcessor) createProxyPackage(ctx context.Context, datasetID string, primaryTarget proxyTarget, packageCreate clientmodels.ProxyPackageCreate) error {
+	targets := []proxyTarget{primaryTarget}
+	bodyTargets := []models.CreateProxyInstanceTarget{models.NewCreateProxyInstanceTarget(primaryTarget.recordID, packageCreate.ProxyRelationship)}
+	for _, additionalTarget := range packageCreate.AdditionalTargets {
+		recordID, err := p.lookupTargetID(additionalTarget.ModelName, additionalTarget.RecordExternalID)
+		if err != nil {
+			return fmt.Errorf("unable to create package proxy for package %s and model %s: %w",
+				packageCreate.NodeID,
+				additionalTarget.ModelName,
+				err)
+		}
+		targets = append(targets, proxyTarget{externalID: additionalTarget.RecordExternalID, recordID: recordID})
+		bodyTargets = append(bodyTargets, models.NewCreateProxyInstanceTarget(recordID, additionalTarget.ProxyRelationship))
+	}
+	body := models.NewCreateProxyInstanceBodyWithTargets(packageCreate.NodeID, bodyTargets...)
+	operationKey := createProxyPackageOperation(primaryTarget.recordID, packageCreate.NodeID, packageCreate.RelationshipTypeOrDefault())
+	// the journal holds one ID per operation, so the IDs of all the targets are joined
+	joinedIDs, skipped, err := p.journaled(operationKey, func() (string, error) {
+		proxyIDs, err := p.Pennsieve.CreateProxyInstance(recordContext(ctx, primaryTarget.externalID), datasetID, body)
+		ids := make([]string, len(proxyIDs))
+		for i, proxyID := range proxyIDs {
+			ids[i] = string(proxyID)
+		}
+		return strings.Join(ids, ","), err
+	})
+	if err != nil {
+		return fmt.Errorf("error creating proxy instance for record %s package %s: %w",
+			primaryTarget.recordID,
+			packageCreate.NodeID,
+			err)
+	}
+	if skipped {
+		return nil
+	}
+	proxyIDs := strings.Split(joinedIDs, ",")
+	for i, target := range targets {
+		var proxyID string
+		if i < len(proxyIDs) {
+			proxyID = proxyIDs[i]
+		}
+		p.Report.Add(ReportEntry{
+			Type:          ProxyEntity,
+			Action:        Created,
+			ID:            proxyID,
+			ExternalID:    target.externalID,
+			Name:          bodyTargets[i].RelationshipType,
+			RecordID:      target.recordID,
+			PackageNodeID: packageCreate.NodeID,
+		})
+	}
+	return nil
+}
+
 func (p *MetadataPostProcessor) lookupTargetID(modelName string, targetExternalID clientmodels.ExternalInstanceID) (clientmodels.PennsieveInstanceID, error) {
 	modelID, err := p.IDStore.ModelID(modelName)
 	if err != nil {
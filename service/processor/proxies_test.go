@@ -1,6 +1,7 @@
 package processor_test
 
 import (
+	"context"
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/pennsieve/processor-post-metadata/client/clienttest"
@@ -10,6 +11,7 @@ import (
 	"github.com/pennsieve/processor-post-metadata/service/models"
 	"github.com/pennsieve/processor-post-metadata/service/processor"
 	"github.com/pennsieve/processor-post-metadata/service/processor/internal/processortest"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"testing"
 )
@@ -43,7 +45,7 @@ func noDeletesProxySchemaDoesNotExist(t *testing.T) {
 
 	testProcessor := processortest.NewBuilder().WithIDStore(initialIDStore).Build(t, mockServer.URL())
 
-	require.NoError(t, testProcessor.ProcessProxyInstanceDeletes(datasetID, clientmodels.ProxyChanges{
+	require.NoError(t, testProcessor.ProcessProxyInstanceDeletes(context.Background(), datasetID, clientmodels.ProxyChanges{
 		CreateProxyRelationshipSchema: true,
 		RecordChanges: []clientmodels.ProxyRecordChanges{
 			{
@@ -72,7 +74,7 @@ func noDeletesProxySchemaExists(t *testing.T) {
 
 	testProcessor := processortest.NewBuilder().WithIDStore(initialIDStore).Build(t, mockServer.URL())
 
-	require.NoError(t, testProcessor.ProcessProxyInstanceDeletes(datasetID, clientmodels.ProxyChanges{
+	require.NoError(t, testProcessor.ProcessProxyInstanceDeletes(context.Background(), datasetID, clientmodels.ProxyChanges{
 		CreateProxyRelationshipSchema: false,
 		RecordChanges: []clientmodels.ProxyRecordChanges{
 			{
@@ -124,7 +126,7 @@ func proxyDeletes(t *testing.T) {
 
 	testProcessor := processortest.NewBuilder().WithIDStore(initialIDStore).Build(t, mockServer.URL())
 
-	require.NoError(t, testProcessor.ProcessProxyInstanceDeletes(datasetID, clientmodels.ProxyChanges{
+	require.NoError(t, testProcessor.ProcessProxyInstanceDeletes(context.Background(), datasetID, clientmodels.ProxyChanges{
 		CreateProxyRelationshipSchema: false,
 		RecordChanges: []clientmodels.ProxyRecordChanges{
 			{
@@ -146,8 +148,13 @@ func proxyDeletes(t *testing.T) {
 
 func TestMetadataPostProcessor_ProcessProxyChanges(t *testing.T) {
 	for scenario, testFunc := range map[string]func(t *testing.T){
-		"create schema and instances":     createProxySchemaAndInstances,
-		"schema exists; create instances": proxySchemaExistsCreateInstances,
+		"create schema and instances":       createProxySchemaAndInstances,
+		"schema exists; create instances":   proxySchemaExistsCreateInstances,
+		"package creates with relationship": proxyPackageCreates,
+		"create instances in batches":       createProxyInstancesInBatches,
+		"batches span records":              proxyBatchesSpanRecords,
+		"batch create partial failure":      proxyBatchCreatePartialFailure,
+		"create without ids in response":    proxyCreateWithoutIDs,
 	} {
 		t.Run(scenario, func(t *testing.T) {
 			testFunc(t)
@@ -181,7 +188,7 @@ func createProxySchemaAndInstances(t *testing.T) {
 
 	testProcessor := processortest.NewBuilder().WithIDStore(initialIDStore).Build(t, mockServer.URL())
 
-	require.NoError(t, testProcessor.ProcessProxyChanges(datasetID, &clientmodels.ProxyChanges{
+	require.NoError(t, testProcessor.ProcessProxyChanges(context.Background(), datasetID, &clientmodels.ProxyChanges{
 		CreateProxyRelationshipSchema: true,
 		RecordChanges: []clientmodels.ProxyRecordChanges{
 			{
@@ -232,7 +239,7 @@ func proxySchemaExistsCreateInstances(t *testing.T) {
 
 	testProcessor := processortest.NewBuilder().WithIDStore(initialIDStore).Build(t, mockServer.URL())
 
-	require.NoError(t, testProcessor.ProcessProxyChanges(datasetID, &clientmodels.ProxyChanges{
+	require.NoError(t, testProcessor.ProcessProxyChanges(context.Background(), datasetID, &clientmodels.ProxyChanges{
 		CreateProxyRelationshipSchema: false,
 		RecordChanges: []clientmodels.ProxyRecordChanges{
 			{
@@ -253,6 +260,248 @@ func proxySchemaExistsCreateInstances(t *testing.T) {
 	mockServer.AssertAllCalledExactlyOnce(t)
 }
 
+func proxyPackageCreates(t *testing.T) {
+	datasetID := processortest.NewDatasetID()
+
+	modelName := uuid.NewString()
+	modelID := clienttest.NewPennsieveSchemaID()
+	targetExternalID := clienttest.NewExternalInstanceID()
+	targetRecordID := clienttest.NewPennsieveInstanceID()
+	target2ExternalID := clienttest.NewExternalInstanceID()
+	target2RecordID := clienttest.NewPennsieveInstanceID()
+
+	initialIDStore := processor.NewIDStoreBuilder().
+		WithModel(modelName, modelID).
+		WithRecord(modelID, targetExternalID, targetRecordID).
+		WithRecord(modelID, target2ExternalID, target2RecordID).
+		Build()
+
+	schemaCreate := clienttest.NewRelationshipSchemaCreate()
+	derivedFrom := clientmodels.ProxyRelationship{
+		RelationshipType: schemaCreate.Name,
+		Direction:        clientmodels.ToTarget,
+		RelationshipData: []clientmodels.RecordValue{{Name: "method", Value: "segmentation"}},
+	}
+	nodeID := NewPackageNodeID()
+
+	expectedCreateCalls := expectedcalls.CreateProxyInstance(datasetID,
+		models.NewCreateProxyInstanceBodyWithTargets(nodeID,
+			models.NewCreateProxyInstanceTarget(targetRecordID, derivedFrom),
+			models.NewCreateProxyInstanceTarget(target2RecordID, clientmodels.ProxyRelationship{}),
+		))
+	mockServer := mock.NewModelService(t,
+		expectedcalls.CreateRelationshipSchema(datasetID, models.NewCreateRelationshipSchemaBody(schemaCreate.Name, schemaCreate.DisplayName, schemaCreate.Description)),
+		expectedCreateCalls)
+	defer mockServer.Close()
+
+	testProcessor := processortest.NewBuilder().WithIDStore(initialIDStore).Build(t, mockServer.URL())
+
+	require.NoError(t, testProcessor.ProcessProxyChanges(context.Background(), datasetID, &clientmodels.ProxyChanges{
+		RelationshipSchemaCreates: []clientmodels.RelationshipSchemaCreate{schemaCreate},
+		RecordChanges: []clientmodels.ProxyRecordChanges{
+			{
+				ModelName:        modelName,
+				RecordExternalID: targetExternalID,
+				PackageCreates: []clientmodels.ProxyPackageCreate{{
+					NodeID:            nodeID,
+					ProxyRelationship: derivedFrom,
+					AdditionalTargets: []clientmodels.ProxyTarget{{ModelName: modelName, RecordExternalID: target2ExternalID}},
+				}},
+			},
+		},
+	}))
+
+	mockServer.AssertAllCalledExactlyOnce(t)
+
+	// one entry for the schema, and one proxy for each target
+	entries := testProcessor.Report.Entries
+	require.Len(t, entries, 3)
+	assert.Equal(t, processor.RelationshipSchemaEntity, entries[0].Type)
+	proxyIDs := expectedCreateCalls.Calls[0].APIResponse
+	assert.Equal(t, processor.ReportEntry{
+		Type:          processor.ProxyEntity,
+		Action:        processor.Created,
+		ID:            proxyIDs[0].ProxyInstance.ID,
+		ExternalID:    targetExternalID,
+		Name:          schemaCreate.Name,
+		RecordID:      targetRecordID,
+		PackageNodeID: nodeID,
+	}, entries[1])
+	assert.Equal(t, processor.ReportEntry{
+		Type:          processor.ProxyEntity,
+		Action:        processor.Created,
+		ID:            proxyIDs[1].ProxyInstance.ID,
+		ExternalID:    target2ExternalID,
+		Name:          models.ProxyRelationshipSchemaName,
+		RecordID:      target2RecordID,
+		PackageNodeID: nodeID,
+	}, entries[2])
+}
+
+// newProxyRecord returns an IDStore with one record, and the record's model name, external ID and Pennsieve ID
+func newProxyRecord() (*processor.IDStore, string, clientmodels.ExternalInstanceID, clientmodels.PennsieveInstanceID) {
+	modelName := uuid.NewString()
+	modelID := clienttest.NewPennsieveSchemaID()
+	targetExternalID := clienttest.NewExternalInstanceID()
+	targetRecordID := clienttest.NewPennsieveInstanceID()
+	idStore := processor.NewIDStoreBuilder().
+		WithModel(modelName, modelID).
+		WithRecord(modelID, targetExternalID, targetRecordID).
+		Build()
+	return idStore, modelName, targetExternalID, targetRecordID
+}
+
+func createProxyInstancesInBatches(t *testing.T) {
+	datasetID := processortest.NewDatasetID()
+	initialIDStore, modelName, targetExternalID, targetRecordID := newProxyRecord()
+
+	var nodeIDs []string
+	var bodies []models.CreateProxyInstanceBody
+	for i := 0; i < 5; i++ {
+		nodeID := NewPackageNodeID()
+		nodeIDs = append(nodeIDs, nodeID)
+		bodies = append(bodies, models.NewCreateProxyInstanceBody(targetRecordID, nodeID))
+	}
+	expectedBatchCalls := expectedcalls.CreateProxyInstances(datasetID, bodies[0:2], bodies[2:4], bodies[4:])
+	mockServer := mock.NewModelService(t, expectedBatchCalls)
+	defer mockServer.Close()
+
+	testProcessor := processortest.NewBuilder().
+		WithIDStore(initialIDStore).
+		WithProxyBatchSize(2).
+		Build(t, mockServer.URL())
+
+	require.NoError(t, testProcessor.ProcessProxyRecordChanges(context.Background(), datasetID, []clientmodels.ProxyRecordChanges{{
+		ModelName:        modelName,
+		RecordExternalID: targetExternalID,
+		NodeIDCreates:    nodeIDs,
+	}}))
+
+	mockServer.AssertAllCalledExactlyOnce(t)
+
+	entries := testProcessor.Report.Entries
+	require.Len(t, entries, len(nodeIDs))
+	for i, entry := range entries {
+		assert.Equal(t, processor.ProxyEntity, entry.Type)
+		assert.Equal(t, nodeIDs[i], entry.PackageNodeID)
+		assert.Equal(t, expectedBatchCalls.Calls[i/2].APIResponse[i%2].Proxies[0].ProxyInstance.ID, entry.ID)
+	}
+}
+
+func proxyBatchesSpanRecords(t *testing.T) {
+	datasetID := processortest.NewDatasetID()
+	modelName := uuid.NewString()
+	modelID := clienttest.NewPennsieveSchemaID()
+	idStoreBuilder := processor.NewIDStoreBuilder().WithModel(modelName, modelID)
+
+	var recordChanges []clientmodels.ProxyRecordChanges
+	var bodies []models.CreateProxyInstanceBody
+	for i := 0; i < 3; i++ {
+		externalID := clienttest.NewExternalInstanceID()
+		recordID := clienttest.NewPennsieveInstanceID()
+		idStoreBuilder = idStoreBuilder.WithRecord(modelID, externalID, recordID)
+		changes := clientmodels.ProxyRecordChanges{ModelName: modelName, RecordExternalID: externalID}
+		for j := 0; j < 2; j++ {
+			nodeID := NewPackageNodeID()
+			changes.NodeIDCreates = append(changes.NodeIDCreates, nodeID)
+			bodies = append(bodies, models.NewCreateProxyInstanceBody(recordID, nodeID))
+		}
+		recordChanges = append(recordChanges, changes)
+	}
+	// six packages of three records in two requests, rather than one request per record
+	mockServer := mock.NewModelService(t, expectedcalls.CreateProxyInstances(datasetID, bodies[0:4], bodies[4:]))
+	defer mockServer.Close()
+
+	testProcessor := processortest.NewBuilder().
+		WithIDStore(idStoreBuilder.Build()).
+		WithProxyBatchSize(4).
+		Build(t, mockServer.URL())
+
+	require.NoError(t, testProcessor.ProcessProxyRecordChanges(context.Background(), datasetID, recordChanges))
+
+	mockServer.AssertAllCalledExactlyOnce(t)
+
+	entries := testProcessor.Report.Entries
+	require.Len(t, entries, len(bodies))
+	for i, entry := range entries {
+		assert.Equal(t, processor.Created, entry.Action)
+		assert.Equal(t, recordChanges[i/2].RecordExternalID, entry.ExternalID)
+		assert.Equal(t, recordChanges[i/2].NodeIDCreates[i%2], entry.PackageNodeID)
+	}
+}
+
+func proxyBatchCreatePartialFailure(t *testing.T) {
+	datasetID := processortest.NewDatasetID()
+	initialIDStore, modelName, targetExternalID, targetRecordID := newProxyRecord()
+
+	nodeIDs := []string{NewPackageNodeID(), NewPackageNodeID(), NewPackageNodeID()}
+	var bodies []models.CreateProxyInstanceBody
+	for _, nodeID := range nodeIDs {
+		bodies = append(bodies, models.NewCreateProxyInstanceBody(targetRecordID, nodeID))
+	}
+	expectedBatchCall := expectedcalls.CreateProxyInstances(datasetID, bodies)
+	// second package fails
+	expectedBatchCall.Calls[0].APIResponse[1] = models.BatchCreateProxyInstancesEntry{Error: "package not found"}
+
+	mockServer := mock.NewModelService(t, expectedBatchCall)
+	defer mockServer.Close()
+
+	testProcessor := processortest.NewBuilder().
+		WithIDStore(initialIDStore).
+		WithProxyBatchSize(10).
+		Build(t, mockServer.URL())
+
+	err := testProcessor.ProcessProxyRecordChanges(context.Background(), datasetID, []clientmodels.ProxyRecordChanges{{
+		ModelName:        modelName,
+		RecordExternalID: targetExternalID,
+		NodeIDCreates:    nodeIDs,
+	}})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, fmt.Sprintf("package %s: package not found", nodeIDs[1]))
+	assert.NotContains(t, err.Error(), nodeIDs[0])
+	assert.NotContains(t, err.Error(), nodeIDs[2])
+
+	mockServer.AssertAllCalledExactlyOnce(t)
+
+	// the failure is reported with its reason, and the proxies that were created are still reported, so that they
+	// can be rolled back
+	entries := testProcessor.Report.Entries
+	require.Len(t, entries, 3)
+	for i, entry := range entries {
+		assert.Equal(t, nodeIDs[i], entry.PackageNodeID)
+	}
+	assert.Equal(t, processor.Created, entries[0].Action)
+	assert.Equal(t, processor.Failed, entries[1].Action)
+	assert.Equal(t, "package not found", entries[1].Error)
+	assert.Empty(t, entries[1].ID)
+	assert.Equal(t, processor.Created, entries[2].Action)
+}
+
+func proxyCreateWithoutIDs(t *testing.T) {
+	datasetID := processortest.NewDatasetID()
+	initialIDStore, modelName, targetExternalID, targetRecordID := newProxyRecord()
+
+	nodeID := NewPackageNodeID()
+	expectedCall := expectedcalls.CreateProxyInstance(datasetID, models.NewCreateProxyInstanceBody(targetRecordID, nodeID))
+	expectedCall.Calls[0].APIResponse = nil
+
+	mockServer := mock.NewModelService(t, expectedCall)
+	defer mockServer.Close()
+
+	testProcessor := processortest.NewBuilder().WithIDStore(initialIDStore).Build(t, mockServer.URL())
+
+	// the proxy may have been created, so an empty response is an error
+	err := testProcessor.ProcessProxyRecordChanges(context.Background(), datasetID, []clientmodels.ProxyRecordChanges{{
+		ModelName:        modelName,
+		RecordExternalID: targetExternalID,
+		NodeIDCreates:    []string{nodeID},
+	}})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "error decoding create proxy instance response")
+
+	mockServer.AssertAllCalledExactlyOnce(t)
+}
+
 func NewPackageNodeID() string {
 	return fmt.Sprintf("N:collection:%s", uuid.NewString())
 }
@@ -0,0 +1,144 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	clientmodels "github.com/pennsieve/processor-post-metadata/client/models"
+	"github.com/pennsieve/processor-post-metadata/service/models"
+	"log/slog"
+)
+
+func (p *MetadataPostProcessor) ProcessRelationshipInstanceDeletes(ctx context.Context, datasetID string, relationshipChanges []clientmodels.RelationshipChanges) error {
+	for _, relationshipChange := range relationshipChanges {
+		if len(relationshipChange.Instances.Delete) == 0 {
+			continue
+		}
+		relationshipLogger := logger.With(slog.Any("relationshipSchemaID", relationshipChange.ID))
+		relationshipLogger.Info("starting relationship deletes")
+		for _, instanceID := range relationshipChange.Instances.Delete {
+			_, skipped, err := p.journaled(deleteRelationshipInstanceOperation(instanceID), func() (string, error) {
+				return "", p.Pennsieve.DeleteRelationshipInstance(ctx, datasetID, relationshipChange.ID, instanceID)
+			})
+			if err != nil {
+				return err
+			}
+			if skipped {
+				continue
+			}
+			p.Report.Add(ReportEntry{
+				Type:     RelationshipInstanceEntity,
+				Action:   Deleted,
+				ID:       string(instanceID),
+				SchemaID: relationshipChange.ID,
+			})
+		}
+		relationshipLogger.Info("finished relationship deletes", slog.Int("count", len(relationshipChange.Instances.Delete)))
+	}
+	return nil
+}
+
+func (p *MetadataPostProcessor) ProcessRelationships(ctx context.Context, datasetID string, relationshipChanges []clientmodels.RelationshipChanges) error {
+	if len(relationshipChanges) == 0 {
+		logger.Info("no relationship changes")
+		return nil
+	}
+	logger.Info("starting relationship changes")
+	for _, relationshipChange := range relationshipChanges {
+		if err := p.ProcessRelationshipChanges(ctx, datasetID, relationshipChange); err != nil {
+			return err
+		}
+	}
+	logger.Info("finished relationship changes")
+	return nil
+}
+
+func (p *MetadataPostProcessor) ProcessRelationshipChanges(ctx context.Context, datasetID string, relationshipChange clientmodels.RelationshipChanges) error {
+	relationshipSchemaID, err := p.CreateRelationshipSchemaIfNecessary(ctx, datasetID, relationshipChange)
+	if err != nil {
+		return err
+	}
+	relationshipLogger := logger.With(slog.Any("relationshipSchemaID", relationshipSchemaID))
+
+	relationshipLogger.Info("creating relationship instances")
+	for _, instanceCreate := range relationshipChange.Instances.Create {
+		if err := p.CreateRelationshipInstance(ctx, datasetID, relationshipSchemaID, instanceCreate); err != nil {
+			return err
+		}
+	}
+	relationshipLogger.Info("created relationship instances", slog.Int("count", len(relationshipChange.Instances.Create)))
+	return nil
+}
+
+func (p *MetadataPostProcessor) CreateRelationshipSchemaIfNecessary(ctx context.Context, datasetID string, relationshipChange clientmodels.RelationshipChanges) (clientmodels.PennsieveSchemaID, error) {
+	if relationshipChange.Create == nil {
+		logger.Info("relationship schema already exists", slog.Any("relationshipSchemaID", relationshipChange.ID))
+		return relationshipChange.ID, nil
+	}
+	relationshipCreate := relationshipChange.Create
+	relationshipLogger := logger.With(slog.String("relationshipName", relationshipCreate.Name))
+	relationshipLogger.Info("creating relationship schema")
+
+	body := models.NewCreateRelationshipSchemaBody(relationshipCreate.Name, relationshipCreate.DisplayName, relationshipCreate.Description)
+	if len(relationshipCreate.FromModelName) > 0 {
+		fromModelID, err := p.IDStore.ModelID(relationshipCreate.FromModelName)
+		if err != nil {
+			return "", fmt.Errorf("unable to create relationship schema %s: %w", relationshipCreate.Name, err)
+		}
+		body.From = &fromModelID
+	}
+	if len(relationshipCreate.ToModelName) > 0 {
+		toModelID, err := p.IDStore.ModelID(relationshipCreate.ToModelName)
+		if err != nil {
+			return "", fmt.Errorf("unable to create relationship schema %s: %w", relationshipCreate.Name, err)
+		}
+		body.To = &toModelID
+	}
+	id, skipped, err := p.journaled(createRelationshipSchemaOperation(relationshipCreate.Name), func() (string, error) {
+		relationshipSchemaID, err := p.Pennsieve.CreateRelationshipSchema(ctx, datasetID, body)
+		return relationshipSchemaID.String(), err
+	})
+	if err != nil {
+		return "", err
+	}
+	relationshipSchemaID := clientmodels.PennsieveSchemaID(id)
+	relationshipLogger.Info("relationship schema created",
+		slog.Any("relationshipSchemaID", relationshipSchemaID),
+		slog.Bool("createdByEarlierRun", skipped))
+	if !skipped {
+		p.Report.Add(ReportEntry{Type: RelationshipSchemaEntity, Action: Created, ID: id, Name: relationshipCreate.Name})
+	}
+	return relationshipSchemaID, nil
+}
+
+func (p *MetadataPostProcessor) CreateRelationshipInstance(ctx context.Context, datasetID string, relationshipSchemaID clientmodels.PennsieveSchemaID, instanceCreate clientmodels.RelationshipInstanceCreate) error {
+	fromRecordID, err := p.lookupTargetID(instanceCreate.FromModelName, instanceCreate.FromExternalID)
+	if err != nil {
+		return fmt.Errorf("'from' record id not found: %w", err)
+	}
+	toRecordID, err := p.lookupTargetID(instanceCreate.ToModelName, instanceCreate.ToExternalID)
+	if err != nil {
+		return fmt.Errorf("'to' record id not found: %w", err)
+	}
+	body := models.NewCreateRelationshipInstanceBody(fromRecordID, toRecordID)
+	operationKey := createRelationshipInstanceOperation(relationshipSchemaID, fromRecordID, toRecordID)
+	instanceID, skipped, err := p.journaled(operationKey, func() (string, error) {
+		instanceID, err := p.Pennsieve.CreateRelationshipInstance(recordContext(ctx, instanceCreate.FromExternalID), datasetID, relationshipSchemaID, body)
+		return string(instanceID), err
+	})
+	if err != nil {
+		return err
+	}
+	if skipped {
+		return nil
+	}
+	p.Report.Add(ReportEntry{
+		Type:           RelationshipInstanceEntity,
+		Action:         Created,
+		ID:             instanceID,
+		SchemaID:       relationshipSchemaID,
+		RecordID:       fromRecordID,
+		FromExternalID: instanceCreate.FromExternalID,
+		ToExternalID:   instanceCreate.ToExternalID,
+	})
+	return nil
+}
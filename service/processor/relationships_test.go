@@ -0,0 +1,161 @@
+package processor_test
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/pennsieve/processor-post-metadata/client/clienttest"
+	clientmodels "github.com/pennsieve/processor-post-metadata/client/models"
+	"github.com/pennsieve/processor-post-metadata/service/internal/test/mock"
+	"github.com/pennsieve/processor-post-metadata/service/internal/test/mock/expectedcalls"
+	"github.com/pennsieve/processor-post-metadata/service/models"
+	"github.com/pennsieve/processor-post-metadata/service/processor"
+	"github.com/pennsieve/processor-post-metadata/service/processor/internal/processortest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestMetadataPostProcessor_ProcessRelationships(t *testing.T) {
+	for scenario, testFunc := range map[string]func(t *testing.T){
+		"create relationship schema and instances":    createRelationshipSchemaAndInstances,
+		"relationship schema exists; create instance": createRelationshipInstance,
+		"relationship instance deletes":               relationshipInstanceDeletes,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			testFunc(t)
+		})
+	}
+}
+
+func createRelationshipSchemaAndInstances(t *testing.T) {
+	datasetID := processortest.NewDatasetID()
+
+	fromModelName := uuid.NewString()
+	fromModelID := clienttest.NewPennsieveSchemaID()
+	toModelName := uuid.NewString()
+	toModelID := clienttest.NewPennsieveSchemaID()
+
+	fromExternalID := clienttest.NewExternalInstanceID()
+	fromRecordID := clienttest.NewPennsieveInstanceID()
+	to1ExternalID := clienttest.NewExternalInstanceID()
+	to1RecordID := clienttest.NewPennsieveInstanceID()
+	to2ExternalID := clienttest.NewExternalInstanceID()
+	to2RecordID := clienttest.NewPennsieveInstanceID()
+
+	initialIDStore := processor.NewIDStoreBuilder().
+		WithModel(fromModelName, fromModelID).
+		WithModel(toModelName, toModelID).
+		WithRecord(fromModelID, fromExternalID, fromRecordID).
+		WithRecord(toModelID, to1ExternalID, to1RecordID).
+		WithRecord(toModelID, to2ExternalID, to2RecordID).
+		Build()
+
+	schemaCreate := clienttest.NewRelationshipSchemaCreate()
+	schemaCreate.FromModelName = fromModelName
+	expectedSchemaCreateBody := models.NewCreateRelationshipSchemaBody(schemaCreate.Name, schemaCreate.DisplayName, schemaCreate.Description)
+	expectedSchemaCreateBody.From = &fromModelID
+	expectedSchemaCreateCall := expectedcalls.CreateRelationshipSchema(datasetID, expectedSchemaCreateBody)
+
+	relationshipSchemaID := clientmodels.PennsieveSchemaID(expectedSchemaCreateCall.APIResponse.ID)
+	expectedInstanceCreateCall := expectedcalls.CreateRelationshipInstance(datasetID, relationshipSchemaID,
+		models.NewCreateRelationshipInstanceBody(fromRecordID, to1RecordID),
+		models.NewCreateRelationshipInstanceBody(fromRecordID, to2RecordID),
+	)
+
+	mockServer := mock.NewModelService(t, expectedSchemaCreateCall, expectedInstanceCreateCall)
+	defer mockServer.Close()
+
+	testProcessor := processortest.NewBuilder().WithIDStore(initialIDStore).Build(t, mockServer.URL())
+
+	require.NoError(t, testProcessor.ProcessRelationships(context.Background(), datasetID, []clientmodels.RelationshipChanges{{
+		Create: &schemaCreate,
+		Instances: clientmodels.RelationshipInstanceChanges{
+			Create: []clientmodels.RelationshipInstanceCreate{
+				{FromModelName: fromModelName, FromExternalID: fromExternalID, ToModelName: toModelName, ToExternalID: to1ExternalID},
+				{FromModelName: fromModelName, FromExternalID: fromExternalID, ToModelName: toModelName, ToExternalID: to2ExternalID},
+			},
+		},
+	}}))
+
+	mockServer.AssertAllCalledExactlyOnce(t)
+
+	entries := testProcessor.Report.Entries
+	require.Len(t, entries, 3)
+	assert.Equal(t, processor.RelationshipSchemaEntity, entries[0].Type)
+	assert.Equal(t, schemaCreate.Name, entries[0].Name)
+	for _, entry := range entries[1:] {
+		assert.Equal(t, processor.RelationshipInstanceEntity, entry.Type)
+		assert.Equal(t, processor.Created, entry.Action)
+		assert.Equal(t, relationshipSchemaID, entry.SchemaID)
+		assert.Equal(t, fromRecordID, entry.RecordID)
+	}
+}
+
+func createRelationshipInstance(t *testing.T) {
+	datasetID := processortest.NewDatasetID()
+
+	modelName := uuid.NewString()
+	modelID := clienttest.NewPennsieveSchemaID()
+	fromExternalID := clienttest.NewExternalInstanceID()
+	fromRecordID := clienttest.NewPennsieveInstanceID()
+	toExternalID := clienttest.NewExternalInstanceID()
+	toRecordID := clienttest.NewPennsieveInstanceID()
+
+	initialIDStore := processor.NewIDStoreBuilder().
+		WithModel(modelName, modelID).
+		WithRecord(modelID, fromExternalID, fromRecordID).
+		WithRecord(modelID, toExternalID, toRecordID).
+		Build()
+
+	relationshipSchemaID := clienttest.NewPennsieveSchemaID()
+	mockServer := mock.NewModelService(t, expectedcalls.CreateRelationshipInstance(datasetID, relationshipSchemaID,
+		models.NewCreateRelationshipInstanceBody(fromRecordID, toRecordID)))
+	defer mockServer.Close()
+
+	testProcessor := processortest.NewBuilder().WithIDStore(initialIDStore).Build(t, mockServer.URL())
+
+	require.NoError(t, testProcessor.ProcessRelationshipChanges(context.Background(), datasetID, clientmodels.RelationshipChanges{
+		ID: relationshipSchemaID,
+		Instances: clientmodels.RelationshipInstanceChanges{
+			Create: []clientmodels.RelationshipInstanceCreate{
+				{FromModelName: modelName, FromExternalID: fromExternalID, ToModelName: modelName, ToExternalID: toExternalID},
+			},
+		},
+	}))
+
+	mockServer.AssertAllCalledExactlyOnce(t)
+}
+
+func relationshipInstanceDeletes(t *testing.T) {
+	datasetID := processortest.NewDatasetID()
+
+	relationshipSchemaID := clienttest.NewPennsieveSchemaID()
+	instance1ID := clienttest.NewPennsieveInstanceID()
+	instance2ID := clienttest.NewPennsieveInstanceID()
+
+	mockServer := mock.NewModelService(t,
+		expectedcalls.DeleteRelationshipInstance(datasetID, relationshipSchemaID, instance1ID),
+		expectedcalls.DeleteRelationshipInstance(datasetID, relationshipSchemaID, instance2ID))
+	defer mockServer.Close()
+
+	testProcessor := processortest.NewBuilder().Build(t, mockServer.URL())
+
+	require.NoError(t, testProcessor.ProcessDeletes(context.Background(), datasetID, clientmodels.Dataset{
+		Relationships: []clientmodels.RelationshipChanges{{
+			ID: relationshipSchemaID,
+			Instances: clientmodels.RelationshipInstanceChanges{
+				Delete: []clientmodels.PennsieveInstanceID{instance1ID, instance2ID},
+			},
+		}},
+	}))
+
+	mockServer.AssertAllCalledExactlyOnce(t)
+
+	entries := testProcessor.Report.Entries
+	require.Len(t, entries, 2)
+	for _, entry := range entries {
+		assert.Equal(t, processor.RelationshipInstanceEntity, entry.Type)
+		assert.Equal(t, processor.Deleted, entry.Action)
+		assert.Equal(t, relationshipSchemaID, entry.SchemaID)
+	}
+}
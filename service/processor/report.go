@@ -0,0 +1,263 @@
+package processor
+
+import (
+	"encoding/json"
+	"fmt"
+	clientmodels "github.com/pennsieve/processor-post-metadata/client/models"
+	"github.com/pennsieve/processor-post-metadata/service/metrics"
+	"github.com/pennsieve/processor-post-metadata/service/util"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const ReportFilename = "report.json"
+
+// Phase names used in the Report
+const (
+	DeletesPhase       = "deletes"
+	ModelChangesPhase  = "model_changes"
+	LinksPhase         = "links"
+	RelationshipsPhase = "relationships"
+	ProxiesPhase       = "proxies"
+)
+
+type EntityType string
+
+const (
+	ModelEntity                EntityType = "model"
+	PropertyEntity             EntityType = "property"
+	RecordEntity               EntityType = "record"
+	LinkSchemaEntity           EntityType = "link_schema"
+	LinkInstanceEntity         EntityType = "link_instance"
+	RelationshipSchemaEntity   EntityType = "relationship_schema"
+	RelationshipInstanceEntity EntityType = "relationship_instance"
+	ProxyEntity                EntityType = "proxy"
+)
+
+type Action string
+
+const (
+	Created Action = "created"
+	Updated Action = "updated"
+	Deleted Action = "deleted"
+	// Failed is an object that could not be created. Only used where Pennsieve reports failures per object, such as
+	// the packages of a bulk proxy create.
+	Failed Action = "failed"
+)
+
+// ReportEntry describes one metadata object the processor created, updated or deleted in Pennsieve.
+// Only the fields that make sense for the entry's Type are set.
+type ReportEntry struct {
+	Phase  string     `json:"phase,omitempty"`
+	Type   EntityType `json:"type"`
+	Action Action     `json:"action"`
+	// ID is the Pennsieve ID of the object. May be empty for proxies if Pennsieve did not return one.
+	ID         string                          `json:"id,omitempty"`
+	ExternalID clientmodels.ExternalInstanceID `json:"external_id,omitempty"`
+	// Name is the name of a model, property or schema, or the relationship type of a proxy created by a
+	// ProxyPackageCreate
+	Name string `json:"name,omitempty"`
+	// ModelID is the model of a record or property, or the "from" model of a link or relationship instance
+	ModelID clientmodels.PennsieveSchemaID `json:"model_id,omitempty"`
+	// RecordID is the "from" record of a link or relationship instance, or the target record of a proxy
+	RecordID clientmodels.PennsieveInstanceID `json:"record_id,omitempty"`
+	// SchemaID is the relationship schema of a relationship instance
+	SchemaID       clientmodels.PennsieveSchemaID  `json:"schema_id,omitempty"`
+	FromExternalID clientmodels.ExternalInstanceID `json:"from_external_id,omitempty"`
+	ToExternalID   clientmodels.ExternalInstanceID `json:"to_external_id,omitempty"`
+	PackageNodeID  string                          `json:"package_node_id,omitempty"`
+	// Error is the reason given by Pennsieve for a Failed entry
+	Error string `json:"error,omitempty"`
+}
+
+// PhaseReport holds the timing, entry counts, and error if any, of one phase of a run
+type PhaseReport struct {
+	Name           string                        `json:"name"`
+	StartedAt      time.Time                     `json:"started_at"`
+	DurationMillis int64                         `json:"duration_ms"`
+	Counts         map[EntityType]map[Action]int `json:"counts"`
+	Error          string                        `json:"error,omitempty"`
+}
+
+// Report is a machine-readable record of what a run actually did. Safe for concurrent use.
+type Report struct {
+	DatasetID      string    `json:"dataset_id,omitempty"`
+	StartedAt      time.Time `json:"started_at"`
+	DurationMillis int64     `json:"duration_ms"`
+	Success        bool      `json:"success"`
+	Error          string    `json:"error,omitempty"`
+	// RolledBack is true if the run failed and everything it created was deleted again. See RollbackOnFailure
+	RolledBack bool `json:"rolled_back,omitempty"`
+	// Stopped is true if the run was cancelled or timed out before finishing. A later run can resume it
+	Stopped bool           `json:"stopped,omitempty"`
+	Phases  []*PhaseReport `json:"phases"`
+	Entries []ReportEntry  `json:"entries"`
+
+	mu           sync.Mutex
+	currentPhase *PhaseReport
+	// metrics, if non-nil, counts entries and records phase and run outcomes as they happen
+	metrics *metrics.Registry
+	// journal, if non-nil, records each Created entry so that a failed resumed run can roll it back
+	journal *Journal
+}
+
+func NewReport() *Report {
+	return &Report{
+		StartedAt: time.Now(),
+		Phases:    []*PhaseReport{},
+		Entries:   []ReportEntry{},
+	}
+}
+
+// Add appends entry to the report, counting it in the current phase if there is one.
+func (r *Report) Add(entry ReportEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if phase := r.currentPhase; phase != nil {
+		entry.Phase = phase.Name
+		if _, found := phase.Counts[entry.Type]; !found {
+			phase.Counts[entry.Type] = make(map[Action]int)
+		}
+		phase.Counts[entry.Type][entry.Action]++
+	}
+	r.Entries = append(r.Entries, entry)
+	if r.journal != nil && entry.Action == Created {
+		if err := r.journal.AppendCreated(entry); err != nil {
+			logger.Warn("error journaling created object; a resumed run cannot roll it back",
+				slog.String("type", string(entry.Type)),
+				slog.String("id", entry.ID),
+				slog.Any("error", err))
+		}
+	}
+	phaseLabel := entry.Phase
+	if len(phaseLabel) == 0 {
+		phaseLabel = noPhaseLabel
+	}
+	r.metrics.Counter(EntitiesMetric,
+		"Metadata objects created, updated or deleted in Pennsieve",
+		"phase", "type", "action").Inc(phaseLabel, string(entry.Type), string(entry.Action))
+}
+
+// Phase runs phaseFunc as the named phase, recording its duration and error.
+func (r *Report) Phase(name string, phaseFunc func() error) error {
+	phase := r.beginPhase(name)
+	err := phaseFunc()
+	r.endPhase(phase, err)
+	return err
+}
+
+// beginPhase starts the named phase. Entries are counted in it until endPhase is called.
+func (r *Report) beginPhase(name string) *PhaseReport {
+	phase := &PhaseReport{
+		Name:      name,
+		StartedAt: time.Now(),
+		Counts:    make(map[EntityType]map[Action]int),
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Phases = append(r.Phases, phase)
+	r.currentPhase = phase
+	return phase
+}
+
+func (r *Report) endPhase(phase *PhaseReport, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	phase.DurationMillis = time.Since(phase.StartedAt).Milliseconds()
+	if err != nil {
+		phase.Error = err.Error()
+	}
+	r.currentPhase = nil
+	r.metrics.Gauge(PhaseDurationMetric, "Duration of the phases of the run in seconds", "phase").
+		Set(time.Since(phase.StartedAt).Seconds(), phase.Name)
+	r.metrics.Gauge(PhaseSuccessMetric, "1 if the phase succeeded, 0 if it failed", "phase").
+		Set(successValue(err), phase.Name)
+}
+
+// Finish records the overall outcome of the run.
+func (r *Report) Finish(runErr error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.DurationMillis = time.Since(r.StartedAt).Milliseconds()
+	r.Success = runErr == nil
+	if runErr != nil {
+		r.Error = runErr.Error()
+	}
+	r.metrics.Gauge(RunDurationMetric, "Duration of the run in seconds").Set(time.Since(r.StartedAt).Seconds())
+	r.metrics.Gauge(RunSuccessMetric, "1 if the run succeeded, 0 if it failed").Set(successValue(runErr))
+	r.metrics.Gauge(RunTimestampMetric, "Unix time at which the run finished").Set(float64(time.Now().Unix()))
+}
+
+func successValue(err error) float64 {
+	if err != nil {
+		return 0
+	}
+	return 1
+}
+
+// entries returns a copy of the entries with the given action
+func (r *Report) entries(action Action) []ReportEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var matching []ReportEntry
+	for _, entry := range r.Entries {
+		if entry.Action == action {
+			matching = append(matching, entry)
+		}
+	}
+	return matching
+}
+
+func (r *Report) setRolledBack(rolledBack bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.RolledBack = rolledBack
+}
+
+func (r *Report) setStopped() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Stopped = true
+}
+
+func (r *Report) setJournal(journal *Journal) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.journal = journal
+}
+
+func (r *Report) setDatasetID(datasetID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.DatasetID = datasetID
+}
+
+// ReportFilePath joins the given output directory with the
+// report file name.
+// Visible for testing.
+func ReportFilePath(outputDirectory string) string {
+	return filepath.Join(outputDirectory, ReportFilename)
+}
+
+func (p *MetadataPostProcessor) reportFilePath() string {
+	return ReportFilePath(p.OutputDirectory)
+}
+
+func writeReportFile(filePath string, report *Report) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return fmt.Errorf("error creating report file %s: %w", filePath, err)
+	}
+	defer util.CloseFileAndWarn(file)
+	report.mu.Lock()
+	defer report.mu.Unlock()
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return fmt.Errorf("error encoding report file %s: %w", filePath, err)
+	}
+	return nil
+}
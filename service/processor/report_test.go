@@ -0,0 +1,174 @@
+package processor_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/pennsieve/processor-post-metadata/client/clienttest"
+	clientmodels "github.com/pennsieve/processor-post-metadata/client/models"
+	"github.com/pennsieve/processor-post-metadata/service/internal/test/mock"
+	"github.com/pennsieve/processor-post-metadata/service/internal/test/mock/expectedcalls"
+	"github.com/pennsieve/processor-post-metadata/service/processor"
+	"github.com/pennsieve/processor-post-metadata/service/processor/internal/processortest"
+	"github.com/pennsieve/processor-pre-metadata/client/models/datatypes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestMetadataPostProcessor_Run_Report(t *testing.T) {
+	for scenario, testFunc := range map[string]func(t *testing.T){
+		"report written on success": reportOnSuccess,
+		"report written on failure": reportOnFailure,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			testFunc(t)
+		})
+	}
+}
+
+func reportOnSuccess(t *testing.T) {
+	integrationID := uuid.NewString()
+	datasetID := processortest.NewDatasetID()
+	outputDirectory := t.TempDir()
+
+	modelID := clienttest.NewPennsieveSchemaID()
+	modelCreate := clienttest.NewModelCreate()
+	recordCreateValues := clienttest.NewRecordValues(clienttest.NewRecordValueSimple(t, datatypes.StringType))
+	createdExternalID := clienttest.NewExternalInstanceID()
+	expectedRecordCreateCall := expectedcalls.RecordCreate(datasetID, modelID, recordCreateValues)
+
+	changeset := clientmodels.Dataset{
+		Models: clientmodels.ModelChanges{
+			Creates: []clientmodels.ModelCreate{{
+				Create: clientmodels.ModelPropsCreate{Model: modelCreate},
+				Records: []clientmodels.RecordCreate{{
+					ExternalID:   createdExternalID,
+					RecordValues: recordCreateValues,
+				}},
+			}},
+		},
+	}
+	writeChangeset(t, changeset, processor.ChangesetFilePath(outputDirectory))
+
+	mockServer := mock.NewModelService(t,
+		expectedcalls.GetIntegration(integrationID, datasetID),
+		expectedcalls.ModelCreate(datasetID, modelID, modelCreate),
+		expectedRecordCreateCall)
+	defer mockServer.Close()
+
+	testProcessor := processortest.NewBuilder().
+		WithIntegrationID(integrationID).
+		WithOutputDirectory(outputDirectory).
+		Build(t, mockServer.URL())
+
+	require.NoError(t, testProcessor.Run(context.Background()))
+
+	report := readReport(t, processor.ReportFilePath(outputDirectory))
+	assert.True(t, report.Success)
+	assert.Empty(t, report.Error)
+	assert.Equal(t, datasetID, report.DatasetID)
+	require.Len(t, report.Phases, 5)
+	assert.Equal(t, processor.ModelChangesPhase, report.Phases[1].Name)
+	assert.Equal(t, 1, report.Phases[1].Counts[processor.ModelEntity][processor.Created])
+	assert.Equal(t, 1, report.Phases[1].Counts[processor.RecordEntity][processor.Created])
+
+	assert.Equal(t, []processor.ReportEntry{
+		{
+			Phase:  processor.ModelChangesPhase,
+			Type:   processor.ModelEntity,
+			Action: processor.Created,
+			ID:     modelID.String(),
+			Name:   modelCreate.Name,
+		},
+		{
+			Phase:      processor.ModelChangesPhase,
+			Type:       processor.RecordEntity,
+			Action:     processor.Created,
+			ID:         expectedRecordCreateCall.APIResponse.ID,
+			ExternalID: createdExternalID,
+			ModelID:    modelID,
+		},
+	}, report.Entries)
+}
+
+func reportOnFailure(t *testing.T) {
+	integrationID := uuid.NewString()
+	datasetID := processortest.NewDatasetID()
+	outputDirectory := t.TempDir()
+
+	modelName := uuid.NewString()
+	modelID := clienttest.NewPennsieveSchemaID()
+	toDelete := []clientmodels.PennsieveInstanceID{clienttest.NewPennsieveInstanceID()}
+	linkCreate := clienttest.NewInstanceLinkedPropertyCreate()
+	fromRecordID := clienttest.NewPennsieveInstanceID()
+
+	changeset := clientmodels.Dataset{
+		Models: clientmodels.ModelChanges{
+			Updates: []clientmodels.ModelUpdate{{
+				ID:      modelID,
+				Records: clientmodels.RecordChanges{Delete: toDelete},
+			}},
+		},
+		LinkedProperties: []clientmodels.LinkedPropertyChanges{{
+			FromModelName: modelName,
+			ToModelName:   modelName,
+			ID:            clienttest.NewPennsieveSchemaID(),
+			Instances: clientmodels.InstanceChanges{
+				Create: []clientmodels.InstanceLinkedPropertyCreate{linkCreate},
+			},
+		}},
+		ExistingModelIDMap: map[string]clientmodels.PennsieveSchemaID{modelName: modelID},
+		RecordIDMaps: []clientmodels.RecordIDMap{{
+			ModelName: modelName,
+			ExternalToPennsieve: map[clientmodels.ExternalInstanceID]clientmodels.PennsieveInstanceID{
+				linkCreate.FromExternalID: fromRecordID,
+				linkCreate.ToExternalID:   clienttest.NewPennsieveInstanceID(),
+			},
+		}},
+	}
+	writeChangeset(t, changeset, processor.ChangesetFilePath(outputDirectory))
+
+	// link create fails
+	mockServer := mock.NewModelService(t,
+		expectedcalls.GetIntegration(integrationID, datasetID),
+		expectedcalls.RecordDelete(datasetID, modelID, toDelete),
+		&mock.ExpectedFailedAPICall{
+			Method:     http.MethodPost,
+			APIPath:    fmt.Sprintf("/models/datasets/%s/concepts/%s/instances/%s/linked", datasetID, modelID, fromRecordID),
+			StatusCode: http.StatusInternalServerError,
+		})
+	defer mockServer.Close()
+
+	testProcessor := processortest.NewBuilder().
+		WithIntegrationID(integrationID).
+		WithOutputDirectory(outputDirectory).
+		Build(t, mockServer.URL())
+
+	runErr := testProcessor.Run(context.Background())
+	require.Error(t, runErr)
+
+	report := readReport(t, processor.ReportFilePath(outputDirectory))
+	assert.False(t, report.Success)
+	assert.Equal(t, runErr.Error(), report.Error)
+	require.Len(t, report.Phases, 3)
+	assert.Equal(t, processor.LinksPhase, report.Phases[2].Name)
+	assert.Equal(t, runErr.Error(), report.Phases[2].Error)
+	assert.Equal(t, 1, report.Phases[0].Counts[processor.RecordEntity][processor.Deleted])
+
+	require.Len(t, report.Entries, 1)
+	assert.Equal(t, string(toDelete[0]), report.Entries[0].ID)
+	assert.Equal(t, processor.Deleted, report.Entries[0].Action)
+}
+
+func readReport(t *testing.T, filePath string) *processor.Report {
+	file, err := os.Open(filePath)
+	require.NoError(t, err)
+	defer file.Close()
+	var report processor.Report
+	require.NoError(t, json.NewDecoder(file).Decode(&report))
+	return &report
+}
@@ -0,0 +1,240 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	clientmodels "github.com/pennsieve/processor-post-metadata/client/models"
+	"github.com/pennsieve/processor-post-metadata/service/models"
+	"log/slog"
+	"os"
+)
+
+const RollbackPhase = "rollback"
+
+// RollbackRecordBatchSize is the maximum number of records deleted by one request when rolling back, unless
+// RecordBatchSize is greater than 1, in which case that is used instead
+const RollbackRecordBatchSize = 1000
+
+// rollback deletes the objects that the Report says this run created, and that the journal says earlier runs of the
+// same changeset created, in reverse dependency order: proxies, relationship instances, link instances, relationship
+// schemas, link schemas, records, properties added to existing models, and then models. It keeps going after a failed
+// delete so that as little as possible is left behind, and returns all the errors. The proxy relationship schema is
+// shared by all proxies in the dataset, so it is not deleted.
+//
+// Afterwards, the journal no longer describes the dataset, so it is discarded, and the next run will start over.
+func (p *MetadataPostProcessor) rollback(ctx context.Context) error {
+	var created []ReportEntry
+	if p.journal != nil {
+		created = p.journal.EarlierCreated()
+	}
+	created = append(created, p.Report.entries(Created)...)
+	if len(created) == 0 {
+		logger.Info("nothing to roll back")
+		return nil
+	}
+	datasetID := p.Report.DatasetID
+	logger.Info("starting rollback", slog.Int("createdCount", len(created)))
+	ctx, endSpan := p.startSpan(ctx, RollbackPhase, PhaseAttribute.String(RollbackPhase))
+	err := p.Report.Phase(RollbackPhase, func() error {
+		return errors.Join(
+			p.rollbackProxies(ctx, datasetID, entriesOfType(created, ProxyEntity)),
+			p.rollbackRelationshipInstances(ctx, datasetID, entriesOfType(created, RelationshipInstanceEntity)),
+			p.rollbackLinkInstances(ctx, datasetID, entriesOfType(created, LinkInstanceEntity)),
+			p.rollbackRelationshipSchemas(ctx, datasetID, entriesOfType(created, RelationshipSchemaEntity)),
+			p.rollbackLinkSchemas(ctx, datasetID, entriesOfType(created, LinkSchemaEntity)),
+			p.rollbackRecords(ctx, datasetID, entriesOfType(created, RecordEntity)),
+			p.rollbackProperties(ctx, datasetID, entriesOfType(created, PropertyEntity)),
+			p.rollbackModels(ctx, datasetID, entriesOfType(created, ModelEntity)),
+		)
+	})
+	endSpan(err)
+	p.Report.setRolledBack(err == nil)
+	if discardErr := p.discardJournal(); discardErr != nil {
+		err = errors.Join(err, discardErr)
+	}
+	if err != nil {
+		return fmt.Errorf("error rolling back: %w", err)
+	}
+	logger.Info("finished rollback")
+	return nil
+}
+
+func (p *MetadataPostProcessor) rollbackProxies(ctx context.Context, datasetID string, proxies []ReportEntry) error {
+	var errs []error
+	var recordIDs []clientmodels.PennsieveInstanceID
+	proxyIDsByRecord := map[clientmodels.PennsieveInstanceID][]clientmodels.PennsieveInstanceID{}
+	for _, proxy := range proxies {
+		if len(proxy.ID) == 0 {
+			errs = append(errs, fmt.Errorf("cannot delete proxy for package %s and record %s: Pennsieve did not return its id",
+				proxy.PackageNodeID, proxy.RecordID))
+			continue
+		}
+		if _, found := proxyIDsByRecord[proxy.RecordID]; !found {
+			recordIDs = append(recordIDs, proxy.RecordID)
+		}
+		proxyIDsByRecord[proxy.RecordID] = append(proxyIDsByRecord[proxy.RecordID], clientmodels.PennsieveInstanceID(proxy.ID))
+	}
+	for _, recordID := range recordIDs {
+		proxyIDs := proxyIDsByRecord[recordID]
+		if err := p.Pennsieve.DeleteProxyInstances(ctx, datasetID, models.NewDeleteProxyInstancesBody(recordID, proxyIDs...)); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, proxyID := range proxyIDs {
+			p.Report.Add(ReportEntry{Type: ProxyEntity, Action: Deleted, ID: string(proxyID), RecordID: recordID})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (p *MetadataPostProcessor) rollbackRelationshipInstances(ctx context.Context, datasetID string, relationshipInstances []ReportEntry) error {
+	var errs []error
+	for _, relationshipInstance := range relationshipInstances {
+		instanceID := clientmodels.PennsieveInstanceID(relationshipInstance.ID)
+		if err := p.Pennsieve.DeleteRelationshipInstance(ctx, datasetID, relationshipInstance.SchemaID, instanceID); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		p.Report.Add(ReportEntry{
+			Type:     RelationshipInstanceEntity,
+			Action:   Deleted,
+			ID:       relationshipInstance.ID,
+			SchemaID: relationshipInstance.SchemaID,
+			RecordID: relationshipInstance.RecordID,
+		})
+	}
+	return errors.Join(errs...)
+}
+
+func (p *MetadataPostProcessor) rollbackLinkInstances(ctx context.Context, datasetID string, linkInstances []ReportEntry) error {
+	var errs []error
+	for _, linkInstance := range linkInstances {
+		linkDelete := clientmodels.InstanceLinkedPropertyDelete{
+			FromRecordID:             linkInstance.RecordID,
+			InstanceLinkedPropertyID: clientmodels.PennsieveInstanceID(linkInstance.ID),
+		}
+		if err := p.Pennsieve.DeleteLinkedPropertyInstance(ctx, datasetID, linkInstance.ModelID, linkDelete); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		p.Report.Add(ReportEntry{
+			Type:     LinkInstanceEntity,
+			Action:   Deleted,
+			ID:       linkInstance.ID,
+			ModelID:  linkInstance.ModelID,
+			RecordID: linkInstance.RecordID,
+		})
+	}
+	return errors.Join(errs...)
+}
+
+func (p *MetadataPostProcessor) rollbackLinkSchemas(ctx context.Context, datasetID string, linkSchemas []ReportEntry) error {
+	var errs []error
+	for _, linkSchema := range linkSchemas {
+		if err := p.Pennsieve.DeleteLinkedPropertySchema(ctx, datasetID, linkSchema.ModelID, clientmodels.PennsieveSchemaID(linkSchema.ID)); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		p.Report.Add(ReportEntry{Type: LinkSchemaEntity, Action: Deleted, ID: linkSchema.ID, Name: linkSchema.Name, ModelID: linkSchema.ModelID})
+	}
+	return errors.Join(errs...)
+}
+
+func (p *MetadataPostProcessor) rollbackRelationshipSchemas(ctx context.Context, datasetID string, relationshipSchemas []ReportEntry) error {
+	var errs []error
+	for _, relationshipSchema := range relationshipSchemas {
+		// shared by all proxies in the dataset
+		if relationshipSchema.Name == models.ProxyRelationshipSchemaName {
+			continue
+		}
+		if err := p.Pennsieve.DeleteRelationshipSchema(ctx, datasetID, clientmodels.PennsieveSchemaID(relationshipSchema.ID)); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		p.Report.Add(ReportEntry{Type: RelationshipSchemaEntity, Action: Deleted, ID: relationshipSchema.ID, Name: relationshipSchema.Name})
+	}
+	return errors.Join(errs...)
+}
+
+func (p *MetadataPostProcessor) rollbackRecords(ctx context.Context, datasetID string, records []ReportEntry) error {
+	var errs []error
+	var modelIDs []clientmodels.PennsieveSchemaID
+	recordIDsByModel := map[clientmodels.PennsieveSchemaID][]clientmodels.PennsieveInstanceID{}
+	for _, record := range records {
+		if _, found := recordIDsByModel[record.ModelID]; !found {
+			modelIDs = append(modelIDs, record.ModelID)
+		}
+		recordIDsByModel[record.ModelID] = append(recordIDsByModel[record.ModelID], clientmodels.PennsieveInstanceID(record.ID))
+	}
+	batchSize := RollbackRecordBatchSize
+	if p.RecordBatchSize > 1 {
+		batchSize = p.RecordBatchSize
+	}
+	for _, modelID := range modelIDs {
+		recordIDs := recordIDsByModel[modelID]
+		// a failed batch does not stop the others, so that only its records are left behind
+		for start := 0; start < len(recordIDs); start += batchSize {
+			batch := recordIDs[start:min(start+batchSize, len(recordIDs))]
+			if err := p.Pennsieve.DeleteRecords(ctx, datasetID, modelID, batch); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			for _, recordID := range batch {
+				p.Report.Add(ReportEntry{Type: RecordEntity, Action: Deleted, ID: string(recordID), ModelID: modelID})
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (p *MetadataPostProcessor) rollbackProperties(ctx context.Context, datasetID string, properties []ReportEntry) error {
+	var errs []error
+	for _, property := range properties {
+		if err := p.Pennsieve.DeleteModelProperty(ctx, datasetID, property.ModelID, clientmodels.PennsieveSchemaID(property.ID)); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		p.Report.Add(ReportEntry{Type: PropertyEntity, Action: Deleted, ID: property.ID, Name: property.Name, ModelID: property.ModelID})
+	}
+	return errors.Join(errs...)
+}
+
+func (p *MetadataPostProcessor) rollbackModels(ctx context.Context, datasetID string, modelEntries []ReportEntry) error {
+	var errs []error
+	for _, model := range modelEntries {
+		if err := p.Pennsieve.DeleteModel(ctx, datasetID, clientmodels.PennsieveSchemaID(model.ID)); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		p.Report.Add(ReportEntry{Type: ModelEntity, Action: Deleted, ID: model.ID, Name: model.Name})
+	}
+	return errors.Join(errs...)
+}
+
+// entriesOfType returns the entries of the given type, most recent first
+func entriesOfType(entries []ReportEntry, entityType EntityType) []ReportEntry {
+	var ofType []ReportEntry
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].Type == entityType {
+			ofType = append(ofType, entries[i])
+		}
+	}
+	return ofType
+}
+
+// discardJournal closes the journal if it is open and removes the journal and saved IDStore files
+func (p *MetadataPostProcessor) discardJournal() error {
+	var errs []error
+	if p.journal != nil {
+		errs = append(errs, p.journal.Close())
+		p.journal = nil
+		p.Report.setJournal(nil)
+	}
+	for _, path := range []string{p.journalFilePath(), p.idStoreFilePath()} {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, fmt.Errorf("error removing %s: %w", path, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,309 @@
+package processor_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/pennsieve/processor-post-metadata/client/clienttest"
+	clientmodels "github.com/pennsieve/processor-post-metadata/client/models"
+	"github.com/pennsieve/processor-post-metadata/service/internal/test/mock"
+	"github.com/pennsieve/processor-post-metadata/service/internal/test/mock/expectedcalls"
+	"github.com/pennsieve/processor-post-metadata/service/models"
+	"github.com/pennsieve/processor-post-metadata/service/processor"
+	"github.com/pennsieve/processor-post-metadata/service/processor/internal/processortest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+)
+
+func TestMetadataPostProcessor_Run_Rollback(t *testing.T) {
+	for scenario, testFunc := range map[string]func(t *testing.T){
+		"rollback deletes created objects in reverse order": rollbackDeletesCreated,
+		"failed rollback is reported":                       failedRollbackReported,
+		"records are deleted in batches after a failed one": rollbackRecordsInBatches,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			testFunc(t)
+		})
+	}
+}
+
+func rollbackDeletesCreated(t *testing.T) {
+	integrationID := uuid.NewString()
+	datasetID := processortest.NewDatasetID()
+	outputDirectory := t.TempDir()
+
+	modelID := clienttest.NewPennsieveSchemaID()
+	modelCreate := clienttest.NewModelCreate()
+	recordCreates, recordValues := newRecordCreates(t, 2)
+
+	existingModelName := uuid.NewString()
+	existingModelID := clienttest.NewPennsieveSchemaID()
+	existingExternalID := clienttest.NewExternalInstanceID()
+	existingRecordID := clienttest.NewPennsieveInstanceID()
+
+	linkSchemaCreate := clienttest.NewSchemaLinkedPropertyCreate()
+	packageNodeIDs := []string{NewPackageNodeID(), NewPackageNodeID()}
+
+	changeset := clientmodels.Dataset{
+		Models: clientmodels.ModelChanges{
+			Creates: []clientmodels.ModelCreate{{
+				Create:  clientmodels.ModelPropsCreate{Model: modelCreate},
+				Records: recordCreates,
+			}},
+		},
+		LinkedProperties: []clientmodels.LinkedPropertyChanges{{
+			FromModelName: modelCreate.Name,
+			ToModelName:   existingModelName,
+			Create:        &linkSchemaCreate,
+			Instances: clientmodels.InstanceChanges{
+				Create: []clientmodels.InstanceLinkedPropertyCreate{{
+					FromExternalID: recordCreates[0].ExternalID,
+					ToExternalID:   existingExternalID,
+				}},
+			},
+		}},
+		Proxies: &clientmodels.ProxyChanges{
+			RecordChanges: []clientmodels.ProxyRecordChanges{{
+				ModelName:        modelCreate.Name,
+				RecordExternalID: recordCreates[0].ExternalID,
+				NodeIDCreates:    packageNodeIDs,
+			}},
+		},
+		ExistingModelIDMap: map[string]clientmodels.PennsieveSchemaID{existingModelName: existingModelID},
+		RecordIDMaps: []clientmodels.RecordIDMap{{
+			ModelName:           existingModelName,
+			ExternalToPennsieve: map[clientmodels.ExternalInstanceID]clientmodels.PennsieveInstanceID{existingExternalID: existingRecordID},
+		}},
+	}
+	writeChangeset(t, changeset, processor.ChangesetFilePath(outputDirectory))
+
+	expectedBatchCall := expectedcalls.RecordBatchCreates(datasetID, modelID, recordValues)
+	recordIDs := []clientmodels.PennsieveInstanceID{
+		clientmodels.PennsieveInstanceID(expectedBatchCall.Calls[0].APIResponse[0].ID),
+		clientmodels.PennsieveInstanceID(expectedBatchCall.Calls[0].APIResponse[1].ID),
+	}
+	expectedLinkSchemaCall := expectedcalls.CreateLinkSchema(datasetID, modelID, models.CreateLinkSchemaBody{
+		Name:        linkSchemaCreate.Name,
+		DisplayName: linkSchemaCreate.DisplayName,
+		To:          existingModelID,
+		Position:    linkSchemaCreate.Position,
+	})
+	linkSchemaID := clientmodels.PennsieveSchemaID(expectedLinkSchemaCall.APIResponse.ID)
+	expectedLinkInstanceCall := expectedcalls.CreateLinkInstance(datasetID, modelID, recordIDs[0], models.CreateLinkInstanceBody{
+		SchemaLinkedPropertyId: linkSchemaID,
+		To:                     existingRecordID,
+	})
+	linkInstanceID := clientmodels.PennsieveInstanceID(expectedLinkInstanceCall.APIResponse.ID)
+
+	// the second proxy create fails
+	expectedProxyCalls := expectedcalls.CreateProxyInstance(datasetID,
+		models.NewCreateProxyInstanceBody(recordIDs[0], packageNodeIDs[0]),
+		models.NewCreateProxyInstanceBody(recordIDs[0], packageNodeIDs[1]))
+	expectedProxyCalls.Calls[1].StatusCode = http.StatusInternalServerError
+	proxyID := clientmodels.PennsieveInstanceID(expectedProxyCalls.Calls[0].APIResponse[0].ProxyInstance.ID)
+
+	mockServer := mock.NewModelService(t,
+		expectedcalls.GetIntegration(integrationID, datasetID),
+		expectedcalls.ModelCreate(datasetID, modelID, modelCreate),
+		expectedBatchCall,
+		expectedLinkSchemaCall,
+		expectedLinkInstanceCall,
+		expectedProxyCalls,
+		// rollback
+		expectedcalls.DeleteProxyInstances(datasetID, models.NewDeleteProxyInstancesBody(recordIDs[0], proxyID)),
+		expectedcalls.DeleteLinkInstance(datasetID, modelID, recordIDs[0], linkInstanceID),
+		expectedcalls.DeleteLinkSchema(datasetID, modelID, linkSchemaID),
+		// most recently created first
+		expectedcalls.RecordDelete(datasetID, modelID, []clientmodels.PennsieveInstanceID{recordIDs[1], recordIDs[0]}),
+		expectedcalls.ModelDelete(datasetID, modelID),
+	)
+	defer mockServer.Close()
+
+	testProcessor := processortest.NewBuilder().
+		WithIntegrationID(integrationID).
+		WithOutputDirectory(outputDirectory).
+		WithRecordBatchSize(2).
+		WithRollbackOnFailure().
+		Build(t, mockServer.URL())
+
+	runErr := testProcessor.Run(context.Background())
+	require.Error(t, runErr)
+	assert.ErrorContains(t, runErr, packageNodeIDs[1])
+
+	mockServer.AssertAllCalledExactlyOnce(t)
+
+	report := readReport(t, processor.ReportFilePath(outputDirectory))
+	assert.False(t, report.Success)
+	assert.True(t, report.RolledBack)
+	rollbackPhase := report.Phases[len(report.Phases)-1]
+	assert.Equal(t, processor.RollbackPhase, rollbackPhase.Name)
+	assert.Empty(t, rollbackPhase.Error)
+	assert.Equal(t, map[processor.EntityType]map[processor.Action]int{
+		processor.ProxyEntity:        {processor.Deleted: 1},
+		processor.LinkInstanceEntity: {processor.Deleted: 1},
+		processor.LinkSchemaEntity:   {processor.Deleted: 1},
+		processor.RecordEntity:       {processor.Deleted: 2},
+		processor.ModelEntity:        {processor.Deleted: 1},
+	}, rollbackPhase.Counts)
+
+	// nothing left to resume
+	assert.NoFileExists(t, processor.JournalFilePath(outputDirectory))
+	assert.NoFileExists(t, processor.IDStoreFilePath(outputDirectory))
+}
+
+func failedRollbackReported(t *testing.T) {
+	integrationID := uuid.NewString()
+	datasetID := processortest.NewDatasetID()
+	outputDirectory := t.TempDir()
+
+	modelID := clienttest.NewPennsieveSchemaID()
+	modelCreate := clienttest.NewModelCreate()
+	recordCreates, recordValues := newRecordCreates(t, 1)
+
+	changeset := clientmodels.Dataset{
+		Models: clientmodels.ModelChanges{
+			Creates: []clientmodels.ModelCreate{{
+				Create:  clientmodels.ModelPropsCreate{Model: modelCreate},
+				Records: recordCreates,
+			}},
+		},
+		LinkedProperties: []clientmodels.LinkedPropertyChanges{{
+			FromModelName: modelCreate.Name,
+			ToModelName:   modelCreate.Name,
+			ID:            clienttest.NewPennsieveSchemaID(),
+			Instances: clientmodels.InstanceChanges{
+				Create: []clientmodels.InstanceLinkedPropertyCreate{{
+					FromExternalID: recordCreates[0].ExternalID,
+					ToExternalID:   recordCreates[0].ExternalID,
+				}},
+			},
+		}},
+	}
+	writeChangeset(t, changeset, processor.ChangesetFilePath(outputDirectory))
+
+	expectedBatchCall := expectedcalls.RecordBatchCreates(datasetID, modelID, recordValues)
+	recordID := clientmodels.PennsieveInstanceID(expectedBatchCall.Calls[0].APIResponse[0].ID)
+
+	mockServer := mock.NewModelService(t,
+		expectedcalls.GetIntegration(integrationID, datasetID),
+		expectedcalls.ModelCreate(datasetID, modelID, modelCreate),
+		expectedBatchCall,
+		&mock.ExpectedFailedAPICall{
+			Method:     http.MethodPost,
+			APIPath:    fmt.Sprintf("/models/datasets/%s/concepts/%s/instances/%s/linked", datasetID, modelID, recordID),
+			StatusCode: http.StatusInternalServerError,
+		},
+		// rollback
+		expectedcalls.RecordDelete(datasetID, modelID, []clientmodels.PennsieveInstanceID{recordID}),
+		&mock.ExpectedFailedAPICall{
+			Method:     http.MethodDelete,
+			APIPath:    fmt.Sprintf("/models/datasets/%s/concepts/%s", datasetID, modelID),
+			StatusCode: http.StatusConflict,
+		},
+	)
+	defer mockServer.Close()
+
+	testProcessor := processortest.NewBuilder().
+		WithIntegrationID(integrationID).
+		WithOutputDirectory(outputDirectory).
+		WithRecordBatchSize(2).
+		WithRollbackOnFailure().
+		Build(t, mockServer.URL())
+
+	runErr := testProcessor.Run(context.Background())
+	require.Error(t, runErr)
+	assert.ErrorContains(t, runErr, "error rolling back")
+
+	mockServer.AssertAllCalledExactlyOnce(t)
+
+	report := readReport(t, processor.ReportFilePath(outputDirectory))
+	assert.False(t, report.RolledBack)
+	rollbackPhase := report.Phases[len(report.Phases)-1]
+	assert.Equal(t, processor.RollbackPhase, rollbackPhase.Name)
+	assert.Contains(t, rollbackPhase.Error, string(modelID))
+	assert.Equal(t, 1, rollbackPhase.Counts[processor.RecordEntity][processor.Deleted])
+	assert.Zero(t, rollbackPhase.Counts[processor.ModelEntity][processor.Deleted])
+}
+
+func rollbackRecordsInBatches(t *testing.T) {
+	integrationID := uuid.NewString()
+	datasetID := processortest.NewDatasetID()
+	outputDirectory := t.TempDir()
+
+	modelID := clienttest.NewPennsieveSchemaID()
+	modelCreate := clienttest.NewModelCreate()
+	recordCreates, recordValues := newRecordCreates(t, 3)
+
+	changeset := clientmodels.Dataset{
+		Models: clientmodels.ModelChanges{
+			Creates: []clientmodels.ModelCreate{{
+				Create:  clientmodels.ModelPropsCreate{Model: modelCreate},
+				Records: recordCreates,
+			}},
+		},
+		LinkedProperties: []clientmodels.LinkedPropertyChanges{{
+			FromModelName: modelCreate.Name,
+			ToModelName:   modelCreate.Name,
+			ID:            clienttest.NewPennsieveSchemaID(),
+			Instances: clientmodels.InstanceChanges{
+				Create: []clientmodels.InstanceLinkedPropertyCreate{{
+					FromExternalID: recordCreates[0].ExternalID,
+					ToExternalID:   recordCreates[1].ExternalID,
+				}},
+			},
+		}},
+	}
+	writeChangeset(t, changeset, processor.ChangesetFilePath(outputDirectory))
+
+	expectedBatchCalls := expectedcalls.RecordBatchCreates(datasetID, modelID, recordValues[0:2], recordValues[2:])
+	recordIDs := []clientmodels.PennsieveInstanceID{
+		clientmodels.PennsieveInstanceID(expectedBatchCalls.Calls[0].APIResponse[0].ID),
+		clientmodels.PennsieveInstanceID(expectedBatchCalls.Calls[0].APIResponse[1].ID),
+		clientmodels.PennsieveInstanceID(expectedBatchCalls.Calls[1].APIResponse[0].ID),
+	}
+	// most recently created first, and the first batch fails
+	expectedDeleteCalls := expectedcalls.RecordDeletes(datasetID, modelID,
+		[]clientmodels.PennsieveInstanceID{recordIDs[2], recordIDs[1]},
+		[]clientmodels.PennsieveInstanceID{recordIDs[0]})
+	expectedDeleteCalls.Calls[0].StatusCode = http.StatusInternalServerError
+
+	mockServer := mock.NewModelService(t,
+		expectedcalls.GetIntegration(integrationID, datasetID),
+		expectedcalls.ModelCreate(datasetID, modelID, modelCreate),
+		expectedBatchCalls,
+		&mock.ExpectedFailedAPICall{
+			Method:     http.MethodPost,
+			APIPath:    fmt.Sprintf("/models/datasets/%s/concepts/%s/instances/%s/linked", datasetID, modelID, recordIDs[0]),
+			StatusCode: http.StatusInternalServerError,
+		},
+		// rollback
+		expectedDeleteCalls,
+		expectedcalls.ModelDelete(datasetID, modelID),
+	)
+	defer mockServer.Close()
+
+	testProcessor := processortest.NewBuilder().
+		WithIntegrationID(integrationID).
+		WithOutputDirectory(outputDirectory).
+		WithRecordBatchSize(2).
+		WithRollbackOnFailure().
+		Build(t, mockServer.URL())
+
+	runErr := testProcessor.Run(context.Background())
+	require.Error(t, runErr)
+	assert.ErrorContains(t, runErr, "error rolling back")
+
+	mockServer.AssertAllCalledExactlyOnce(t)
+
+	report := readReport(t, processor.ReportFilePath(outputDirectory))
+	assert.False(t, report.RolledBack)
+	rollbackPhase := report.Phases[len(report.Phases)-1]
+	assert.Equal(t, processor.RollbackPhase, rollbackPhase.Name)
+	assert.Contains(t, rollbackPhase.Error, "error deleting 2 records")
+	assert.Equal(t, map[processor.EntityType]map[processor.Action]int{
+		processor.RecordEntity: {processor.Deleted: 1},
+		processor.ModelEntity:  {processor.Deleted: 1},
+	}, rollbackPhase.Counts)
+}
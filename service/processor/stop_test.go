@@ -0,0 +1,115 @@
+package processor_test
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/pennsieve/processor-post-metadata/client/clienttest"
+	clientmodels "github.com/pennsieve/processor-post-metadata/client/models"
+	"github.com/pennsieve/processor-post-metadata/service/internal/test/mock"
+	"github.com/pennsieve/processor-post-metadata/service/internal/test/mock/expectedcalls"
+	"github.com/pennsieve/processor-post-metadata/service/processor"
+	"github.com/pennsieve/processor-post-metadata/service/processor/internal/processortest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestMetadataPostProcessor_Run_Stop(t *testing.T) {
+	for scenario, testFunc := range map[string]func(t *testing.T){
+		"in-flight request finishes and no new ones start": inFlightRequestFinishes,
+		"run timeout stops the run":                        runTimeoutStopsRun,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			testFunc(t)
+		})
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(request *http.Request) (*http.Response, error) {
+	return f(request)
+}
+
+func inFlightRequestFinishes(t *testing.T) {
+	integrationID := uuid.NewString()
+	datasetID := processortest.NewDatasetID()
+	outputDirectory := t.TempDir()
+	modelID := clienttest.NewPennsieveSchemaID()
+
+	recordCreates, recordValues := newRecordCreates(t, 3)
+	changeset := clientmodels.Dataset{
+		Models: clientmodels.ModelChanges{
+			Updates: []clientmodels.ModelUpdate{{
+				ID:      modelID,
+				Records: clientmodels.RecordChanges{Create: recordCreates},
+			}},
+		},
+	}
+	writeChangeset(t, changeset, processor.ChangesetFilePath(outputDirectory))
+
+	expectedRecordCreates := expectedcalls.RecordCreates(datasetID, modelID, recordValues...)
+	mockServer := mock.NewModelService(t,
+		expectedcalls.GetIntegration(integrationID, datasetID),
+		expectedRecordCreates)
+	defer mockServer.Close()
+
+	stopCause := errors.New("stopped by test")
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	// stop the run while the first record create is in flight
+	httpClient := &http.Client{Transport: roundTripperFunc(func(request *http.Request) (*http.Response, error) {
+		if request.Method == http.MethodPost {
+			cancel(stopCause)
+		}
+		return http.DefaultTransport.RoundTrip(request)
+	})}
+
+	testProcessor := processortest.NewBuilder().
+		WithIntegrationID(integrationID).
+		WithOutputDirectory(outputDirectory).
+		WithRollbackOnFailure().
+		WithHTTPClient(httpClient).
+		Build(t, mockServer.URL())
+
+	runErr := testProcessor.Run(ctx)
+	require.ErrorIs(t, runErr, stopCause)
+
+	assert.Equal(t, []int{1, 0, 0}, expectedRecordCreates.CallCounts())
+
+	report := readReport(t, processor.ReportFilePath(outputDirectory))
+	assert.True(t, report.Stopped)
+	assert.False(t, report.RolledBack)
+	require.Len(t, report.Entries, 1)
+	assert.Equal(t, processor.Created, report.Entries[0].Action)
+	assert.Equal(t, recordCreates[0].ExternalID, report.Entries[0].ExternalID)
+
+	// the journal is kept so that the next run can resume
+	assert.FileExists(t, processor.JournalFilePath(outputDirectory))
+}
+
+func runTimeoutStopsRun(t *testing.T) {
+	integrationID := uuid.NewString()
+	outputDirectory := t.TempDir()
+	writeChangeset(t, clientmodels.Dataset{}, processor.ChangesetFilePath(outputDirectory))
+
+	mockServer := mock.NewModelService(t)
+	defer mockServer.Close()
+
+	testProcessor := processortest.NewBuilder().
+		WithIntegrationID(integrationID).
+		WithOutputDirectory(outputDirectory).
+		Build(t, mockServer.URL())
+	testProcessor.RunTimeout = time.Nanosecond
+
+	runErr := testProcessor.Run(context.Background())
+	require.ErrorIs(t, runErr, processor.ErrRunTimeout)
+	assert.Empty(t, mockServer.RequestHeaders())
+
+	report := readReport(t, processor.ReportFilePath(outputDirectory))
+	assert.True(t, report.Stopped)
+	assert.False(t, report.Success)
+}
@@ -0,0 +1,354 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/pennsieve/processor-post-metadata/client"
+	clientmodels "github.com/pennsieve/processor-post-metadata/client/models"
+	"github.com/pennsieve/processor-post-metadata/client/stream"
+	"github.com/pennsieve/processor-post-metadata/client/validation"
+	"github.com/pennsieve/processor-post-metadata/service/util"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// streamPhaseNames are the Report phase names of the stream phases
+var streamPhaseNames = []string{
+	stream.DeletesPhase:       DeletesPhase,
+	stream.ModelChangesPhase:  ModelChangesPhase,
+	stream.LinksPhase:         LinksPhase,
+	stream.RelationshipsPhase: RelationshipsPhase,
+	stream.ProxiesPhase:       ProxiesPhase,
+}
+
+// processStream applies a streamed changeset one section at a time, so that only one section of record values
+// is held in memory. The file is read twice: once to validate all of it before any changes are made, and once
+// to apply it.
+func (p *MetadataPostProcessor) processStream(ctx context.Context) error {
+	filePath := p.streamChangesetFilePath()
+	if err := validateStreamFile(filePath); err != nil {
+		return err
+	}
+	logger.Info("validated streamed changeset file", slog.String("path", filePath))
+	datasetID, err := p.getDatasetID(ctx)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("error opening changeset file %s: %w", filePath, err)
+	}
+	defer util.CloseFileAndWarn(file)
+
+	state := newStreamState(p, datasetID)
+	reader := stream.NewReader(file)
+	for {
+		section, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return state.endPhase(fmt.Errorf("error reading changeset file %s: %w", filePath, err))
+		}
+		if err := state.apply(ctx, section); err != nil {
+			return state.endPhase(fmt.Errorf("error applying section %d of changeset file %s: %w", reader.Index(), filePath, err))
+		}
+	}
+	// make sure every phase is in the Report, even if the last sections had no changes for it
+	if err := state.enter(ctx, stream.ProxiesPhase); err != nil {
+		return err
+	}
+	if err := state.endPhase(nil); err != nil {
+		return err
+	}
+	logger.Info("finished metadata processing", slog.Int("sectionCount", reader.Index()+1))
+	return nil
+}
+
+func validateStreamFile(filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("error opening changeset file %s: %w", filePath, err)
+	}
+	defer util.CloseFileAndWarn(file)
+	validator := validation.NewValidator()
+	reader := stream.NewReader(file)
+	for {
+		section, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error reading changeset file %s: %w", filePath, err)
+		}
+		validator.Add(fmt.Sprintf("sections[%d].", reader.Index()), section)
+	}
+	if err := validator.Err(); err != nil {
+		return fmt.Errorf("invalid changeset file %s: %w", filePath, err)
+	}
+	return nil
+}
+
+type linkSchemaKey struct {
+	fromModelName string
+	name          string
+}
+
+// streamState holds what the processor needs to remember between sections of a streamed changeset
+type streamState struct {
+	p         *MetadataPostProcessor
+	datasetID string
+	// phase is the current phase. NoPhase before the first section with changes.
+	phase       stream.Phase
+	phaseReport *PhaseReport
+	// phaseContext carries the span of the current phase, which lasts across sections. endPhaseSpan ends it.
+	phaseContext context.Context
+	endPhaseSpan func(err error)
+	// modelIDs holds the models created by earlier sections, by name
+	modelIDs map[string]clientmodels.PennsieveSchemaID
+	// recordCounts holds the number of record creates in earlier sections, by model
+	recordCounts map[clientmodels.PennsieveSchemaID]int
+	// linkSchemaIDs holds the link schemas created by earlier sections
+	linkSchemaIDs map[linkSchemaKey]clientmodels.PennsieveSchemaID
+	// relationshipSchemaIDs holds the relationship schemas created by earlier sections, by name
+	relationshipSchemaIDs          map[string]clientmodels.PennsieveSchemaID
+	proxyRelationshipSchemaCreated bool
+}
+
+func newStreamState(p *MetadataPostProcessor, datasetID string) *streamState {
+	return &streamState{
+		p:                     p,
+		datasetID:             datasetID,
+		phase:                 stream.NoPhase,
+		modelIDs:              map[string]clientmodels.PennsieveSchemaID{},
+		recordCounts:          map[clientmodels.PennsieveSchemaID]int{},
+		linkSchemaIDs:         map[linkSchemaKey]clientmodels.PennsieveSchemaID{},
+		relationshipSchemaIDs: map[string]clientmodels.PennsieveSchemaID{},
+	}
+}
+
+// enter ends the current phase and begins each of the following phases up to and including phase, so that the
+// Report lists the same phases as for a changeset.json.
+func (s *streamState) enter(ctx context.Context, phase stream.Phase) error {
+	for s.phase < phase {
+		if err := s.endPhase(nil); err != nil {
+			return err
+		}
+		s.phase++
+		phaseName := streamPhaseNames[s.phase]
+		s.phaseContext, s.endPhaseSpan = s.p.startSpan(ctx, phaseName, PhaseAttribute.String(phaseName))
+		s.phaseReport = s.p.Report.beginPhase(phaseName)
+	}
+	return nil
+}
+
+// endPhase ends the current phase, if any, with err. If err is nil, saves a checkpoint. Returns err or
+// the checkpoint error.
+func (s *streamState) endPhase(err error) error {
+	if s.phaseReport == nil {
+		return err
+	}
+	s.p.Report.endPhase(s.phaseReport, err)
+	s.phaseReport = nil
+	s.endPhaseSpan(err)
+	if err != nil {
+		return err
+	}
+	return s.p.checkpoint()
+}
+
+func (s *streamState) apply(ctx context.Context, section clientmodels.Dataset) error {
+	s.p.IDStore.AddModels(section.ExistingModelIDMap)
+	// As for a changeset.json, RecordIDMaps of models that exist are added before any changes, and those of models
+	// created by the section after its model changes
+	existingRecordIDMaps, createdRecordIDMaps := s.p.IDStore.splitRecordIDMaps(section.RecordIDMaps)
+	if err := s.p.IDStore.AddRecordIDMaps(existingRecordIDMaps); err != nil {
+		return err
+	}
+	recordIDMapsAdded := false
+	addRecordIDMaps := func() error {
+		if recordIDMapsAdded {
+			return nil
+		}
+		recordIDMapsAdded = true
+		return s.p.IDStore.AddRecordIDMaps(createdRecordIDMaps)
+	}
+	first, last := stream.PhasesOf(section)
+	for phase := first; phase != stream.NoPhase && phase <= last; phase++ {
+		if phase > stream.ModelChangesPhase {
+			if err := addRecordIDMaps(); err != nil {
+				return err
+			}
+		}
+		if err := s.enter(ctx, phase); err != nil {
+			return err
+		}
+		if err := s.applyPhase(s.phaseContext, phase, section); err != nil {
+			return err
+		}
+	}
+	return addRecordIDMaps()
+}
+
+func (s *streamState) applyPhase(ctx context.Context, phase stream.Phase, section clientmodels.Dataset) error {
+	switch phase {
+	case stream.DeletesPhase:
+		return s.p.ProcessDeletes(ctx, s.datasetID, section)
+	case stream.ModelChangesPhase:
+		return s.applyModelChanges(ctx, section.Models)
+	case stream.LinksPhase:
+		return s.applyLinks(ctx, section.LinkedProperties)
+	case stream.RelationshipsPhase:
+		return s.applyRelationships(ctx, section.Relationships)
+	case stream.ProxiesPhase:
+		return s.applyProxies(ctx, section.Proxies)
+	default:
+		return fmt.Errorf("unknown phase %d", phase)
+	}
+}
+
+// applyModelChanges creates models that were not created by earlier sections, updates models and their properties,
+// and creates and updates records
+func (s *streamState) applyModelChanges(ctx context.Context, modelChanges clientmodels.ModelChanges) error {
+	for _, modelCreate := range modelChanges.Creates {
+		if err := s.applyModelCreate(ctx, modelCreate); err != nil {
+			return err
+		}
+	}
+	for _, modelUpdate := range modelChanges.Updates {
+		if err := s.applyModelUpdate(ctx, modelUpdate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *streamState) applyModelCreate(ctx context.Context, modelCreate clientmodels.ModelCreate) (err error) {
+	modelName := modelCreate.Create.Model.Name
+	modelID, created := s.modelIDs[modelName]
+	ctx, endSpan := s.p.startModelSpan(ctx, modelName, modelID)
+	defer func() { endSpan(err) }()
+	if !created {
+		if modelID, err = s.p.CreateModel(ctx, s.datasetID, modelCreate.Create); err != nil {
+			return err
+		}
+		s.modelIDs[modelName] = modelID
+		setSpanModelID(ctx, modelID)
+	}
+	return s.createRecords(ctx, modelID, modelCreate.Records)
+}
+
+func (s *streamState) applyModelUpdate(ctx context.Context, modelUpdate clientmodels.ModelUpdate) (err error) {
+	ctx, endSpan := s.p.startModelSpan(ctx, "", modelUpdate.ID)
+	defer func() { endSpan(err) }()
+	if err := s.p.processModelSchemaChanges(ctx, s.datasetID, modelUpdate); err != nil {
+		return err
+	}
+	if len(modelUpdate.Records.Create) > 0 {
+		if err := s.createRecords(ctx, modelUpdate.ID, modelUpdate.Records.Create); err != nil {
+			return err
+		}
+	}
+	if len(modelUpdate.Records.Update) > 0 {
+		if err := s.p.UpdateRecords(ctx, s.datasetID, modelUpdate.ID, modelUpdate.Records.Update); err != nil {
+			return err
+		}
+	}
+	return s.p.UpsertRecords(ctx, s.datasetID, modelUpdate.ID, modelUpdate.Records.Upsert)
+}
+
+// createRecords numbers the record creates of a model across sections, since records without an external ID are
+// journaled by their position
+func (s *streamState) createRecords(ctx context.Context, modelID clientmodels.PennsieveSchemaID, recordCreates []clientmodels.RecordCreate) error {
+	firstIndex := s.recordCounts[modelID]
+	s.recordCounts[modelID] += len(recordCreates)
+	return s.p.createRecords(ctx, s.datasetID, modelID, recordCreates, firstIndex)
+}
+
+// applyLinks creates link schemas that were not created by earlier sections, and creates link instances
+func (s *streamState) applyLinks(ctx context.Context, linkChanges []clientmodels.LinkedPropertyChanges) error {
+	for _, linkChange := range linkChanges {
+		if linkChange.Create != nil {
+			key := linkSchemaKey{fromModelName: linkChange.FromModelName, name: linkChange.Create.Name}
+			linkSchemaID, created := s.linkSchemaIDs[key]
+			if !created {
+				schemaIDs, err := s.p.CreateLinkSchemaIfNecessary(ctx, s.datasetID, linkChange)
+				if err != nil {
+					return err
+				}
+				linkSchemaID = schemaIDs.Link
+				s.linkSchemaIDs[key] = linkSchemaID
+			}
+			// the schema exists now
+			linkChange.ID = linkSchemaID
+			linkChange.Create = nil
+		}
+		if err := s.p.ProcessLinkChanges(ctx, s.datasetID, linkChange); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyRelationships creates relationship schemas that were not created by earlier sections, and creates relationship
+// instances
+func (s *streamState) applyRelationships(ctx context.Context, relationshipChanges []clientmodels.RelationshipChanges) error {
+	for _, relationshipChange := range relationshipChanges {
+		if relationshipChange.Create != nil {
+			name := relationshipChange.Create.Name
+			relationshipSchemaID, created := s.relationshipSchemaIDs[name]
+			if !created {
+				var err error
+				if relationshipSchemaID, err = s.p.CreateRelationshipSchemaIfNecessary(ctx, s.datasetID, relationshipChange); err != nil {
+					return err
+				}
+				s.relationshipSchemaIDs[name] = relationshipSchemaID
+			}
+			// the schema exists now
+			relationshipChange.ID = relationshipSchemaID
+			relationshipChange.Create = nil
+		}
+		if err := s.p.ProcessRelationshipChanges(ctx, s.datasetID, relationshipChange); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyProxies creates the proxy relationship schema if requested and not created by an earlier section, and
+// creates proxies
+func (s *streamState) applyProxies(ctx context.Context, proxyChanges *clientmodels.ProxyChanges) error {
+	if proxyChanges == nil {
+		return nil
+	}
+	changes := *proxyChanges
+	if s.proxyRelationshipSchemaCreated {
+		changes.CreateProxyRelationshipSchema = false
+	}
+	if err := s.p.ProcessProxyChanges(ctx, s.datasetID, &changes); err != nil {
+		return err
+	}
+	s.proxyRelationshipSchemaCreated = s.proxyRelationshipSchemaCreated || changes.CreateProxyRelationshipSchema
+	return nil
+}
+
+// StreamChangesetFilePath joins the given output directory with the
+// streamed changeset file name.
+// Visible for testing.
+func StreamChangesetFilePath(outputDirectory string) string {
+	return filepath.Join(outputDirectory, client.StreamFilename)
+}
+
+func (p *MetadataPostProcessor) streamChangesetFilePath() string {
+	return StreamChangesetFilePath(p.OutputDirectory)
+}
+
+// streaming returns true if the changeset is streamed, that is, if there is a streamed changeset file
+// in the output directory
+func (p *MetadataPostProcessor) streaming() bool {
+	_, err := os.Stat(p.streamChangesetFilePath())
+	return err == nil
+}
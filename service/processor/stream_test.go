@@ -0,0 +1,267 @@
+package processor_test
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/pennsieve/processor-post-metadata/client/clienttest"
+	clientmodels "github.com/pennsieve/processor-post-metadata/client/models"
+	"github.com/pennsieve/processor-post-metadata/client/stream"
+	"github.com/pennsieve/processor-post-metadata/service/internal/test/mock"
+	"github.com/pennsieve/processor-post-metadata/service/internal/test/mock/expectedcalls"
+	"github.com/pennsieve/processor-post-metadata/service/models"
+	"github.com/pennsieve/processor-post-metadata/service/processor"
+	"github.com/pennsieve/processor-post-metadata/service/processor/internal/processortest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+)
+
+func TestMetadataPostProcessor_Run_Stream(t *testing.T) {
+	for scenario, testFunc := range map[string]func(t *testing.T){
+		"sections are applied in order":     streamSectionsApplied,
+		"invalid stream makes no API calls": streamInvalidNoAPICalls,
+		"proxy delete of existing record":   streamProxyDeleteOfExistingRecord,
+		"record ID map of created model":    streamRecordIDMapOfCreatedModel,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			testFunc(t)
+		})
+	}
+}
+
+func streamSectionsApplied(t *testing.T) {
+	integrationID := uuid.NewString()
+	datasetID := processortest.NewDatasetID()
+	outputDirectory := t.TempDir()
+
+	modelID := clienttest.NewPennsieveSchemaID()
+	modelCreate := clienttest.NewModelCreate()
+	recordCreates, recordValues := newRecordCreates(t, 3)
+
+	existingModelName := uuid.NewString()
+	existingModelID := clienttest.NewPennsieveSchemaID()
+	existingExternalID := clienttest.NewExternalInstanceID()
+	existingRecordID := clienttest.NewPennsieveInstanceID()
+
+	linkSchemaCreate := clienttest.NewSchemaLinkedPropertyCreate()
+	var instanceCreates []clientmodels.InstanceLinkedPropertyCreate
+	for _, recordCreate := range recordCreates {
+		instanceCreates = append(instanceCreates, clientmodels.InstanceLinkedPropertyCreate{
+			FromExternalID: recordCreate.ExternalID,
+			ToExternalID:   existingExternalID,
+		})
+	}
+	packageNodeID := NewPackageNodeID()
+
+	changeset := clientmodels.Dataset{
+		Models: clientmodels.ModelChanges{
+			Creates: []clientmodels.ModelCreate{{
+				Create:  clientmodels.ModelPropsCreate{Model: modelCreate},
+				Records: recordCreates,
+			}},
+		},
+		LinkedProperties: []clientmodels.LinkedPropertyChanges{{
+			FromModelName: modelCreate.Name,
+			ToModelName:   existingModelName,
+			Create:        &linkSchemaCreate,
+			Instances:     clientmodels.InstanceChanges{Create: instanceCreates},
+		}},
+		Proxies: &clientmodels.ProxyChanges{
+			CreateProxyRelationshipSchema: true,
+			RecordChanges: []clientmodels.ProxyRecordChanges{{
+				ModelName:        modelCreate.Name,
+				RecordExternalID: recordCreates[2].ExternalID,
+				NodeIDCreates:    []string{packageNodeID},
+			}},
+		},
+		ExistingModelIDMap: map[string]clientmodels.PennsieveSchemaID{existingModelName: existingModelID},
+		RecordIDMaps: []clientmodels.RecordIDMap{{
+			ModelName:           existingModelName,
+			ExternalToPennsieve: map[clientmodels.ExternalInstanceID]clientmodels.PennsieveInstanceID{existingExternalID: existingRecordID},
+		}},
+	}
+	// the model create and the link are each split over two sections
+	writeStreamChangeset(t, 2, changeset, processor.StreamChangesetFilePath(outputDirectory))
+
+	expectedBatchCalls := expectedcalls.RecordBatchCreates(datasetID, modelID, recordValues[:2], recordValues[2:])
+	recordIDs := []clientmodels.PennsieveInstanceID{
+		clientmodels.PennsieveInstanceID(expectedBatchCalls.Calls[0].APIResponse[0].ID),
+		clientmodels.PennsieveInstanceID(expectedBatchCalls.Calls[0].APIResponse[1].ID),
+		clientmodels.PennsieveInstanceID(expectedBatchCalls.Calls[1].APIResponse[0].ID),
+	}
+	expectedLinkSchemaCall := expectedcalls.CreateLinkSchema(datasetID, modelID, models.CreateLinkSchemaBody{
+		Name:        linkSchemaCreate.Name,
+		DisplayName: linkSchemaCreate.DisplayName,
+		To:          existingModelID,
+		Position:    linkSchemaCreate.Position,
+	})
+	linkSchemaID := clientmodels.PennsieveSchemaID(expectedLinkSchemaCall.APIResponse.ID)
+
+	expectedCalls := []mock.ExpectedCall{
+		expectedcalls.GetIntegration(integrationID, datasetID),
+		expectedcalls.ModelCreate(datasetID, modelID, modelCreate),
+		expectedBatchCalls,
+		expectedLinkSchemaCall,
+		expectedcalls.CreateProxyRelationshipSchema(datasetID),
+		expectedcalls.CreateProxyInstance(datasetID, models.NewCreateProxyInstanceBody(recordIDs[2], packageNodeID)),
+	}
+	for _, recordID := range recordIDs {
+		expectedCalls = append(expectedCalls, expectedcalls.CreateLinkInstance(datasetID, modelID, recordID, models.CreateLinkInstanceBody{
+			SchemaLinkedPropertyId: linkSchemaID,
+			To:                     existingRecordID,
+		}))
+	}
+	mockServer := mock.NewModelService(t, expectedCalls...)
+	defer mockServer.Close()
+
+	testProcessor := processortest.NewBuilder().
+		WithIntegrationID(integrationID).
+		WithOutputDirectory(outputDirectory).
+		WithRecordBatchSize(2).
+		Build(t, mockServer.URL())
+
+	require.NoError(t, testProcessor.Run(context.Background()))
+
+	// the model, link schema, and proxy relationship schema are only created once
+	mockServer.AssertAllCalledExactlyOnce(t)
+
+	report := readReport(t, processor.ReportFilePath(outputDirectory))
+	assert.True(t, report.Success)
+	require.Len(t, report.Phases, 5)
+	for i, name := range []string{processor.DeletesPhase, processor.ModelChangesPhase, processor.LinksPhase, processor.RelationshipsPhase, processor.ProxiesPhase} {
+		assert.Equal(t, name, report.Phases[i].Name)
+	}
+	assert.Equal(t, 1, report.Phases[1].Counts[processor.ModelEntity][processor.Created])
+	assert.Equal(t, 3, report.Phases[1].Counts[processor.RecordEntity][processor.Created])
+	assert.Equal(t, 1, report.Phases[2].Counts[processor.LinkSchemaEntity][processor.Created])
+	assert.Equal(t, 3, report.Phases[2].Counts[processor.LinkInstanceEntity][processor.Created])
+}
+
+func streamInvalidNoAPICalls(t *testing.T) {
+	outputDirectory := t.TempDir()
+
+	linkSchemaCreate := clienttest.NewSchemaLinkedPropertyCreate()
+	changeset := clientmodels.Dataset{
+		LinkedProperties: []clientmodels.LinkedPropertyChanges{{
+			FromModelName: uuid.NewString(),
+			ToModelName:   uuid.NewString(),
+			Create:        &linkSchemaCreate,
+		}},
+	}
+	writeStreamChangeset(t, stream.DefaultMaxSectionSize, changeset, processor.StreamChangesetFilePath(outputDirectory))
+
+	mockServer := mock.NewModelService(t)
+	defer mockServer.Close()
+
+	testProcessor := processortest.NewBuilder().
+		WithOutputDirectory(outputDirectory).
+		Build(t, mockServer.URL())
+
+	err := testProcessor.Run(context.Background())
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "sections[0].linked_properties[0].from_model_name")
+	assert.ErrorContains(t, err, "sections[0].linked_properties[0].to_model_name")
+}
+
+func streamProxyDeleteOfExistingRecord(t *testing.T) {
+	integrationID := uuid.NewString()
+	datasetID := processortest.NewDatasetID()
+	outputDirectory := t.TempDir()
+
+	modelName := uuid.NewString()
+	modelID := clienttest.NewPennsieveSchemaID()
+	externalID := clienttest.NewExternalInstanceID()
+	recordID := clienttest.NewPennsieveInstanceID()
+	proxyID := clienttest.NewPennsieveInstanceID()
+
+	// a single section, so its RecordIDMaps are needed by its own deletes phase
+	changeset := clientmodels.Dataset{
+		ExistingModelIDMap: map[string]clientmodels.PennsieveSchemaID{modelName: modelID},
+		RecordIDMaps: []clientmodels.RecordIDMap{{
+			ModelName:           modelName,
+			ExternalToPennsieve: map[clientmodels.ExternalInstanceID]clientmodels.PennsieveInstanceID{externalID: recordID},
+		}},
+		Proxies: &clientmodels.ProxyChanges{
+			RecordChanges: []clientmodels.ProxyRecordChanges{{
+				ModelName:         modelName,
+				RecordExternalID:  externalID,
+				InstanceIDDeletes: []clientmodels.PennsieveInstanceID{proxyID},
+			}},
+		},
+	}
+	writeChangeset(t, changeset, processor.StreamChangesetFilePath(outputDirectory))
+
+	mockServer := mock.NewModelService(t,
+		expectedcalls.GetIntegration(integrationID, datasetID),
+		expectedcalls.DeleteProxyInstances(datasetID, models.NewDeleteProxyInstancesBody(recordID, proxyID)),
+	)
+	defer mockServer.Close()
+
+	testProcessor := processortest.NewBuilder().
+		WithIntegrationID(integrationID).
+		WithOutputDirectory(outputDirectory).
+		Build(t, mockServer.URL())
+
+	require.NoError(t, testProcessor.Run(context.Background()))
+
+	mockServer.AssertAllCalledExactlyOnce(t)
+}
+
+func streamRecordIDMapOfCreatedModel(t *testing.T) {
+	integrationID := uuid.NewString()
+	datasetID := processortest.NewDatasetID()
+	outputDirectory := t.TempDir()
+
+	modelID := clienttest.NewPennsieveSchemaID()
+	modelCreate := clienttest.NewModelCreate()
+	externalID := clienttest.NewExternalInstanceID()
+	recordID := clienttest.NewPennsieveInstanceID()
+	packageNodeID := NewPackageNodeID()
+
+	// a single section, so its RecordIDMaps can only be added after its model changes
+	changeset := clientmodels.Dataset{
+		Models: clientmodels.ModelChanges{
+			Creates: []clientmodels.ModelCreate{{Create: clientmodels.ModelPropsCreate{Model: modelCreate}}},
+		},
+		RecordIDMaps: []clientmodels.RecordIDMap{{
+			ModelName:           modelCreate.Name,
+			ExternalToPennsieve: map[clientmodels.ExternalInstanceID]clientmodels.PennsieveInstanceID{externalID: recordID},
+		}},
+		Proxies: &clientmodels.ProxyChanges{
+			RecordChanges: []clientmodels.ProxyRecordChanges{{
+				ModelName:        modelCreate.Name,
+				RecordExternalID: externalID,
+				NodeIDCreates:    []string{packageNodeID},
+			}},
+		},
+	}
+	writeChangeset(t, changeset, processor.StreamChangesetFilePath(outputDirectory))
+
+	mockServer := mock.NewModelService(t,
+		expectedcalls.GetIntegration(integrationID, datasetID),
+		expectedcalls.ModelCreate(datasetID, modelID, modelCreate),
+		expectedcalls.CreateProxyInstance(datasetID, models.NewCreateProxyInstanceBody(recordID, packageNodeID)),
+	)
+	defer mockServer.Close()
+
+	testProcessor := processortest.NewBuilder().
+		WithIntegrationID(integrationID).
+		WithOutputDirectory(outputDirectory).
+		Build(t, mockServer.URL())
+
+	require.NoError(t, testProcessor.Run(context.Background()))
+
+	mockServer.AssertAllCalledExactlyOnce(t)
+}
+
+func writeStreamChangeset(t *testing.T, maxSectionSize int, changeset clientmodels.Dataset, filePath string) {
+	file, err := os.Create(filePath)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, file.Close())
+	}()
+	writer := stream.NewWriter(file)
+	writer.MaxSectionSize = maxSectionSize
+	require.NoError(t, writer.Write(changeset))
+}
@@ -0,0 +1,95 @@
+package processor
+
+import (
+	"context"
+	clientmodels "github.com/pennsieve/processor-post-metadata/client/models"
+	"github.com/pennsieve/processor-post-metadata/service/pennsieve"
+	"github.com/pennsieve/processor-post-metadata/service/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"log/slog"
+	"path/filepath"
+	"time"
+)
+
+// TracesFilename is the name of the file in the output directory that spans are written to by the file exporter
+const TracesFilename = "traces.jsonl"
+
+// Span attribute keys of processor spans. Request spans also carry the pennsieve package attributes.
+const (
+	PhaseAttribute         = attribute.Key("processor.phase")
+	ModelNameAttribute     = attribute.Key("processor.model_name")
+	IntegrationIDAttribute = attribute.Key("processor.integration_id")
+)
+
+const tracingShutdownTimeout = 10 * time.Second
+
+// TracesFilePath joins the given output directory with the
+// traces file name.
+// Visible for testing.
+func TracesFilePath(outputDirectory string) string {
+	return filepath.Join(outputDirectory, TracesFilename)
+}
+
+// startSpan starts a span as a child of the span in ctx. Requests made with the returned context are its children.
+// The returned func ends the span with err.
+func (p *MetadataPostProcessor) startSpan(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, func(err error)) {
+	spanCtx, span := p.tracing().Tracer().Start(ctx, name, trace.WithAttributes(attributes...))
+	return spanCtx, func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+// startModelSpan starts a span for the changes to one model, so that a slow run shows which model took the time.
+// Either name or modelID may be empty.
+func (p *MetadataPostProcessor) startModelSpan(ctx context.Context, name string, modelID clientmodels.PennsieveSchemaID) (context.Context, func(err error)) {
+	var attributes []attribute.KeyValue
+	if len(name) > 0 {
+		attributes = append(attributes, ModelNameAttribute.String(name))
+	}
+	if len(modelID) > 0 {
+		attributes = append(attributes, pennsieve.ModelIDKey.String(modelID.String()))
+	}
+	return p.startSpan(ctx, "model", attributes...)
+}
+
+// setSpanModelID adds the ID of a model created in the model span in ctx
+func setSpanModelID(ctx context.Context, modelID clientmodels.PennsieveSchemaID) {
+	trace.SpanFromContext(ctx).SetAttributes(pennsieve.ModelIDKey.String(modelID.String()))
+}
+
+// recordContext adds the external ID of a record to the spans of requests made with the returned context
+func recordContext(ctx context.Context, externalID clientmodels.ExternalInstanceID) context.Context {
+	if len(externalID) == 0 {
+		return ctx
+	}
+	return pennsieve.WithSpanAttributes(ctx, pennsieve.ExternalIDKey.String(string(externalID)))
+}
+
+func (p *MetadataPostProcessor) tracing() *tracing.Provider {
+	if p.Tracing == nil {
+		return tracing.NoopProvider()
+	}
+	return p.Tracing
+}
+
+// traced runs f in a span called name that is the root of the trace of the run, and then shuts down tracing so that
+// every span is exported before the process exits.
+func (p *MetadataPostProcessor) traced(ctx context.Context, name string, f func(ctx context.Context) error) (err error) {
+	spanCtx, end := p.startSpan(ctx, name, IntegrationIDAttribute.String(p.IntegrationID))
+	defer func() {
+		end(err)
+		// the run may have been stopped, but the spans should still be exported
+		shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), tracingShutdownTimeout)
+		defer cancel()
+		if shutdownErr := p.tracing().Shutdown(shutdownCtx); shutdownErr != nil {
+			logger.Warn("unable to export traces", slog.Any("error", shutdownErr))
+		}
+	}()
+	return f(spanCtx)
+}
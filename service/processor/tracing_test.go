@@ -0,0 +1,98 @@
+package processor_test
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/pennsieve/processor-post-metadata/client/clienttest"
+	clientmodels "github.com/pennsieve/processor-post-metadata/client/models"
+	"github.com/pennsieve/processor-post-metadata/service/internal/test/mock"
+	"github.com/pennsieve/processor-post-metadata/service/internal/test/mock/expectedcalls"
+	"github.com/pennsieve/processor-post-metadata/service/pennsieve"
+	"github.com/pennsieve/processor-post-metadata/service/processor"
+	"github.com/pennsieve/processor-post-metadata/service/processor/internal/processortest"
+	"github.com/pennsieve/processor-post-metadata/service/tracing"
+	"github.com/pennsieve/processor-pre-metadata/client/models/datatypes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"testing"
+)
+
+func TestMetadataPostProcessor_Run_Tracing(t *testing.T) {
+	integrationID := uuid.NewString()
+	datasetID := processortest.NewDatasetID()
+	outputDirectory := t.TempDir()
+
+	modelID := clienttest.NewPennsieveSchemaID()
+	modelCreate := clienttest.NewModelCreate()
+	recordCreateValues := clienttest.NewRecordValues(clienttest.NewRecordValueSimple(t, datatypes.StringType))
+	externalID := clienttest.NewExternalInstanceID()
+
+	changeset := clientmodels.Dataset{
+		Models: clientmodels.ModelChanges{
+			Creates: []clientmodels.ModelCreate{{
+				Create: clientmodels.ModelPropsCreate{Model: modelCreate},
+				Records: []clientmodels.RecordCreate{{
+					ExternalID:   externalID,
+					RecordValues: recordCreateValues,
+				}},
+			}},
+		},
+	}
+	writeChangeset(t, changeset, processor.ChangesetFilePath(outputDirectory))
+
+	mockServer := mock.NewModelService(t,
+		expectedcalls.GetIntegration(integrationID, datasetID),
+		expectedcalls.ModelCreate(datasetID, modelID, modelCreate),
+		expectedcalls.RecordCreate(datasetID, modelID, recordCreateValues))
+	defer mockServer.Close()
+
+	recorder := tracetest.NewSpanRecorder()
+	testProcessor := processortest.NewBuilder().
+		WithIntegrationID(integrationID).
+		WithOutputDirectory(outputDirectory).
+		WithTracing(tracing.NewSDKProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))).
+		Build(t, mockServer.URL())
+
+	require.NoError(t, testProcessor.Run(context.Background()))
+
+	// the recorder keeps the spans after Run shuts down tracing
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	runSpan := requireSpan(t, spans, "Run")
+	assert.False(t, runSpan.Parent().IsValid())
+	assert.Contains(t, runSpan.Attributes(), pennsieve.DatasetIDKey.String(datasetID))
+
+	phaseSpan := requireSpan(t, spans, processor.ModelChangesPhase)
+	assert.Equal(t, runSpan.SpanContext().SpanID(), phaseSpan.Parent().SpanID())
+	for _, phase := range []string{processor.DeletesPhase, processor.LinksPhase, processor.RelationshipsPhase, processor.ProxiesPhase} {
+		requireSpan(t, spans, phase)
+	}
+
+	modelSpan := requireSpan(t, spans, "model")
+	assert.Equal(t, phaseSpan.SpanContext().SpanID(), modelSpan.Parent().SpanID())
+	assert.Contains(t, modelSpan.Attributes(), processor.ModelNameAttribute.String(modelCreate.Name))
+	assert.Contains(t, modelSpan.Attributes(), pennsieve.ModelIDKey.String(modelID.String()))
+
+	recordSpan := requireSpan(t, spans, "POST /models/datasets/{id}/concepts/{id}/instances")
+	assert.Equal(t, modelSpan.SpanContext().SpanID(), recordSpan.Parent().SpanID())
+	assert.Contains(t, recordSpan.Attributes(), pennsieve.DatasetIDKey.String(datasetID))
+	assert.Contains(t, recordSpan.Attributes(), pennsieve.ModelIDKey.String(modelID.String()))
+	assert.Contains(t, recordSpan.Attributes(), pennsieve.ExternalIDKey.String(string(externalID)))
+	assert.Contains(t, recordSpan.Attributes(), attribute.Int("http.response.status_code", 200))
+
+	// every request carries the trace of the run
+	for _, header := range mockServer.RequestHeaders() {
+		assert.Contains(t, header.Get("traceparent"), runSpan.SpanContext().TraceID().String())
+	}
+}
+
+func requireSpan(t *testing.T, spans map[string]sdktrace.ReadOnlySpan, name string) sdktrace.ReadOnlySpan {
+	span, found := spans[name]
+	require.True(t, found, "no span %q", name)
+	return span
+}
@@ -0,0 +1,190 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	clientmodels "github.com/pennsieve/processor-post-metadata/client/models"
+	"log/slog"
+	"math"
+	"strconv"
+)
+
+// RecordQueryPageSize is the number of records requested at a time when looking up existing records for upserts
+const RecordQueryPageSize = 1000
+
+// recordKey identifies the lookup of a model's existing records by the value of one of their properties
+type recordKey struct {
+	modelID     clientmodels.PennsieveSchemaID
+	keyProperty string
+}
+
+// UpsertRecords updates or creates each record in recordUpserts, depending on whether the model has a record with
+// the same value for the upsert's key property, and adds the records to the IDStore. The first upsert that is not
+// skipped by the journal looks up the existing records for the key values of all of recordUpserts at once.
+func (p *MetadataPostProcessor) UpsertRecords(ctx context.Context, datasetID string, modelID clientmodels.PennsieveSchemaID, recordUpserts []clientmodels.RecordUpsert) error {
+	if len(recordUpserts) == 0 {
+		return nil
+	}
+	modelLogger := logger.With(slog.Any("modelID", modelID))
+	modelLogger.Info("upserting records")
+	keyValues := map[string][]any{}
+	for _, recordUpsert := range recordUpserts {
+		if keyValue, found := recordUpsert.KeyValue(); found {
+			keyValues[recordUpsert.KeyProperty] = append(keyValues[recordUpsert.KeyProperty], keyValue)
+		}
+	}
+	for _, recordUpsert := range recordUpserts {
+		if err := p.upsertRecord(ctx, datasetID, modelID, recordUpsert, keyValues[recordUpsert.KeyProperty]); err != nil {
+			return err
+		}
+	}
+	modelLogger.Info("upserted records", slog.Int("count", len(recordUpserts)))
+	return nil
+}
+
+// UpsertRecord updates or creates the record of recordUpsert. Use UpsertRecords for more than one upsert of a model, so
+// that the existing records are looked up once.
+func (p *MetadataPostProcessor) UpsertRecord(ctx context.Context, datasetID string, modelID clientmodels.PennsieveSchemaID, recordUpsert clientmodels.RecordUpsert) error {
+	keyValue, _ := recordUpsert.KeyValue()
+	return p.upsertRecord(ctx, datasetID, modelID, recordUpsert, []any{keyValue})
+}
+
+// upsertRecord updates or creates the record of recordUpsert. keyValues are the key values to look up together with
+// the upsert's own if the model's existing records have to be read.
+func (p *MetadataPostProcessor) upsertRecord(ctx context.Context, datasetID string, modelID clientmodels.PennsieveSchemaID, recordUpsert clientmodels.RecordUpsert, keyValues []any) error {
+	keyValue, found := recordUpsert.KeyValue()
+	if !found {
+		return fmt.Errorf("unable to upsert record %s of model %s: no value for key property %s",
+			recordUpsert.ExternalID, modelID, recordUpsert.KeyProperty)
+	}
+	key := recordKey{modelID: modelID, keyProperty: recordUpsert.KeyProperty}
+	action := Updated
+	id, skipped, err := p.journaled(upsertRecordOperation(modelID, recordUpsert.ExternalID), func() (string, error) {
+		index, err := p.existingRecordIDs(ctx, datasetID, key, keyValues)
+		if err != nil {
+			return "", err
+		}
+		if recordID, exists := index.recordIDs[keyString(keyValue)]; exists {
+			_, err := p.Pennsieve.UpdateRecord(recordContext(ctx, recordUpsert.ExternalID), datasetID, modelID, recordID, recordUpsert.RecordValues)
+			return string(recordID), err
+		}
+		action = Created
+		recordID, err := p.Pennsieve.CreateRecord(recordContext(ctx, recordUpsert.ExternalID), datasetID, modelID, recordUpsert.RecordValues)
+		if err != nil {
+			return "", err
+		}
+		// a later upsert with the same key value should update this record
+		index.recordIDs[keyString(keyValue)] = recordID
+		return string(recordID), nil
+	})
+	if err != nil {
+		return fmt.Errorf("error upserting record %s of model %s: %w", recordUpsert.ExternalID, modelID, err)
+	}
+	recordID := clientmodels.PennsieveInstanceID(id)
+	p.IDStore.AddRecord(modelID, recordUpsert.ExternalID, recordID)
+	if !skipped {
+		p.Report.Add(ReportEntry{
+			Type:       RecordEntity,
+			Action:     action,
+			ID:         id,
+			ExternalID: recordUpsert.ExternalID,
+			ModelID:    modelID,
+		})
+	}
+	return nil
+}
+
+// recordKeyIndex holds the records of a model by their value for a key property. Only the key values in lookedUp have
+// been looked up, so a key value missing from recordIDs means no record only if it is in lookedUp.
+type recordKeyIndex struct {
+	recordIDs map[string]clientmodels.PennsieveInstanceID
+	lookedUp  map[string]bool
+}
+
+// existingRecordIDs returns the index of the model's records by their value for the key property, after looking up
+// those of keyValues that were not looked up before. The lookup reads the model's records from Pennsieve,
+// RecordQueryPageSize at a time, but only indexes the records with one of the key values. Two records with the same
+// key value are an error, since an upsert could not tell which one to update.
+func (p *MetadataPostProcessor) existingRecordIDs(ctx context.Context, datasetID string, key recordKey, keyValues []any) (*recordKeyIndex, error) {
+	index, loaded := p.recordKeyIndexes[key]
+	if !loaded {
+		index = &recordKeyIndex{
+			recordIDs: map[string]clientmodels.PennsieveInstanceID{},
+			lookedUp:  map[string]bool{},
+		}
+	}
+	missing := map[string]bool{}
+	for _, keyValue := range keyValues {
+		if value := keyString(keyValue); !index.lookedUp[value] {
+			missing[value] = true
+		}
+	}
+	if len(missing) == 0 {
+		return index, nil
+	}
+	found := 0
+	for offset := 0; ; offset += RecordQueryPageSize {
+		records, err := p.Pennsieve.QueryRecords(ctx, datasetID, key.modelID, RecordQueryPageSize, offset)
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range records {
+			for _, value := range record.Values {
+				if value.Name != key.keyProperty || value.Value == nil || !missing[keyString(value.Value)] {
+					continue
+				}
+				recordID := clientmodels.PennsieveInstanceID(record.ID)
+				if otherID, duplicate := index.recordIDs[keyString(value.Value)]; duplicate {
+					return nil, fmt.Errorf("records %s and %s of model %s both have value %v for key property %s",
+						otherID, recordID, key.modelID, value.Value, key.keyProperty)
+				}
+				index.recordIDs[keyString(value.Value)] = recordID
+				found++
+			}
+		}
+		if len(records) < RecordQueryPageSize {
+			break
+		}
+	}
+	for value := range missing {
+		index.lookedUp[value] = true
+	}
+	logger.Info("looked up existing records for upserts",
+		slog.Any("modelID", key.modelID),
+		slog.String("keyProperty", key.keyProperty),
+		slog.Int("keyValues", len(missing)),
+		slog.Int("found", found))
+	if p.recordKeyIndexes == nil {
+		p.recordKeyIndexes = map[recordKey]*recordKeyIndex{}
+	}
+	p.recordKeyIndexes[key] = index
+	return index, nil
+}
+
+// keyString is the form of a key property value used for comparisons. Values decoded from JSON and values given in
+// the changeset may have different types, for example float64 and int, so numbers with an integer value are written
+// as integers, and other numbers in their shortest exact form.
+func keyString(value any) string {
+	switch number := value.(type) {
+	case float64:
+		return floatKeyString(number)
+	case float32:
+		return floatKeyString(float64(number))
+	case json.Number:
+		if intValue, err := number.Int64(); err == nil {
+			return strconv.FormatInt(intValue, 10)
+		}
+		if floatValue, err := number.Float64(); err == nil {
+			return floatKeyString(floatValue)
+		}
+	}
+	return fmt.Sprint(value)
+}
+
+func floatKeyString(value float64) string {
+	if value == math.Trunc(value) && math.Abs(value) < 1<<63 {
+		return strconv.FormatInt(int64(value), 10)
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
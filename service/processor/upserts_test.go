@@ -0,0 +1,185 @@
+package processor_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/pennsieve/processor-post-metadata/client/clienttest"
+	clientmodels "github.com/pennsieve/processor-post-metadata/client/models"
+	"github.com/pennsieve/processor-post-metadata/service/internal/test/mock"
+	"github.com/pennsieve/processor-post-metadata/service/internal/test/mock/expectedcalls"
+	"github.com/pennsieve/processor-post-metadata/service/models"
+	"github.com/pennsieve/processor-post-metadata/service/processor"
+	"github.com/pennsieve/processor-post-metadata/service/processor/internal/processortest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestMetadataPostProcessor_UpsertRecords(t *testing.T) {
+	for scenario, testFunc := range map[string]func(t *testing.T){
+		"update existing and create new records": upsertUpdatesAndCreates,
+		"existing records are read in pages":     upsertReadsPages,
+		"duplicate key values are an error":      upsertDuplicateKeyValues,
+		"numeric key values match across types":  upsertNumericKeyValues,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			testFunc(t)
+		})
+	}
+}
+
+func newRecordUpsert(name string, visit string) clientmodels.RecordUpsert {
+	return clientmodels.RecordUpsert{
+		ExternalID:  clienttest.NewExternalInstanceID(),
+		KeyProperty: "name",
+		RecordValues: clientmodels.RecordValues{Values: []clientmodels.RecordValue{
+			{Name: "name", Value: name},
+			{Name: "visit", Value: visit},
+		}},
+	}
+}
+
+func newRecordResponse(name string) models.RecordResponse {
+	return models.RecordResponse{
+		APIResponse: models.APIResponse{ID: string(clienttest.NewPennsieveInstanceID())},
+		Values:      []clientmodels.RecordValue{{Name: "name", Value: name}},
+	}
+}
+
+func upsertUpdatesAndCreates(t *testing.T) {
+	datasetID := processortest.NewDatasetID()
+	modelID := clienttest.NewPennsieveSchemaID()
+
+	existing := newRecordResponse("subject-1")
+	updateExisting := newRecordUpsert("subject-1", "baseline")
+	createNew := newRecordUpsert("subject-2", "baseline")
+	// same key as the record created by the upsert before
+	updateNew := newRecordUpsert("subject-2", "follow-up")
+
+	// duplicate key values are only an error if they are upserted
+	upsertCalls := expectedcalls.RecordUpserts(datasetID, modelID,
+		[][]models.RecordResponse{{existing, newRecordResponse("subject-3"), newRecordResponse("subject-3")}},
+		createNew.RecordValues)
+	createdID := clientmodels.PennsieveInstanceID(upsertCalls.Calls[1].APIResponse.(models.APIResponse).ID)
+
+	mockServer := mock.NewModelService(t,
+		upsertCalls,
+		expectedcalls.RecordUpdate(datasetID, modelID, clientmodels.PennsieveInstanceID(existing.ID), updateExisting.RecordValues),
+		expectedcalls.RecordUpdate(datasetID, modelID, createdID, updateNew.RecordValues))
+	defer mockServer.Close()
+
+	testProcessor := processortest.NewBuilder().Build(t, mockServer.URL())
+
+	require.NoError(t, testProcessor.ProcessModelCreatesUpdates(context.Background(), datasetID, nil, []clientmodels.ModelUpdate{{
+		ID:      modelID,
+		Records: clientmodels.RecordChanges{Upsert: []clientmodels.RecordUpsert{updateExisting, createNew, updateNew}},
+	}}))
+
+	// existing records are only read once
+	mockServer.AssertAllCalledExactlyOnce(t)
+
+	for upsert, expectedID := range map[*clientmodels.RecordUpsert]clientmodels.PennsieveInstanceID{
+		&updateExisting: clientmodels.PennsieveInstanceID(existing.ID),
+		&createNew:      createdID,
+		&updateNew:      createdID,
+	} {
+		recordID, err := testProcessor.IDStore.RecordID(modelID, upsert.ExternalID)
+		require.NoError(t, err)
+		assert.Equal(t, expectedID, recordID)
+	}
+
+	entries := testProcessor.Report.Entries
+	require.Len(t, entries, 3)
+	assert.Equal(t, processor.Updated, entries[0].Action)
+	assert.Equal(t, processor.Created, entries[1].Action)
+	assert.Equal(t, processor.Updated, entries[2].Action)
+}
+
+func upsertReadsPages(t *testing.T) {
+	datasetID := processortest.NewDatasetID()
+	modelID := clienttest.NewPennsieveSchemaID()
+
+	var firstPage []models.RecordResponse
+	for i := 0; i < processor.RecordQueryPageSize; i++ {
+		firstPage = append(firstPage, newRecordResponse(fmt.Sprintf("subject-%d", i)))
+	}
+	existing := newRecordResponse("last-subject")
+	upsert := newRecordUpsert("last-subject", "baseline")
+
+	mockServer := mock.NewModelService(t,
+		expectedcalls.RecordUpserts(datasetID, modelID, [][]models.RecordResponse{firstPage, {existing}}),
+		expectedcalls.RecordUpdate(datasetID, modelID, clientmodels.PennsieveInstanceID(existing.ID), upsert.RecordValues))
+	defer mockServer.Close()
+
+	testProcessor := processortest.NewBuilder().Build(t, mockServer.URL())
+
+	require.NoError(t, testProcessor.UpsertRecords(context.Background(), datasetID, modelID, []clientmodels.RecordUpsert{upsert}))
+
+	mockServer.AssertAllCalledExactlyOnce(t)
+
+	recordID, err := testProcessor.IDStore.RecordID(modelID, upsert.ExternalID)
+	require.NoError(t, err)
+	assert.Equal(t, clientmodels.PennsieveInstanceID(existing.ID), recordID)
+}
+
+func upsertDuplicateKeyValues(t *testing.T) {
+	datasetID := processortest.NewDatasetID()
+	modelID := clienttest.NewPennsieveSchemaID()
+
+	first := newRecordResponse("subject-1")
+	second := newRecordResponse("subject-1")
+	upsert := newRecordUpsert("subject-1", "baseline")
+
+	mockServer := mock.NewModelService(t,
+		expectedcalls.RecordUpserts(datasetID, modelID, [][]models.RecordResponse{{first, newRecordResponse("subject-2"), second}}))
+	defer mockServer.Close()
+
+	testProcessor := processortest.NewBuilder().Build(t, mockServer.URL())
+
+	err := testProcessor.UpsertRecords(context.Background(), datasetID, modelID, []clientmodels.RecordUpsert{upsert})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, first.ID)
+	assert.ErrorContains(t, err, second.ID)
+
+	mockServer.AssertAllCalledExactlyOnce(t)
+	_, err = testProcessor.IDStore.RecordID(modelID, upsert.ExternalID)
+	assert.Error(t, err)
+}
+
+func upsertNumericKeyValues(t *testing.T) {
+	datasetID := processortest.NewDatasetID()
+	modelID := clienttest.NewPennsieveSchemaID()
+
+	// JSON numbers are decoded as float64, so the existing record's key value is 1e+06
+	existing := models.RecordResponse{
+		APIResponse: models.APIResponse{ID: string(clienttest.NewPennsieveInstanceID())},
+		Values:      []clientmodels.RecordValue{{Name: "number", Value: 1000000}},
+	}
+	upsert := clientmodels.RecordUpsert{
+		ExternalID:  clienttest.NewExternalInstanceID(),
+		KeyProperty: "number",
+		RecordValues: clientmodels.RecordValues{Values: []clientmodels.RecordValue{
+			{Name: "number", Value: 1000000},
+			{Name: "visit", Value: "baseline"},
+		}},
+	}
+	expectedUpdate := clientmodels.RecordValues{Values: []clientmodels.RecordValue{
+		{Name: "number", Value: float64(1000000)},
+		{Name: "visit", Value: "baseline"},
+	}}
+
+	mockServer := mock.NewModelService(t,
+		expectedcalls.RecordUpserts(datasetID, modelID, [][]models.RecordResponse{{existing}}),
+		expectedcalls.RecordUpdate(datasetID, modelID, clientmodels.PennsieveInstanceID(existing.ID), expectedUpdate))
+	defer mockServer.Close()
+
+	testProcessor := processortest.NewBuilder().Build(t, mockServer.URL())
+
+	require.NoError(t, testProcessor.UpsertRecords(context.Background(), datasetID, modelID, []clientmodels.RecordUpsert{upsert}))
+
+	mockServer.AssertAllCalledExactlyOnce(t)
+
+	recordID, err := testProcessor.IDStore.RecordID(modelID, upsert.ExternalID)
+	require.NoError(t, err)
+	assert.Equal(t, clientmodels.PennsieveInstanceID(existing.ID), recordID)
+}
@@ -0,0 +1,73 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// DefaultRecordConcurrency is the number of record creates or updates run at the same time if not configured otherwise
+const DefaultRecordConcurrency = 1
+
+// DefaultRecordBatchSize is the number of records created per request if not configured otherwise. A size <= 1
+// creates each record with its own request, so batching is off by default.
+const DefaultRecordBatchSize = 0
+
+// DefaultProxyBatchSize is the number of package proxies created per request if not configured otherwise. As for
+// records, a size <= 1 means one request per package.
+const DefaultProxyBatchSize = 0
+
+// forEachConcurrently calls f(i) for each i in [0, n) with at most concurrency calls running at once.
+// Once a call fails, no new calls are started, but those already running are allowed to finish. The errors
+// returned by f are joined in order of i, so the result does not depend on goroutine scheduling.
+// If concurrency <= 1, the calls are made in order on the current goroutine, stopping at the first error.
+// Once ctx is done, no new calls are started either, and the cause of ctx is returned with any errors.
+func forEachConcurrently(ctx context.Context, n int, concurrency int, f func(i int) error) error {
+	stopped := func(started int) error {
+		return fmt.Errorf("stopped after starting %d of %d operations: %w", started, n, context.Cause(ctx))
+	}
+	if concurrency <= 1 {
+		for i := 0; i < n; i++ {
+			if ctx.Err() != nil {
+				return stopped(i)
+			}
+			if err := f(i); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	errs := make([]error, n)
+	indexes := make(chan int)
+	var failed sync.Once
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for w := 0; w < min(concurrency, n); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if err := f(i); err != nil {
+					errs[i] = err
+					failed.Do(func() { close(stop) })
+				}
+			}
+		}()
+	}
+	var stopErr error
+dispatch:
+	for i := 0; i < n; i++ {
+		select {
+		case indexes <- i:
+		case <-stop:
+			break dispatch
+		case <-ctx.Done():
+			stopErr = stopped(i)
+			break dispatch
+		}
+	}
+	close(indexes)
+	wg.Wait()
+	return errors.Join(append(errs, stopErr)...)
+}
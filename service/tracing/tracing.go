@@ -0,0 +1,116 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"os"
+)
+
+// ServiceName is the service.name resource attribute of exported spans
+const ServiceName = "processor-post-metadata"
+
+// InstrumentationName is the name of the tracers used by the service
+const InstrumentationName = "github.com/pennsieve/processor-post-metadata/service"
+
+// Exporter names accepted by NewProvider
+const (
+	NoExporter     = "none"
+	StdoutExporter = "stdout"
+	FileExporter   = "file"
+	OTLPExporter   = "otlp"
+)
+
+// Propagator writes the traceparent header of outgoing requests
+var Propagator = propagation.TraceContext{}
+
+// Provider is a trace.TracerProvider that can be shut down to flush and close its exporter
+type Provider struct {
+	trace.TracerProvider
+	shutdown func(ctx context.Context) error
+}
+
+// NoopProvider returns a Provider that records nothing
+func NoopProvider() *Provider {
+	return &Provider{
+		TracerProvider: noop.NewTracerProvider(),
+		shutdown:       func(context.Context) error { return nil },
+	}
+}
+
+// NewProvider returns a Provider for the named exporter:
+//   - "none" or "": spans are not recorded
+//   - "stdout": spans are written to stdout as JSON
+//   - "file": spans are written to filePath as JSON, one span per line
+//   - "otlp": spans are sent with OTLP over HTTP, configured by the standard OTEL_EXPORTER_OTLP_* environment variables
+func NewProvider(exporterName string, filePath string) (*Provider, error) {
+	var exporter sdktrace.SpanExporter
+	var closeFile func() error
+	var err error
+	switch exporterName {
+	case NoExporter, "":
+		return NoopProvider(), nil
+	case StdoutExporter:
+		exporter, err = stdouttrace.New()
+	case FileExporter:
+		file, fileErr := os.Create(filePath)
+		if fileErr != nil {
+			return nil, fmt.Errorf("error creating trace file %s: %w", filePath, fileErr)
+		}
+		closeFile = file.Close
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(file))
+	case OTLPExporter:
+		exporter, err = otlptracehttp.New(context.Background())
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q; expected one of %q, %q, %q or %q",
+			exporterName, NoExporter, StdoutExporter, FileExporter, OTLPExporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error creating %s trace exporter: %w", exporterName, err)
+	}
+	spanProcessor := sdktrace.NewBatchSpanProcessor(exporter)
+	if exporterName != OTLPExporter {
+		// local exporters are cheap, and writing spans as they end means a crash loses nothing
+		spanProcessor = sdktrace.NewSimpleSpanProcessor(exporter)
+	}
+	sdkProvider := sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(spanProcessor),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(ServiceName))),
+	)
+	return &Provider{
+		TracerProvider: sdkProvider,
+		shutdown: func(ctx context.Context) error {
+			err := sdkProvider.Shutdown(ctx)
+			if closeFile != nil {
+				err = errors.Join(err, closeFile())
+			}
+			return err
+		},
+	}, nil
+}
+
+// NewSDKProvider returns a Provider backed by sdkProvider, for callers that configure their own exporters
+func NewSDKProvider(sdkProvider *sdktrace.TracerProvider) *Provider {
+	return &Provider{TracerProvider: sdkProvider, shutdown: sdkProvider.Shutdown}
+}
+
+// Tracer returns the tracer of the service
+func (p *Provider) Tracer() trace.Tracer {
+	return p.TracerProvider.Tracer(InstrumentationName)
+}
+
+// Shutdown exports any remaining spans and releases the exporter. No spans are recorded afterwards.
+func (p *Provider) Shutdown(ctx context.Context) error {
+	if err := p.shutdown(ctx); err != nil {
+		return fmt.Errorf("error shutting down tracing: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package tracing_test
+
+import (
+	"context"
+	"github.com/pennsieve/processor-post-metadata/service/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewProvider(t *testing.T) {
+	for scenario, testFunc := range map[string]func(t *testing.T){
+		"file exporter writes spans":   fileExporterWritesSpans,
+		"none records nothing":         noneRecordsNothing,
+		"unknown exporter is an error": unknownExporterIsAnError,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			testFunc(t)
+		})
+	}
+}
+
+func fileExporterWritesSpans(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "traces.jsonl")
+	provider, err := tracing.NewProvider(tracing.FileExporter, filePath)
+	require.NoError(t, err)
+
+	_, span := provider.Tracer().Start(context.Background(), "test-span")
+	span.End()
+	require.NoError(t, provider.Shutdown(context.Background()))
+
+	content, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), `"Name":"test-span"`)
+	assert.Contains(t, string(content), tracing.ServiceName)
+}
+
+func noneRecordsNothing(t *testing.T) {
+	provider, err := tracing.NewProvider(tracing.NoExporter, "")
+	require.NoError(t, err)
+	_, span := provider.Tracer().Start(context.Background(), "test-span")
+	assert.False(t, span.IsRecording())
+	assert.NoError(t, provider.Shutdown(context.Background()))
+}
+
+func unknownExporterIsAnError(t *testing.T) {
+	_, err := tracing.NewProvider("zipkin", "")
+	assert.ErrorContains(t, err, `unknown trace exporter "zipkin"`)
+}
@@ -1,6 +1,7 @@
 package util
 
 import (
+	"context"
 	"fmt"
 	"github.com/pennsieve/processor-post-metadata/service/logging"
 	"io"
@@ -19,23 +20,9 @@ func CloseAndWarn(response *http.Response) {
 	}
 }
 
-func Invoke(request *http.Request) (*http.Response, error) {
-
-	res, err := http.DefaultClient.Do(request)
-	if err != nil {
-		return nil, fmt.Errorf("error invoking %s %s: %w", request.Method, request.URL, err)
-	}
-	if err := checkHTTPStatus(res); err != nil {
-		// if there was an error, checkHTTPStatus read the body
-		if closeError := res.Body.Close(); closeError != nil {
-			logger.Warn("error closing response body from http status error",
-				slog.String("method", request.Method),
-				slog.String("url", request.URL.String()),
-				slog.Any("error", closeError))
-		}
-		return nil, err
-	}
-	return res, nil
+// Invoke sends the request once with client. See InvokeWithRetry to retry failures.
+func Invoke(ctx context.Context, client *http.Client, request *http.Request) (*http.Response, error) {
+	return InvokeWithRetry(ctx, client, request, NoRetry, nil)
 }
 
 // checkHTTPStatus returns an error if 400 <= response status code < 600. Otherwise, returns nil.
@@ -56,16 +43,33 @@ func checkHTTPStatus(response *http.Response) error {
 			displayBody = fmt.Sprintf("<truncated for logging> %s", string(responseBody[:1000]))
 		}
 		displayBody = string(responseBody)
-		errorType := "client"
-		if response.StatusCode >= http.StatusInternalServerError {
-			errorType = "server"
+		return &HTTPStatusError{
+			StatusCode: response.StatusCode,
+			message: fmt.Sprintf("%s error %s calling %s %s; response body: %s",
+				StatusClass(response.StatusCode),
+				response.Status,
+				response.Request.Method,
+				response.Request.URL,
+				displayBody),
 		}
-		return fmt.Errorf("%s error %s calling %s %s; response body: %s",
-			errorType,
-			response.Status,
-			response.Request.Method,
-			response.Request.URL,
-			displayBody)
 	}
 	return nil
 }
+
+// HTTPStatusError is returned by Invoke and InvokeWithRetry if the final response had an error status
+type HTTPStatusError struct {
+	StatusCode int
+	message    string
+}
+
+func (e *HTTPStatusError) Error() string {
+	return e.message
+}
+
+// StatusClass returns "server" for 5xx status codes, otherwise "client"
+func StatusClass(statusCode int) string {
+	if statusCode >= http.StatusInternalServerError {
+		return "server"
+	}
+	return "client"
+}
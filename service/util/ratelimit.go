@@ -0,0 +1,80 @@
+package util
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket that limits how often requests are sent. The bucket holds up to burst tokens
+// and refills at rate tokens per second. A nil *RateLimiter does not limit anything.
+type RateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a RateLimiter that allows rate requests per second on average and bursts of up to burst
+// requests. Returns nil, meaning no limit, if rate <= 0. burst values < 1 are treated as 1.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	b := math.Max(1, float64(burst))
+	return &RateLimiter{
+		rate:   rate,
+		burst:  b,
+		tokens: b,
+		last:   time.Now(),
+	}
+}
+
+// Wait blocks until the caller may send a request or ctx is done. Callers are served in the order they call Wait.
+// Returns the cause of ctx if it is done before the caller may send, in which case the caller must not send.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return context.Cause(ctx)
+	}
+	delay := l.reserve()
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.cancel()
+		return context.Cause(ctx)
+	}
+}
+
+// reserve takes a token from the bucket and returns how long the caller must wait before the token is
+// available. The token count goes negative when callers are waiting, so later callers wait longer.
+func (l *RateLimiter) reserve() time.Duration {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// cancel returns a token taken by reserve that will not be used
+func (l *RateLimiter) cancel() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens = math.Min(l.burst, l.tokens+1)
+}
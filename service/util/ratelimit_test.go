@@ -0,0 +1,81 @@
+package util_test
+
+import (
+	"context"
+	"github.com/pennsieve/processor-post-metadata/service/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	for scenario, testFunc := range map[string]func(t *testing.T){
+		"burst does not wait":           burstDoesNotWait,
+		"waits after burst":             waitsAfterBurst,
+		"concurrent callers share rate": concurrentCallersShareRate,
+		"nil limiter does not wait":     nilLimiterDoesNotWait,
+		"done context stops waiting":    doneContextStopsWaiting,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			testFunc(t)
+		})
+	}
+}
+
+func burstDoesNotWait(t *testing.T) {
+	limiter := util.NewRateLimiter(1, 5)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		require.NoError(t, limiter.Wait(context.Background()))
+	}
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+}
+
+func waitsAfterBurst(t *testing.T) {
+	limiter := util.NewRateLimiter(50, 1)
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		require.NoError(t, limiter.Wait(context.Background()))
+	}
+	// first is free, the next five wait 20ms each
+	assert.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+}
+
+func concurrentCallersShareRate(t *testing.T) {
+	limiter := util.NewRateLimiter(50, 1)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			require.NoError(t, limiter.Wait(context.Background()))
+		}()
+	}
+	wg.Wait()
+	assert.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+}
+
+func nilLimiterDoesNotWait(t *testing.T) {
+	limiter := util.NewRateLimiter(0, 10)
+	assert.Nil(t, limiter)
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		require.NoError(t, limiter.Wait(context.Background()))
+	}
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+}
+
+func doneContextStopsWaiting(t *testing.T) {
+	limiter := util.NewRateLimiter(0.01, 1)
+	require.NoError(t, limiter.Wait(context.Background()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := limiter.Wait(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+}
@@ -0,0 +1,171 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const DefaultRetryMaxAttempts = 3
+const DefaultRetryBaseDelay = 500 * time.Millisecond
+const DefaultRetryMaxDelay = 30 * time.Second
+
+// RetryPolicy controls how InvokeWithRetry retries failed requests
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of times a request is sent. Values <= 1 mean no retries.
+	MaxAttempts int
+	// BaseDelay is the upper bound of the random delay before the first retry. It doubles for each following retry
+	BaseDelay time.Duration
+	// MaxDelay caps the computed delay and any delay requested by a Retry-After header. Values <= 0 mean no cap
+	MaxDelay time.Duration
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: DefaultRetryMaxAttempts,
+		BaseDelay:   DefaultRetryBaseDelay,
+		MaxDelay:    DefaultRetryMaxDelay,
+	}
+}
+
+// NoRetry is a RetryPolicy that sends each request once
+var NoRetry = RetryPolicy{MaxAttempts: 1}
+
+// InvokeWithRetry is like Invoke, but retries according to policy. A request is only retried if it is idempotent, or
+// if the failure shows that Pennsieve never applied it: a connection that could not be established, or a
+// 429 Too Many Requests response. Every attempt waits for limiter, which may be nil.
+// ctx stops new attempts: once it is done, no further attempt is sent, including one waiting for limiter. An attempt
+// in progress is governed by the context of request and by client.Timeout.
+func InvokeWithRetry(ctx context.Context, client *http.Client, request *http.Request, policy RetryPolicy, limiter *RateLimiter) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		if err := limiter.Wait(ctx); err != nil {
+			return nil, fmt.Errorf("not sending %s %s: %w", request.Method, request.URL, err)
+		}
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("not sending %s %s: %w", request.Method, request.URL, context.Cause(ctx))
+		}
+		res, err := client.Do(request)
+		var retryAfter time.Duration
+		var retryable bool
+		if err != nil {
+			err = fmt.Errorf("error invoking %s %s: %w", request.Method, request.URL, err)
+			retryable = isRetryableConnectionError(request, err)
+		} else if statusErr := checkHTTPStatus(res); statusErr != nil {
+			// if there was an error, checkHTTPStatus read the body
+			if closeError := res.Body.Close(); closeError != nil {
+				logger.Warn("error closing response body from http status error",
+					slog.String("method", request.Method),
+					slog.String("url", request.URL.String()),
+					slog.Any("error", closeError))
+			}
+			err = statusErr
+			retryable = isRetryableStatus(request, res.StatusCode)
+			retryAfter = parseRetryAfter(res.Header.Get("Retry-After"))
+		} else {
+			return res, nil
+		}
+
+		if !retryable || attempt >= policy.MaxAttempts {
+			return nil, err
+		}
+		if request.Body != nil {
+			if request.GetBody == nil {
+				// cannot resend the body
+				return nil, err
+			}
+			body, bodyErr := request.GetBody()
+			if bodyErr != nil {
+				return nil, errors.Join(err, fmt.Errorf("error resetting body for retry: %w", bodyErr))
+			}
+			request.Body = body
+		}
+		delay := retryAfter
+		if delay <= 0 {
+			delay = policy.backoff(attempt)
+		} else if policy.MaxDelay > 0 && delay > policy.MaxDelay {
+			delay = policy.MaxDelay
+		}
+		logger.Warn("retrying request",
+			slog.String("method", request.Method),
+			slog.String("url", request.URL.String()),
+			slog.Int("attempt", attempt),
+			slog.Int("maxAttempts", policy.MaxAttempts),
+			slog.Duration("delay", delay),
+			slog.String("error", err.Error()))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, errors.Join(err, fmt.Errorf("not retrying %s %s: %w", request.Method, request.URL, context.Cause(ctx)))
+		}
+	}
+}
+
+// backoff returns a random delay in [0, min(MaxDelay, BaseDelay * 2^(attempt-1)))
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	ceiling := p.BaseDelay << (attempt - 1)
+	if ceiling <= 0 || (p.MaxDelay > 0 && ceiling > p.MaxDelay) {
+		// ceiling <= 0 if the shift overflowed
+		ceiling = p.MaxDelay
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling)))
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+func isRetryableStatus(request *http.Request, statusCode int) bool {
+	switch statusCode {
+	case http.StatusTooManyRequests:
+		// request was throttled, not processed
+		return true
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		// request may or may not have reached Pennsieve
+		return isIdempotent(request.Method)
+	default:
+		return false
+	}
+}
+
+func isRetryableConnectionError(request *http.Request, err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		// no connection, so the request was never sent
+		return true
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return isIdempotent(request.Method)
+	}
+	return false
+}
+
+// parseRetryAfter returns the delay requested by a Retry-After header value, which can be a number of seconds or
+// an HTTP date. Returns 0 if the value is empty or cannot be parsed.
+func parseRetryAfter(value string) time.Duration {
+	if len(value) == 0 {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
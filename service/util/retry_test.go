@@ -0,0 +1,223 @@
+package util_test
+
+import (
+	"bytes"
+	"context"
+	"github.com/pennsieve/processor-post-metadata/service/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var testRetryPolicy = util.RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   time.Millisecond,
+	MaxDelay:    5 * time.Millisecond,
+}
+
+func TestInvokeWithRetry(t *testing.T) {
+	for scenario, testFunc := range map[string]func(t *testing.T){
+		"idempotent request retried on 503":   idempotentRetriedOn503,
+		"non-idempotent not retried on 503":   postNotRetriedOn503,
+		"non-idempotent retried on 429":       postRetriedOn429,
+		"gives up after max attempts":         givesUpAfterMaxAttempts,
+		"client errors are not retried":       clientErrorNotRetried,
+		"body is resent on retry":             bodyResentOnRetry,
+		"POST retried if connection refused":  postRetriedIfNoConnection,
+		"no retry policy sends once":          noRetrySendsOnce,
+		"done context stops retries":          doneContextStopsRetries,
+		"done context sends nothing":          doneContextSendsNothing,
+		"done context stops limited requests": doneContextStopsLimitedRequests,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			testFunc(t)
+		})
+	}
+}
+
+// failingServer responds with each of the given status codes in turn, and then 200 OK
+func failingServer(t *testing.T, statusCodes ...int) (*httptest.Server, *[]string) {
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		body, err := io.ReadAll(request.Body)
+		require.NoError(t, err)
+		bodies = append(bodies, string(body))
+		if len(bodies) <= len(statusCodes) {
+			writer.Header().Set("Retry-After", "0")
+			http.Error(writer, "failure", statusCodes[len(bodies)-1])
+			return
+		}
+		_, err = writer.Write([]byte(`{"id": "1"}`))
+		require.NoError(t, err)
+	}))
+	t.Cleanup(server.Close)
+	return server, &bodies
+}
+
+func newRequest(t *testing.T, method string, url string, body string) *http.Request {
+	var bodyReader io.Reader
+	if len(body) > 0 {
+		bodyReader = bytes.NewBufferString(body)
+	}
+	request, err := http.NewRequest(method, url, bodyReader)
+	require.NoError(t, err)
+	return request
+}
+
+func idempotentRetriedOn503(t *testing.T) {
+	server, bodies := failingServer(t, http.StatusServiceUnavailable)
+	response, err := util.InvokeWithRetry(context.Background(), http.DefaultClient, newRequest(t, http.MethodGet, server.URL, ""), testRetryPolicy, nil)
+	require.NoError(t, err)
+	util.CloseAndWarn(response)
+	assert.Len(t, *bodies, 2)
+}
+
+func postNotRetriedOn503(t *testing.T) {
+	server, bodies := failingServer(t, http.StatusServiceUnavailable)
+	_, err := util.InvokeWithRetry(context.Background(), http.DefaultClient, newRequest(t, http.MethodPost, server.URL, `{"name": "a"}`), testRetryPolicy, nil)
+	require.Error(t, err)
+	assert.Len(t, *bodies, 1)
+}
+
+func postRetriedOn429(t *testing.T) {
+	server, bodies := failingServer(t, http.StatusTooManyRequests, http.StatusTooManyRequests)
+	response, err := util.InvokeWithRetry(context.Background(), http.DefaultClient, newRequest(t, http.MethodPost, server.URL, `{"name": "a"}`), testRetryPolicy, nil)
+	require.NoError(t, err)
+	util.CloseAndWarn(response)
+	assert.Len(t, *bodies, 3)
+}
+
+func givesUpAfterMaxAttempts(t *testing.T) {
+	server, bodies := failingServer(t, http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway)
+	_, err := util.InvokeWithRetry(context.Background(), http.DefaultClient, newRequest(t, http.MethodDelete, server.URL, ""), testRetryPolicy, nil)
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "502")
+	assert.Len(t, *bodies, 3)
+}
+
+func doneContextStopsRetries(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var received int
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		received++
+		// the run is stopped while the first attempt is in progress
+		cancel()
+		http.Error(writer, "failure", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(server.Close)
+	_, err := util.InvokeWithRetry(ctx, http.DefaultClient, newRequest(t, http.MethodGet, server.URL, ""), testRetryPolicy, nil)
+	require.ErrorIs(t, err, context.Canceled)
+	assert.ErrorContains(t, err, "not retrying")
+	assert.Equal(t, 1, received)
+}
+
+func doneContextSendsNothing(t *testing.T) {
+	server, bodies := failingServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := util.InvokeWithRetry(ctx, http.DefaultClient, newRequest(t, http.MethodGet, server.URL, ""), testRetryPolicy, nil)
+	require.ErrorIs(t, err, context.Canceled)
+	assert.ErrorContains(t, err, "not sending")
+	assert.Empty(t, *bodies)
+}
+
+func doneContextStopsLimitedRequests(t *testing.T) {
+	var received atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		received.Add(1)
+	}))
+	t.Cleanup(server.Close)
+	// one request is let through at once, and the rest wait far longer than the test
+	limiter := util.NewRateLimiter(0.01, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var wg sync.WaitGroup
+	errs := make([]error, 5)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			response, err := util.InvokeWithRetry(ctx, http.DefaultClient, newRequest(t, http.MethodPost, server.URL, `{"name": "a"}`), testRetryPolicy, limiter)
+			if err == nil {
+				util.CloseAndWarn(response)
+			}
+			errs[i] = err
+		}(i)
+	}
+	require.Eventually(t, func() bool { return received.Load() == 1 }, time.Second, time.Millisecond)
+	cancel()
+	wg.Wait()
+
+	assert.Equal(t, int32(1), received.Load())
+	var canceled int
+	for _, err := range errs {
+		if err != nil {
+			assert.ErrorIs(t, err, context.Canceled)
+			assert.ErrorContains(t, err, "not sending")
+			canceled++
+		}
+	}
+	assert.Equal(t, 4, canceled)
+}
+
+func clientErrorNotRetried(t *testing.T) {
+	server, bodies := failingServer(t, http.StatusBadRequest)
+	_, err := util.InvokeWithRetry(context.Background(), http.DefaultClient, newRequest(t, http.MethodGet, server.URL, ""), testRetryPolicy, nil)
+	require.Error(t, err)
+	assert.Len(t, *bodies, 1)
+}
+
+func bodyResentOnRetry(t *testing.T) {
+	server, bodies := failingServer(t, http.StatusGatewayTimeout)
+	body := `{"values": [{"name": "a", "value": 1}]}`
+	response, err := util.InvokeWithRetry(context.Background(), http.DefaultClient, newRequest(t, http.MethodPut, server.URL, body), testRetryPolicy, nil)
+	require.NoError(t, err)
+	util.CloseAndWarn(response)
+	assert.Equal(t, []string{body, body}, *bodies)
+}
+
+func postRetriedIfNoConnection(t *testing.T) {
+	// start and stop a server to get a URL with nothing listening
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+
+	policy := testRetryPolicy
+	policy.MaxAttempts = 2
+	start := time.Now()
+	_, err := util.InvokeWithRetry(context.Background(), http.DefaultClient, newRequest(t, http.MethodPost, url, `{"name": "a"}`), policy, nil)
+	require.Error(t, err)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func noRetrySendsOnce(t *testing.T) {
+	server, bodies := failingServer(t, http.StatusServiceUnavailable)
+	_, err := util.Invoke(context.Background(), http.DefaultClient, newRequest(t, http.MethodGet, server.URL, ""))
+	require.Error(t, err)
+	assert.Len(t, *bodies, 1)
+}
+
+func retryAfterCappedByMaxDelay(t *testing.T) {
+	var received int
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		received++
+		if received == 1 {
+			writer.Header().Set("Retry-After", "3600")
+			http.Error(writer, "slow down", http.StatusTooManyRequests)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	start := time.Now()
+	response, err := util.InvokeWithRetry(context.Background(), http.DefaultClient, newRequest(t, http.MethodPost, server.URL, `{"name": "a"}`), testRetryPolicy, nil)
+	require.NoError(t, err)
+	util.CloseAndWarn(response)
+	assert.Equal(t, 2, received)
+	assert.Less(t, time.Since(start), time.Second)
+}